@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+)
+
+// BanKey stops a key from being uploaded or returned by lookups. The key doesn't have to be
+// stored: it can be banned before it's uploaded.
+func BanKey() (exitCode int) {
+	if len(os.Args) < 4 {
+		fmt.Printf("Usage: ban_key <fingerprint> <reason>\n")
+		return 1
+	}
+
+	fingerprint, err := fpr.Parse(os.Args[2])
+	if err != nil {
+		fmt.Printf("invalid fingerprint: %v\n", err)
+		return 1
+	}
+	reason := strings.Join(os.Args[3:], " ")
+
+	if err := datastore.BanKey(nil, fingerprint, reason, time.Now()); err != nil {
+		fmt.Printf("error banning key: %v\n", err)
+		return 1
+	}
+	fmt.Printf("banned key %s\n", fingerprint.Hex())
+	return 0
+}
+
+// UnbanKey lifts a ban made with BanKey.
+func UnbanKey() (exitCode int) {
+	if len(os.Args) != 3 {
+		fmt.Printf("Usage: unban_key <fingerprint>\n")
+		return 1
+	}
+
+	fingerprint, err := fpr.Parse(os.Args[2])
+	if err != nil {
+		fmt.Printf("invalid fingerprint: %v\n", err)
+		return 1
+	}
+
+	found, err := datastore.UnbanKey(nil, fingerprint)
+	if err != nil {
+		fmt.Printf("error unbanning key: %v\n", err)
+		return 1
+	} else if !found {
+		fmt.Printf("key %s isn't banned\n", fingerprint.Hex())
+		return 1
+	}
+	fmt.Printf("unbanned key %s\n", fingerprint.Hex())
+	return 0
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/server"
+)
+
+// CheckRosters checks every stored team's roster and signature, printing any that are broken,
+// for example after a manual edit to the database. It exits with 1 if any are.
+func CheckRosters() (exitCode int) {
+	teams, err := datastore.ListTeams(nil)
+	if err != nil {
+		fmt.Printf("error listing teams: %v\n", err)
+		return 1
+	}
+
+	var healthy, broken int
+	for _, dbTeam := range teams {
+		if err := server.CheckStoredTeam(dbTeam); err != nil {
+			fmt.Printf("team %s: %v\n", dbTeam.UUID, err)
+			broken++
+		} else {
+			healthy++
+		}
+	}
+
+	fmt.Printf("%d teams healthy, %d broken\n", healthy, broken)
+	if broken > 0 {
+		return 1
+	}
+	return 0
+}
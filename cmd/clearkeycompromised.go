@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fluidkeys/api/datastore"
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+)
+
+// ClearKeyCompromised undoes a report that a key is compromised, for example if the owner
+// reported it by mistake and has proved to us that they still control it.
+func ClearKeyCompromised() (exitCode int) {
+	if len(os.Args) != 3 {
+		fmt.Printf("Usage: clear_key_compromised <fingerprint>\n")
+		return 1
+	}
+
+	fingerprint, err := fpr.Parse(os.Args[2])
+	if err != nil {
+		fmt.Printf("invalid fingerprint: %v\n", err)
+		return 1
+	}
+
+	found, err := datastore.ClearKeyCompromised(nil, fingerprint)
+	if err != nil {
+		fmt.Printf("error clearing compromised key: %v\n", err)
+		return 1
+	} else if !found {
+		fmt.Printf("key %s isn't reported as compromised\n", fingerprint.Hex())
+		return 1
+	}
+	fmt.Printf("key %s is no longer reported as compromised\n", fingerprint.Hex())
+	return 0
+}
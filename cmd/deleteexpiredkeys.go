@@ -4,24 +4,20 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/fluidkeys/api/datastore"
 	"github.com/fluidkeys/api/email"
 )
 
 func DeleteExpiredKeys() (exitCode int) {
-	expiredKeys, err := datastore.ListExpiredKeys()
-	if err != nil {
-		fmt.Printf("error listing expired keys: %v\n", err)
-		return 1
-	}
-
 	var keysDeleted int
+	var secretsDeleted int
 	var emailsSent int
 	var errorsSeen int
 
-	for _, expiredKey := range expiredKeys {
-		fmt.Printf("deleting key %s (verified emails: %s)",
+	err := datastore.ForEachExpiredKey(func(expiredKey datastore.ExpiredKey) error {
+		fmt.Printf("deleting key %s (verified emails: %s)\n",
 			expiredKey.UserProfile.Key.Fingerprint().Hex(),
 			strings.Join(expiredKey.VerifiedEmails, ", "))
 
@@ -44,18 +40,40 @@ func DeleteExpiredKeys() (exitCode int) {
 
 		}
 
-		_, err := datastore.DeletePublicKey(expiredKey.UserProfile.Key.Fingerprint())
+		_, keySecretsDeleted, err := datastore.DeletePublicKeyWithReason(
+			expiredKey.UserProfile.Key.Fingerprint(), "key expired", time.Now())
 		if err != nil {
-			log.Printf("error calling DeletePublicKey(%s): %v",
+			log.Printf("error calling DeletePublicKeyWithReason(%s): %v",
 				expiredKey.UserProfile.Key.Fingerprint(), err)
 			errorsSeen++
-			continue
 		} else {
+			fmt.Printf("deleted key %s and %d pending secrets\n",
+				expiredKey.UserProfile.Key.Fingerprint().Hex(), keySecretsDeleted)
 			keysDeleted++
+			secretsDeleted += keySecretsDeleted
 		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("error listing expired keys: %v\n", err)
+		errorsSeen++
+	}
+
+	fmt.Printf("%d keys deleted, %d pending secrets deleted, %d emails sent, %d errors\n",
+		keysDeleted, secretsDeleted, emailsSent, errorsSeen)
+
+	err = email.SendOpsSummary(email.JobSummary{
+		Job:            "delete_expired_keys",
+		FinishedAt:     time.Now(),
+		KeysDeleted:    keysDeleted,
+		SecretsDeleted: secretsDeleted,
+		EmailsSent:     emailsSent,
+		Errors:         errorsSeen,
+	})
+	if err != nil {
+		fmt.Printf("error sending ops summary: %v\n", err)
 	}
 
-	fmt.Printf("%d keys deleted, %d emails sent, %d errors\n", keysDeleted, emailsSent, errorsSeen)
 	if errorsSeen > 0 {
 		return 1
 	}
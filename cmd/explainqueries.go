@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fluidkeys/api/datastore"
+)
+
+// ExplainQueries prints the database query plans for the hot queries, to check that they're
+// using indexes.
+func ExplainQueries() (exitCode int) {
+	plans, err := datastore.ExplainQueries()
+	if err != nil {
+		fmt.Printf("error explaining queries: %v\n", err)
+		return 1
+	}
+
+	for _, plan := range plans {
+		fmt.Printf("%s:\n%s\n\n", plan.Name, plan.Plan)
+	}
+	return 0
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fluidkeys/api/server"
+)
+
+// GenOpenAPI prints the OpenAPI spec for the API to stdout. It's committed as openapi.json,
+// so run `make openapi` after changing a route or anything in v1structs.
+func GenOpenAPI() (exitCode int) {
+	spec, err := server.OpenAPISpec()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error generating OpenAPI spec: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(spec)
+	return 0
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+)
+
+// ListEmailFailures prints the emails that couldn't be delivered, oldest first.
+func ListEmailFailures() (exitCode int) {
+	failures, err := datastore.ListEmailFailures(nil)
+	if err != nil {
+		fmt.Printf("error listing email failures: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("failed_at,recipient,email_template_id,error\n")
+	for _, failure := range failures {
+		fmt.Printf("%s,\"%s\",%s,\"%s\"\n",
+			failure.FailedAt.Format(time.RFC3339),
+			failure.Recipient,
+			failure.EmailTemplateID,
+			failure.Error)
+	}
+	return 0
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+)
+
+// ListSuspicious prints the rejected requests that looked like replay or tampering attempts,
+// oldest first.
+func ListSuspicious() (exitCode int) {
+	requests, err := datastore.ListSuspiciousRequests(nil)
+	if err != nil {
+		fmt.Printf("error listing suspicious requests: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("created_at,kind,fingerprint,ip_address,detail\n")
+	for _, request := range requests {
+		fmt.Printf("%s,%s,%s,%s,\"%s\"\n",
+			request.CreatedAt.Format(time.RFC3339),
+			request.Kind,
+			request.Fingerprint.Hex(),
+			request.IPAddress,
+			request.Detail)
+	}
+	return 0
+}
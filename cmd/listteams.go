@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fluidkeys/api/datastore"
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/team"
+)
+
+// ListTeams prints the UUID, name and number of members of every stored team, or with a
+// fingerprint, only the teams that key is an admin of.
+func ListTeams() (exitCode int) {
+	var teams []team.Team
+	var err error
+
+	switch len(os.Args) {
+	case 2:
+		teams, err = datastore.ListTeamRosters(nil)
+
+	case 3:
+		fingerprint, parseErr := fpr.Parse(os.Args[2])
+		if parseErr != nil {
+			fmt.Printf("invalid fingerprint: %v\n", parseErr)
+			return 1
+		}
+		teams, err = datastore.ListTeamsAdministeredBy(nil, fingerprint)
+
+	default:
+		fmt.Printf("Usage: list_teams [fingerprint]\n")
+		return 1
+	}
+
+	if err != nil {
+		fmt.Printf("error listing teams: %v\n", err)
+		return 1
+	}
+
+	for _, t := range teams {
+		fmt.Printf("%s  %-30s  %d members\n", t.UUID, t.Name, len(t.People))
+	}
+	fmt.Printf("%d teams\n", len(teams))
+	return 0
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+)
+
+// defaultJoinRequestMaxAgeDays is how old a request to join a team can get before
+// PruneJoinRequests deletes it, unless a different age is given.
+const defaultJoinRequestMaxAgeDays = 30
+
+// PruneJoinRequests deletes requests to join a team that are older than the given number of days
+// (default 30), so admins aren't shown requests that nobody's waiting on any more.
+func PruneJoinRequests() (exitCode int) {
+	maxAgeDays := defaultJoinRequestMaxAgeDays
+
+	if len(os.Args) == 3 {
+		days, err := strconv.Atoi(os.Args[2])
+		if err != nil || days < 1 {
+			fmt.Printf("invalid max age `%s`: must be a whole number of days\n", os.Args[2])
+			return 1
+		}
+		maxAgeDays = days
+	} else if len(os.Args) != 2 {
+		fmt.Printf("Usage: prune_join_requests [max_age_days]\n")
+		return 1
+	}
+
+	cutoff := time.Now().Add(-time.Duration(maxAgeDays*24) * time.Hour)
+
+	deleted, err := datastore.DeleteJoinRequestsOlderThan(nil, cutoff)
+	if err != nil {
+		fmt.Printf("error pruning requests to join teams: %v\n", err)
+		return 1
+	}
+	fmt.Printf("deleted %d requests to join teams older than %d days\n", deleted, maxAgeDays)
+	return 0
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+)
+
+// PurgeDeletedTeams deletes teams that were soft-deleted longer ago than
+// datastore.TeamDeletionGracePeriod. After this they can't be restored.
+func PurgeDeletedTeams() (exitCode int) {
+	purged, err := datastore.PurgeDeletedTeams(nil, time.Now())
+	if err != nil {
+		fmt.Printf("error purging deleted teams: %v\n", err)
+		return 1
+	}
+	fmt.Printf("purged %d deleted teams\n", purged)
+	return 0
+}
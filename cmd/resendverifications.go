@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fluidkeys/api/email"
+)
+
+// ResendVerifications sends another verification email to addresses on keys that were uploaded
+// but never verified.
+func ResendVerifications() (exitCode int) {
+	sent, err := email.ResendVerificationEmails(time.Now())
+	if err != nil {
+		fmt.Printf("error resending verification emails: %v\n", err)
+		return 1
+	}
+	fmt.Printf("resent %d verification emails\n", sent)
+	return 0
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/gofrs/uuid"
+)
+
+// RestoreTeam brings back a soft-deleted team, as long as it's within
+// datastore.TeamDeletionGracePeriod of being deleted.
+func RestoreTeam() (exitCode int) {
+	if len(os.Args) != 3 {
+		fmt.Printf("Usage: restore_team <team_uuid>\n")
+		return 1
+	}
+
+	teamUUID, err := uuid.FromString(os.Args[2])
+	if err != nil {
+		fmt.Printf("invalid team UUID: %v\n", err)
+		return 1
+	}
+
+	found, err := datastore.RestoreTeam(nil, teamUUID, time.Now())
+	if err != nil {
+		fmt.Printf("error restoring team: %v\n", err)
+		return 1
+	} else if !found {
+		fmt.Printf("no deleted team %s within the grace period of %s\n",
+			teamUUID, datastore.TeamDeletionGracePeriod)
+		return 1
+	}
+	fmt.Printf("restored team %s\n", teamUUID)
+	return 0
+}
@@ -7,9 +7,14 @@ import (
 )
 
 func SendEmails() (exitCode int) {
-	if err := email.SendFromCron(); err != nil {
+	summary, err := email.SendFromCron()
+	if err != nil {
 		fmt.Printf("error sending emails: %v\n", err)
-		return 1
+		exitCode = 1
 	}
-	return 0
+
+	if err := email.SendOpsSummary(summary); err != nil {
+		fmt.Printf("error sending ops summary: %v\n", err)
+	}
+	return exitCode
 }
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fluidkeys/api/datastore"
+)
+
+// SweepOrphans lists every email_key_link that isn't backed by a completed verification. With
+// --delete, it also deletes them, so those emails no longer resolve to a key.
+func SweepOrphans() (exitCode int) {
+	deleteLinks := false
+	if len(os.Args) == 3 && os.Args[2] == "--delete" {
+		deleteLinks = true
+	} else if len(os.Args) != 2 {
+		fmt.Printf("Usage: sweep_orphans [--delete]\n")
+		return 1
+	}
+
+	links, err := datastore.FindUnbackedEmailLinks(nil)
+	if err != nil {
+		fmt.Printf("error finding unbacked email links: %v\n", err)
+		return 1
+	}
+
+	var deleted int
+	for _, link := range links {
+		fmt.Printf("%s -> %s\n", link.Email, link.KeyFingerprint.Hex())
+
+		if !deleteLinks {
+			continue
+		}
+
+		found, err := datastore.DeleteUnbackedEmailLink(nil, link.Email)
+		if err != nil {
+			fmt.Printf("error deleting link for %s: %v\n", link.Email, err)
+			return 1
+		} else if found {
+			deleted++
+		}
+	}
+
+	if deleteLinks {
+		fmt.Printf("%d unbacked email links, %d deleted\n", len(links), deleted)
+	} else {
+		fmt.Printf("%d unbacked email links (run with --delete to delete them)\n", len(links))
+	}
+	return 0
+}
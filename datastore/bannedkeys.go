@@ -0,0 +1,56 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+)
+
+// BanKey blocks the key with the given fingerprint from being uploaded or looked up. Banning a
+// key that's already banned updates the reason but keeps the original time.
+// txn is a database transaction, or nil to run outside of a transaction
+func BanKey(txn *sql.Tx, fingerprint fpr.Fingerprint, reason string, now time.Time) error {
+	if reason == "" {
+		return fmt.Errorf("invalid reason: cannot be empty")
+	}
+
+	query := `INSERT INTO banned_keys(fingerprint, banned_at, reason)
+              VALUES ($1, $2, $3)
+              ON CONFLICT (fingerprint) DO UPDATE SET reason=EXCLUDED.reason`
+
+	if _, err := transactionOrDatabase(txn).Exec(
+		query, dbFormat(fingerprint), now, reason); err != nil {
+		return fmt.Errorf("error inserting into db: %v", err)
+	}
+	return nil
+}
+
+// UnbanKey lifts the ban on the key with the given fingerprint, returning found=false if it
+// wasn't banned.
+func UnbanKey(txn *sql.Tx, fingerprint fpr.Fingerprint) (found bool, err error) {
+	result, err := transactionOrDatabase(txn).Exec(
+		`DELETE FROM banned_keys WHERE fingerprint=$1`, dbFormat(fingerprint))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// IsKeyBanned returns true if the key with the given fingerprint has been banned.
+func IsKeyBanned(txn *sql.Tx, fingerprint fpr.Fingerprint) (bool, error) {
+	var banned bool
+	err := transactionOrDatabase(txn).QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM banned_keys WHERE fingerprint=$1)`, dbFormat(fingerprint),
+	).Scan(&banned)
+	if err != nil {
+		return false, err
+	}
+	return banned, nil
+}
@@ -0,0 +1,62 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestBanKey(t *testing.T) {
+	fingerprint := exampledata.ExampleFingerprint2
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+
+	_, err := UnbanKey(nil, fingerprint)
+	assert.NoError(t, err)
+	defer UnbanKey(nil, fingerprint)
+
+	t.Run("key isn't banned to begin with", func(t *testing.T) {
+		banned, err := IsKeyBanned(nil, fingerprint)
+		assert.NoError(t, err)
+		assert.Equal(t, false, banned)
+	})
+
+	t.Run("ban a key that isn't stored", func(t *testing.T) {
+		assert.NoError(t, BanKey(nil, fingerprint, "spam", now))
+
+		banned, err := IsKeyBanned(nil, fingerprint)
+		assert.NoError(t, err)
+		assert.Equal(t, true, banned)
+	})
+
+	t.Run("banning again is fine", func(t *testing.T) {
+		assert.NoError(t, BanKey(nil, fingerprint, "still spam", now.Add(time.Hour)))
+	})
+
+	t.Run("rejects empty reason", func(t *testing.T) {
+		assert.GotError(t, BanKey(nil, exampledata.ExampleFingerprint3, "", now))
+	})
+
+	t.Run("other keys aren't banned", func(t *testing.T) {
+		banned, err := IsKeyBanned(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+		assert.Equal(t, false, banned)
+	})
+
+	t.Run("unban", func(t *testing.T) {
+		found, err := UnbanKey(nil, fingerprint)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		banned, err := IsKeyBanned(nil, fingerprint)
+		assert.NoError(t, err)
+		assert.Equal(t, false, banned)
+	})
+
+	t.Run("unban a key that isn't banned", func(t *testing.T) {
+		found, err := UnbanKey(nil, fingerprint)
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+	})
+}
@@ -3,6 +3,7 @@ package datastore
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"os"
 	"strings"
 	"time"
@@ -62,35 +63,188 @@ func RunInTransaction(fn func(txn *sql.Tx) error) error {
 
 // UpsertPublicKey either inserts or updates a public key based on the
 // fingerprint. For updates, any foreign key relationships are maintained.
+// If StripThirdPartySignatures is set, certifications from other keys are removed before the
+// key is stored.
 // txn is a database transaction, or nil to run outside of a transaction
 func UpsertPublicKey(txn *sql.Tx, armoredPublicKey string) error {
-	key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+	row, err := makeKeyRow(armoredPublicKey)
 	if err != nil {
-		return fmt.Errorf("error loading armored key: %v", err)
+		return err
 	}
 
-	fingerprint := key.Fingerprint()
-
-	query := `INSERT INTO keys (fingerprint, armored_public_key)
-	          VALUES ($1, $2)
+	// a key that's uploaded again is no longer deleted
+	query := `WITH undeleted AS (DELETE FROM deleted_keys WHERE fingerprint=$1)
+	          INSERT INTO keys (fingerprint, armored_public_key, earliest_expiry)
+	          VALUES ($1, $2, COALESCE($3, 'infinity'::timestamp))
 		  ON CONFLICT (fingerprint) DO UPDATE
-		      SET armored_public_key=EXCLUDED.armored_public_key`
+		      SET armored_public_key=EXCLUDED.armored_public_key,
+		          earliest_expiry=EXCLUDED.earliest_expiry`
 
-	_, err = transactionOrDatabase(txn).Exec(query, dbFormat(fingerprint), armoredPublicKey)
+	_, err = transactionOrDatabase(txn).Exec(
+		query, dbFormat(row.fingerprint), row.armoredPublicKey, row.earliestExpiry,
+	)
 
 	return err
 }
 
-// DeletePublicKey deletes a key by its fingerprint, returning found=true if
-// a matching key was found and deleted.
-// Note that any linked emails and stored secrets will also be deleted.
-// If there was no matching key (e.g. it was already deleted), found is false
-// and error is nil.
-// An error is returned only if something failed e.g. a database error.
-func DeletePublicKey(fingerprint fpr.Fingerprint) (found bool, err error) {
-	query := `DELETE FROM keys WHERE keys.fingerprint=$1`
+// keyRow is a public key ready to be stored in the keys table.
+type keyRow struct {
+	fingerprint      fpr.Fingerprint
+	armoredPublicKey string
+	earliestExpiry   *time.Time
+}
+
+// makeKeyRow loads the armored public key and works out what to store for it, stripping third
+// party signatures if StripThirdPartySignatures is set.
+func makeKeyRow(armoredPublicKey string) (*keyRow, error) {
+	key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading armored key: %v", err)
+	}
+
+	if StripThirdPartySignatures {
+		armoredPublicKey, err = stripThirdPartySignatures(key, armoredPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("error stripping third party signatures: %v", err)
+		}
+	}
+
+	return &keyRow{
+		fingerprint:      key.Fingerprint(),
+		armoredPublicKey: armoredPublicKey,
+		earliestExpiry:   earliestExpiryForDB(key),
+	}, nil
+}
+
+// earliestExpiryForDB returns the key's earliest UID expiry in UTC, or nil if none of its UIDs
+// expire, which is stored as 'infinity'.
+func earliestExpiryForDB(key *pgpkey.PgpKey) *time.Time {
+	earliestExpiry := getEarliestExpiry(key)
+	if earliestExpiry == nil {
+		return nil
+	}
+	utc := earliestExpiry.UTC()
+	return &utc
+}
+
+// backfillEarliestExpiry works out earliest_expiry for any keys stored before it was added.
+// Keys that can't be parsed are marked with earliest_expiry_unknown so they're only tried once.
+// Their earliest_expiry stays NULL, so ListKeysExpiring and ForEachExpiredKey never see them.
+func backfillEarliestExpiry(txn *sql.Tx) error {
+	rows, err := transactionOrDatabase(txn).Query(
+		`SELECT id, armored_public_key
+		 FROM keys
+		 WHERE earliest_expiry IS NULL
+		 AND NOT earliest_expiry_unknown`)
+	if err != nil {
+		return err
+	}
+
+	armoredKeys := map[int]string{}
+	for rows.Next() {
+		var keyID int
+		var armoredPublicKey string
+		if err := rows.Scan(&keyID, &armoredPublicKey); err != nil {
+			rows.Close()
+			return err
+		}
+		armoredKeys[keyID] = armoredPublicKey
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	query := `UPDATE keys SET earliest_expiry=COALESCE($1, 'infinity'::timestamp) WHERE id=$2`
+	unknownQuery := `UPDATE keys SET earliest_expiry_unknown=TRUE WHERE id=$1`
+	skipped := 0
+
+	for keyID, armoredPublicKey := range armoredKeys {
+		key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+		if err != nil {
+			log.Printf("not backfilling earliest_expiry for key %d: %v", keyID, err)
+			if _, err := transactionOrDatabase(txn).Exec(unknownQuery, keyID); err != nil {
+				return err
+			}
+			skipped++
+			continue
+		}
 
-	result, err := db.Exec(query, dbFormat(fingerprint))
+		_, err = transactionOrDatabase(txn).Exec(query, earliestExpiryForDB(key), keyID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if skipped > 0 {
+		log.Printf("backfilled earliest_expiry for %d keys, skipped %d that couldn't be parsed",
+			len(armoredKeys)-skipped, skipped)
+	}
+	return nil
+}
+
+// SetBasicAuthPasswordHash stores the hash of the key's basic auth password, replacing any
+// existing password. It returns ErrNotFound if there's no such key.
+func SetBasicAuthPasswordHash(txn *sql.Tx, fingerprint fpr.Fingerprint, passwordHash string) error {
+	query := `UPDATE keys SET basic_auth_password_sha256=$1 WHERE fingerprint=$2`
+
+	result, err := transactionOrDatabase(txn).Exec(query, passwordHash, dbFormat(fingerprint))
+	if err != nil {
+		return err
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if numRowsAffected < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetBasicAuthPasswordHash returns the hash of the key's basic auth password. It returns
+// ErrNotFound if there's no such key or the key doesn't have a password.
+func GetBasicAuthPasswordHash(txn *sql.Tx, fingerprint fpr.Fingerprint) (string, error) {
+	query := `SELECT basic_auth_password_sha256 FROM keys WHERE fingerprint=$1`
+
+	var passwordHash *string
+	err := transactionOrDatabase(txn).QueryRow(query, dbFormat(fingerprint)).Scan(&passwordHash)
+	if err == sql.ErrNoRows || (err == nil && passwordHash == nil) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return *passwordHash, nil
+}
+
+// SetKeyCompromised records that the key's owner has reported it as compromised at the given
+// time. If it's already been reported, the original time is kept. It returns ErrNotFound if
+// there's no such key.
+func SetKeyCompromised(txn *sql.Tx, fingerprint fpr.Fingerprint, now time.Time) error {
+	query := `UPDATE keys SET compromised_at=COALESCE(compromised_at, $1) WHERE fingerprint=$2`
+
+	result, err := transactionOrDatabase(txn).Exec(query, now.UTC(), dbFormat(fingerprint))
+	if err != nil {
+		return err
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if numRowsAffected < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ClearKeyCompromised undoes SetKeyCompromised, for example if a key was reported by mistake.
+// It returns found=false if the key doesn't exist or hasn't been reported as compromised.
+func ClearKeyCompromised(txn *sql.Tx, fingerprint fpr.Fingerprint) (found bool, err error) {
+	query := `UPDATE keys SET compromised_at=NULL
+	          WHERE fingerprint=$1
+	          AND compromised_at IS NOT NULL`
+
+	result, err := transactionOrDatabase(txn).Exec(query, dbFormat(fingerprint))
 	if err != nil {
 		return false, err
 	}
@@ -99,12 +253,75 @@ func DeletePublicKey(fingerprint fpr.Fingerprint) (found bool, err error) {
 	if err != nil {
 		return false, err
 	}
+	return numRowsAffected > 0, nil
+}
 
-	if numRowsAffected < 1 {
-		return false, nil // not found (but no error)
+// GetKeyCompromisedAt returns when the key was reported as compromised, or nil if it hasn't
+// been. It returns ErrNotFound if there's no such key.
+func GetKeyCompromisedAt(txn *sql.Tx, fingerprint fpr.Fingerprint) (*time.Time, error) {
+	query := `SELECT compromised_at FROM keys WHERE fingerprint=$1`
+
+	var compromisedAt *time.Time
+	err := transactionOrDatabase(txn).QueryRow(query, dbFormat(fingerprint)).Scan(&compromisedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
 	}
+	return compromisedAt, nil
+}
 
-	return true, nil // found and deleted
+// DeletePublicKey deletes a key by its fingerprint, returning found=true if
+// a matching key was found and deleted.
+// Note that any linked emails and stored secrets will also be deleted:
+// secretsDeleted is how many secrets went with the key.
+// If there was no matching key (e.g. it was already deleted), found is false
+// and error is nil.
+// An error is returned only if something failed e.g. a database error.
+// txn is a database transaction, or nil to run in a new transaction
+func DeletePublicKey(txn *sql.Tx, fingerprint fpr.Fingerprint) (
+	found bool, secretsDeleted int, err error) {
+
+	if txn == nil {
+		err = RunInTransaction(func(txn *sql.Tx) (err error) {
+			found, secretsDeleted, err = deletePublicKey(txn, fingerprint)
+			return err
+		})
+	} else {
+		found, secretsDeleted, err = deletePublicKey(txn, fingerprint)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return found, secretsDeleted, nil
+}
+
+func deletePublicKey(txn *sql.Tx, fingerprint fpr.Fingerprint) (
+	found bool, secretsDeleted int, err error) {
+
+	// lock the key so no secrets can be sent to it between counting and deleting
+	var keyID int
+	err = txn.QueryRow(
+		`SELECT id FROM keys WHERE fingerprint=$1 FOR UPDATE`, dbFormat(fingerprint),
+	).Scan(&keyID)
+	if err == sql.ErrNoRows {
+		return false, 0, nil // not found (but no error)
+	} else if err != nil {
+		return false, 0, err
+	}
+
+	err = txn.QueryRow(
+		`SELECT COUNT(*) FROM secrets WHERE recipient_key_id=$1`, keyID,
+	).Scan(&secretsDeleted)
+	if err != nil {
+		return false, 0, fmt.Errorf("error counting secrets: %v", err)
+	}
+
+	// secrets are deleted by the ON DELETE CASCADE
+	if _, err := txn.Exec(`DELETE FROM keys WHERE id=$1`, keyID); err != nil {
+		return false, 0, err
+	}
+	return true, secretsDeleted, nil
 }
 
 // LinkEmailToFingerprint records that the given public key should be returned
@@ -151,34 +368,107 @@ func QueryEmailVerifiedForFingerprint(txn *sql.Tx, email string, fingerprint fpr
 	return count > 0, nil
 }
 
+// EmailFingerprint is an email address and the key fingerprint it might be verified for.
+type EmailFingerprint struct {
+	Email       string
+	Fingerprint fpr.Fingerprint
+}
+
+// QueryEmailsVerified is the batch version of QueryEmailVerifiedForFingerprint: it returns a map
+// containing every given pair, set to true if the email is verified for the fingerprint.
+// It makes a single query, however many pairs are given.
+func QueryEmailsVerified(txn *sql.Tx, pairs []EmailFingerprint) (map[EmailFingerprint]bool, error) {
+	verified := make(map[EmailFingerprint]bool)
+	if len(pairs) == 0 {
+		return verified, nil
+	}
+
+	values := []string{}
+	args := []interface{}{}
+
+	for i, pair := range pairs {
+		verified[pair] = false
+
+		values = append(values,
+			fmt.Sprintf("(%d, $%d::citext, $%d)", i, len(args)+1, len(args)+2))
+		args = append(args, pair.Email, dbFormat(pair.Fingerprint))
+	}
+
+	query := `SELECT pairs.idx
+              FROM (VALUES ` + strings.Join(values, ", ") + `) AS pairs(idx, email, fingerprint)
+              INNER JOIN keys ON keys.fingerprint = pairs.fingerprint
+              INNER JOIN email_key_link ON email_key_link.key_id = keys.id
+                                       AND email_key_link.email = pairs.email`
+
+	rows, err := transactionOrDatabase(txn).Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var idx int
+		if err := rows.Scan(&idx); err != nil {
+			return nil, err
+		}
+		verified[pairs[idx]] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return verified, nil
+}
+
 // GetArmoredPublicKeyForEmail returns an ASCII-armored public key for the given email, if the
-// email address has been verified.
+// email address has been verified. If NormalizeDottedEmails is on, an address that only differs
+// by dots in its local part is found too.
 func GetArmoredPublicKeyForEmail(txn *sql.Tx, email string) (
 	armoredPublicKey string, found bool, err error) {
 
-	query := `SELECT email_key_link.email,
-	                 keys.armored_public_key
-		  FROM email_key_link
-		  LEFT JOIN keys ON email_key_link.key_id = keys.id
-		  WHERE email_key_link.email=$1`
+	armoredPublicKey, _, found, err = GetArmoredPublicKeyAndVerifiedAtForEmail(txn, email)
+	return armoredPublicKey, found, err
+}
+
+// GetArmoredPublicKeyAndVerifiedAtForEmail is like GetArmoredPublicKeyForEmail, but also returns
+// when the email was verified for the key, from the verification behind the email_key_link.
+// That's when the link was opened, or for verifications from before that was recorded, when it
+// was sent. verifiedAt is nil if the link isn't backed by a verification.
+func GetArmoredPublicKeyAndVerifiedAtForEmail(txn *sql.Tx, email string) (
+	armoredPublicKey string, verifiedAt *time.Time, found bool, err error) {
 
 	var gotEmail string
 
-	err = transactionOrDatabase(txn).QueryRow(query, email).Scan(&gotEmail, &armoredPublicKey)
+	err = transactionOrDatabase(txn).QueryRow(
+		getArmoredPublicKeyForEmailQuery, email,
+	).Scan(&gotEmail, &armoredPublicKey, &verifiedAt)
 	if err == sql.ErrNoRows {
-		return "", false, nil // return found=false without an error
+		gotEmail, armoredPublicKey, verifiedAt, found, err = getArmoredPublicKeyForDottedEmail(
+			txn, email)
+		if err != nil || !found {
+			return "", nil, false, err // found=false without an error, unless there was one
+		}
 
 	} else if err != nil {
-		return "", false, err
+		return "", nil, false, err
 	}
 
-	if strings.ToLower(email) != strings.ToLower(gotEmail) {
-		return "", false, fmt.Errorf("queried for '%s', got back '%s'", email, gotEmail)
+	if !emailMatches(email, gotEmail) {
+		return "", nil, false, fmt.Errorf("queried for '%s', got back '%s'", email, gotEmail)
 	}
 
-	return armoredPublicKey, true, nil
+	return armoredPublicKey, verifiedAt, true, nil
 }
 
+const getArmoredPublicKeyForEmailQuery = `SELECT email_key_link.email,
+	                 keys.armored_public_key,
+	                 COALESCE(email_verifications.verified_at, email_verifications.created_at)
+		  FROM email_key_link
+		  LEFT JOIN keys ON email_key_link.key_id = keys.id
+		  LEFT JOIN email_verifications
+		         ON email_key_link.email_verification_uuid = email_verifications.uuid
+		  WHERE email_key_link.email=$1`
+
 // GetArmoredPublicKeyForFingerprint returns an ASCII-armored public key for the given fingerprint,
 // regardless of whether the email addresses in the key have been verified.
 func GetArmoredPublicKeyForFingerprint(fingerprint fpr.Fingerprint) (armoredPublicKey string, found bool, err error) {
@@ -246,19 +536,126 @@ func CreateVerification(
 	return &secretUUID, err
 }
 
-// MarkVerificationAsVerified sets the user agent and IP address from the verifying HTTP request.
+// MarkVerificationAsVerified sets the user agent and IP address from the verifying HTTP request,
+// and the time it was verified.
 // Typically this is a browser from someone opening a link in their email.
 func MarkVerificationAsVerified(txn *sql.Tx, secretUUID uuid.UUID,
-	userAgent string, ipAddress string) error {
+	userAgent string, ipAddress string, now time.Time) error {
 
 	query := `UPDATE email_verifications
-		         SET (verify_user_agent, verify_ip_address) = ($2, $3)
+		         SET (verify_user_agent, verify_ip_address, verified_at) = ($2, $3, $4)
 			 WHERE uuid=$1`
 
-	_, err := transactionOrDatabase(txn).Exec(query, secretUUID, userAgent, ipAddress)
+	_, err := transactionOrDatabase(txn).Exec(query, secretUUID, userAgent, ipAddress, now)
+	return err
+}
+
+// ExpireVerification makes the email_verification with the given secret UUID expire now, for
+// example because its email couldn't be sent. Another verification can then be sent to the
+// address without waiting for this one to run out.
+func ExpireVerification(txn *sql.Tx, secretUUID uuid.UUID, now time.Time) error {
+	query := `UPDATE email_verifications
+	          SET valid_until = $2
+	          WHERE uuid = $1
+	          AND valid_until > $2`
+
+	_, err := transactionOrDatabase(txn).Exec(query, secretUUID, now)
 	return err
 }
 
+// ListVerificationsForKey returns every email verification created for the given key, newest
+// first, including ones that were never completed or have expired. It's the key owner's audit
+// trail of which emails have been linked to their key, and from where.
+func ListVerificationsForKey(txn *sql.Tx, fingerprint fpr.Fingerprint) ([]EmailVerification, error) {
+	query := `SELECT uuid,
+                     email_sent_to,
+                     key_fingerprint,
+                     created_at,
+                     upsert_user_agent,
+                     host(upsert_ip_address),
+                     verified_at,
+                     verify_user_agent,
+                     host(verify_ip_address)
+              FROM email_verifications
+              WHERE key_fingerprint=$1
+              ORDER BY created_at DESC`
+
+	rows, err := transactionOrDatabase(txn).Query(query, dbFormat(fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifications := []EmailVerification{}
+	for rows.Next() {
+		v := EmailVerification{}
+		var fingerprintString string
+
+		err := rows.Scan(
+			&v.UUID, &v.EmailSentTo, &fingerprintString, &v.CreatedAt,
+			&v.UpsertUserAgent, &v.UpsertIPAddress,
+			&v.VerifiedAt, &v.VerifyUserAgent, &v.VerifyIPAddress,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		v.KeyFingerprint, err = parseDbFormat(fingerprintString)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing fingerprint '%s': %v", fingerprintString, err)
+		}
+		verifications = append(verifications, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return verifications, nil
+}
+
+// GetVerificationHistoryForEmail returns every completed verification of the given email address,
+// oldest first, whichever key it was for. Since an email can be re-linked to a new key, this is
+// the history of the keys the email has been verified for.
+func GetVerificationHistoryForEmail(txn *sql.Tx, email string) ([]EmailVerification, error) {
+	query := `SELECT uuid,
+                     email_sent_to,
+                     key_fingerprint,
+                     created_at,
+                     verified_at
+              FROM email_verifications
+              WHERE lower(email_sent_to)=lower($1)
+              AND verify_ip_address IS NOT NULL
+              ORDER BY created_at`
+
+	rows, err := transactionOrDatabase(txn).Query(query, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifications := []EmailVerification{}
+	for rows.Next() {
+		v := EmailVerification{}
+		var fingerprintString string
+
+		err := rows.Scan(
+			&v.UUID, &v.EmailSentTo, &fingerprintString, &v.CreatedAt, &v.VerifiedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		v.KeyFingerprint, err = parseDbFormat(fingerprintString)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing fingerprint '%s': %v", fingerprintString, err)
+		}
+		verifications = append(verifications, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return verifications, nil
+}
+
 // GetVerification returns the email and fingerprint of a currently-active email_verification
 // for the given secret UUID token.
 func GetVerification(txn *sql.Tx, secretUUID uuid.UUID, now time.Time) (*EmailVerification, error) {
@@ -290,6 +687,34 @@ func GetVerification(txn *sql.Tx, secretUUID uuid.UUID, now time.Time) (*EmailVe
 	return &v, nil
 }
 
+// GetVerificationTimeForEmailFingerprint returns the time of the most recent completed
+// verification of the given email address for the given fingerprint. A verification is completed
+// when someone opens the link we emailed them, so the returned time is when that link was opened.
+// Verifications completed before we recorded verified_at fall back to when the link was sent.
+// If the email has never been verified for the fingerprint, ErrNotFound is returned.
+func GetVerificationTimeForEmailFingerprint(txn *sql.Tx, email string, fingerprint fpr.Fingerprint) (
+	*time.Time, error) {
+
+	query := `SELECT COALESCE(verified_at, created_at)
+              FROM email_verifications
+              WHERE lower(email_sent_to)=lower($1)
+              AND key_fingerprint=$2
+              AND verify_ip_address IS NOT NULL
+              ORDER BY COALESCE(verified_at, created_at) DESC
+              LIMIT 1`
+
+	var verifiedAt time.Time
+
+	err := transactionOrDatabase(txn).QueryRow(query, email, dbFormat(fingerprint)).Scan(&verifiedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &verifiedAt, nil
+}
+
 // HasActiveVerificationForEmail returns whether we recently sent a
 // verification email to the given email address, and if that verification
 // is still valid, e.g. not expired
@@ -308,6 +733,22 @@ func HasActiveVerificationForEmail(txn *sql.Tx, email string) (bool, error) {
 	return count > 0, nil
 }
 
+// GetActiveVerificationValidUntil returns when the active email_verification for the given email
+// expires, or nil if there isn't one at the given time.
+func GetActiveVerificationValidUntil(txn *sql.Tx, email string, now time.Time) (*time.Time, error) {
+	query := `SELECT MAX(valid_until)
+	          FROM email_verifications
+	          WHERE email_sent_to=$1
+		  AND valid_until > $2`
+
+	var validUntil *time.Time
+	err := transactionOrDatabase(txn).QueryRow(query, email, now).Scan(&validUntil)
+	if err != nil {
+		return nil, err
+	}
+	return validUntil, nil
+}
+
 func getKeyIDForFingerprint(txn *sql.Tx, fingerprint fpr.Fingerprint) (keyID int64, found bool, err error) {
 	query := `SELECT keys.id FROM keys WHERE fingerprint=$1`
 
@@ -325,6 +766,14 @@ func getKeyIDForFingerprint(txn *sql.Tx, fingerprint fpr.Fingerprint) (keyID int
 // CreateSecret stores the armoredEncryptedSecret (which must be encrypted to
 // the given `recipientFingerprint`) against the recipient public key.
 func CreateSecret(recipientFingerprint fpr.Fingerprint, armoredEncryptedSecret string, now time.Time) (*uuid.UUID, error) {
+	return CreateSecretWithSenderHint(recipientFingerprint, armoredEncryptedSecret, "", now)
+}
+
+// CreateSecretWithSenderHint is like CreateSecret, but also stores armoredEncryptedSenderHint,
+// a message from the sender (also encrypted to the recipient) saying who the secret is from.
+// An empty armoredEncryptedSenderHint means there's no hint.
+func CreateSecretWithSenderHint(recipientFingerprint fpr.Fingerprint, armoredEncryptedSecret string,
+	armoredEncryptedSenderHint string, now time.Time) (*uuid.UUID, error) {
 	secretUUID, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
@@ -344,8 +793,13 @@ func CreateSecret(recipientFingerprint fpr.Fingerprint, armoredEncryptedSecret s
                       recipient_key_id,
                       uuid,
                       created_at,
-                      armored_encrypted_secret)
-                  VALUES ($1, $2, $3, $4)`
+                      armored_encrypted_secret,
+                      size_bytes,
+                      size_bytes_unknown,
+                      encrypted_sender_hint)
+                  VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	sizeBytes := secretSizeForDB(armoredEncryptedSecret)
 
 	_, err = db.Exec(
 		query,
@@ -353,6 +807,9 @@ func CreateSecret(recipientFingerprint fpr.Fingerprint, armoredEncryptedSecret s
 		secretUUID,
 		createdAt,
 		armoredEncryptedSecret,
+		sizeBytes,
+		sizeBytes == nil,
+		sql.NullString{String: armoredEncryptedSenderHint, Valid: armoredEncryptedSenderHint != ""},
 	)
 	if err != nil {
 		return nil, err
@@ -364,12 +821,44 @@ func CreateSecret(recipientFingerprint fpr.Fingerprint, armoredEncryptedSecret s
 func GetSecrets(recipientFingerprint fpr.Fingerprint) ([]*secret, error) {
 	secrets := make([]*secret, 0)
 
-	query := `SELECT secrets.armored_encrypted_secret, secrets.uuid
+	rows, err := db.Query(getSecretsQuery, dbFormat(recipientFingerprint))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		secret := secret{}
+		err = rows.Scan(&secret.ArmoredEncryptedSecret, &secret.SecretUUID, &secret.SizeBytes,
+			&secret.ArmoredEncryptedSenderHint)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, &secret)
+	}
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+const getSecretsQuery = `SELECT secrets.armored_encrypted_secret, secrets.uuid,
+	                 COALESCE(secrets.size_bytes, 0),
+	                 COALESCE(secrets.encrypted_sender_hint, '')
 	          FROM secrets
 		  LEFT JOIN keys ON secrets.recipient_key_id=keys.id
 		  WHERE keys.fingerprint=$1`
 
-	rows, err := db.Query(query, dbFormat(recipientFingerprint))
+// GetSecretsMetadata returns a slice of secrets for the given public key fingerprint with only
+// their SecretUUID, CreatedAt and SizeBytes populated, avoiding loading the armored encrypted
+// secrets.
+func GetSecretsMetadata(recipientFingerprint fpr.Fingerprint) ([]*secret, error) {
+	secrets := make([]*secret, 0)
+
+	rows, err := db.Query(getSecretsMetadataQuery, dbFormat(recipientFingerprint))
 	if err != nil {
 		return nil, err
 	}
@@ -377,7 +866,8 @@ func GetSecrets(recipientFingerprint fpr.Fingerprint) ([]*secret, error) {
 
 	for rows.Next() {
 		secret := secret{}
-		err = rows.Scan(&secret.ArmoredEncryptedSecret, &secret.SecretUUID)
+		err = rows.Scan(&secret.SecretUUID, &secret.CreatedAt, &secret.SizeBytes,
+			&secret.ArmoredEncryptedSenderHint)
 		if err != nil {
 			return nil, err
 		}
@@ -392,16 +882,118 @@ func GetSecrets(recipientFingerprint fpr.Fingerprint) ([]*secret, error) {
 	return secrets, nil
 }
 
+const getSecretsMetadataQuery = `SELECT secrets.uuid, secrets.created_at,
+	                 COALESCE(secrets.size_bytes, 0),
+	                 COALESCE(secrets.encrypted_sender_hint, '')
+	          FROM secrets
+		  LEFT JOIN keys ON secrets.recipient_key_id=keys.id
+		  WHERE keys.fingerprint=$1`
+
+// CountSecretsForRecipient returns how many secrets are stored for the given public key
+// fingerprint.
+func CountSecretsForRecipient(txn *sql.Tx, recipientFingerprint fpr.Fingerprint) (int, error) {
+	query := `SELECT COUNT(*)
+	          FROM secrets
+	          JOIN keys ON secrets.recipient_key_id=keys.id
+	          WHERE keys.fingerprint=$1`
+
+	var count int
+	err := transactionOrDatabase(txn).QueryRow(query, dbFormat(recipientFingerprint)).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// SecretInbox summarises the secrets stored for a public key
+type SecretInbox struct {
+	// Count is how many secrets are stored
+	Count int
+
+	// OldestCreatedAt is when the oldest stored secret was sent, or nil if there aren't any
+	OldestCreatedAt *time.Time
+
+	// TotalSizeBytes is the total size of the secrets once their ASCII armor is decoded. Secrets
+	// whose size isn't known yet count as 0.
+	TotalSizeBytes int
+}
+
+// SecretInboxSummary returns the number, age and size of the secrets stored for the given
+// public key fingerprint, without loading the secrets themselves.
+func SecretInboxSummary(txn *sql.Tx, recipientFingerprint fpr.Fingerprint) (*SecretInbox, error) {
+	query := `SELECT COUNT(*),
+	                 MIN(secrets.created_at),
+	                 COALESCE(SUM(secrets.size_bytes), 0)
+	          FROM secrets
+	          JOIN keys ON secrets.recipient_key_id=keys.id
+	          WHERE keys.fingerprint=$1`
+
+	inbox := SecretInbox{}
+	err := transactionOrDatabase(txn).QueryRow(query, dbFormat(recipientFingerprint)).Scan(
+		&inbox.Count, &inbox.OldestCreatedAt, &inbox.TotalSizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &inbox, nil
+}
+
+// OldSecret identifies a stored secret by its UUID and recipient, without its content.
+type OldSecret struct {
+	UUID                 uuid.UUID
+	RecipientFingerprint fpr.Fingerprint
+	CreatedAt            time.Time
+}
+
+// ListOldSecrets returns every secret that was sent before olderThan and hasn't been picked up
+// and deleted, oldest first. It doesn't delete anything, so operators can see what a cleanup
+// would purge.
+func ListOldSecrets(txn *sql.Tx, olderThan time.Time) ([]OldSecret, error) {
+	query := `SELECT secrets.uuid, keys.fingerprint, secrets.created_at
+	          FROM secrets
+	          JOIN keys ON secrets.recipient_key_id = keys.id
+	          WHERE secrets.created_at < $1
+	          ORDER BY secrets.created_at, secrets.id`
+
+	rows, err := transactionOrDatabase(txn).Query(query, olderThan)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := []OldSecret{}
+	for rows.Next() {
+		secret := OldSecret{}
+		var dbFingerprint string
+		if err := rows.Scan(&secret.UUID, &dbFingerprint, &secret.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		secret.RecipientFingerprint, err = parseDbFormat(dbFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
 // DeleteSecret deletes the given secret (by UUID) if the recipientFingerprint matches the secret,
 // or returns an error if not.
-func DeleteSecret(secretUUID uuid.UUID, recipientFingerprint fpr.Fingerprint) (found bool, err error) {
+// txn is a database transaction, or nil to run outside of a transaction
+func DeleteSecret(txn *sql.Tx, secretUUID uuid.UUID, recipientFingerprint fpr.Fingerprint) (
+	found bool, err error) {
+
 	query := `DELETE FROM secrets
 	          USING keys
 	          WHERE secrets.recipient_key_id = keys.id
 	          AND secrets.uuid=$1
 		  AND keys.fingerprint=$2`
 
-	result, err := db.Exec(query, secretUUID, dbFormat(recipientFingerprint))
+	result, err := transactionOrDatabase(txn).Exec(
+		query, secretUUID, dbFormat(recipientFingerprint))
 	if err != nil {
 		return false, err
 	}
@@ -418,6 +1010,10 @@ func DeleteSecret(secretUUID uuid.UUID, recipientFingerprint fpr.Fingerprint) (f
 	return true, nil // found and deleted
 }
 
+// ErrSingleUseUUIDAlreadyUsed is returned by VerifySingleUseNumberNotStored if the UUID has
+// been stored before.
+var ErrSingleUseUUIDAlreadyUsed = fmt.Errorf("single use UUID already used")
+
 // VerifySingleUseNumberNotStored returns an error if the given singleUseUUID already exists in
 // the database
 func VerifySingleUseNumberNotStored(singleUseUUID uuid.UUID) error {
@@ -430,7 +1026,7 @@ func VerifySingleUseNumberNotStored(singleUseUUID uuid.UUID) error {
 	}
 
 	if count > 0 {
-		return fmt.Errorf("single use UUID already used")
+		return ErrSingleUseUUIDAlreadyUsed
 	}
 
 	return nil
@@ -473,6 +1069,14 @@ func Migrate() error {
 		}
 	}
 
+	if err := backfillEarliestExpiry(tx); err != nil {
+		return fmt.Errorf("error backfilling earliest_expiry (rolling back everything): %v", err)
+	}
+
+	if err := backfillSecretSizes(tx); err != nil {
+		return fmt.Errorf("error backfilling secret size_bytes (rolling back everything): %v", err)
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -532,19 +1136,66 @@ type txDbInterface interface {
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 }
 
+// fingerprintVersions maps the length in bytes of a fingerprint to the OpenPGP key version
+// stored as its prefix in the database. fpr.Fingerprint only holds 20-byte (v4) fingerprints,
+// so longer v5 / v6 fingerprints can be added here once it supports them.
+var fingerprintVersions = map[int]string{
+	20: "4",
+}
+
+// dbFormat returns the fingerprint as stored in the database, prefixed with its key version,
+// e.g. '4:AAAABBBBCCCCDDDDEEEEFFFF0000111122223333'
 func dbFormat(fingerprint fpr.Fingerprint) string {
-	return fmt.Sprintf("4:%s", fingerprint.Hex())
+	bytes := fingerprint.Bytes()
+	return fmt.Sprintf("%s:%s", fingerprintVersions[len(bytes)], fingerprint.Hex())
 }
 
-// returns a fingerprint.Fingerprint from e.g '4:'
+// returns a fingerprint.Fingerprint from e.g '4:AAAABBBBCCCCDDDDEEEEFFFF0000111122223333'
 func parseDbFormat(fingerprint string) (fpr.Fingerprint, error) {
-	return fpr.Parse(fingerprint[2:])
+	parts := strings.SplitN(fingerprint, ":", 2)
+	if len(parts) != 2 {
+		return fpr.Fingerprint{}, fmt.Errorf("missing version prefix in stored fingerprint '%s'", fingerprint)
+	}
+
+	version, hexFingerprint := parts[0], parts[1]
+	if !isSupportedFingerprintVersion(version) {
+		return fpr.Fingerprint{}, fmt.Errorf("unsupported fingerprint version '%s'", version)
+	}
+
+	parsed, err := fpr.Parse(hexFingerprint)
+	if err != nil {
+		return fpr.Fingerprint{}, err
+	}
+
+	bytes := parsed.Bytes()
+	if fingerprintVersions[len(bytes)] != version {
+		return fpr.Fingerprint{}, fmt.Errorf(
+			"fingerprint of %d bytes doesn't match version '%s'", len(bytes), version)
+	}
+	return parsed, nil
+}
+
+func isSupportedFingerprintVersion(version string) bool {
+	for _, supported := range fingerprintVersions {
+		if version == supported {
+			return true
+		}
+	}
+	return false
 }
 
 type secret struct {
 	ArmoredEncryptedSecret string
 	SecretUUID             string
 	CreatedAt              time.Time
+
+	// SizeBytes is the length of the secret once its ASCII armor is decoded, or 0 if that
+	// couldn't be worked out
+	SizeBytes int
+
+	// ArmoredEncryptedSenderHint is an optional message from the sender, encrypted to the
+	// recipient, saying who the secret is from. It's empty if the sender didn't give one.
+	ArmoredEncryptedSenderHint string
 }
 
 // EmailVerification represents the data in the email_verifications database table
@@ -552,4 +1203,17 @@ type EmailVerification struct {
 	UUID           *uuid.UUID
 	EmailSentTo    string
 	KeyFingerprint fingerprint.Fingerprint
+
+	// The fields below are only populated by ListVerificationsForKey, except for CreatedAt and
+	// VerifiedAt which GetVerificationHistoryForEmail also populates
+
+	CreatedAt       time.Time
+	UpsertUserAgent *string
+	UpsertIPAddress *string
+
+	// VerifiedAt, VerifyUserAgent and VerifyIPAddress are nil if the verification was never
+	// completed. VerifiedAt is also nil for verifications completed before it was recorded.
+	VerifiedAt      *time.Time
+	VerifyUserAgent *string
+	VerifyIPAddress *string
 }
@@ -1,11 +1,15 @@
 package datastore
 
 import (
+	"bytes"
+	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/fluidkeys/crypto/openpgp/armor"
 	"github.com/fluidkeys/fluidkeys/assert"
 	"github.com/fluidkeys/fluidkeys/exampledata"
 	"github.com/gofrs/uuid"
@@ -118,10 +122,28 @@ func TestEmailVerificationFunctions(t *testing.T) {
 
 		assert.Equal(t, "test@example.com", v.EmailSentTo)
 		assert.Equal(t, exampledata.ExampleFingerprint2, v.KeyFingerprint)
+
+		t.Run("still valid just before expiry", func(t *testing.T) {
+			justBeforeExpiry := now.Add(time.Duration(15)*time.Minute - time.Second)
+			_, err := GetVerification(nil, *verificationUUID, justBeforeExpiry)
+			assert.NoError(t, err)
+		})
+
+		t.Run("not found just after expiry", func(t *testing.T) {
+			justAfterExpiry := now.Add(time.Duration(15)*time.Minute + time.Second)
+			v, err := GetVerification(nil, *verificationUUID, justAfterExpiry)
+			assert.GotError(t, err)
+			if v != nil {
+				t.Fatalf("expected nil verification, got %v", v)
+			}
+		})
 	})
 
+	verifiedAt := now.Add(10 * time.Minute)
+
 	t.Run("test MarkVerificationAsVerified", func(t *testing.T) {
-		err := MarkVerificationAsVerified(nil, *verificationUUID, "fake user agent 2", "1.1.1.1")
+		err := MarkVerificationAsVerified(
+			nil, *verificationUUID, "fake user agent 2", "1.1.1.1", verifiedAt)
 		assert.NoError(t, err)
 
 		query := `SELECT
@@ -142,6 +164,37 @@ func TestEmailVerificationFunctions(t *testing.T) {
 		assert.Equal(t, "fake user agent 2", *verifyUserAgent)
 		assert.Equal(t, "1.1.1.1", *verifyIPAddress)
 	})
+
+	t.Run("test GetVerificationTimeForEmailFingerprint", func(t *testing.T) {
+		t.Run("returns time the link was opened, not sent", func(t *testing.T) {
+			got, err := GetVerificationTimeForEmailFingerprint(nil, email, fingerprint)
+			assert.NoError(t, err)
+			assertEqualTime(t, verifiedAt, *got)
+		})
+
+		t.Run("is case insensitive for email", func(t *testing.T) {
+			got, err := GetVerificationTimeForEmailFingerprint(
+				nil, "TEST@example.com", fingerprint)
+			assert.NoError(t, err)
+			assertEqualTime(t, verifiedAt, *got)
+		})
+
+		t.Run("returns ErrNotFound for a different fingerprint", func(t *testing.T) {
+			_, err := GetVerificationTimeForEmailFingerprint(
+				nil, email, exampledata.ExampleFingerprint3)
+			assert.Equal(t, ErrNotFound, err)
+		})
+
+		t.Run("returns ErrNotFound for an unverified email", func(t *testing.T) {
+			_, err := CreateVerification(
+				nil, "unverified@example.com", fingerprint, "fake user agent", "0.0.0.0", now,
+			)
+			assert.NoError(t, err)
+
+			_, err = GetVerificationTimeForEmailFingerprint(nil, "unverified@example.com", fingerprint)
+			assert.Equal(t, ErrNotFound, err)
+		})
+	})
 }
 
 func assertEqualTime(t *testing.T, expected time.Time, got time.Time) {
@@ -151,6 +204,145 @@ func assertEqualTime(t *testing.T, expected time.Time, got time.Time) {
 	}
 }
 
+func TestListVerificationsForKey(t *testing.T) {
+	sentAt := time.Date(2019, 7, 3, 9, 0, 0, 0, time.UTC)
+	verifiedAt := sentAt.Add(5 * time.Minute)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	defer DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+
+	verificationUUID, err := CreateVerification(
+		nil, "list1@example.com", exampledata.ExampleFingerprint3, "uploader", "10.0.0.1", sentAt,
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, MarkVerificationAsVerified(
+		nil, *verificationUUID, "browser", "10.0.0.2", verifiedAt))
+
+	_, err = CreateVerification(
+		nil, "list2@example.com", exampledata.ExampleFingerprint3, "uploader", "10.0.0.3",
+		sentAt.Add(time.Hour),
+	)
+	assert.NoError(t, err)
+
+	verifications, err := ListVerificationsForKey(nil, exampledata.ExampleFingerprint3)
+	assert.NoError(t, err)
+
+	// other tests may leave verifications for the same key behind, so look for ours by email
+	found := map[string]EmailVerification{}
+	position := map[string]int{}
+	for i, v := range verifications {
+		assert.Equal(t, exampledata.ExampleFingerprint3, v.KeyFingerprint)
+		found[v.EmailSentTo] = v
+		position[v.EmailSentTo] = i
+	}
+
+	t.Run("newer verification comes first", func(t *testing.T) {
+		if _, got := found["list1@example.com"]; !got {
+			t.Fatalf("list1@example.com missing from %v", verifications)
+		}
+		if _, got := found["list2@example.com"]; !got {
+			t.Fatalf("list2@example.com missing from %v", verifications)
+		}
+		if position["list2@example.com"] > position["list1@example.com"] {
+			t.Fatalf("expected list2@example.com before list1@example.com")
+		}
+	})
+
+	t.Run("completed verification has verify fields", func(t *testing.T) {
+		v := found["list1@example.com"]
+		if v.VerifiedAt == nil || !verifiedAt.Equal(*v.VerifiedAt) {
+			t.Fatalf("expected VerifiedAt=%v, got %v", verifiedAt, v.VerifiedAt)
+		}
+		if v.VerifyIPAddress == nil || *v.VerifyIPAddress != "10.0.0.2" {
+			t.Fatalf("expected VerifyIPAddress=10.0.0.2, got %v", v.VerifyIPAddress)
+		}
+		if v.UpsertIPAddress == nil || *v.UpsertIPAddress != "10.0.0.1" {
+			t.Fatalf("expected UpsertIPAddress=10.0.0.1, got %v", v.UpsertIPAddress)
+		}
+	})
+
+	t.Run("incomplete verification has no verify fields", func(t *testing.T) {
+		v := found["list2@example.com"]
+		if v.VerifiedAt != nil || v.VerifyIPAddress != nil || v.VerifyUserAgent != nil {
+			t.Fatalf("expected verify fields to be nil, got %+v", v)
+		}
+	})
+}
+
+func TestGetVerificationHistoryForEmail(t *testing.T) {
+	email := "history@example.com"
+	firstSentAt := time.Date(2019, 7, 3, 9, 0, 0, 0, time.UTC)
+	secondSentAt := firstSentAt.Add(24 * time.Hour)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	defer DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	defer DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+
+	// created out of order, to check the result is sorted
+	secondUUID, err := CreateVerification(
+		nil, email, exampledata.ExampleFingerprint4, "uploader", "10.0.0.1", secondSentAt,
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, MarkVerificationAsVerified(
+		nil, *secondUUID, "browser", "10.0.0.2", secondSentAt.Add(time.Minute)))
+
+	firstUUID, err := CreateVerification(
+		nil, "History@Example.com", exampledata.ExampleFingerprint3, "uploader", "10.0.0.1",
+		firstSentAt,
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, MarkVerificationAsVerified(
+		nil, *firstUUID, "browser", "10.0.0.2", firstSentAt.Add(time.Minute)))
+
+	_, err = CreateVerification(
+		nil, email, exampledata.ExampleFingerprint3, "uploader", "10.0.0.1",
+		secondSentAt.Add(time.Hour),
+	)
+	assert.NoError(t, err)
+
+	history, err := GetVerificationHistoryForEmail(nil, email)
+	assert.NoError(t, err)
+
+	// verifications outlive their keys, so earlier test runs leave theirs behind: look for ours
+	// by UUID
+	position := map[uuid.UUID]int{}
+	for i, v := range history {
+		if !strings.EqualFold(v.EmailSentTo, email) {
+			t.Fatalf("got verification for another email: %v", v)
+		}
+		if v.VerifiedAt == nil {
+			t.Fatalf("got incomplete verification: %v", v)
+		}
+		position[*v.UUID] = i
+	}
+
+	t.Run("includes completed verifications", func(t *testing.T) {
+		for _, u := range []uuid.UUID{*firstUUID, *secondUUID} {
+			if _, got := position[u]; !got {
+				t.Fatalf("verification %s missing from %v", u, history)
+			}
+		}
+	})
+
+	t.Run("oldest verification comes first", func(t *testing.T) {
+		for i := 1; i < len(history); i++ {
+			if history[i].CreatedAt.Before(history[i-1].CreatedAt) {
+				t.Fatalf("history isn't in chronological order: %v", history)
+			}
+		}
+		first := history[position[*firstUUID]]
+		assert.Equal(t, exampledata.ExampleFingerprint3, first.KeyFingerprint)
+		assertEqualTime(t, firstSentAt, first.CreatedAt)
+	})
+
+	t.Run("populates VerifiedAt", func(t *testing.T) {
+		second := history[position[*secondUUID]]
+		assert.Equal(t, exampledata.ExampleFingerprint4, second.KeyFingerprint)
+		assertEqualTime(t, secondSentAt.Add(time.Minute), *second.VerifiedAt)
+	})
+}
+
 func TestLinkEmailToFingerprint(t *testing.T) {
 	email := "test@example.com"
 	fingerprint := exampledata.ExampleFingerprint2
@@ -166,7 +358,8 @@ func TestLinkEmailToFingerprint(t *testing.T) {
 
 	assert.NoError(t, err)
 
-	err = MarkVerificationAsVerified(nil, *verificationUUID, "fake user agent 2", "1.1.1.1")
+	err = MarkVerificationAsVerified(
+		nil, *verificationUUID, "fake user agent 2", "1.1.1.1", now)
 	assert.NoError(t, err)
 
 	err = LinkEmailToFingerprint(nil, email, fingerprint, verificationUUID)
@@ -262,3 +455,558 @@ func TestLinkEmailToFingerprint(t *testing.T) {
 	})
 
 }
+
+func TestDeletePublicKey(t *testing.T) {
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+
+	t.Run("counts the secrets deleted with the key", func(t *testing.T) {
+		assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+		assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+		defer func() {
+			_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+			assert.NoError(t, err)
+		}()
+
+		for i := 0; i < 3; i++ {
+			_, err := CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", now)
+			assert.NoError(t, err)
+		}
+		// a secret for a different key shouldn't be counted (or deleted)
+		_, err := CreateSecret(exampledata.ExampleFingerprint3, "fake-secret", now)
+		assert.NoError(t, err)
+
+		found, secretsDeleted, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+		assert.Equal(t, 3, secretsDeleted)
+
+		otherSecrets, err := GetSecrets(exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(otherSecrets))
+	})
+
+	t.Run("counts no secrets for a key without any", func(t *testing.T) {
+		assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+
+		found, secretsDeleted, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+		assert.Equal(t, 0, secretsDeleted)
+	})
+
+	t.Run("returns not found for a key that isn't stored", func(t *testing.T) {
+		found, secretsDeleted, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+		assert.Equal(t, 0, secretsDeleted)
+	})
+
+	t.Run("in a transaction that's rolled back, the key isn't deleted", func(t *testing.T) {
+		assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+		defer func() {
+			_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+			assert.NoError(t, err)
+		}()
+
+		errRollback := fmt.Errorf("roll back")
+		err := RunInTransaction(func(txn *sql.Tx) error {
+			found, _, err := DeletePublicKey(txn, exampledata.ExampleFingerprint2)
+			assert.NoError(t, err)
+			assert.Equal(t, true, found)
+			return errRollback
+		})
+		assert.Equal(t, errRollback, err)
+
+		_, found, err := GetArmoredPublicKeyForFingerprint(exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+	})
+}
+
+func TestDeleteSecret(t *testing.T) {
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+	}()
+
+	t.Run("outside a transaction", func(t *testing.T) {
+		secretUUID, err := CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", now)
+		assert.NoError(t, err)
+
+		found, err := DeleteSecret(nil, *secretUUID, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		count, err := CountSecretsForRecipient(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	t.Run("for a different recipient isn't found", func(t *testing.T) {
+		secretUUID, err := CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", now)
+		assert.NoError(t, err)
+		defer DeleteSecret(nil, *secretUUID, exampledata.ExampleFingerprint2)
+
+		found, err := DeleteSecret(nil, *secretUUID, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+	})
+
+	t.Run("in a transaction that's rolled back, the secret isn't deleted", func(t *testing.T) {
+		secretUUID, err := CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", now)
+		assert.NoError(t, err)
+		defer DeleteSecret(nil, *secretUUID, exampledata.ExampleFingerprint2)
+
+		errRollback := fmt.Errorf("roll back")
+		err = RunInTransaction(func(txn *sql.Tx) error {
+			found, err := DeleteSecret(txn, *secretUUID, exampledata.ExampleFingerprint2)
+			assert.NoError(t, err)
+			assert.Equal(t, true, found)
+			return errRollback
+		})
+		assert.Equal(t, errRollback, err)
+
+		count, err := CountSecretsForRecipient(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestListOldSecrets(t *testing.T) {
+	// well before the secrets made by other tests, so they aren't listed
+	cutoff := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		_, _, err = DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+	}()
+
+	oldest, err := CreateSecret(
+		exampledata.ExampleFingerprint3, "fake-secret", cutoff.Add(-30*24*time.Hour))
+	assert.NoError(t, err)
+	old, err := CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", cutoff.Add(-time.Hour))
+	assert.NoError(t, err)
+	_, err = CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", cutoff)
+	assert.NoError(t, err)
+	_, err = CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", cutoff.Add(time.Hour))
+	assert.NoError(t, err)
+
+	t.Run("lists secrets older than the cutoff, oldest first", func(t *testing.T) {
+		secrets, err := ListOldSecrets(nil, cutoff)
+		assert.NoError(t, err)
+		if len(secrets) != 2 {
+			t.Fatalf("expected 2 old secrets, got %d", len(secrets))
+		}
+
+		assert.Equal(t, *oldest, secrets[0].UUID)
+		assert.Equal(t, exampledata.ExampleFingerprint3, secrets[0].RecipientFingerprint)
+		assert.Equal(t, true, cutoff.Add(-30*24*time.Hour).Equal(secrets[0].CreatedAt))
+
+		assert.Equal(t, *old, secrets[1].UUID)
+		assert.Equal(t, exampledata.ExampleFingerprint2, secrets[1].RecipientFingerprint)
+		assert.Equal(t, true, cutoff.Add(-time.Hour).Equal(secrets[1].CreatedAt))
+	})
+
+	t.Run("doesn't delete them", func(t *testing.T) {
+		count, err := CountSecretsForRecipient(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("lists none before the oldest", func(t *testing.T) {
+		secrets, err := ListOldSecrets(nil, cutoff.Add(-365*24*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(secrets))
+	})
+}
+
+func TestCountSecretsForRecipient(t *testing.T) {
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		_, _, err = DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+	}()
+
+	t.Run("zero for a key without secrets", func(t *testing.T) {
+		count, err := CountSecretsForRecipient(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", now)
+		assert.NoError(t, err)
+	}
+	_, err := CreateSecret(exampledata.ExampleFingerprint3, "fake-secret", now)
+	assert.NoError(t, err)
+
+	t.Run("counts only the recipient's secrets", func(t *testing.T) {
+		count, err := CountSecretsForRecipient(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("zero for a key that isn't stored", func(t *testing.T) {
+		count, err := CountSecretsForRecipient(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+}
+
+func TestSecretInboxSummary(t *testing.T) {
+	older := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+	}()
+
+	t.Run("empty inbox", func(t *testing.T) {
+		inbox, err := SecretInboxSummary(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, SecretInbox{}, *inbox)
+	})
+
+	_, err := CreateSecret(exampledata.ExampleFingerprint2, armorPayload(t, 250), newer)
+	assert.NoError(t, err)
+	_, err = CreateSecret(exampledata.ExampleFingerprint2, armorPayload(t, 100), older)
+	assert.NoError(t, err)
+	// invalid armor, so its size isn't known
+	_, err = CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", newer)
+	assert.NoError(t, err)
+
+	t.Run("with secrets", func(t *testing.T) {
+		inbox, err := SecretInboxSummary(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+
+		assert.Equal(t, 3, inbox.Count)
+		assert.Equal(t, 350, inbox.TotalSizeBytes)
+		if inbox.OldestCreatedAt == nil || !older.Equal(*inbox.OldestCreatedAt) {
+			t.Fatalf("expected OldestCreatedAt=%v, got %v", older, inbox.OldestCreatedAt)
+		}
+	})
+
+	t.Run("for a key that isn't stored", func(t *testing.T) {
+		inbox, err := SecretInboxSummary(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, inbox.Count)
+	})
+}
+
+// armorPayload returns an ASCII-armored PGP message of numBytes bytes once it's decoded. The
+// payload isn't a real encrypted message.
+func armorPayload(t *testing.T, numBytes int) string {
+	t.Helper()
+	armored := new(bytes.Buffer)
+	writer, err := armor.Encode(armored, "PGP MESSAGE", nil)
+	assert.NoError(t, err)
+	_, err = writer.Write(bytes.Repeat([]byte{0xAB}, numBytes))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return armored.String()
+}
+
+func TestCreateSecretWithSenderHint(t *testing.T) {
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+	}()
+
+	withHint, err := CreateSecretWithSenderHint(
+		exampledata.ExampleFingerprint2, "fake-secret", "fake-hint", now)
+	assert.NoError(t, err)
+	withoutHint, err := CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", now)
+	assert.NoError(t, err)
+
+	expected := map[string]string{
+		withHint.String():    "fake-hint",
+		withoutHint.String(): "",
+	}
+
+	t.Run("GetSecrets returns the hint", func(t *testing.T) {
+		secrets, err := GetSecrets(exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(secrets))
+		for _, s := range secrets {
+			assert.Equal(t, expected[s.SecretUUID], s.ArmoredEncryptedSenderHint)
+		}
+	})
+
+	t.Run("GetSecretsMetadata returns the hint", func(t *testing.T) {
+		secrets, err := GetSecretsMetadata(exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(secrets))
+		for _, s := range secrets {
+			assert.Equal(t, expected[s.SecretUUID], s.ArmoredEncryptedSenderHint)
+		}
+	})
+}
+
+func TestQueryEmailsVerified(t *testing.T) {
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	assert.NoError(t,
+		LinkEmailToFingerprint(nil, "test2@example.com", exampledata.ExampleFingerprint2, nil))
+
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		_, _, err = DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+	}()
+
+	verifiedPair := EmailFingerprint{"test2@example.com", exampledata.ExampleFingerprint2}
+	uppercasePair := EmailFingerprint{"TEST2@example.com", exampledata.ExampleFingerprint2}
+	wrongKeyPair := EmailFingerprint{"test2@example.com", exampledata.ExampleFingerprint3}
+	unverifiedPair := EmailFingerprint{"test3@example.com", exampledata.ExampleFingerprint3}
+	missingKeyPair := EmailFingerprint{"test4@example.com", exampledata.ExampleFingerprint4}
+
+	t.Run("with a mix of verified and unverified pairs", func(t *testing.T) {
+		got, err := QueryEmailsVerified(nil, []EmailFingerprint{
+			verifiedPair, uppercasePair, wrongKeyPair, unverifiedPair, missingKeyPair,
+		})
+		assert.NoError(t, err)
+
+		expected := map[EmailFingerprint]bool{
+			verifiedPair:   true,
+			uppercasePair:  true,
+			wrongKeyPair:   false,
+			unverifiedPair: false,
+			missingKeyPair: false,
+		}
+		assert.Equal(t, expected, got)
+	})
+
+	t.Run("with no pairs", func(t *testing.T) {
+		got, err := QueryEmailsVerified(nil, []EmailFingerprint{})
+		assert.NoError(t, err)
+		assert.Equal(t, map[EmailFingerprint]bool{}, got)
+	})
+}
+
+func TestBasicAuthPasswordHash(t *testing.T) {
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+	}()
+
+	t.Run("GetBasicAuthPasswordHash returns ErrNotFound before a password is set", func(t *testing.T) {
+		_, err := GetBasicAuthPasswordHash(nil, exampledata.ExampleFingerprint2)
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("set then get password hash", func(t *testing.T) {
+		assert.NoError(t, SetBasicAuthPasswordHash(nil, exampledata.ExampleFingerprint2, "hash1"))
+		assert.NoError(t, SetBasicAuthPasswordHash(nil, exampledata.ExampleFingerprint2, "hash2"))
+
+		got, err := GetBasicAuthPasswordHash(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, "hash2", got)
+	})
+
+	t.Run("upserting the key again keeps the password hash", func(t *testing.T) {
+		assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+
+		got, err := GetBasicAuthPasswordHash(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, "hash2", got)
+	})
+
+	t.Run("SetBasicAuthPasswordHash returns ErrNotFound for a missing key", func(t *testing.T) {
+		err := SetBasicAuthPasswordHash(nil, exampledata.ExampleFingerprint4, "hash")
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestParseDbFormat(t *testing.T) {
+	t.Run("parses a version 4 fingerprint", func(t *testing.T) {
+		got, err := parseDbFormat(dbFormat(exampledata.ExampleFingerprint4))
+		assert.NoError(t, err)
+		assert.Equal(t, exampledata.ExampleFingerprint4, got)
+	})
+
+	t.Run("rejects a v5 fingerprint until fpr.Fingerprint can hold one", func(t *testing.T) {
+		v5 := "5:" + strings.Repeat("AB", 32)
+		_, err := parseDbFormat(v5)
+		assert.GotError(t, err)
+	})
+
+	malformed := []struct {
+		name  string
+		input string
+	}{
+		{"empty string", ""},
+		{"single character", "4"},
+		{"missing version prefix", exampledata.ExampleFingerprint4.Hex()},
+		{"wrong version prefix", "5:" + exampledata.ExampleFingerprint4.Hex()},
+		{"empty version prefix", ":" + exampledata.ExampleFingerprint4.Hex()},
+		{"prefix only", "4:"},
+		{"bad hex", "4:not-a-fingerprint"},
+	}
+
+	for _, test := range malformed {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseDbFormat(test.input)
+			assert.GotError(t, err)
+		})
+	}
+}
+
+func TestEarliestExpiry(t *testing.T) {
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	defer func() {
+		_, err := db.Exec("DELETE FROM keys")
+		assert.NoError(t, err)
+	}()
+
+	// key 2's UIDs expire, key 4's don't
+	key2Expiry := time.Date(2038, 9, 7, 9, 59, 49, 0, time.UTC)
+
+	assertEarliestExpiry := func(t *testing.T) {
+		t.Helper()
+
+		var got time.Time
+		err := db.QueryRow(`SELECT earliest_expiry FROM keys WHERE fingerprint=$1`,
+			dbFormat(exampledata.ExampleFingerprint2)).Scan(&got)
+		assert.NoError(t, err)
+		if !key2Expiry.Equal(got) {
+			t.Fatalf("expected earliest_expiry %v, got %v", key2Expiry, got)
+		}
+
+		var isInfinity bool
+		err = db.QueryRow(`SELECT earliest_expiry = 'infinity' FROM keys WHERE fingerprint=$1`,
+			dbFormat(exampledata.ExampleFingerprint4)).Scan(&isInfinity)
+		assert.NoError(t, err)
+		assert.Equal(t, true, isInfinity)
+	}
+
+	t.Run("upsert populates earliest_expiry", func(t *testing.T) {
+		assertEarliestExpiry(t)
+	})
+
+	t.Run("upsert updates earliest_expiry", func(t *testing.T) {
+		expiredKey := makeExpiredPublicKey(t, exampledata.ExamplePrivateKey4, "test4")
+		assert.NoError(t, UpsertPublicKey(nil, expiredKey))
+		defer func() { assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4)) }()
+
+		var got time.Time
+		err := db.QueryRow(`SELECT earliest_expiry FROM keys WHERE fingerprint=$1`,
+			dbFormat(exampledata.ExampleFingerprint4)).Scan(&got)
+		assert.NoError(t, err)
+		expected := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+		if !expected.Equal(got) {
+			t.Fatalf("expected earliest_expiry %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("backfill populates missing earliest_expiry", func(t *testing.T) {
+		_, err := db.Exec(`UPDATE keys SET earliest_expiry=NULL`)
+		assert.NoError(t, err)
+
+		assert.NoError(t, backfillEarliestExpiry(nil))
+		assertEarliestExpiry(t)
+	})
+
+	t.Run("backfill marks keys that can't be parsed", func(t *testing.T) {
+		_, err := db.Exec(
+			`UPDATE keys SET armored_public_key='not a key', earliest_expiry=NULL
+			 WHERE fingerprint=$1`, dbFormat(exampledata.ExampleFingerprint4))
+		assert.NoError(t, err)
+		defer func() { assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4)) }()
+
+		assert.NoError(t, backfillEarliestExpiry(nil))
+
+		var earliestExpiry *time.Time
+		var earliestExpiryUnknown bool
+		err = db.QueryRow(
+			`SELECT earliest_expiry, earliest_expiry_unknown FROM keys WHERE fingerprint=$1`,
+			dbFormat(exampledata.ExampleFingerprint4)).Scan(&earliestExpiry, &earliestExpiryUnknown)
+		assert.NoError(t, err)
+		if earliestExpiry != nil {
+			t.Fatalf("expected nil earliest_expiry, got %v", *earliestExpiry)
+		}
+		assert.Equal(t, true, earliestExpiryUnknown)
+	})
+}
+
+func TestKeyCompromised(t *testing.T) {
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+	}()
+
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+	later := now.Add(time.Duration(1) * time.Hour)
+
+	t.Run("GetKeyCompromisedAt returns nil for a key that hasn't been reported", func(t *testing.T) {
+		compromisedAt, err := GetKeyCompromisedAt(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		if compromisedAt != nil {
+			t.Fatalf("expected compromisedAt=nil, got %v", compromisedAt)
+		}
+	})
+
+	t.Run("reporting twice keeps the first time", func(t *testing.T) {
+		assert.NoError(t, SetKeyCompromised(nil, exampledata.ExampleFingerprint2, now))
+		assert.NoError(t, SetKeyCompromised(nil, exampledata.ExampleFingerprint2, later))
+
+		compromisedAt, err := GetKeyCompromisedAt(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		if compromisedAt == nil || !now.Equal(*compromisedAt) {
+			t.Fatalf("expected compromisedAt=%v, got %v", now, compromisedAt)
+		}
+	})
+
+	t.Run("upserting the key again keeps it compromised", func(t *testing.T) {
+		assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+
+		compromisedAt, err := GetKeyCompromisedAt(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		if compromisedAt == nil {
+			t.Fatalf("expected key to still be compromised")
+		}
+	})
+
+	t.Run("clearing it un-compromises the key", func(t *testing.T) {
+		found, err := ClearKeyCompromised(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		compromisedAt, err := GetKeyCompromisedAt(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		if compromisedAt != nil {
+			t.Fatalf("expected compromisedAt=nil, got %v", compromisedAt)
+		}
+
+		found, err = ClearKeyCompromised(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+	})
+
+	t.Run("missing key returns ErrNotFound", func(t *testing.T) {
+		assert.Equal(t, ErrNotFound, SetKeyCompromised(nil, exampledata.ExampleFingerprint4, now))
+
+		_, err := GetKeyCompromisedAt(nil, exampledata.ExampleFingerprint4)
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
@@ -0,0 +1,63 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+)
+
+// DeletedKey is a key that the server deleted, remembered so that lookups can tell clients
+// it's gone.
+type DeletedKey struct {
+	Fingerprint fpr.Fingerprint
+	DeletedAt   time.Time
+	Reason      string
+}
+
+// DeletePublicKeyWithReason is like DeletePublicKey, but also remembers that the key was
+// deleted, and why, for GetDeletedKey.
+func DeletePublicKeyWithReason(fingerprint fpr.Fingerprint, reason string, now time.Time) (
+	found bool, secretsDeleted int, err error) {
+
+	if reason == "" {
+		return false, 0, fmt.Errorf("invalid reason: cannot be empty")
+	}
+
+	err = RunInTransaction(func(txn *sql.Tx) (err error) {
+		found, secretsDeleted, err = deletePublicKey(txn, fingerprint)
+		if err != nil || !found {
+			return err
+		}
+
+		query := `INSERT INTO deleted_keys(fingerprint, deleted_at, reason)
+                  VALUES ($1, $2, $3)
+                  ON CONFLICT (fingerprint) DO UPDATE
+                  SET deleted_at=EXCLUDED.deleted_at, reason=EXCLUDED.reason`
+
+		if _, err := txn.Exec(query, dbFormat(fingerprint), now, reason); err != nil {
+			return fmt.Errorf("error inserting into deleted_keys: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return found, secretsDeleted, nil
+}
+
+// GetDeletedKey returns when and why the key with the given fingerprint was deleted, or
+// ErrNotFound if it wasn't, or has been uploaded again since.
+func GetDeletedKey(txn *sql.Tx, fingerprint fpr.Fingerprint) (*DeletedKey, error) {
+	deletedKey := DeletedKey{Fingerprint: fingerprint}
+	err := transactionOrDatabase(txn).QueryRow(
+		`SELECT deleted_at, reason FROM deleted_keys WHERE fingerprint=$1`, dbFormat(fingerprint),
+	).Scan(&deletedKey.DeletedAt, &deletedKey.Reason)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &deletedKey, nil
+}
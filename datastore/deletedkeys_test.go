@@ -0,0 +1,71 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestDeletePublicKeyWithReason(t *testing.T) {
+	fingerprint := exampledata.ExampleFingerprint4
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	defer DeletePublicKey(nil, fingerprint)
+
+	t.Run("stored key isn't deleted", func(t *testing.T) {
+		_, err := GetDeletedKey(nil, fingerprint)
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("rejects empty reason", func(t *testing.T) {
+		_, _, err := DeletePublicKeyWithReason(fingerprint, "", now)
+		assert.GotError(t, err)
+	})
+
+	t.Run("deletes the key and remembers why", func(t *testing.T) {
+		found, _, err := DeletePublicKeyWithReason(fingerprint, "key expired", now)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		_, found, err = GetArmoredPublicKeyForFingerprint(fingerprint)
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+
+		deletedKey, err := GetDeletedKey(nil, fingerprint)
+		assert.NoError(t, err)
+		assert.Equal(t, "key expired", deletedKey.Reason)
+		assertEqualTime(t, now, deletedKey.DeletedAt)
+	})
+
+	t.Run("key that was never stored isn't remembered", func(t *testing.T) {
+		found, _, err := DeletePublicKeyWithReason(
+			exampledata.ExampleFingerprint3, "key expired", now)
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+
+		_, err = GetDeletedKey(nil, exampledata.ExampleFingerprint3)
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("uploading the key again forgets the deletion", func(t *testing.T) {
+		assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+
+		_, err := GetDeletedKey(nil, fingerprint)
+		assert.Equal(t, ErrNotFound, err)
+	})
+
+	t.Run("bulk upload forgets the deletion too", func(t *testing.T) {
+		_, _, err := DeletePublicKeyWithReason(fingerprint, "key expired", now)
+		assert.NoError(t, err)
+
+		keyErrors, err := UpsertPublicKeys(nil, []string{exampledata.ExamplePublicKey4})
+		assert.NoError(t, err)
+		assert.NoError(t, keyErrors[0])
+
+		_, err = GetDeletedKey(nil, fingerprint)
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// EmailFailure is an email that couldn't be delivered.
+type EmailFailure struct {
+	FailedAt        time.Time
+	Recipient       string
+	EmailTemplateID string
+	Error           string
+}
+
+// RecordEmailFailure records that the given email template couldn't be delivered to recipient.
+// txn is a database transaction, or nil to run outside of a transaction. Pass nil if the
+// failure happened inside a transaction that's about to be rolled back, otherwise the record
+// is rolled back too.
+func RecordEmailFailure(
+	txn *sql.Tx, emailTemplateID string, recipient string, sendErr error, now time.Time) error {
+
+	if recipient == "" {
+		return fmt.Errorf("invalid recipient: cannot be empty")
+	}
+	if sendErr == nil {
+		return fmt.Errorf("invalid sendErr: cannot be nil")
+	}
+
+	query := `INSERT INTO email_failures(
+                  failed_at,
+                  recipient,
+                  email_template_id,
+                  error
+              )
+              VALUES ($1, $2, $3, $4)`
+
+	_, err := transactionOrDatabase(txn).Exec(
+		query, now, recipient, emailTemplateID, sendErr.Error(),
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting into db: %v", err)
+	}
+	return nil
+}
+
+// ListEmailFailures returns all the recorded email failures, oldest first.
+func ListEmailFailures(txn *sql.Tx) ([]EmailFailure, error) {
+	query := `SELECT failed_at,
+                     recipient,
+                     email_template_id,
+                     error
+              FROM email_failures
+              ORDER BY failed_at, id`
+
+	rows, err := transactionOrDatabase(txn).Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []EmailFailure{}
+	for rows.Next() {
+		failure := EmailFailure{}
+		err := rows.Scan(
+			&failure.FailedAt, &failure.Recipient, &failure.EmailTemplateID, &failure.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
@@ -0,0 +1,49 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestRecordEmailFailure(t *testing.T) {
+	defer func() {
+		_, err := db.Exec("DELETE FROM email_failures")
+		assert.NoError(t, err)
+	}()
+
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+	later := now.Add(time.Duration(10) * time.Minute)
+
+	t.Run("records failures, returned oldest first", func(t *testing.T) {
+		assert.NoError(t, RecordEmailFailure(
+			nil, "template_2", "second@example.com", fmt.Errorf("554 rejected"), later))
+		assert.NoError(t, RecordEmailFailure(
+			nil, "template_1", "first@example.com", fmt.Errorf("550 no such user"), now))
+
+		failures, err := ListEmailFailures(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(failures))
+
+		if !now.Equal(failures[0].FailedAt) {
+			t.Fatalf("expected FailedAt=%v, got %v", now, failures[0].FailedAt)
+		}
+		assert.Equal(t, "first@example.com", failures[0].Recipient)
+		assert.Equal(t, "template_1", failures[0].EmailTemplateID)
+		assert.Equal(t, "550 no such user", failures[0].Error)
+
+		assert.Equal(t, "second@example.com", failures[1].Recipient)
+	})
+
+	t.Run("rejects empty recipient", func(t *testing.T) {
+		err := RecordEmailFailure(nil, "template_1", "", fmt.Errorf("failed"), now)
+		assert.Equal(t, fmt.Errorf("invalid recipient: cannot be empty"), err)
+	})
+
+	t.Run("rejects nil error", func(t *testing.T) {
+		err := RecordEmailFailure(nil, "template_1", "test@example.com", nil, now)
+		assert.Equal(t, fmt.Errorf("invalid sendErr: cannot be nil"), err)
+	})
+}
@@ -0,0 +1,83 @@
+package datastore
+
+import (
+	"database/sql"
+
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+)
+
+// UnbackedEmailLink is an email_key_link row with no verification behind it: its
+// email_verification_uuid is null and there's no completed verification of the email for
+// the linked key.
+type UnbackedEmailLink struct {
+	Email          string
+	KeyFingerprint fpr.Fingerprint
+}
+
+// unbackedEmailLinkCondition selects email_key_link rows that aren't backed by a completed
+// verification.
+const unbackedEmailLinkCondition = `email_key_link.email_verification_uuid IS NULL
+              AND NOT EXISTS (
+                  SELECT 1
+                  FROM email_verifications
+                  WHERE email_verifications.key_id = email_key_link.key_id
+                  AND email_verifications.email_sent_to = email_key_link.email
+                  AND email_verifications.verify_ip_address IS NOT NULL
+              )`
+
+// FindUnbackedEmailLinks returns every email_key_link that isn't backed by a completed
+// verification, ordered by email. These can appear when a verification is deleted (the link's
+// email_verification_uuid is set to null) or after a manual edit to the database.
+func FindUnbackedEmailLinks(txn *sql.Tx) ([]UnbackedEmailLink, error) {
+	query := `SELECT email_key_link.email,
+                     keys.fingerprint
+              FROM email_key_link
+              JOIN keys ON keys.id = email_key_link.key_id
+              WHERE ` + unbackedEmailLinkCondition + `
+              ORDER BY email_key_link.email`
+
+	rows, err := transactionOrDatabase(txn).Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []UnbackedEmailLink{}
+	for rows.Next() {
+		link := UnbackedEmailLink{}
+		var dbFingerprint string
+		if err := rows.Scan(&link.Email, &dbFingerprint); err != nil {
+			return nil, err
+		}
+
+		link.KeyFingerprint, err = parseDbFormat(dbFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// DeleteUnbackedEmailLink deletes the email_key_link for the given email, but only if it still
+// isn't backed by a completed verification, so a link verified since FindUnbackedEmailLinks
+// ran is left alone. It returns found=false if there was nothing to delete.
+func DeleteUnbackedEmailLink(txn *sql.Tx, email string) (found bool, err error) {
+	query := `DELETE FROM email_key_link
+              WHERE email_key_link.email = $1
+              AND ` + unbackedEmailLinkCondition
+
+	result, err := transactionOrDatabase(txn).Exec(query, email)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,86 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestFindUnbackedEmailLinks(t *testing.T) {
+	fingerprint := exampledata.ExampleFingerprint2
+
+	// start without any links left over from other tests
+	_, _, err := DeletePublicKey(nil, fingerprint)
+	assert.NoError(t, err)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+	}()
+
+	// linkVerified creates a completed verification for the email and links it to the key,
+	// recording the verification's UUID on the link only if withUUID is true.
+	linkVerified := func(email string, withUUID bool) {
+		verificationUUID, err := CreateVerification(
+			nil, email, fingerprint, "fake user agent", "0.0.0.0", now)
+		assert.NoError(t, err)
+		assert.NoError(t, MarkVerificationAsVerified(
+			nil, *verificationUUID, "fake user agent", "0.0.0.0", now))
+
+		if withUUID {
+			assert.NoError(t, LinkEmailToFingerprint(nil, email, fingerprint, verificationUUID))
+		} else {
+			assert.NoError(t, LinkEmailToFingerprint(nil, email, fingerprint, nil))
+		}
+	}
+
+	linkVerified("backed@example.com", true)
+	linkVerified("backed-without-uuid@example.com", false)
+	assert.NoError(t, LinkEmailToFingerprint(nil, "unbacked@example.com", fingerprint, nil))
+
+	// findUnbacked returns the unbacked links for this test's key, ignoring other keys'
+	findUnbacked := func() map[string]UnbackedEmailLink {
+		links, err := FindUnbackedEmailLinks(nil)
+		assert.NoError(t, err)
+
+		found := map[string]UnbackedEmailLink{}
+		for _, link := range links {
+			if link.KeyFingerprint == fingerprint {
+				found[link.Email] = link
+			}
+		}
+		return found
+	}
+
+	t.Run("finds only the link without a completed verification", func(t *testing.T) {
+		assert.Equal(t, map[string]UnbackedEmailLink{
+			"unbacked@example.com": {Email: "unbacked@example.com", KeyFingerprint: fingerprint},
+		}, findUnbacked())
+	})
+
+	t.Run("doesn't delete a backed link", func(t *testing.T) {
+		found, err := DeleteUnbackedEmailLink(nil, "backed-without-uuid@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+
+		_, found, err = GetArmoredPublicKeyForEmail(nil, "backed-without-uuid@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+	})
+
+	t.Run("deletes an unbacked link", func(t *testing.T) {
+		found, err := DeleteUnbackedEmailLink(nil, "unbacked@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		assert.Equal(t, 0, len(findUnbacked()))
+	})
+
+	t.Run("returns found=false for a link that doesn't exist", func(t *testing.T) {
+		found, err := DeleteUnbackedEmailLink(nil, "unbacked@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+	})
+}
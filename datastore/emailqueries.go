@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"sort"
-	"strings"
 	"time"
 
 	"github.com/fluidkeys/fluidkeys/pgpkey"
@@ -18,15 +17,15 @@ type keyExpiring = struct {
 	PrimaryEmail    string
 }
 
-// ListKeysExpiring lists keys expiring in the next 15 days
+// ListKeysExpiring lists keys expiring in the next 15 days. Keys with a NULL earliest_expiry
+// (see backfillEarliestExpiry) aren't included.
 func ListKeysExpiring() (keys []keyExpiring, err error) {
-	query := `SELECT keys.id,
-                     keys.armored_public_key,
-                     email_key_link.email
-              FROM email_key_link
-              INNER JOIN keys                ON email_key_link.key_id = keys.id`
+	now := time.Now()
+	day := time.Duration(24) * time.Hour
+
+	fifteenDaysFromNow := now.Add(15 * day)
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(listKeysExpiringQuery, now.UTC(), fifteenDaysFromNow.UTC())
 	if err != nil {
 		return nil, err
 	}
@@ -65,18 +64,13 @@ func ListKeysExpiring() (keys []keyExpiring, err error) {
 			continue
 		}
 
-		now := time.Now()
-		day := time.Duration(24) * time.Hour
-
-		fifteenDaysFromNow := now.Add(15 * day)
-
 		if nextExpiry.Before(now) || nextExpiry.After(fifteenDaysFromNow) {
 			continue
 		}
 
 		daysUntilExpiry := int(nextExpiry.Sub(now).Round(day).Seconds() / 86400)
 
-		profile, err := loadUserProfile(nil, keyID)
+		profile, err := loadKeyWithProfile(nil, keyID)
 		if err != nil {
 			log.Printf("%s can't load user profile: %v", key.Fingerprint().Hex(), err)
 			continue
@@ -99,51 +93,125 @@ func ListKeysExpiring() (keys []keyExpiring, err error) {
 	return keys, nil
 }
 
-type expiredKey = struct {
+const listKeysExpiringQuery = `SELECT keys.id,
+                                      keys.armored_public_key,
+                                      email_key_link.email
+                               FROM email_key_link
+                               INNER JOIN keys ON email_key_link.key_id = keys.id
+                               WHERE keys.earliest_expiry BETWEEN $1 AND $2`
+
+// ExpiredKey is a PGP key that has expired, with its email addresses split by whether they're
+// verified.
+type ExpiredKey struct {
 	UserProfile      *UserProfile
 	VerifiedEmails   []string
 	UnverifiedEmails []string
 }
 
+// expiredKeysPageSize is how many keys ForEachExpiredKey reads from the database at a time.
+var expiredKeysPageSize = 500
+
 // ListExpiredKeys returns all PGP keys that have expired. It populates VerifiedEmail with any
 // email on the key that's verified, preferably the "primary" UID but falling back to any
 // verified email.
-func ListExpiredKeys() (expiredKeys []expiredKey, err error) {
+// Prefer ForEachExpiredKey, which doesn't hold every expired key in memory at once.
+func ListExpiredKeys() (expiredKeys []ExpiredKey, err error) {
+	err = ForEachExpiredKey(func(expiredKey ExpiredKey) error {
+		expiredKeys = append(expiredKeys, expiredKey)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expiredKeys, nil
+}
+
+// ForEachExpiredKey calls fn for each PGP key that has expired, reading and parsing keys a page
+// at a time. If fn returns an error, iteration stops and that error is returned.
+// Keys with a NULL earliest_expiry (see backfillEarliestExpiry) are never visited.
+// It's safe for fn to delete the key it's given.
+func ForEachExpiredKey(fn func(ExpiredKey) error) error {
+	afterKeyID := 0
+
+	for {
+		expiredKeys, lastKeyID, err := listExpiredKeysPage(afterKeyID, expiredKeysPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, expiredKey := range expiredKeys {
+			if err := fn(expiredKey); err != nil {
+				return err
+			}
+		}
+
+		if lastKeyID == 0 {
+			return nil // no more keys
+		}
+		afterKeyID = lastKeyID
+	}
+}
+
+// listExpiredKeysPage reads up to `limit` expired keys with an ID greater than afterKeyID,
+// along with the ID of the last key read (or 0 if there were none).
+// Paging on key ID rather than an offset means deleting keys between pages doesn't cause any to
+// be skipped.
+func listExpiredKeysPage(afterKeyID int, limit int) (
+	expiredKeys []ExpiredKey, lastKeyID int, err error) {
+
 	query := `SELECT keys.id,
                      keys.armored_public_key
-              FROM keys`
+              FROM keys
+              WHERE keys.id > $1
+                AND keys.earliest_expiry < $2
+              ORDER BY keys.id
+              LIMIT $3`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, afterKeyID, time.Now().UTC(), limit)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	defer rows.Close()
+
+	type keyRow struct {
+		id            int
+		armoredPublic string
+	}
+	keyRows := []keyRow{}
 
 	for rows.Next() {
-		var keyID int
-		var armoredPublic string
-		err = rows.Scan(&keyID, &armoredPublic)
-		if err != nil {
-			return nil, err
+		row := keyRow{}
+		if err = rows.Scan(&row.id, &row.armoredPublic); err != nil {
+			rows.Close()
+			return nil, 0, err
 		}
+		keyRows = append(keyRows, row)
+	}
+	// close rows before loading user profiles and emails, which make their own queries
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
 
-		key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublic)
+	for _, row := range keyRows {
+		lastKeyID = row.id
+
+		key, err := pgpkey.LoadFromArmoredPublicKey(row.armoredPublic)
 		if err != nil {
 			log.Printf("error loading key: %v", err)
 			continue
 		}
 
-		if !anyUIDHasExpired(key, time.Now()) {
+		if !AnyUIDHasExpired(key, time.Now()) {
 			continue
 		}
 
-		profile, err := loadUserProfile(nil, keyID)
+		profile, err := loadKeyWithProfile(nil, row.id)
 		if err != nil {
 			log.Printf("%s can't load user profile: %v", key.Fingerprint().Hex(), err)
 			continue
 		}
 
-		result := expiredKey{UserProfile: profile}
+		result := ExpiredKey{UserProfile: profile}
 
 		for _, email := range key.Emails(true) {
 			isVerified, err := QueryEmailVerifiedForFingerprint(nil, email, key.Fingerprint())
@@ -159,10 +227,7 @@ func ListExpiredKeys() (expiredKeys []expiredKey, err error) {
 		}
 		expiredKeys = append(expiredKeys, result)
 	}
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
-	return expiredKeys, nil
+	return expiredKeys, lastKeyID, nil
 }
 
 // GetTimeLastSent returns the most recent the given email type was sent to the given key, or
@@ -174,17 +239,10 @@ func GetTimeLastSent(txn *sql.Tx, emailTemplateID string, userProfileUUID uuid.U
 		return nil, fmt.Errorf("invalid emailTemplateID: cannot be empty")
 	}
 
-	query := `SELECT sent_at
-              FROM emails_sent
-			  WHERE email_template_id=$1
-			    AND user_profile_uuid=$2
-              ORDER BY sent_at DESC
-			  LIMIT 1`
-
 	var sentAt time.Time
 
 	err := transactionOrDatabase(txn).QueryRow(
-		query, emailTemplateID, userProfileUUID,
+		getTimeLastSentQuery, emailTemplateID, userProfileUUID,
 	).Scan(&sentAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -195,6 +253,13 @@ func GetTimeLastSent(txn *sql.Tx, emailTemplateID string, userProfileUUID uuid.U
 	return &sentAt, nil
 }
 
+const getTimeLastSentQuery = `SELECT sent_at
+              FROM emails_sent
+			  WHERE email_template_id=$1
+			    AND user_profile_uuid=$2
+              ORDER BY sent_at DESC
+			  LIMIT 1`
+
 // RecordSentEmail records that the given email type was sent to the given key
 func RecordSentEmail(txn *sql.Tx, emailTemplateID string, userProfileUUID uuid.UUID, now time.Time) error {
 	var count int
@@ -270,16 +335,17 @@ func CanSendWithRateLimit(
 	return now.After(nextAllowed), nil
 }
 
+// emailMatches returns true if the emails are the same address: see canonicalEmail.
 func emailMatches(firstEmail string, secondEmail string) bool {
 	// TODO: make this less naive
-	return strings.ToLower(firstEmail) == strings.ToLower(secondEmail)
+	return canonicalEmail(firstEmail) == canonicalEmail(secondEmail)
 }
 
-// anyUIDHasExpired returns true if all these things are true:
+// AnyUIDHasExpired returns true if all these things are true:
 // * it has an encryption subkey (TODO)
 // * its primary user ID has not expired
 //   - note: we just check if *any* user id has expired, and call that invalid.
-func anyUIDHasExpired(key *pgpkey.PgpKey, now time.Time) bool {
+func AnyUIDHasExpired(key *pgpkey.PgpKey, now time.Time) bool {
 	earliestExpiry := getEarliestExpiry(key)
 	if earliestExpiry == nil {
 		return false
@@ -320,3 +386,94 @@ func getEarliestExpiry(key *pgpkey.PgpKey) *time.Time {
 
 	return &expiries[0]
 }
+
+// UnverifiedKeyEmail is an email address on a stored key that we've sent verification emails
+// to, but which hasn't been linked to any key.
+type UnverifiedKeyEmail struct {
+	Email string
+	Key   *pgpkey.PgpKey
+
+	// FirstSentAt, FirstIPAddress and FirstUserAgent are from the first verification for the
+	// email and key: the time and origin of the request that uploaded the key
+	FirstSentAt    time.Time
+	FirstIPAddress string
+	FirstUserAgent string
+
+	// VerificationsSent counts every verification for the email and key, including resends
+	VerificationsSent int
+	LastSentAt        time.Time
+}
+
+// ListUnverifiedKeyEmails returns the email addresses on stored keys that have been sent a
+// verification email but still aren't linked to any key. Emails that have since been removed
+// from the key are left out.
+func ListUnverifiedKeyEmails(txn *sql.Tx) ([]UnverifiedKeyEmail, error) {
+	rows, err := transactionOrDatabase(txn).Query(listUnverifiedKeyEmailsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	unverified := []UnverifiedKeyEmail{}
+	for rows.Next() {
+		u := UnverifiedKeyEmail{}
+		var armoredPublicKey string
+		var ipAddress, userAgent *string
+
+		err := rows.Scan(
+			&u.Email, &armoredPublicKey,
+			&u.FirstSentAt, &ipAddress, &userAgent,
+			&u.VerificationsSent, &u.LastSentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		u.Key, err = pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+		if err != nil {
+			log.Printf("error loading key: %v", err)
+			continue
+		}
+
+		if !keyHasEmail(u.Key, u.Email) {
+			continue
+		}
+
+		if ipAddress != nil {
+			u.FirstIPAddress = *ipAddress
+		}
+		if userAgent != nil {
+			u.FirstUserAgent = *userAgent
+		}
+		unverified = append(unverified, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return unverified, nil
+}
+
+const listUnverifiedKeyEmailsQuery = `SELECT DISTINCT ON (lower(v.email_sent_to), v.key_fingerprint)
+                 v.email_sent_to,
+                 keys.armored_public_key,
+                 v.created_at,
+                 host(v.upsert_ip_address),
+                 v.upsert_user_agent,
+                 COUNT(*) OVER per_email_key,
+                 MAX(v.created_at) OVER per_email_key
+          FROM email_verifications v
+          JOIN keys ON keys.fingerprint = v.key_fingerprint
+          WHERE NOT EXISTS (
+              SELECT 1 FROM email_key_link WHERE email_key_link.email = v.email_sent_to::citext
+          )
+          WINDOW per_email_key AS (PARTITION BY lower(v.email_sent_to), v.key_fingerprint)
+          ORDER BY lower(v.email_sent_to), v.key_fingerprint, v.created_at`
+
+func keyHasEmail(key *pgpkey.PgpKey, email string) bool {
+	for _, keyEmail := range key.Emails(true) {
+		if emailMatches(keyEmail, email) {
+			return true
+		}
+	}
+	return false
+}
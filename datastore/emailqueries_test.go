@@ -7,6 +7,8 @@ import (
 
 	"github.com/fluidkeys/fluidkeys/assert"
 	"github.com/fluidkeys/fluidkeys/exampledata"
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
 	"github.com/gofrs/uuid"
 )
 
@@ -161,6 +163,118 @@ func TestCanSendWithRateLimit(t *testing.T) {
 	})
 }
 
+func TestForEachExpiredKey(t *testing.T) {
+	// key 2 hasn't expired, keys 3 and 4 are re-signed to have expired
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	assert.NoError(t, UpsertPublicKey(
+		nil, makeExpiredPublicKey(t, exampledata.ExamplePrivateKey3, "test3")))
+	assert.NoError(t, UpsertPublicKey(
+		nil, makeExpiredPublicKey(t, exampledata.ExamplePrivateKey4, "test4")))
+
+	for _, fingerprint := range []fpr.Fingerprint{
+		exampledata.ExampleFingerprint2,
+		exampledata.ExampleFingerprint3,
+		exampledata.ExampleFingerprint4,
+	} {
+		keyID, err := getKeyID(nil, fingerprint)
+		assert.NoError(t, err)
+		_, err = createUserProfile(nil, keyID)
+		assert.NoError(t, err)
+	}
+
+	defer func() {
+		_, err := db.Exec("DELETE FROM keys")
+		assert.NoError(t, err)
+	}()
+
+	originalPageSize := expiredKeysPageSize
+	defer func() { expiredKeysPageSize = originalPageSize }()
+
+	for _, pageSize := range []int{1, 2, 500} {
+		t.Run(fmt.Sprintf("with page size %d", pageSize), func(t *testing.T) {
+			expiredKeysPageSize = pageSize
+
+			got := []fpr.Fingerprint{}
+			err := ForEachExpiredKey(func(expiredKey ExpiredKey) error {
+				got = append(got, expiredKey.UserProfile.Key.Fingerprint())
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []fpr.Fingerprint{
+				exampledata.ExampleFingerprint3,
+				exampledata.ExampleFingerprint4,
+			}, got)
+		})
+	}
+
+	t.Run("stops and returns the callback's error", func(t *testing.T) {
+		expiredKeysPageSize = 1
+
+		callbackErr := fmt.Errorf("stop")
+		calls := 0
+		err := ForEachExpiredKey(func(expiredKey ExpiredKey) error {
+			calls++
+			return callbackErr
+		})
+		assert.Equal(t, callbackErr, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("page only reads expired keys", func(t *testing.T) {
+		key3ID, err := getKeyID(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+
+		expiredKeys, lastKeyID, err := listExpiredKeysPage(0, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(expiredKeys))
+		assert.Equal(t, exampledata.ExampleFingerprint3, expiredKeys[0].UserProfile.Key.Fingerprint())
+		assert.Equal(t, key3ID, lastKeyID)
+	})
+
+	t.Run("page after the last key is empty", func(t *testing.T) {
+		key4ID, err := getKeyID(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+
+		expiredKeys, lastKeyID, err := listExpiredKeysPage(key4ID, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(expiredKeys))
+		assert.Equal(t, 0, lastKeyID)
+	})
+
+	t.Run("deleting keys from the callback doesn't skip any", func(t *testing.T) {
+		expiredKeysPageSize = 1
+
+		got := []fpr.Fingerprint{}
+		err := ForEachExpiredKey(func(expiredKey ExpiredKey) error {
+			got = append(got, expiredKey.UserProfile.Key.Fingerprint())
+			_, _, err := DeletePublicKey(nil, expiredKey.UserProfile.Key.Fingerprint())
+			return err
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []fpr.Fingerprint{
+			exampledata.ExampleFingerprint3,
+			exampledata.ExampleFingerprint4,
+		}, got)
+	})
+}
+
+// makeExpiredPublicKey returns the armored public key for the given private key, re-signed so
+// that all of its UIDs expired on 1st January 2019.
+func makeExpiredPublicKey(t *testing.T, armoredPrivateKey string, password string) string {
+	t.Helper()
+
+	key, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(armoredPrivateKey, password)
+	assert.NoError(t, err)
+
+	signedAt := time.Date(2018, 12, 10, 0, 0, 0, 0, time.UTC)
+	expiredAt := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, key.UpdateExpiryForAllUserIds(expiredAt, signedAt))
+
+	armored, err := key.Armor()
+	assert.NoError(t, err)
+	return armored
+}
+
 func deleteEmailsSent(t *testing.T) {
 	t.Helper()
 
@@ -180,3 +294,61 @@ func createKeyAndUserProfile(t *testing.T) *UserProfile {
 	assert.NoError(t, err)
 	return profile
 }
+
+func TestListUnverifiedKeyEmails(t *testing.T) {
+	firstSent := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	resent := firstSent.Add(7 * 24 * time.Hour)
+
+	_, err := db.Exec("DELETE FROM email_verifications")
+	assert.NoError(t, err)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+
+	defer func() {
+		_, err := db.Exec("DELETE FROM keys")
+		assert.NoError(t, err)
+	}()
+
+	// key 2: unverified, sent twice
+	_, err = CreateVerification(
+		nil, "test2@example.com", exampledata.ExampleFingerprint2, "uploader", "10.0.0.1", firstSent)
+	assert.NoError(t, err)
+	_, err = CreateVerification(
+		nil, "test2@example.com", exampledata.ExampleFingerprint2, "uploader", "10.0.0.1", resent)
+	assert.NoError(t, err)
+
+	// key 2: an email that's no longer on the key
+	_, err = CreateVerification(
+		nil, "removed@example.com", exampledata.ExampleFingerprint2, "uploader", "10.0.0.1",
+		firstSent)
+	assert.NoError(t, err)
+
+	// key 4: verified and linked
+	_, err = CreateVerification(
+		nil, "test4@example.com", exampledata.ExampleFingerprint4, "uploader", "10.0.0.2", firstSent)
+	assert.NoError(t, err)
+	assert.NoError(t, LinkEmailToFingerprint(
+		nil, "test4@example.com", exampledata.ExampleFingerprint4, nil))
+
+	unverified, err := ListUnverifiedKeyEmails(nil)
+	assert.NoError(t, err)
+
+	if len(unverified) != 1 {
+		t.Fatalf("expected 1 unverified email, got %d: %v", len(unverified), unverified)
+	}
+
+	got := unverified[0]
+	assert.Equal(t, "test2@example.com", got.Email)
+	assert.Equal(t, exampledata.ExampleFingerprint2, got.Key.Fingerprint())
+	assert.Equal(t, 2, got.VerificationsSent)
+	assert.Equal(t, "10.0.0.1", got.FirstIPAddress)
+	assert.Equal(t, "uploader", got.FirstUserAgent)
+
+	if !firstSent.Equal(got.FirstSentAt) {
+		t.Fatalf("expected FirstSentAt=%v, got %v", firstSent, got.FirstSentAt)
+	}
+	if !resent.Equal(got.LastSentAt) {
+		t.Fatalf("expected LastSentAt=%v, got %v", resent, got.LastSentAt)
+	}
+}
@@ -0,0 +1,79 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+)
+
+// QueryPlan is the output of running EXPLAIN on one of the hot queries.
+type QueryPlan struct {
+	Name string
+	Plan string
+}
+
+// ExplainQueries runs EXPLAIN on the queries that run most often (or over the biggest tables)
+// and returns their query plans. It's used to check that the indexes are actually being used.
+// The queries are run with placeholder parameters, so they won't match any rows.
+func ExplainQueries() ([]QueryPlan, error) {
+	placeholderFingerprint := "4:0000000000000000000000000000000000000000"
+	placeholderTime := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	hotQueries := []struct {
+		name  string
+		query string
+		args  []interface{}
+	}{
+		{"GetArmoredPublicKeyForEmail", getArmoredPublicKeyForEmailQuery,
+			[]interface{}{"explain@example.com"}},
+		{"GetArmoredPublicKeyForEmailHash", getLinkedEmailsForDomainQuery,
+			[]interface{}{"example.com"}},
+		{"GetSecrets", getSecretsQuery,
+			[]interface{}{placeholderFingerprint}},
+		{"GetSecretsMetadata", getSecretsMetadataQuery,
+			[]interface{}{placeholderFingerprint}},
+		{"MarkSecretsDelivered", markSecretsDeliveredQuery,
+			[]interface{}{pq.Array([]string{uuid.Nil.String()}), placeholderTime}},
+		{"GetRequestsToJoinTeam", getRequestsToJoinTeamQuery,
+			[]interface{}{uuid.Nil}},
+		{"GetTimeLastSent", getTimeLastSentQuery,
+			[]interface{}{"explain", uuid.Nil}},
+		{"ListKeysExpiring", listKeysExpiringQuery,
+			[]interface{}{placeholderTime, placeholderTime}},
+	}
+
+	plans := []QueryPlan{}
+
+	for _, q := range hotQueries {
+		plan, err := explain(q.query, q.args...)
+		if err != nil {
+			return nil, fmt.Errorf("error explaining %s: %v", q.name, err)
+		}
+		plans = append(plans, QueryPlan{Name: q.name, Plan: plan})
+	}
+	return plans, nil
+}
+
+func explain(query string, args ...interface{}) (string, error) {
+	rows, err := db.Query("EXPLAIN "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	lines := []string{}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
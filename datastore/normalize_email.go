@@ -0,0 +1,90 @@
+package datastore
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/fluidkeys/api/env"
+)
+
+func init() {
+	NormalizeDottedEmails = env.Bool("NORMALIZE_DOTTED_EMAILS", NormalizeDottedEmails)
+}
+
+// NormalizeDottedEmails controls whether dots in the local part of addresses at
+// dotInsensitiveDomains are ignored, so that a key linked to foo.bar@gmail.com is also found by
+// looking up foobar@gmail.com (and vice versa), as Gmail delivers both to the same mailbox.
+// It's off by default, since for most domains those are different people: set
+// NORMALIZE_DOTTED_EMAILS=1 to switch it on.
+var NormalizeDottedEmails bool
+
+// dotInsensitiveDomains are the (lowercase) domains whose mail provider ignores dots in the
+// local part of an address.
+var dotInsensitiveDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+}
+
+// canonicalEmail returns the form of the email that equivalent addresses share: lowercased
+// and, if NormalizeDottedEmails is on and the domain ignores dots, with the dots removed from
+// the local part.
+func canonicalEmail(email string) string {
+	email = strings.ToLower(email)
+
+	localPart, domain, ok := ignoresDots(email)
+	if !ok {
+		return email
+	}
+	return strings.Replace(localPart, ".", "", -1) + "@" + domain
+}
+
+// ignoresDots splits the (lowercase) email into its local part and domain, returning ok=true
+// if NormalizeDottedEmails is on and the domain is one of dotInsensitiveDomains.
+func ignoresDots(email string) (localPart string, domain string, ok bool) {
+	if !NormalizeDottedEmails {
+		return "", "", false
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return "", "", false
+	}
+
+	localPart, domain = email[:at], email[at+1:]
+	return localPart, domain, dotInsensitiveDomains[domain]
+}
+
+// getArmoredPublicKeyForDottedEmail looks for a key linked to any address that only differs
+// from email by dots in its local part. If more than one matches, the first by address wins.
+// It returns found=false if NormalizeDottedEmails is off or email's domain doesn't ignore dots.
+func getArmoredPublicKeyForDottedEmail(txn *sql.Tx, email string) (
+	gotEmail string, armoredPublicKey string, verifiedAt *time.Time, found bool, err error) {
+
+	localPart, domain, ok := ignoresDots(strings.ToLower(email))
+	if !ok {
+		return "", "", nil, false, nil
+	}
+
+	err = transactionOrDatabase(txn).QueryRow(
+		getArmoredPublicKeyForDottedEmailQuery, domain, strings.Replace(localPart, ".", "", -1),
+	).Scan(&gotEmail, &armoredPublicKey, &verifiedAt)
+	if err == sql.ErrNoRows {
+		return "", "", nil, false, nil
+	} else if err != nil {
+		return "", "", nil, false, err
+	}
+	return gotEmail, armoredPublicKey, verifiedAt, true, nil
+}
+
+const getArmoredPublicKeyForDottedEmailQuery = `SELECT email_key_link.email,
+	                 keys.armored_public_key,
+	                 COALESCE(email_verifications.verified_at, email_verifications.created_at)
+		  FROM email_key_link
+		  INNER JOIN keys ON email_key_link.key_id = keys.id
+		  LEFT JOIN email_verifications
+		         ON email_key_link.email_verification_uuid = email_verifications.uuid
+		  WHERE lower(split_part(email_key_link.email, '@', 2)) = $1
+		    AND replace(lower(split_part(email_key_link.email, '@', 1)), '.', '') = $2
+		  ORDER BY lower(email_key_link.email)
+		  LIMIT 1`
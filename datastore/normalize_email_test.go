@@ -0,0 +1,84 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestEmailMatches(t *testing.T) {
+	defer func(previous bool) { NormalizeDottedEmails = previous }(NormalizeDottedEmails)
+
+	tests := []struct {
+		first           string
+		second          string
+		expectedWithout bool
+		expectedWith    bool
+	}{
+		{"foo.bar@gmail.com", "foo.bar@gmail.com", true, true},
+		{"Foo.Bar@Gmail.com", "foo.bar@gmail.com", true, true},
+		{"foo.bar@gmail.com", "foobar@gmail.com", false, true},
+		{"f.o.o.bar@googlemail.com", "foobar@googlemail.com", false, true},
+		{"foo.bar@gmail.com", "foobar@googlemail.com", false, false},
+		{"foo.bar@example.com", "foobar@example.com", false, false},
+		{"foo.bar@gmail.com.example.com", "foobar@gmail.com.example.com", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.first+" "+test.second, func(t *testing.T) {
+			NormalizeDottedEmails = false
+			assert.Equal(t, test.expectedWithout, emailMatches(test.first, test.second))
+
+			NormalizeDottedEmails = true
+			assert.Equal(t, test.expectedWith, emailMatches(test.first, test.second))
+		})
+	}
+}
+
+func TestGetArmoredPublicKeyForDottedEmail(t *testing.T) {
+	defer func(previous bool) { NormalizeDottedEmails = previous }(NormalizeDottedEmails)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	assert.NoError(t, LinkEmailToFingerprint(
+		nil, "foo.bar@gmail.com", exampledata.ExampleFingerprint4, nil))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}()
+
+	t.Run("with normalization off", func(t *testing.T) {
+		NormalizeDottedEmails = false
+
+		t.Run("finds the exact address", func(t *testing.T) {
+			_, found, err := GetArmoredPublicKeyForEmail(nil, "foo.bar@gmail.com")
+			assert.NoError(t, err)
+			assert.Equal(t, true, found)
+		})
+
+		t.Run("doesn't find an undotted address", func(t *testing.T) {
+			_, found, err := GetArmoredPublicKeyForEmail(nil, "foobar@gmail.com")
+			assert.NoError(t, err)
+			assert.Equal(t, false, found)
+		})
+	})
+
+	t.Run("with normalization on", func(t *testing.T) {
+		NormalizeDottedEmails = true
+
+		for _, email := range []string{"foobar@gmail.com", "f.oo.bar@gmail.com", "FooBar@Gmail.com"} {
+			t.Run("finds "+email, func(t *testing.T) {
+				armoredPublicKey, found, err := GetArmoredPublicKeyForEmail(nil, email)
+				assert.NoError(t, err)
+				assert.Equal(t, true, found)
+				assert.Equal(t, exampledata.ExamplePublicKey4, armoredPublicKey)
+			})
+		}
+
+		t.Run("doesn't find the address at another domain", func(t *testing.T) {
+			_, found, err := GetArmoredPublicKeyForEmail(nil, "foobar@example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, false, found)
+		})
+	})
+}
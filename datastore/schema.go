@@ -145,6 +145,141 @@ var migrateDatabaseStatements = []string{
 
                 user_profile_uuid UUID NOT NULL REFERENCES user_profiles(uuid) ON DELETE CASCADE
 	)`,
+
+	// indexes for foreign keys and hot queries. check they're used with `explain_queries`
+	`CREATE INDEX IF NOT EXISTS email_key_link_key_id_idx ON email_key_link (key_id)`,
+
+	`CREATE INDEX IF NOT EXISTS secrets_recipient_key_id_idx ON secrets (recipient_key_id)`,
+
+	`CREATE INDEX IF NOT EXISTS team_join_requests_team_uuid_idx ON team_join_requests (team_uuid)`,
+
+	`CREATE INDEX IF NOT EXISTS emails_sent_user_profile_uuid_email_template_id_idx
+	     ON emails_sent (user_profile_uuid, email_template_id)`,
+
+	// Web Key Directory lookups find every linked email at a domain
+	`CREATE INDEX IF NOT EXISTS email_key_link_domain_idx
+	     ON email_key_link (lower(split_part(email, '@', 2)))`,
+
+	`ALTER TABLE keys ADD COLUMN IF NOT EXISTS basic_auth_password_sha256 TEXT`,
+
+	// earliest_expiry is when the key's first UID expires, or 'infinity' if none of them do.
+	// NULL means it hasn't been worked out yet: Migrate backfills those rows. Keys left NULL
+	// (because they can't be parsed) never show up as expiring or expired.
+	`ALTER TABLE keys ADD COLUMN IF NOT EXISTS earliest_expiry TIMESTAMP`,
+
+	`CREATE INDEX IF NOT EXISTS keys_earliest_expiry_idx ON keys (earliest_expiry)`,
+
+	// compromised_at is when the key's owner reported it as compromised, or NULL if they haven't
+	`ALTER TABLE keys ADD COLUMN IF NOT EXISTS compromised_at TIMESTAMP`,
+
+	`CREATE TABLE IF NOT EXISTS suspicious_requests (
+                -- suspicious_requests records rejected requests that look like replay or
+                -- tampering attempts, for example a reused single use UUID.
+                --
+                -- fingerprint isn't a foreign key: the key may never have been stored.
+
+                id BIGSERIAL PRIMARY KEY,
+                created_at TIMESTAMP NOT NULL,
+                kind TEXT NOT NULL,
+                fingerprint VARCHAR NOT NULL,
+                ip_address TEXT NOT NULL,
+                detail TEXT NOT NULL
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS email_failures (
+                -- email_failures records emails that couldn't be delivered, even after
+                -- retrying, so they can be investigated and sent again by hand.
+
+                id BIGSERIAL PRIMARY KEY,
+                failed_at TIMESTAMP NOT NULL,
+                recipient TEXT NOT NULL,
+
+                -- email_template_id is the same as emails_sent.email_template_id
+                email_template_id TEXT NOT NULL default '',
+
+                error TEXT NOT NULL
+	)`,
+
+	// verified_at is when the verification link was opened, or NULL if it hasn't been (or it was
+	// opened before this column was added: check verify_ip_address for those)
+	`ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP`,
+
+	`CREATE TABLE IF NOT EXISTS secret_receipts (
+                -- secret_receipts let the sender of a secret find out if it's been picked up.
+                --
+                -- secret_uuid isn't a foreign key: the receipt outlives the secret, which is
+                -- deleted once the recipient has it.
+
+                secret_uuid UUID PRIMARY KEY,
+                sender_token_sha256 TEXT NOT NULL,
+                created_at TIMESTAMP NOT NULL,
+                delivered_at TIMESTAMP,
+                deleted_at TIMESTAMP
+	)`,
+
+	// deleted_at is when the team was soft-deleted, or NULL if it hasn't been. Soft-deleted teams
+	// are hidden and get purged once datastore.TeamDeletionGracePeriod has passed.
+	`ALTER TABLE teams ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+
+	// required_admin_signatures is how many of the team's admins must sign a roster update
+	`ALTER TABLE teams ADD COLUMN IF NOT EXISTS required_admin_signatures INTEGER NOT NULL DEFAULT 1`,
+
+	`CREATE TABLE IF NOT EXISTS secret_audit (
+                -- secret_audit records who sent a secret to whom, when the server is configured
+                -- to audit secret sends. It only ever holds metadata: never the secret itself.
+                --
+                -- secret_uuid isn't a foreign key: the audit record outlives the secret.
+
+                id BIGSERIAL PRIMARY KEY,
+                created_at TIMESTAMP NOT NULL,
+                secret_uuid UUID NOT NULL,
+                sender_ip_address TEXT NOT NULL,
+                recipient_fingerprint VARCHAR NOT NULL,
+                size_bytes INTEGER NOT NULL
+	)`,
+
+	// size_bytes is the length of the encrypted secret once its ASCII armor is decoded.
+	// NULL means it hasn't been worked out yet: Migrate backfills those rows.
+	`ALTER TABLE secrets ADD COLUMN IF NOT EXISTS size_bytes INTEGER`,
+
+	// size_bytes_unknown is set for secrets whose armor can't be decoded, so size_bytes stays
+	// NULL without Migrate trying to backfill it every time.
+	`ALTER TABLE secrets ADD COLUMN IF NOT EXISTS size_bytes_unknown BOOLEAN NOT NULL DEFAULT FALSE`,
+
+	`CREATE TABLE IF NOT EXISTS banned_keys (
+                -- banned_keys lists keys that operators have blocked, for example because
+                -- they're being used for abuse. Banned keys can't be uploaded and aren't
+                -- returned by lookups.
+                --
+                -- fingerprint isn't a foreign key: a key can be banned before it's uploaded,
+                -- and the ban outlives the key.
+
+                fingerprint VARCHAR PRIMARY KEY,
+                banned_at TIMESTAMP NOT NULL,
+                reason TEXT NOT NULL
+	)`,
+
+	`CREATE TABLE IF NOT EXISTS deleted_keys (
+                -- deleted_keys remembers keys that were deleted by the server, for example
+                -- because they expired, so lookups can say the key is gone rather than that
+                -- it never existed. The row is deleted if the key is uploaded again.
+
+                fingerprint VARCHAR PRIMARY KEY,
+                deleted_at TIMESTAMP NOT NULL,
+                reason TEXT NOT NULL
+	)`,
+
+	// encrypted_sender_hint is an optional ASCII-armored message from the sender, encrypted to
+	// the recipient, saying who the secret is from. The server can't read it.
+	`ALTER TABLE secrets ADD COLUMN IF NOT EXISTS encrypted_sender_hint TEXT`,
+
+	// updated_at is when the team's roster was last written. It's NULL for teams last written
+	// before this column was added.
+	`ALTER TABLE teams ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP`,
+
+	// earliest_expiry_unknown is set for keys that can't be parsed, so earliest_expiry stays
+	// NULL without Migrate trying to backfill it every time.
+	`ALTER TABLE keys ADD COLUMN IF NOT EXISTS earliest_expiry_unknown BOOLEAN NOT NULL DEFAULT FALSE`,
 }
 
 // allTables is used by the test helper DropAllTheTables to keep track of what tables to
@@ -154,7 +289,13 @@ var allTables = []string{
 	"email_key_link",
 	"email_verifications",
 	"secrets",
+	"secret_receipts",
+	"secret_audit",
 	"emails_sent",
+	"email_failures",
+	"suspicious_requests",
+	"banned_keys",
+	"deleted_keys",
 	"user_profiles",
 	"keys",
 	"team_join_requests",
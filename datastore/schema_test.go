@@ -0,0 +1,43 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestMigrateCreatesIndexes(t *testing.T) {
+	expectedIndexes := []struct {
+		table string
+		index string
+	}{
+		{"email_key_link", "email_key_link_key_id_idx"},
+		{"secrets", "secrets_recipient_key_id_idx"},
+		{"team_join_requests", "team_join_requests_team_uuid_idx"},
+		{"emails_sent", "emails_sent_user_profile_uuid_email_template_id_idx"},
+		{"email_key_link", "email_key_link_domain_idx"},
+		{"keys", "keys_earliest_expiry_idx"},
+	}
+
+	for _, expected := range expectedIndexes {
+		t.Run(expected.index, func(t *testing.T) {
+			query := `SELECT COUNT(*) FROM pg_indexes WHERE tablename=$1 AND indexname=$2`
+
+			var count int
+			err := db.QueryRow(query, expected.table, expected.index).Scan(&count)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, count)
+		})
+	}
+}
+
+func TestExplainQueries(t *testing.T) {
+	plans, err := ExplainQueries()
+	assert.NoError(t, err)
+
+	for _, plan := range plans {
+		if plan.Plan == "" {
+			t.Fatalf("got empty query plan for %s", plan.Name)
+		}
+	}
+}
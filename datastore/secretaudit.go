@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/gofrs/uuid"
+)
+
+// SecretAudit records the metadata of a secret that was sent. It deliberately has nowhere to
+// put the secret itself.
+type SecretAudit struct {
+	CreatedAt            time.Time
+	SecretUUID           uuid.UUID
+	SenderIPAddress      string
+	RecipientFingerprint fpr.Fingerprint
+
+	// SizeBytes is the length of the encrypted secret once its ASCII armor is decoded, as stored
+	// in secrets.size_bytes
+	SizeBytes int
+}
+
+// RecordSecretAudit stores an audit record for a secret that was sent.
+// txn is a database transaction, or nil to run outside of a transaction
+func RecordSecretAudit(txn *sql.Tx, audit SecretAudit) error {
+	query := `INSERT INTO secret_audit(
+                  created_at,
+                  secret_uuid,
+                  sender_ip_address,
+                  recipient_fingerprint,
+                  size_bytes
+              )
+              VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := transactionOrDatabase(txn).Exec(
+		query, audit.CreatedAt, audit.SecretUUID, audit.SenderIPAddress,
+		dbFormat(audit.RecipientFingerprint), audit.SizeBytes,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting into db: %v", err)
+	}
+	return nil
+}
+
+// ListSecretAudits returns all the secret audit records, oldest first.
+func ListSecretAudits(txn *sql.Tx) ([]SecretAudit, error) {
+	query := `SELECT created_at,
+                     secret_uuid,
+                     sender_ip_address,
+                     recipient_fingerprint,
+                     size_bytes
+              FROM secret_audit
+              ORDER BY created_at, id`
+
+	rows, err := transactionOrDatabase(txn).Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := []SecretAudit{}
+	for rows.Next() {
+		audit := SecretAudit{}
+		var dbFingerprint string
+		err := rows.Scan(
+			&audit.CreatedAt, &audit.SecretUUID, &audit.SenderIPAddress, &dbFingerprint,
+			&audit.SizeBytes,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		audit.RecipientFingerprint, err = parseDbFormat(dbFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		audits = append(audits, audit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return audits, nil
+}
@@ -0,0 +1,69 @@
+package datastore
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	"github.com/gofrs/uuid"
+)
+
+func TestRecordSecretAudit(t *testing.T) {
+	defer func() {
+		_, err := db.Exec("DELETE FROM secret_audit")
+		assert.NoError(t, err)
+	}()
+
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+	secretUUID := uuid.Must(uuid.NewV4())
+
+	t.Run("records and lists the audit", func(t *testing.T) {
+		assert.NoError(t, RecordSecretAudit(nil, SecretAudit{
+			CreatedAt:            now,
+			SecretUUID:           secretUUID,
+			SenderIPAddress:      "192.0.2.1",
+			RecipientFingerprint: exampledata.ExampleFingerprint4,
+			SizeBytes:            1234,
+		}))
+
+		audits, err := ListSecretAudits(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(audits))
+
+		assert.Equal(t, secretUUID, audits[0].SecretUUID)
+		assert.Equal(t, "192.0.2.1", audits[0].SenderIPAddress)
+		assert.Equal(t, exampledata.ExampleFingerprint4, audits[0].RecipientFingerprint)
+		assert.Equal(t, 1234, audits[0].SizeBytes)
+		if !now.Equal(audits[0].CreatedAt) {
+			t.Fatalf("expected CreatedAt=%v, got %v", now, audits[0].CreatedAt)
+		}
+	})
+
+	t.Run("table has no column that could hold the secret", func(t *testing.T) {
+		rows, err := db.Query(`SELECT column_name
+                               FROM information_schema.columns
+                               WHERE table_name = 'secret_audit'`)
+		assert.NoError(t, err)
+		defer rows.Close()
+
+		columns := []string{}
+		for rows.Next() {
+			var column string
+			assert.NoError(t, rows.Scan(&column))
+			columns = append(columns, column)
+		}
+		assert.NoError(t, rows.Err())
+		sort.Strings(columns)
+
+		assert.Equal(t, []string{
+			"created_at",
+			"id",
+			"recipient_fingerprint",
+			"secret_uuid",
+			"sender_ip_address",
+			"size_bytes",
+		}, columns)
+	})
+}
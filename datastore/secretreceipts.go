@@ -0,0 +1,93 @@
+package datastore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+)
+
+// SecretReceipt lets the sender of a secret find out whether the recipient has picked it up.
+type SecretReceipt struct {
+	SecretUUID        uuid.UUID
+	SenderTokenSHA256 string
+	CreatedAt         time.Time
+
+	// DeliveredAt is when the recipient first downloaded the secret, or nil if they haven't
+	DeliveredAt *time.Time
+
+	// DeletedAt is when the recipient deleted the secret, or nil if they haven't
+	DeletedAt *time.Time
+}
+
+// CreateSecretReceipt stores a receipt for the given secret. senderTokenHash is the hash of the
+// token the sender must present to read the receipt.
+func CreateSecretReceipt(
+	txn *sql.Tx, secretUUID uuid.UUID, senderTokenHash string, now time.Time) error {
+
+	query := `INSERT INTO secret_receipts(secret_uuid, sender_token_sha256, created_at)
+              VALUES ($1, $2, $3)`
+
+	_, err := transactionOrDatabase(txn).Exec(query, secretUUID, senderTokenHash, now)
+	return err
+}
+
+// GetSecretReceipt returns the receipt for the given secret, or ErrNotFound if there isn't one.
+func GetSecretReceipt(txn *sql.Tx, secretUUID uuid.UUID) (*SecretReceipt, error) {
+	query := `SELECT secret_uuid,
+                     sender_token_sha256,
+                     created_at,
+                     delivered_at,
+                     deleted_at
+              FROM secret_receipts
+              WHERE secret_uuid = $1`
+
+	receipt := SecretReceipt{}
+	err := transactionOrDatabase(txn).QueryRow(query, secretUUID).Scan(
+		&receipt.SecretUUID,
+		&receipt.SenderTokenSHA256,
+		&receipt.CreatedAt,
+		&receipt.DeliveredAt,
+		&receipt.DeletedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// MarkSecretsDelivered records that the given secrets have been downloaded by their recipient.
+// Secrets that were already delivered keep their original time.
+func MarkSecretsDelivered(txn *sql.Tx, secretUUIDs []uuid.UUID, now time.Time) error {
+	if len(secretUUIDs) == 0 {
+		return nil
+	}
+
+	uuidStrings := make([]string, 0, len(secretUUIDs))
+	for _, secretUUID := range secretUUIDs {
+		uuidStrings = append(uuidStrings, secretUUID.String())
+	}
+
+	_, err := transactionOrDatabase(txn).Exec(
+		markSecretsDeliveredQuery, pq.Array(uuidStrings), now)
+	return err
+}
+
+const markSecretsDeliveredQuery = `UPDATE secret_receipts
+          SET delivered_at = COALESCE(delivered_at, $2)
+          WHERE secret_uuid = ANY($1::uuid[])`
+
+// MarkSecretDeleted records that the recipient has deleted the given secret. A secret that's
+// deleted without being listed first counts as delivered too.
+func MarkSecretDeleted(txn *sql.Tx, secretUUID uuid.UUID, now time.Time) error {
+	query := `UPDATE secret_receipts
+              SET delivered_at = COALESCE(delivered_at, $2),
+                  deleted_at = COALESCE(deleted_at, $2)
+              WHERE secret_uuid = $1`
+
+	_, err := transactionOrDatabase(txn).Exec(query, secretUUID, now)
+	return err
+}
@@ -0,0 +1,77 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	"github.com/gofrs/uuid"
+)
+
+func TestSecretReceipts(t *testing.T) {
+	createdAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	deliveredAt := createdAt.Add(time.Hour)
+	deletedAt := createdAt.Add(2 * time.Hour)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}()
+
+	secretUUID, err := CreateSecret(exampledata.ExampleFingerprint4, "secret", createdAt)
+	assert.NoError(t, err)
+
+	assert.NoError(t, CreateSecretReceipt(nil, *secretUUID, "tokenhash", createdAt))
+
+	t.Run("new receipt isn't delivered or deleted", func(t *testing.T) {
+		receipt, err := GetSecretReceipt(nil, *secretUUID)
+		assert.NoError(t, err)
+		assert.Equal(t, "tokenhash", receipt.SenderTokenSHA256)
+		if !createdAt.Equal(receipt.CreatedAt) {
+			t.Fatalf("expected CreatedAt=%v, got %v", createdAt, receipt.CreatedAt)
+		}
+		if receipt.DeliveredAt != nil || receipt.DeletedAt != nil {
+			t.Fatalf("expected DeliveredAt and DeletedAt to be nil, got %v, %v",
+				receipt.DeliveredAt, receipt.DeletedAt)
+		}
+	})
+
+	t.Run("MarkSecretsDelivered keeps the first delivery time", func(t *testing.T) {
+		assert.NoError(t, MarkSecretsDelivered(nil, []uuid.UUID{*secretUUID}, deliveredAt))
+		assert.NoError(t, MarkSecretsDelivered(nil, []uuid.UUID{*secretUUID}, deletedAt))
+
+		receipt, err := GetSecretReceipt(nil, *secretUUID)
+		assert.NoError(t, err)
+		if receipt.DeliveredAt == nil || !deliveredAt.Equal(*receipt.DeliveredAt) {
+			t.Fatalf("expected DeliveredAt=%v, got %v", deliveredAt, receipt.DeliveredAt)
+		}
+	})
+
+	t.Run("MarkSecretsDelivered with no secrets", func(t *testing.T) {
+		assert.NoError(t, MarkSecretsDelivered(nil, []uuid.UUID{}, deliveredAt))
+	})
+
+	t.Run("receipt outlives the deleted secret", func(t *testing.T) {
+		found, err := DeleteSecret(nil, *secretUUID, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		assert.NoError(t, MarkSecretDeleted(nil, *secretUUID, deletedAt))
+
+		receipt, err := GetSecretReceipt(nil, *secretUUID)
+		assert.NoError(t, err)
+		if receipt.DeliveredAt == nil || !deliveredAt.Equal(*receipt.DeliveredAt) {
+			t.Fatalf("expected DeliveredAt=%v, got %v", deliveredAt, receipt.DeliveredAt)
+		}
+		if receipt.DeletedAt == nil || !deletedAt.Equal(*receipt.DeletedAt) {
+			t.Fatalf("expected DeletedAt=%v, got %v", deletedAt, receipt.DeletedAt)
+		}
+	})
+
+	t.Run("GetSecretReceipt returns ErrNotFound for an unknown secret", func(t *testing.T) {
+		_, err := GetSecretReceipt(nil, uuid.Must(uuid.NewV4()))
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
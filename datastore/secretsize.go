@@ -0,0 +1,91 @@
+package datastore
+
+import (
+	"database/sql"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/fluidkeys/crypto/openpgp/armor"
+)
+
+// ArmoredSecretSize returns the length of the encrypted secret once its ASCII armor has been
+// decoded, which is roughly how much a client would download if it fetched the secret
+// unarmored.
+func ArmoredSecretSize(armoredEncryptedSecret string) (int, error) {
+	block, err := armor.Decode(strings.NewReader(armoredEncryptedSecret))
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := io.Copy(ioutil.Discard, block.Body)
+	if err != nil {
+		return 0, err
+	}
+	return int(size), nil
+}
+
+// secretSizeForDB returns the size to store in secrets.size_bytes, or nil if the secret's
+// armor can't be decoded.
+func secretSizeForDB(armoredEncryptedSecret string) *int {
+	size, err := ArmoredSecretSize(armoredEncryptedSecret)
+	if err != nil {
+		return nil
+	}
+	return &size
+}
+
+// backfillSecretSizes works out size_bytes for any secrets stored before it was added. Secrets
+// whose armor can't be decoded are marked with size_bytes_unknown so they're only tried once.
+func backfillSecretSizes(txn *sql.Tx) error {
+	rows, err := transactionOrDatabase(txn).Query(
+		`SELECT id, armored_encrypted_secret
+		 FROM secrets
+		 WHERE size_bytes IS NULL
+		 AND NOT size_bytes_unknown`)
+	if err != nil {
+		return err
+	}
+
+	armoredSecrets := map[int]string{}
+	for rows.Next() {
+		var secretID int
+		var armoredEncryptedSecret string
+		if err := rows.Scan(&secretID, &armoredEncryptedSecret); err != nil {
+			rows.Close()
+			return err
+		}
+		armoredSecrets[secretID] = armoredEncryptedSecret
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	query := `UPDATE secrets SET size_bytes=$1 WHERE id=$2`
+	unknownQuery := `UPDATE secrets SET size_bytes_unknown=TRUE WHERE id=$1`
+	skipped := 0
+
+	for secretID, armoredEncryptedSecret := range armoredSecrets {
+		size, err := ArmoredSecretSize(armoredEncryptedSecret)
+		if err != nil {
+			log.Printf("not backfilling size_bytes for secret %d: %v", secretID, err)
+			if _, err := transactionOrDatabase(txn).Exec(unknownQuery, secretID); err != nil {
+				return err
+			}
+			skipped++
+			continue
+		}
+
+		if _, err := transactionOrDatabase(txn).Exec(query, size, secretID); err != nil {
+			return err
+		}
+	}
+
+	if skipped > 0 {
+		log.Printf("backfilled size_bytes for %d secrets, skipped %d with undecodable armor",
+			len(armoredSecrets)-skipped, skipped)
+	}
+	return nil
+}
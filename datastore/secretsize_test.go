@@ -0,0 +1,81 @@
+package datastore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/crypto/openpgp/armor"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestArmoredSecretSize(t *testing.T) {
+	t.Run("returns the decoded length", func(t *testing.T) {
+		payload := bytes.Repeat([]byte{0xAB}, 1000)
+
+		armored := new(bytes.Buffer)
+		writer, err := armor.Encode(armored, "PGP MESSAGE", nil)
+		assert.NoError(t, err)
+		_, err = writer.Write(payload)
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Close())
+
+		size, err := ArmoredSecretSize(armored.String())
+		assert.NoError(t, err)
+		assert.Equal(t, 1000, size)
+	})
+
+	t.Run("returns an error for invalid armor", func(t *testing.T) {
+		_, err := ArmoredSecretSize("fake-secret")
+		assert.GotError(t, err)
+
+		if secretSizeForDB("fake-secret") != nil {
+			t.Fatalf("expected nil size for invalid armor")
+		}
+	})
+}
+
+func TestBackfillSecretSizes(t *testing.T) {
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+	}()
+
+	secretUUID, err := CreateSecret(exampledata.ExampleFingerprint2, "fake-secret", now)
+	assert.NoError(t, err)
+
+	readSize := func(t *testing.T) (sizeBytes *int, sizeBytesUnknown bool) {
+		t.Helper()
+		err := db.QueryRow(
+			`SELECT size_bytes, size_bytes_unknown FROM secrets WHERE uuid=$1`, *secretUUID,
+		).Scan(&sizeBytes, &sizeBytesUnknown)
+		assert.NoError(t, err)
+		return sizeBytes, sizeBytesUnknown
+	}
+
+	t.Run("new secret with invalid armor is marked as unknown size", func(t *testing.T) {
+		sizeBytes, sizeBytesUnknown := readSize(t)
+		if sizeBytes != nil {
+			t.Fatalf("expected nil size_bytes, got %d", *sizeBytes)
+		}
+		assert.Equal(t, true, sizeBytesUnknown)
+	})
+
+	t.Run("secret stored before sizes were recorded is marked once", func(t *testing.T) {
+		_, err := db.Exec(
+			`UPDATE secrets SET size_bytes_unknown=FALSE WHERE uuid=$1`, *secretUUID)
+		assert.NoError(t, err)
+
+		assert.NoError(t, backfillSecretSizes(nil))
+
+		sizeBytes, sizeBytesUnknown := readSize(t)
+		if sizeBytes != nil {
+			t.Fatalf("expected nil size_bytes, got %d", *sizeBytes)
+		}
+		assert.Equal(t, true, sizeBytesUnknown)
+	})
+}
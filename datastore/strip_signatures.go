@@ -0,0 +1,146 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fluidkeys/api/env"
+	"github.com/fluidkeys/crypto/openpgp"
+	"github.com/fluidkeys/crypto/openpgp/armor"
+	"github.com/fluidkeys/crypto/openpgp/packet"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+)
+
+func init() {
+	StripThirdPartySignatures = env.Bool("STRIP_THIRD_PARTY_SIGNATURES", StripThirdPartySignatures)
+}
+
+// StripThirdPartySignatures controls whether UpsertPublicKey discards certifications made by
+// other keys before storing a public key. The server never uses them, and on popular keys
+// they can make up most of the armored blob.
+// It's off by default (the key is stored exactly as uploaded): set
+// STRIP_THIRD_PARTY_SIGNATURES=1 to switch it on.
+var StripThirdPartySignatures bool
+
+// stripThirdPartySignatures returns the armored key with any user ID signatures not issued by
+// the key itself removed. Self-signatures, subkey binding signatures and revocations are kept.
+// If there's nothing to strip, the original armoredPublicKey is returned unaltered.
+func stripThirdPartySignatures(key *pgpkey.PgpKey, armoredPublicKey string) (string, error) {
+	var numStripped int
+
+	for _, identity := range key.Identities {
+		selfSignatures := identity.Signatures[:0]
+
+		for _, sig := range identity.Signatures {
+			if sig.IssuerKeyId != nil && *sig.IssuerKeyId == key.PrimaryKey.KeyId {
+				selfSignatures = append(selfSignatures, sig)
+			} else {
+				numStripped++
+			}
+		}
+		identity.Signatures = selfSignatures
+	}
+
+	if numStripped == 0 {
+		return armoredPublicKey, nil
+	}
+
+	buf := new(bytes.Buffer)
+	armorWriteCloser, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err := serializePublicKey(armorWriteCloser, key, armoredPublicKey); err != nil {
+		return "", fmt.Errorf("error serializing key: %v", err)
+	}
+
+	if err := armorWriteCloser.Close(); err != nil {
+		return "", fmt.Errorf("failed to close armorer: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// serializePublicKey writes out the public parts of the key. Unlike openpgp.Entity.Serialize
+// it also writes out key revocations, and identities in a stable order.
+// Subkeys are copied unchanged from armoredPublicKey rather than re-serialized: openpgp.Subkey
+// only holds one signature, so a revoked subkey would otherwise lose its binding signature.
+func serializePublicKey(w io.Writer, key *pgpkey.PgpKey, armoredPublicKey string) error {
+	if err := key.PrimaryKey.Serialize(w); err != nil {
+		return err
+	}
+
+	for _, revocation := range key.Revocations {
+		if err := revocation.Serialize(w); err != nil {
+			return err
+		}
+	}
+
+	names := []string{}
+	for name := range key.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		identity := key.Identities[name]
+
+		if err := identity.UserId.Serialize(w); err != nil {
+			return err
+		}
+		if err := identity.SelfSignature.Serialize(w); err != nil {
+			return err
+		}
+		for _, sig := range identity.Signatures {
+			if err := sig.Serialize(w); err != nil {
+				return err
+			}
+		}
+	}
+
+	return copySubkeyPackets(w, armoredPublicKey)
+}
+
+const (
+	publicKeyPacketTag    = 6
+	publicSubkeyPacketTag = 14
+)
+
+// copySubkeyPackets writes every packet from the first subkey of the armored key onwards,
+// byte for byte, stopping if another primary key starts.
+func copySubkeyPackets(w io.Writer, armoredPublicKey string) error {
+	block, err := armor.Decode(strings.NewReader(armoredPublicKey))
+	if err != nil {
+		return fmt.Errorf("error decoding armor: %v", err)
+	}
+
+	packets := packet.NewOpaqueReader(block.Body)
+	inSubkeys := false
+
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading packet: %v", err)
+		}
+
+		switch p.Tag {
+		case publicKeyPacketTag:
+			if inSubkeys {
+				return nil
+			}
+		case publicSubkeyPacketTag:
+			inSubkeys = true
+		}
+
+		if inSubkeys {
+			if err := p.Serialize(w); err != nil {
+				return err
+			}
+		}
+	}
+}
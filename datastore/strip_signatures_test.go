@@ -0,0 +1,187 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/crypto/openpgp"
+	"github.com/fluidkeys/crypto/openpgp/armor"
+	"github.com/fluidkeys/crypto/openpgp/packet"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+)
+
+func TestStripThirdPartySignatures(t *testing.T) {
+	signedArmoredKey := makeHeavilySignedKey(t)
+
+	defer func() {
+		StripThirdPartySignatures = false
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}()
+
+	t.Run("key is stored unaltered when switched off", func(t *testing.T) {
+		StripThirdPartySignatures = false
+		assert.NoError(t, UpsertPublicKey(nil, signedArmoredKey))
+
+		stored, found, err := GetArmoredPublicKeyForFingerprint(exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+		assert.Equal(t, signedArmoredKey, stored)
+	})
+
+	t.Run("third party signatures are removed when switched on", func(t *testing.T) {
+		StripThirdPartySignatures = true
+		assert.NoError(t, UpsertPublicKey(nil, signedArmoredKey))
+
+		stored, found, err := GetArmoredPublicKeyForFingerprint(exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		t.Run("stored key is smaller", func(t *testing.T) {
+			if len(stored) >= len(signedArmoredKey) {
+				t.Fatalf("expected stored key to be smaller than %d bytes, got %d bytes",
+					len(signedArmoredKey), len(stored))
+			}
+		})
+
+		t.Run("stored key has only self signatures", func(t *testing.T) {
+			key, err := pgpkey.LoadFromArmoredPublicKey(stored)
+			assert.NoError(t, err)
+			assert.Equal(t, exampledata.ExampleFingerprint4, key.Fingerprint())
+
+			for _, identity := range key.Identities {
+				if identity.SelfSignature == nil {
+					t.Fatalf("identity %s lost its self signature", identity.Name)
+				}
+				if len(identity.Signatures) != 0 {
+					t.Fatalf("expected 0 third party signatures on %s, got %d",
+						identity.Name, len(identity.Signatures))
+				}
+			}
+		})
+
+		t.Run("stored key keeps its subkeys", func(t *testing.T) {
+			original, err := pgpkey.LoadFromArmoredPublicKey(exampledata.ExamplePublicKey4)
+			assert.NoError(t, err)
+
+			key, err := pgpkey.LoadFromArmoredPublicKey(stored)
+			assert.NoError(t, err)
+			assert.Equal(t, len(original.Subkeys), len(key.Subkeys))
+		})
+	})
+
+	t.Run("key without third party signatures is returned unaltered", func(t *testing.T) {
+		key, err := pgpkey.LoadFromArmoredPublicKey(exampledata.ExamplePublicKey4)
+		assert.NoError(t, err)
+
+		got, err := stripThirdPartySignatures(key, exampledata.ExamplePublicKey4)
+		assert.NoError(t, err)
+		assert.Equal(t, exampledata.ExamplePublicKey4, got)
+	})
+}
+
+func TestStripThirdPartySignaturesWithRevokedSubkey(t *testing.T) {
+	signedArmoredKey := revokeSubkey(t, makeHeavilySignedKey(t))
+
+	key, err := pgpkey.LoadFromArmoredPublicKey(signedArmoredKey)
+	assert.NoError(t, err)
+
+	stripped, err := stripThirdPartySignatures(key, signedArmoredKey)
+	assert.NoError(t, err)
+
+	t.Run("subkey keeps its binding signature", func(t *testing.T) {
+		assert.Equal(t, 1, countSignatures(t, stripped, packet.SigTypeSubkeyBinding))
+	})
+
+	t.Run("subkey keeps its revocation", func(t *testing.T) {
+		assert.Equal(t, 1, countSignatures(t, stripped, packet.SigTypeSubkeyRevocation))
+	})
+}
+
+// makeHeavilySignedKey returns example key 4, armored, with its identities certified many
+// times by example keys 2 and 3.
+func makeHeavilySignedKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := pgpkey.LoadFromArmoredPublicKey(exampledata.ExamplePublicKey4)
+	assert.NoError(t, err)
+
+	signer2, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey2, "test2")
+	assert.NoError(t, err)
+
+	signer3, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey3, "test3")
+	assert.NoError(t, err)
+
+	for name := range key.Identities {
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, key.SignIdentity(name, &signer2.Entity, nil))
+			assert.NoError(t, key.SignIdentity(name, &signer3.Entity, nil))
+		}
+	}
+
+	armored, err := key.Armor()
+	assert.NoError(t, err)
+	return armored
+}
+
+// revokeSubkey returns the armored example key 4 with a revocation signature for its subkey
+// appended after the subkey's binding signature.
+func revokeSubkey(t *testing.T, armoredPublicKey string) string {
+	t.Helper()
+
+	privateKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+
+	revocation := &packet.Signature{
+		SigType:      packet.SigTypeSubkeyRevocation,
+		PubKeyAlgo:   privateKey.PrivateKey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &privateKey.PrimaryKey.KeyId,
+	}
+	err = revocation.SignKey(privateKey.Subkeys[0].PublicKey, privateKey.PrivateKey, nil)
+	assert.NoError(t, err)
+
+	block, err := armor.Decode(strings.NewReader(armoredPublicKey))
+	assert.NoError(t, err)
+	packets, err := ioutil.ReadAll(block.Body)
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	armorWriteCloser, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	_, err = armorWriteCloser.Write(packets)
+	assert.NoError(t, err)
+	assert.NoError(t, revocation.Serialize(armorWriteCloser))
+	assert.NoError(t, armorWriteCloser.Close())
+	return buf.String()
+}
+
+// countSignatures returns the number of signature packets of the given type in the armored key.
+func countSignatures(t *testing.T, armoredPublicKey string, sigType packet.SignatureType) int {
+	t.Helper()
+
+	block, err := armor.Decode(strings.NewReader(armoredPublicKey))
+	assert.NoError(t, err)
+
+	count := 0
+	packets := packet.NewReader(block.Body)
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			return count
+		}
+		assert.NoError(t, err)
+
+		if sig, ok := p.(*packet.Signature); ok && sig.SigType == sigType {
+			count++
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+)
+
+// SuspiciousRequest is a rejected request that looked like a replay or tampering attempt.
+type SuspiciousRequest struct {
+	CreatedAt   time.Time
+	Kind        string
+	Fingerprint fpr.Fingerprint
+	IPAddress   string
+	Detail      string
+}
+
+// RecordSuspiciousRequest records a rejected request that looks like a replay or tampering
+// attempt. kind is a short identifier for the reason it was rejected, for example
+// `single_use_uuid_reused`, and detail is a human-readable description.
+// txn is a database transaction, or nil to run outside of a transaction
+func RecordSuspiciousRequest(
+	txn *sql.Tx, kind string, fingerprint fpr.Fingerprint, ipAddress string, detail string,
+	now time.Time) error {
+
+	if kind == "" {
+		return fmt.Errorf("invalid kind: cannot be empty")
+	}
+
+	query := `INSERT INTO suspicious_requests(
+                  created_at,
+                  kind,
+                  fingerprint,
+                  ip_address,
+                  detail
+              )
+              VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := transactionOrDatabase(txn).Exec(
+		query, now, kind, dbFormat(fingerprint), ipAddress, detail,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting into db: %v", err)
+	}
+	return nil
+}
+
+// ListSuspiciousRequests returns all the recorded suspicious requests, oldest first.
+func ListSuspiciousRequests(txn *sql.Tx) ([]SuspiciousRequest, error) {
+	query := `SELECT created_at,
+                     kind,
+                     fingerprint,
+                     ip_address,
+                     detail
+              FROM suspicious_requests
+              ORDER BY created_at, id`
+
+	rows, err := transactionOrDatabase(txn).Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []SuspiciousRequest{}
+	for rows.Next() {
+		request := SuspiciousRequest{}
+		var dbFingerprint string
+		err := rows.Scan(
+			&request.CreatedAt, &request.Kind, &dbFingerprint, &request.IPAddress,
+			&request.Detail,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Fingerprint, err = parseDbFormat(dbFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
@@ -0,0 +1,44 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestRecordSuspiciousRequest(t *testing.T) {
+	defer func() {
+		_, err := db.Exec("DELETE FROM suspicious_requests")
+		assert.NoError(t, err)
+	}()
+
+	now := time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC)
+
+	t.Run("records request for a key that isn't stored", func(t *testing.T) {
+		assert.NoError(t, RecordSuspiciousRequest(
+			nil, "single_use_uuid_reused", exampledata.ExampleFingerprint2, "192.0.2.1",
+			"single use UUID already used", now,
+		))
+
+		requests, err := ListSuspiciousRequests(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(requests))
+
+		assert.Equal(t, "single_use_uuid_reused", requests[0].Kind)
+		assert.Equal(t, exampledata.ExampleFingerprint2, requests[0].Fingerprint)
+		assert.Equal(t, "192.0.2.1", requests[0].IPAddress)
+		assert.Equal(t, "single use UUID already used", requests[0].Detail)
+		if !now.Equal(requests[0].CreatedAt) {
+			t.Fatalf("expected CreatedAt=%v, got %v", now, requests[0].CreatedAt)
+		}
+	})
+
+	t.Run("rejects empty kind", func(t *testing.T) {
+		err := RecordSuspiciousRequest(
+			nil, "", exampledata.ExampleFingerprint2, "192.0.2.1", "", now)
+		assert.Equal(t, fmt.Errorf("invalid kind: cannot be empty"), err)
+	})
+}
@@ -3,28 +3,41 @@ package datastore
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"sort"
 	"time"
 
 	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/team"
 	"github.com/gofrs/uuid"
 )
 
-// GetTeam returns a Team from the database
+// TeamDeletionGracePeriod is how long a soft-deleted team can be restored with RestoreTeam
+// before PurgeDeletedTeams deletes it for good.
+var TeamDeletionGracePeriod = time.Duration(30*24) * time.Hour
+
+// GetTeam returns a Team from the database. Soft-deleted teams aren't returned: they give
+// ErrNotFound.
 func GetTeam(txn *sql.Tx, teamUUID uuid.UUID) (*Team, error) {
 	query := `SELECT uuid,
                      created_at,
+					 updated_at,
 					 roster,
-					 roster_signature
+					 roster_signature,
+					 required_admin_signatures
 		  FROM teams
-		  WHERE uuid=$1`
+		  WHERE uuid=$1
+		  AND deleted_at IS NULL`
 
 	team := Team{}
 
 	err := transactionOrDatabase(txn).QueryRow(query, teamUUID).Scan(
 		&team.UUID,
 		&team.CreatedAt,
+		&team.UpdatedAt,
 		&team.Roster,
 		&team.RosterSignature,
+		&team.RequiredAdminSignatures,
 	)
 	if err == sql.ErrNoRows {
 		return nil, ErrNotFound
@@ -36,6 +49,42 @@ func GetTeam(txn *sql.Tx, teamUUID uuid.UUID) (*Team, error) {
 	return &team, nil
 }
 
+// ListTeams returns every team that hasn't been deleted, oldest first. Their rosters aren't
+// parsed, so teams with a corrupt roster are included.
+func ListTeams(txn *sql.Tx) ([]Team, error) {
+	query := `SELECT uuid,
+                     created_at,
+                     updated_at,
+                     roster,
+                     roster_signature,
+                     required_admin_signatures
+              FROM teams
+              WHERE deleted_at IS NULL
+              ORDER BY created_at, uuid`
+
+	rows, err := transactionOrDatabase(txn).Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	teams := []Team{}
+	for rows.Next() {
+		team := Team{}
+		if err := rows.Scan(
+			&team.UUID, &team.CreatedAt, &team.UpdatedAt, &team.Roster, &team.RosterSignature,
+			&team.RequiredAdminSignatures,
+		); err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
 // TeamExists returns true if the team with the given UUID already exists in the database
 func TeamExists(txn *sql.Tx, teamUUID uuid.UUID) (bool, error) {
 	_, err := GetTeam(txn, teamUUID)
@@ -52,32 +101,70 @@ func TeamExists(txn *sql.Tx, teamUUID uuid.UUID) (bool, error) {
 }
 
 // UpsertTeam creates a team in the database.
-// If a team already exists with team.UUID it updates the team.
+// If a team already exists with team.UUID it updates the team. If that team has been
+// soft-deleted it returns ErrTeamDeleted and leaves it alone.
 func UpsertTeam(txn *sql.Tx, team Team) error {
-	query := `INSERT INTO teams (uuid, created_at, roster, roster_signature)
-	          VALUES ($1, $2, $3, $4)
+	// on update, team.CreatedAt is the time of this write, so it becomes updated_at
+	query := `INSERT INTO teams (
+                  uuid, created_at, updated_at, roster, roster_signature, required_admin_signatures)
+	          VALUES ($1, $2, $2, $3, $4, $5)
               ON CONFLICT (uuid) DO UPDATE
-              SET roster           = EXCLUDED.roster,
-                  roster_signature = EXCLUDED.roster_signature`
+              SET updated_at                = EXCLUDED.created_at,
+                  roster                    = EXCLUDED.roster,
+                  roster_signature          = EXCLUDED.roster_signature,
+                  required_admin_signatures = EXCLUDED.required_admin_signatures
+              WHERE teams.deleted_at IS NULL`
+
+	requiredAdminSignatures := team.RequiredAdminSignatures
+	if requiredAdminSignatures < 1 {
+		requiredAdminSignatures = 1
+	}
 
 	// query := `INSERT INTO teams (uuid, created_at, roster, roster_signature)
 	//           VALUES ($1, $2)
 	// 	  ON CONFLICT (uid) DO UPDATE
 	// 	      SET armored_public_key=EXCLUDED.armored_public_key`
 
-	_, err := transactionOrDatabase(txn).Exec(
+	result, err := transactionOrDatabase(txn).Exec(
 		query,
 		team.UUID,
 		team.CreatedAt,
 		team.Roster,
 		team.RosterSignature,
+		requiredAdminSignatures,
 	)
+	if err != nil {
+		return err
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if numRowsAffected < 1 {
+		return ErrTeamDeleted // the conflicting team is soft-deleted so wasn't updated
+	}
+	return nil
+}
+
+// LockTeam locks the team's row until the end of txn, so that reading the team and then
+// writing it back can't race another transaction doing the same. It returns ErrNotFound if the
+// team doesn't exist or has been soft-deleted.
+func LockTeam(txn *sql.Tx, teamUUID uuid.UUID) error {
+	query := `SELECT uuid FROM teams WHERE uuid = $1 AND deleted_at IS NULL FOR UPDATE`
 
+	var lockedUUID uuid.UUID
+	err := txn.QueryRow(query, teamUUID).Scan(&lockedUUID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
 	return err
 }
 
 // DeleteTeam deletes the team with the given UUID and returns true if it was deleted, or false
-// if the team was not found.
+// if the team was not found. The team and its requests to join are gone for good: use
+// SoftDeleteTeam unless that's really what's wanted.
 func DeleteTeam(txn *sql.Tx, teamUUID uuid.UUID) (found bool, err error) {
 	query := `DELETE FROM teams WHERE uuid = $1`
 
@@ -98,54 +185,137 @@ func DeleteTeam(txn *sql.Tx, teamUUID uuid.UUID) (found bool, err error) {
 	return true, nil // found and deleted
 }
 
+// SoftDeleteTeam hides the team with the given UUID, as if it had been deleted, and returns
+// true if it was found. It can be brought back with RestoreTeam until TeamDeletionGracePeriod
+// has passed, after which PurgeDeletedTeams deletes it.
+func SoftDeleteTeam(txn *sql.Tx, teamUUID uuid.UUID, now time.Time) (found bool, err error) {
+	query := `UPDATE teams SET deleted_at = $2 WHERE uuid = $1 AND deleted_at IS NULL`
+
+	result, err := transactionOrDatabase(txn).Exec(query, teamUUID, now)
+	if err != nil {
+		return false, err
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return numRowsAffected > 0, nil
+}
+
+// RestoreTeam undoes SoftDeleteTeam and returns true if the team was restored, or false if
+// there's no soft-deleted team with the given UUID that's still within TeamDeletionGracePeriod.
+func RestoreTeam(txn *sql.Tx, teamUUID uuid.UUID, now time.Time) (found bool, err error) {
+	query := `UPDATE teams SET deleted_at = NULL WHERE uuid = $1 AND deleted_at > $2`
+
+	result, err := transactionOrDatabase(txn).Exec(
+		query, teamUUID, now.Add(-TeamDeletionGracePeriod))
+	if err != nil {
+		return false, err
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return numRowsAffected > 0, nil
+}
+
+// PurgeDeletedTeams deletes teams that were soft-deleted more than TeamDeletionGracePeriod
+// before now, along with their requests to join. It returns how many teams were deleted.
+func PurgeDeletedTeams(txn *sql.Tx, now time.Time) (int, error) {
+	query := `DELETE FROM teams WHERE deleted_at <= $1`
+
+	result, err := transactionOrDatabase(txn).Exec(query, now.Add(-TeamDeletionGracePeriod))
+	if err != nil {
+		return 0, err
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(numRowsAffected), nil
+}
+
 // CreateRequestToJoinTeam creates a new request to add the given email and key fingerprint to
 // the team.
 // It's only allowed to have a single request per {team, email} pair. Attempts to create a second
-// request for the same {team, email} but *different* fingerprint will fail.
+// request for the same {team, email} but *different* fingerprint fail with
+// ErrConflictingRequestToJoinTeam.
+// It's safe to call concurrently: identical requests made at the same time all get the UUID of
+// the one that was stored.
 func CreateRequestToJoinTeam(
 	txn *sql.Tx, teamUUID uuid.UUID,
 	email string, fingerprint fpr.Fingerprint, now time.Time) (*uuid.UUID, error) {
 
+	requestUUID, _, err := CreateRequestToJoinTeamIfNew(txn, teamUUID, email, fingerprint, now)
+	return requestUUID, err
+}
+
+// CreateRequestToJoinTeamIfNew is like CreateRequestToJoinTeam, but also returns created=false
+// if an identical request was already stored, in which case its UUID is returned.
+func CreateRequestToJoinTeamIfNew(
+	txn *sql.Tx, teamUUID uuid.UUID,
+	email string, fingerprint fpr.Fingerprint, now time.Time) (
+	requestUUID *uuid.UUID, created bool, err error) {
+
 	if exists, err := TeamExists(txn, teamUUID); err != nil {
-		return nil, fmt.Errorf("error checking if team exists: %v", err)
+		return nil, false, fmt.Errorf("error checking if team exists: %v", err)
 	} else if !exists {
-		return nil, ErrNotFound
+		return nil, false, ErrNotFound
 	}
 
-	existingRequest, err := GetRequestToJoinTeam(txn, teamUUID, email)
-	if err != nil && err != ErrNotFound {
-		return nil, fmt.Errorf("error looking for existing request: %v", err)
-	}
-
-	if existingRequest != nil && existingRequest.Fingerprint == fingerprint {
-		// got an existing, identical request. rather than creating a new one, just return the
-		// UUID of the existing one
-		return &existingRequest.UUID, nil
-	} else if existingRequest != nil && existingRequest.Fingerprint != fingerprint {
-		// got an existing request for the same {team, email} combination but with a different
-		// fingerprint. reject it.
-		return nil, fmt.Errorf("existing request for {team, email}")
+	newRequestUUID, err := uuid.NewV4()
+	if err != nil {
+		return nil, false, err
 	}
 
+	// rather than checking for an existing request first, which races with concurrent calls,
+	// let the unique constraint decide
 	query := `INSERT INTO team_join_requests (uuid, created_at, team_uuid, email, fingerprint)
-	          VALUES ($1, $2, $3, $4, $5)`
+	          VALUES ($1, $2, $3, $4, $5)
+	          ON CONFLICT (team_uuid, email) DO NOTHING
+	          RETURNING uuid`
 
-	newRequestUUID, err := uuid.NewV4()
-	if err != nil {
-		return nil, err
-	}
-	_, err = transactionOrDatabase(txn).Exec(
+	var insertedUUID uuid.UUID
+	err = transactionOrDatabase(txn).QueryRow(
 		query,
 		newRequestUUID,
 		now,
 		teamUUID,
 		email,
 		dbFormat(fingerprint),
-	)
+	).Scan(&insertedUUID)
+	if err == nil {
+		return &insertedUUID, true, nil
+	} else if err != sql.ErrNoRows {
+		return nil, false, err
+	}
 
-	return &newRequestUUID, err
+	// nothing was inserted, so there's already a request for this {team, email}
+	existingRequest, err := GetRequestToJoinTeam(txn, teamUUID, email)
+	if err != nil {
+		return nil, false, fmt.Errorf("error looking for existing request: %v", err)
+	}
+
+	if existingRequest.Fingerprint != fingerprint {
+		// got an existing request for the same {team, email} combination but with a different
+		// fingerprint. reject it.
+		return nil, false, ErrConflictingRequestToJoinTeam
+	}
+
+	// got an existing, identical request. rather than creating a new one, just return the
+	// UUID of the existing one
+	return &existingRequest.UUID, false, nil
 }
 
+// ErrConflictingRequestToJoinTeam is returned by CreateRequestToJoinTeam if there's already a
+// request for the same {team, email} with a different fingerprint.
+var ErrConflictingRequestToJoinTeam = fmt.Errorf("existing request for {team, email}")
+
 // GetRequestToJoinTeam searches for an existing request for the given team UUID and email
 // address combination.
 func GetRequestToJoinTeam(txn *sql.Tx, teamUUID uuid.UUID, email string) (
@@ -173,6 +343,39 @@ func GetRequestToJoinTeam(txn *sql.Tx, teamUUID uuid.UUID, email string) (
 		return nil, err
 	}
 
+	if request.Fingerprint, err = parseDbFormat(fingerprintString); err != nil {
+		return nil, fmt.Errorf("got bad fingerprint from database: %v", fingerprintString)
+	}
+	request.TeamUUID = teamUUID
+
+	return &request, nil
+}
+
+// GetRequestToJoinTeamByUUID returns the request to join a team with the given UUID, including
+// the UUID of the team, or ErrNotFound.
+func GetRequestToJoinTeamByUUID(txn *sql.Tx, requestUUID uuid.UUID) (*RequestToJoinTeam, error) {
+	query := `SELECT uuid, team_uuid, created_at, email, fingerprint
+	            FROM team_join_requests
+	            WHERE uuid=$1`
+
+	request := RequestToJoinTeam{}
+
+	var fingerprintString string
+
+	err := transactionOrDatabase(txn).QueryRow(query, requestUUID).Scan(
+		&request.UUID,
+		&request.TeamUUID,
+		&request.CreatedAt,
+		&request.Email,
+		&fingerprintString,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+
+	} else if err != nil {
+		return nil, err
+	}
+
 	if request.Fingerprint, err = parseDbFormat(fingerprintString); err != nil {
 		return nil, fmt.Errorf("got bad fingerprint from database: %v", fingerprintString)
 	}
@@ -201,13 +404,60 @@ func DeleteRequestToJoinTeam(txn *sql.Tx, requestUUID uuid.UUID) (found bool, er
 	return true, nil // found and deleted
 }
 
-// GetRequestsToJoinTeam returns a slice of RequestToJoinTeams.
+// DeleteJoinRequestsOlderThan deletes every request to join a team that was made before cutoff,
+// returning how many were deleted.
+func DeleteJoinRequestsOlderThan(txn *sql.Tx, cutoff time.Time) (int, error) {
+	query := `DELETE FROM team_join_requests WHERE created_at < $1`
+
+	result, err := transactionOrDatabase(txn).Exec(query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(numRowsAffected), nil
+}
+
+// GetRequestsToJoinTeam returns all the requests to join the given team, oldest first. Requests
+// made at the same time are ordered by UUID, so the order is always the same.
 func GetRequestsToJoinTeam(txn *sql.Tx, teamUUID uuid.UUID) ([]RequestToJoinTeam, error) {
-	query := `SELECT uuid, created_at, email, fingerprint
-		        FROM team_join_requests
-	            WHERE team_uuid=$1`
+	return getRequestsToJoinTeam(txn, teamUUID, sql.NullInt64{}, 0)
+}
+
+// GetRequestsToJoinTeamPage returns up to limit requests to join the given team, oldest first,
+// skipping the first offset. total is the number of requests there are for the team altogether.
+func GetRequestsToJoinTeamPage(txn *sql.Tx, teamUUID uuid.UUID, limit int, offset int) (
+	requests []RequestToJoinTeam, total int, err error) {
+
+	if limit < 1 {
+		return nil, 0, fmt.Errorf("invalid limit %d: must be at least 1", limit)
+	} else if offset < 0 {
+		return nil, 0, fmt.Errorf("invalid offset %d: cannot be negative", offset)
+	}
 
-	rows, err := transactionOrDatabase(txn).Query(query, teamUUID)
+	query := `SELECT COUNT(*) FROM team_join_requests WHERE team_uuid=$1`
+	if err := transactionOrDatabase(txn).QueryRow(query, teamUUID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	requests, err = getRequestsToJoinTeam(
+		txn, teamUUID, sql.NullInt64{Int64: int64(limit), Valid: true}, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return requests, total, nil
+}
+
+// getRequestsToJoinTeam returns the requests to join the given team, oldest first. A null limit
+// returns every request after offset.
+func getRequestsToJoinTeam(txn *sql.Tx, teamUUID uuid.UUID, limit sql.NullInt64, offset int) (
+	[]RequestToJoinTeam, error) {
+
+	rows, err := transactionOrDatabase(txn).Query(
+		getRequestsToJoinTeamQuery, teamUUID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -229,6 +479,7 @@ func GetRequestsToJoinTeam(txn *sql.Tx, teamUUID uuid.UUID) ([]RequestToJoinTeam
 		if requestToJoinTeam.Fingerprint, err = parseDbFormat(fingerprintString); err != nil {
 			return nil, fmt.Errorf("got bad fingerprint from database: %v", fingerprintString)
 		}
+		requestToJoinTeam.TeamUUID = teamUUID
 		requestsToJoinTeam = append(requestsToJoinTeam, requestToJoinTeam)
 	}
 	err = rows.Err()
@@ -240,6 +491,112 @@ func GetRequestsToJoinTeam(txn *sql.Tx, teamUUID uuid.UUID) ([]RequestToJoinTeam
 	return requestsToJoinTeam, nil
 }
 
+// getRequestsToJoinTeamQuery orders by uuid as well as created_at so that pages are stable
+// when several requests were made at the same time. A NULL limit means no limit.
+const getRequestsToJoinTeamQuery = `SELECT uuid, created_at, email, fingerprint
+		        FROM team_join_requests
+	            WHERE team_uuid=$1
+	            ORDER BY created_at, uuid
+	            LIMIT $2 OFFSET $3`
+
+// ListTeamsForFingerprint returns the stored teams whose roster lists the given fingerprint,
+// ordered by name.
+func ListTeamsForFingerprint(txn *sql.Tx, fingerprint fpr.Fingerprint) ([]team.Team, error) {
+	rosters, err := loadAllRosters(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := []team.Team{}
+	for _, roster := range rosters {
+		if roster.Contains(fingerprint) {
+			teams = append(teams, *roster)
+		}
+	}
+
+	sortTeamsByName(teams)
+	return teams, nil
+}
+
+// ListTeamsAdministeredBy returns the stored teams whose roster lists the given fingerprint as
+// an admin, ordered by name.
+func ListTeamsAdministeredBy(txn *sql.Tx, fingerprint fpr.Fingerprint) ([]team.Team, error) {
+	rosters, err := loadAllRosters(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := []team.Team{}
+	for _, roster := range rosters {
+		if roster.IsAdmin(fingerprint) {
+			teams = append(teams, *roster)
+		}
+	}
+
+	sortTeamsByName(teams)
+	return teams, nil
+}
+
+// ListTeamRosters returns every stored team's parsed roster, ordered by name. Rosters that fail
+// to parse are logged and skipped.
+func ListTeamRosters(txn *sql.Tx) ([]team.Team, error) {
+	rosters, err := loadAllRosters(txn)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := []team.Team{}
+	for _, roster := range rosters {
+		teams = append(teams, *roster)
+	}
+
+	sortTeamsByName(teams)
+	return teams, nil
+}
+
+// sortTeamsByName sorts the teams by name, then by UUID so the order is always the same.
+func sortTeamsByName(teams []team.Team) {
+	sort.Slice(teams, func(i, j int) bool {
+		if teams[i].Name != teams[j].Name {
+			return teams[i].Name < teams[j].Name
+		}
+		return teams[i].UUID.String() < teams[j].UUID.String()
+	})
+}
+
+// loadAllRosters parses every stored team roster, except soft-deleted teams. Rosters that fail to parse are logged and
+// skipped. The roster signatures aren't checked: they were verified when the roster was
+// uploaded.
+func loadAllRosters(txn *sql.Tx) ([]*team.Team, error) {
+	rows, err := transactionOrDatabase(txn).Query(
+		`SELECT uuid, roster, roster_signature FROM teams WHERE deleted_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rosters := []*team.Team{}
+	for rows.Next() {
+		var teamUUID uuid.UUID
+		var roster, rosterSignature string
+
+		if err := rows.Scan(&teamUUID, &roster, &rosterSignature); err != nil {
+			return nil, err
+		}
+
+		loaded, err := team.Load(roster, rosterSignature)
+		if err != nil {
+			log.Printf("team %s: error loading roster: %v", teamUUID, err)
+			continue
+		}
+		rosters = append(rosters, loaded)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return rosters, nil
+}
+
 // Team represents a team in the database
 type Team struct {
 	UUID   uuid.UUID
@@ -248,16 +605,28 @@ type Team struct {
 	// RosterSignature is the ASCII-armored, detached signature of the Roster
 	RosterSignature string
 	CreatedAt       time.Time
+
+	// UpdatedAt is when the team was last written, or nil for teams last written before it was
+	// recorded.
+	UpdatedAt *time.Time
+
+	// RequiredAdminSignatures is how many of the team's admins must sign an update to the
+	// roster. 0 is stored as 1.
+	RequiredAdminSignatures int
 }
 
 // RequestToJoinTeam represents a request to join a team in the database.
 type RequestToJoinTeam struct {
 	UUID        uuid.UUID
+	TeamUUID    uuid.UUID
 	CreatedAt   time.Time
 	Email       string
 	Fingerprint fpr.Fingerprint
 }
 
+// ErrTeamDeleted indicates that a team couldn't be updated because it's been soft-deleted
+var ErrTeamDeleted = fmt.Errorf("team has been deleted")
+
 // ErrNotFound indicates that the requested item wasn't found in the database (but the query was
 // successful)
 var ErrNotFound = fmt.Errorf("not found")
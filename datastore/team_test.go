@@ -1,6 +1,8 @@
 package datastore
 
 import (
+	"fmt"
+	"sort"
 	"testing"
 	"time"
 
@@ -34,10 +36,11 @@ func TestUpsertTeam(t *testing.T) {
 		}
 
 		updatedTeam := Team{
-			UUID:            teamUUID,
-			Roster:          "updated-roster",
-			RosterSignature: "updated-signature",
-			CreatedAt:       later, // CreatedAt should *not* change on update
+			UUID:                    teamUUID,
+			Roster:                  "updated-roster",
+			RosterSignature:         "updated-signature",
+			CreatedAt:               later, // CreatedAt should *not* change on update
+			RequiredAdminSignatures: 2,
 		}
 		err := UpsertTeam(nil, originalTeam)
 		assert.NoError(t, err)
@@ -56,10 +59,21 @@ func TestUpsertTeam(t *testing.T) {
 			assert.Equal(t, updatedTeam.RosterSignature, retrievedTeam.RosterSignature)
 		})
 
+		t.Run("required admin signatures has been updated", func(t *testing.T) {
+			assert.Equal(t, 2, retrievedTeam.RequiredAdminSignatures)
+		})
+
 		t.Run("CreatedAt remains unchanged", func(t *testing.T) {
 			assert.Equal(t, true, originalTeam.CreatedAt.Equal(retrievedTeam.CreatedAt))
 		})
 
+		t.Run("UpdatedAt is the time of the update", func(t *testing.T) {
+			if retrievedTeam.UpdatedAt == nil {
+				t.Fatalf("expected UpdatedAt to be set, got nil")
+			}
+			assert.Equal(t, true, later.Equal(*retrievedTeam.UpdatedAt))
+		})
+
 	})
 }
 
@@ -76,6 +90,7 @@ func TestGetTeam(t *testing.T) {
 		assert.Equal(t, team.UUID, testUUID)
 		assert.Equal(t, team.Roster, "fake-roster")
 		assert.Equal(t, team.RosterSignature, "fake-signature")
+		assert.Equal(t, 1, team.RequiredAdminSignatures) // 0 is stored as 1
 		if !team.CreatedAt.Equal(now) {
 			t.Fatalf("expected %s, got %s", team.CreatedAt, now)
 		}
@@ -129,6 +144,126 @@ func TestDeleteTeam(t *testing.T) {
 	})
 }
 
+func TestSoftDeleteTeam(t *testing.T) {
+	deletedAt := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	withinGracePeriod := deletedAt.Add(TeamDeletionGracePeriod - time.Hour)
+	afterGracePeriod := deletedAt.Add(TeamDeletionGracePeriod + time.Hour)
+
+	t.Run("soft-deleted team is hidden", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+		createTestRequestToJoinTeam(t)
+
+		found, err := SoftDeleteTeam(nil, testUUID, deletedAt)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		_, err = GetTeam(nil, testUUID)
+		assert.Equal(t, ErrNotFound, err)
+
+		exists, err := TeamExists(nil, testUUID)
+		assert.NoError(t, err)
+		assert.Equal(t, false, exists)
+
+		t.Run("and can't be soft-deleted again", func(t *testing.T) {
+			found, err := SoftDeleteTeam(nil, testUUID, deletedAt)
+			assert.NoError(t, err)
+			assert.Equal(t, false, found)
+		})
+
+		t.Run("and can't be overwritten by upserting", func(t *testing.T) {
+			err := UpsertTeam(nil, Team{
+				UUID:            testUUID,
+				Roster:          "hijacked-roster",
+				RosterSignature: "hijacked-signature",
+				CreatedAt:       later,
+			})
+			assert.Equal(t, ErrTeamDeleted, err)
+		})
+
+		t.Run("and its requests to join are kept", func(t *testing.T) {
+			requests, err := GetRequestsToJoinTeam(nil, testUUID)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(requests))
+		})
+	})
+
+	t.Run("restore within grace period", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+
+		_, err := SoftDeleteTeam(nil, testUUID, deletedAt)
+		assert.NoError(t, err)
+
+		found, err := RestoreTeam(nil, testUUID, withinGracePeriod)
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+
+		team, err := GetTeam(nil, testUUID)
+		assert.NoError(t, err)
+		assert.Equal(t, "fake-roster", team.Roster)
+	})
+
+	t.Run("can't restore after grace period", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+
+		_, err := SoftDeleteTeam(nil, testUUID, deletedAt)
+		assert.NoError(t, err)
+
+		found, err := RestoreTeam(nil, testUUID, afterGracePeriod)
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+	})
+
+	t.Run("can't restore a team that isn't deleted", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+
+		found, err := RestoreTeam(nil, testUUID, withinGracePeriod)
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+		createTestRequestToJoinTeam(t)
+
+		_, err := SoftDeleteTeam(nil, testUUID, deletedAt)
+		assert.NoError(t, err)
+
+		t.Run("keeps teams within grace period", func(t *testing.T) {
+			_, err := PurgeDeletedTeams(nil, withinGracePeriod)
+			assert.NoError(t, err)
+
+			found, err := RestoreTeam(nil, testUUID, withinGracePeriod)
+			assert.NoError(t, err)
+			assert.Equal(t, true, found)
+
+			_, err = SoftDeleteTeam(nil, testUUID, deletedAt)
+			assert.NoError(t, err)
+		})
+
+		t.Run("deletes teams after grace period", func(t *testing.T) {
+			purged, err := PurgeDeletedTeams(nil, afterGracePeriod)
+			assert.NoError(t, err)
+			if purged < 1 {
+				t.Fatalf("expected at least 1 team purged, got %d", purged)
+			}
+
+			// it's really gone: a hard delete finds nothing
+			found, err := DeleteTeam(nil, testUUID)
+			assert.NoError(t, err)
+			assert.Equal(t, false, found)
+
+			requests, err := GetRequestsToJoinTeam(nil, testUUID)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, len(requests))
+		})
+	})
+}
+
 func TestGetRequestToJoinTeam(t *testing.T) {
 	now := time.Date(2019, 6, 19, 16, 35, 41, 0, time.UTC)
 
@@ -170,6 +305,38 @@ func TestGetRequestToJoinTeam(t *testing.T) {
 	})
 }
 
+func TestGetRequestToJoinTeamByUUID(t *testing.T) {
+	now := time.Date(2019, 7, 16, 10, 0, 0, 0, time.UTC)
+
+	t.Run("with existing request", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+
+		fingerprint := fpr.MustParse("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+
+		createdUUID, err := CreateRequestToJoinTeam(
+			nil, testUUID, "test@example.com", fingerprint, now)
+		assert.NoError(t, err)
+
+		got, err := GetRequestToJoinTeamByUUID(nil, *createdUUID)
+		assert.NoError(t, err)
+
+		assert.Equal(t, *createdUUID, got.UUID)
+		assert.Equal(t, testUUID, got.TeamUUID)
+		assert.Equal(t, "test@example.com", got.Email)
+		assert.Equal(t, fingerprint, got.Fingerprint)
+
+		if !now.Equal(got.CreatedAt) {
+			t.Fatalf("expected CreatedAt `%v`, got `%v`", now, got.CreatedAt)
+		}
+	})
+
+	t.Run("with no matching request", func(t *testing.T) {
+		_, err := GetRequestToJoinTeamByUUID(nil, uuid.Must(uuid.NewV4()))
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
 func TestDeleteRequestToJoinTeam(t *testing.T) {
 	createTestTeam(t)
 	requestUUID := createTestRequestToJoinTeam(t)
@@ -188,6 +355,35 @@ func TestDeleteRequestToJoinTeam(t *testing.T) {
 	})
 }
 
+func TestDeleteJoinRequestsOlderThan(t *testing.T) {
+	createTestTeam(t)
+	defer deleteTestTeam(t)
+
+	// well before the requests made by other tests, so they're never old enough to be deleted
+	cutoff := time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC)
+	old := cutoff.Add(-time.Hour)
+	recent := cutoff.Add(time.Hour)
+
+	for _, email := range []string{"old1@example.com", "old2@example.com"} {
+		_, err := CreateRequestToJoinTeam(nil, testUUID, email, exampledata.ExampleFingerprint4, old)
+		assert.NoError(t, err)
+	}
+	_, err := CreateRequestToJoinTeam(
+		nil, testUUID, "recent@example.com", exampledata.ExampleFingerprint4, recent)
+	assert.NoError(t, err)
+
+	deleted, err := DeleteJoinRequestsOlderThan(nil, cutoff)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	t.Run("only recent requests are left", func(t *testing.T) {
+		requests, err := GetRequestsToJoinTeam(nil, testUUID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(requests))
+		assert.Equal(t, "recent@example.com", requests[0].Email)
+	})
+}
+
 func TestGetRequestsToJoinTeam(t *testing.T) {
 	now := time.Date(2019, 6, 19, 16, 35, 41, 0, time.UTC)
 
@@ -204,7 +400,7 @@ func TestGetRequestsToJoinTeam(t *testing.T) {
 		fingerprint2 := fpr.MustParse("BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB")
 
 		createdUUID2, err := CreateRequestToJoinTeam(
-			nil, testUUID, "test2@example.com", fingerprint2, now)
+			nil, testUUID, "test2@example.com", fingerprint2, now.Add(time.Second))
 		assert.NoError(t, err)
 
 		got, err := GetRequestsToJoinTeam(nil, testUUID)
@@ -221,6 +417,52 @@ func TestGetRequestsToJoinTeam(t *testing.T) {
 		assert.Equal(t, fingerprint2, got[1].Fingerprint)
 	})
 
+	t.Run("orders requests by created_at, not by when they were inserted", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+
+		newerUUID, err := CreateRequestToJoinTeam(nil, testUUID, "newer@example.com",
+			fpr.MustParse("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"), now.Add(time.Hour))
+		assert.NoError(t, err)
+
+		olderUUID, err := CreateRequestToJoinTeam(nil, testUUID, "older@example.com",
+			fpr.MustParse("BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB"), now)
+		assert.NoError(t, err)
+
+		got, err := GetRequestsToJoinTeam(nil, testUUID)
+		assert.NoError(t, err)
+
+		assert.Equal(t, 2, len(got))
+		assert.Equal(t, *olderUUID, got[0].UUID)
+		assert.Equal(t, *newerUUID, got[1].UUID)
+	})
+
+	t.Run("orders requests made at the same time by uuid", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+
+		createdUUIDs := []uuid.UUID{}
+		for i := 0; i < 5; i++ {
+			createdUUID, err := CreateRequestToJoinTeam(
+				nil, testUUID, fmt.Sprintf("test%d@example.com", i),
+				fpr.MustParse(fmt.Sprintf("%040d", i)), now)
+			assert.NoError(t, err)
+			createdUUIDs = append(createdUUIDs, *createdUUID)
+		}
+		sort.Slice(createdUUIDs, func(i, j int) bool {
+			return createdUUIDs[i].String() < createdUUIDs[j].String()
+		})
+
+		got, err := GetRequestsToJoinTeam(nil, testUUID)
+		assert.NoError(t, err)
+
+		gotUUIDs := []uuid.UUID{}
+		for _, request := range got {
+			gotUUIDs = append(gotUUIDs, request.UUID)
+		}
+		assert.Equal(t, createdUUIDs, gotUUIDs)
+	})
+
 	t.Run("with team existing but no requests", func(t *testing.T) {
 		createTestTeam(t)
 		defer deleteTestTeam(t)
@@ -241,6 +483,69 @@ func TestGetRequestsToJoinTeam(t *testing.T) {
 	})
 }
 
+func TestGetRequestsToJoinTeamPage(t *testing.T) {
+	now := time.Date(2019, 6, 19, 16, 35, 41, 0, time.UTC)
+
+	createTestTeam(t)
+	defer deleteTestTeam(t)
+
+	// create them newest first, to check they come back ordered by created_at
+	createdUUIDs := make([]uuid.UUID, 5)
+	for i := len(createdUUIDs) - 1; i >= 0; i-- {
+		createdUUID, err := CreateRequestToJoinTeam(
+			nil, testUUID, fmt.Sprintf("test%d@example.com", i),
+			fpr.MustParse(fmt.Sprintf("%040d", i)), now.Add(time.Duration(i)*time.Minute))
+		assert.NoError(t, err)
+		createdUUIDs[i] = *createdUUID
+	}
+
+	uuidsOf := func(requests []RequestToJoinTeam) []uuid.UUID {
+		uuids := []uuid.UUID{}
+		for _, request := range requests {
+			uuids = append(uuids, request.UUID)
+		}
+		return uuids
+	}
+
+	t.Run("first page", func(t *testing.T) {
+		got, total, err := GetRequestsToJoinTeamPage(nil, testUUID, 2, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, total)
+		assert.Equal(t, createdUUIDs[0:2], uuidsOf(got))
+	})
+
+	t.Run("middle page", func(t *testing.T) {
+		got, total, err := GetRequestsToJoinTeamPage(nil, testUUID, 2, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, total)
+		assert.Equal(t, createdUUIDs[2:4], uuidsOf(got))
+	})
+
+	t.Run("last, partial page", func(t *testing.T) {
+		got, total, err := GetRequestsToJoinTeamPage(nil, testUUID, 2, 4)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, total)
+		assert.Equal(t, createdUUIDs[4:5], uuidsOf(got))
+	})
+
+	t.Run("offset past the end", func(t *testing.T) {
+		got, total, err := GetRequestsToJoinTeamPage(nil, testUUID, 2, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, total)
+		assert.Equal(t, 0, len(got))
+	})
+
+	t.Run("rejects a limit less than 1", func(t *testing.T) {
+		_, _, err := GetRequestsToJoinTeamPage(nil, testUUID, 0, 0)
+		assert.Equal(t, fmt.Errorf("invalid limit 0: must be at least 1"), err)
+	})
+
+	t.Run("rejects a negative offset", func(t *testing.T) {
+		_, _, err := GetRequestsToJoinTeamPage(nil, testUUID, 2, -1)
+		assert.Equal(t, fmt.Errorf("invalid offset -1: cannot be negative"), err)
+	})
+}
+
 func TestCreateRequestToJoinTeam(t *testing.T) {
 	t.Run("when team exists and request is OK", func(t *testing.T) {
 		createTestTeam(t)
@@ -290,7 +595,7 @@ func TestCreateRequestToJoinTeam(t *testing.T) {
 			fpr.MustParse("BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB"), // different fingerprint
 			now,
 		)
-		assert.GotError(t, err)
+		assert.Equal(t, ErrConflictingRequestToJoinTeam, err)
 	})
 
 	t.Run("existing (team, email, fingerprint) request should silently succeed", func(t *testing.T) {
@@ -314,6 +619,222 @@ func TestCreateRequestToJoinTeam(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, *firstUUID, *secondUUID) // return the UUID of the existing, identical req
 	})
+
+	t.Run("IfNew reports whether the request was created", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+
+		firstUUID, created, err := CreateRequestToJoinTeamIfNew(
+			nil, testUUID,
+			"created-request@example.com",
+			fpr.MustParse("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"),
+			now,
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, true, created)
+
+		secondUUID, created, err := CreateRequestToJoinTeamIfNew(
+			nil, testUUID,
+			"created-request@example.com",
+			fpr.MustParse("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"),
+			now,
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, false, created)
+		assert.Equal(t, *firstUUID, *secondUUID)
+	})
+
+	t.Run("concurrent identical requests all get the same UUID", func(t *testing.T) {
+		createTestTeam(t)
+		defer deleteTestTeam(t)
+
+		const numRequests = 10
+		type result struct {
+			uuid *uuid.UUID
+			err  error
+		}
+		results := make(chan result, numRequests)
+		start := make(chan struct{})
+
+		for i := 0; i < numRequests; i++ {
+			go func() {
+				<-start
+				requestUUID, err := CreateRequestToJoinTeam(
+					nil, testUUID,
+					"concurrent-request@example.com",
+					fpr.MustParse("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"),
+					now,
+				)
+				results <- result{requestUUID, err}
+			}()
+		}
+		close(start)
+
+		uuidsSeen := map[uuid.UUID]bool{}
+		for i := 0; i < numRequests; i++ {
+			r := <-results
+			if r.err != nil {
+				t.Fatalf("request %d failed: %v", i, r.err)
+			}
+			uuidsSeen[*r.uuid] = true
+		}
+		assert.Equal(t, 1, len(uuidsSeen))
+
+		stored, err := GetRequestToJoinTeam(nil, testUUID, "concurrent-request@example.com")
+		assert.NoError(t, err)
+		if !uuidsSeen[stored.UUID] {
+			t.Fatalf("stored request %s wasn't the one returned", stored.UUID)
+		}
+	})
+}
+
+func TestListTeams(t *testing.T) {
+	olderTeam := Team{
+		UUID: uuid.Must(uuid.NewV4()), Roster: "not a roster", RosterSignature: "fake-signature",
+		CreatedAt: now,
+	}
+	newerTeam := Team{
+		UUID: uuid.Must(uuid.NewV4()), Roster: "fake-roster", RosterSignature: "fake-signature",
+		CreatedAt: later,
+	}
+	deletedTeam := Team{
+		UUID: uuid.Must(uuid.NewV4()), Roster: "fake-roster", RosterSignature: "fake-signature",
+		CreatedAt: now,
+	}
+
+	for _, team := range []Team{newerTeam, olderTeam, deletedTeam} {
+		assert.NoError(t, UpsertTeam(nil, team))
+	}
+	_, err := SoftDeleteTeam(nil, deletedTeam.UUID, later)
+	assert.NoError(t, err)
+
+	defer func() {
+		for _, team := range []Team{olderTeam, newerTeam, deletedTeam} {
+			_, err := DeleteTeam(nil, team.UUID)
+			assert.NoError(t, err)
+		}
+	}()
+
+	t.Run("returns teams that aren't deleted, oldest first, without parsing them", func(t *testing.T) {
+		teams, err := ListTeams(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(teams))
+
+		assert.Equal(t, olderTeam.UUID, teams[0].UUID)
+		assert.Equal(t, "not a roster", teams[0].Roster)
+		assert.Equal(t, "fake-signature", teams[0].RosterSignature)
+
+		assert.Equal(t, newerTeam.UUID, teams[1].UUID)
+	})
+}
+
+func TestListTeamsForFingerprint(t *testing.T) {
+	teamA := uuid.Must(uuid.NewV4())
+	teamB := uuid.Must(uuid.NewV4())
+	teamC := uuid.Must(uuid.NewV4())
+
+	for _, team := range []Team{
+		{UUID: teamB, Roster: makeTestRoster(teamB, "Team B",
+			exampledata.ExampleFingerprint2, exampledata.ExampleFingerprint4)},
+		{UUID: teamA, Roster: makeTestRoster(teamA, "Team A", exampledata.ExampleFingerprint4)},
+		{UUID: teamC, Roster: makeTestRoster(teamC, "Team C", exampledata.ExampleFingerprint2)},
+	} {
+		team.RosterSignature = "not checked"
+		team.CreatedAt = now
+		assert.NoError(t, UpsertTeam(nil, team))
+	}
+
+	defer func() {
+		for _, teamUUID := range []uuid.UUID{teamA, teamB, teamC} {
+			_, err := DeleteTeam(nil, teamUUID)
+			assert.NoError(t, err)
+		}
+	}()
+
+	t.Run("returns the teams listing the fingerprint, ordered by name", func(t *testing.T) {
+		teams, err := ListTeamsForFingerprint(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(teams))
+		assert.Equal(t, teamA, teams[0].UUID)
+		assert.Equal(t, teamB, teams[1].UUID)
+	})
+
+	t.Run("returns no teams for a fingerprint that isn't in any", func(t *testing.T) {
+		teams, err := ListTeamsForFingerprint(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(teams))
+	})
+}
+
+func TestListTeamsAdministeredBy(t *testing.T) {
+	teamA := uuid.Must(uuid.NewV4())
+	teamB := uuid.Must(uuid.NewV4())
+	teamC := uuid.Must(uuid.NewV4())
+
+	// the first fingerprint in each roster is the admin
+	for _, team := range []Team{
+		{UUID: teamB, Roster: makeTestRoster(teamB, "Team B",
+			exampledata.ExampleFingerprint2, exampledata.ExampleFingerprint4)},
+		{UUID: teamA, Roster: makeTestRoster(teamA, "Team A", exampledata.ExampleFingerprint4)},
+		{UUID: teamC, Roster: makeTestRoster(teamC, "Team C", exampledata.ExampleFingerprint2)},
+	} {
+		team.RosterSignature = "not checked"
+		team.CreatedAt = now
+		assert.NoError(t, UpsertTeam(nil, team))
+	}
+
+	defer func() {
+		for _, teamUUID := range []uuid.UUID{teamA, teamB, teamC} {
+			_, err := DeleteTeam(nil, teamUUID)
+			assert.NoError(t, err)
+		}
+	}()
+
+	t.Run("returns only the teams the fingerprint administers", func(t *testing.T) {
+		teams, err := ListTeamsAdministeredBy(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(teams))
+		assert.Equal(t, teamA, teams[0].UUID)
+	})
+
+	t.Run("orders the teams by name", func(t *testing.T) {
+		teams, err := ListTeamsAdministeredBy(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(teams))
+		assert.Equal(t, teamB, teams[0].UUID)
+		assert.Equal(t, teamC, teams[1].UUID)
+	})
+
+	t.Run("returns no teams for a fingerprint that isn't an admin", func(t *testing.T) {
+		teams, err := ListTeamsAdministeredBy(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(teams))
+	})
+
+	t.Run("ListTeamRosters returns every team", func(t *testing.T) {
+		teams, err := ListTeamRosters(nil)
+		assert.NoError(t, err)
+
+		found := map[uuid.UUID]int{}
+		for _, team := range teams {
+			found[team.UUID] = len(team.People)
+		}
+		assert.Equal(t, map[uuid.UUID]int{teamA: 1, teamB: 2, teamC: 1},
+			map[uuid.UUID]int{teamA: found[teamA], teamB: found[teamB], teamC: found[teamC]})
+	})
+}
+
+// makeTestRoster returns a roster for the given team listing the given fingerprints. The first
+// fingerprint is the admin.
+func makeTestRoster(teamUUID uuid.UUID, name string, fingerprints ...fpr.Fingerprint) string {
+	roster := fmt.Sprintf("uuid = \"%s\"\nversion = 1\nname = \"%s\"\n", teamUUID, name)
+	for i, fingerprint := range fingerprints {
+		roster += fmt.Sprintf(
+			"\n[[person]]\nemail = \"person%d@example.com\"\nfingerprint = \"%s\"\n"+
+				"is_admin = %v\n",
+			i, fingerprint.Hex(), i == 0)
+	}
+	return roster
 }
 
 func createTestTeam(t *testing.T) {
@@ -0,0 +1,95 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// upsertPublicKeysBatchSize is how many keys UpsertPublicKeys stores per INSERT. Each key takes
+// 3 parameters, which keeps well under Postgres's limit of 65535 per statement.
+const upsertPublicKeysBatchSize = 1000
+
+// UpsertPublicKeys stores many public keys at once, for bulk imports. It's like calling
+// UpsertPublicKey for each key, but inserts them in batches of multi-row statements.
+// A key that can't be loaded doesn't stop the others being stored: keyErrors has an entry for
+// each of armoredPublicKeys, which is nil if that key was stored. err is only set if storing the
+// keys failed, in which case none of them are stored.
+// If the same key appears more than once, the last copy is the one stored.
+// txn is a database transaction, or nil to store all the keys in a new transaction.
+func UpsertPublicKeys(txn *sql.Tx, armoredPublicKeys []string) (keyErrors []error, err error) {
+	if txn == nil {
+		err = RunInTransaction(func(txn *sql.Tx) error {
+			keyErrors, err = UpsertPublicKeys(txn, armoredPublicKeys)
+			return err
+		})
+		return keyErrors, err
+	}
+
+	keyErrors = make([]error, len(armoredPublicKeys))
+
+	// the same fingerprint can't be updated twice in one statement, so keep only the last copy
+	rowsByFingerprint := map[string]*keyRow{}
+	fingerprintOrder := []string{}
+
+	for i, armoredPublicKey := range armoredPublicKeys {
+		row, err := makeKeyRow(armoredPublicKey)
+		if err != nil {
+			keyErrors[i] = err
+			continue
+		}
+
+		dbFingerprint := dbFormat(row.fingerprint)
+		if _, seen := rowsByFingerprint[dbFingerprint]; !seen {
+			fingerprintOrder = append(fingerprintOrder, dbFingerprint)
+		}
+		rowsByFingerprint[dbFingerprint] = row
+	}
+
+	for start := 0; start < len(fingerprintOrder); start += upsertPublicKeysBatchSize {
+		end := start + upsertPublicKeysBatchSize
+		if end > len(fingerprintOrder) {
+			end = len(fingerprintOrder)
+		}
+
+		rows := []*keyRow{}
+		for _, dbFingerprint := range fingerprintOrder[start:end] {
+			rows = append(rows, rowsByFingerprint[dbFingerprint])
+		}
+
+		if err := upsertKeyRows(txn, rows); err != nil {
+			return nil, fmt.Errorf("error storing keys: %v", err)
+		}
+	}
+
+	return keyErrors, nil
+}
+
+// upsertKeyRows inserts or updates the given keys in a single statement.
+func upsertKeyRows(txn *sql.Tx, rows []*keyRow) error {
+	values := []string{}
+	fingerprintParams := []string{}
+	args := []interface{}{}
+
+	for _, row := range rows {
+		n := len(args)
+		values = append(values, fmt.Sprintf(
+			"($%d, $%d, COALESCE($%d::timestamp, 'infinity'::timestamp))", n+1, n+2, n+3))
+		fingerprintParams = append(fingerprintParams, fmt.Sprintf("$%d", n+1))
+		args = append(args, dbFormat(row.fingerprint), row.armoredPublicKey, row.earliestExpiry)
+	}
+
+	// keys that are uploaded again are no longer deleted
+	query := `WITH undeleted AS (
+	              DELETE FROM deleted_keys
+	              WHERE fingerprint IN (` + strings.Join(fingerprintParams, ", ") + `)
+	          )
+	          INSERT INTO keys (fingerprint, armored_public_key, earliest_expiry)
+	          VALUES ` + strings.Join(values, ", ") + `
+		  ON CONFLICT (fingerprint) DO UPDATE
+		      SET armored_public_key=EXCLUDED.armored_public_key,
+		          earliest_expiry=EXCLUDED.earliest_expiry`
+
+	_, err := transactionOrDatabase(txn).Exec(query, args...)
+	return err
+}
@@ -0,0 +1,66 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+)
+
+func TestUpsertPublicKeys(t *testing.T) {
+	fingerprints := []fpr.Fingerprint{
+		exampledata.ExampleFingerprint2,
+		exampledata.ExampleFingerprint3,
+		exampledata.ExampleFingerprint4,
+	}
+
+	deleteKeys := func() {
+		for _, fingerprint := range fingerprints {
+			_, _, err := DeletePublicKey(nil, fingerprint)
+			assert.NoError(t, err)
+		}
+	}
+	deleteKeys()
+	defer deleteKeys()
+
+	keyErrors, err := UpsertPublicKeys(nil, []string{
+		exampledata.ExamplePublicKey2,
+		"not a key",
+		exampledata.ExamplePublicKey3,
+		exampledata.ExamplePublicKey4,
+		exampledata.ExamplePublicKey2, // duplicates are fine
+	})
+	assert.NoError(t, err)
+
+	t.Run("returns an error for each key", func(t *testing.T) {
+		assert.Equal(t, 5, len(keyErrors))
+		for i, keyErr := range keyErrors {
+			if i == 1 {
+				assert.GotError(t, keyErr)
+			} else {
+				assert.NoError(t, keyErr)
+			}
+		}
+	})
+
+	t.Run("stores the valid keys", func(t *testing.T) {
+		for _, fingerprint := range fingerprints {
+			_, found, err := GetArmoredPublicKeyForFingerprint(fingerprint)
+			assert.NoError(t, err)
+			assert.Equal(t, true, found)
+		}
+	})
+
+	t.Run("updates keys that are already stored", func(t *testing.T) {
+		keyErrors, err := UpsertPublicKeys(nil, []string{exampledata.ExamplePublicKey4})
+		assert.NoError(t, err)
+		assert.NoError(t, keyErrors[0])
+	})
+
+	t.Run("with no keys", func(t *testing.T) {
+		keyErrors, err := UpsertPublicKeys(nil, []string{})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(keyErrors))
+	})
+}
@@ -136,3 +136,99 @@ func createUserProfile(txn *sql.Tx, keyID int) (*UserProfile, error) {
 
 	return loadUserProfile(txn, keyID)
 }
+
+// GetUserProfile returns the user profile for the given key, creating it with the default
+// preferences if it doesn't exist yet.
+func GetUserProfile(txn *sql.Tx, fingerprint fpr.Fingerprint) (*UserProfile, error) {
+	keyID, err := getKeyID(txn, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	return loadUserProfile(txn, keyID)
+}
+
+// GetKeyWithProfile returns the user profile for the key with the given fingerprint, with its
+// Key loaded. Unlike GetUserProfile it only takes one query, unless the key has no profile yet,
+// in which case one is created with the default preferences.
+func GetKeyWithProfile(txn *sql.Tx, fingerprint fpr.Fingerprint) (*UserProfile, error) {
+	return queryKeyWithProfile(txn, "keys.fingerprint=$1", dbFormat(fingerprint),
+		fmt.Errorf("no key found with fingerprint %s", fingerprint))
+}
+
+// loadKeyWithProfile is like GetKeyWithProfile, for the key with the given ID.
+func loadKeyWithProfile(txn *sql.Tx, keyID int) (*UserProfile, error) {
+	return queryKeyWithProfile(txn, "keys.id=$1", keyID,
+		fmt.Errorf("no such key with id %d", keyID))
+}
+
+// queryKeyWithProfile loads the key matching the condition along with its user profile,
+// returning errNoKey if there's no such key.
+func queryKeyWithProfile(
+	txn *sql.Tx, condition string, arg interface{}, errNoKey error) (*UserProfile, error) {
+
+	query := `SELECT keys.id,
+                     keys.armored_public_key,
+                     user_profiles.uuid,
+                     user_profiles.optout_emails_expiry_warnings
+              FROM keys
+              LEFT JOIN user_profiles ON user_profiles.key_id=keys.id
+              WHERE ` + condition
+
+	var keyID int
+	var armoredPublicKey string
+	var profileUUID *uuid.UUID
+	var optoutEmailsExpiryWarnings sql.NullBool
+
+	err := transactionOrDatabase(txn).QueryRow(query, arg).Scan(
+		&keyID, &armoredPublicKey, &profileUUID, &optoutEmailsExpiryWarnings,
+	)
+	if err == sql.ErrNoRows {
+		return nil, errNoKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	if profileUUID == nil {
+		// no user profile found: create one
+		profile, err := createUserProfile(txn, keyID)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"no user profile for key ID %d, and couldn't make one: %s", keyID, err)
+		}
+		return profile, nil
+	}
+
+	key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error loading key: %v", err)
+	}
+
+	return &UserProfile{
+		UUID:                       *profileUUID,
+		OptoutEmailsExpiryWarnings: optoutEmailsExpiryWarnings.Bool,
+		KeyID:                      keyID,
+		Key:                        key,
+	}, nil
+}
+
+// UpdateUserProfilePreferences sets the email preferences on the given user profile.
+func UpdateUserProfilePreferences(
+	txn *sql.Tx, profileUUID uuid.UUID, optoutEmailsExpiryWarnings bool) error {
+
+	query := `UPDATE user_profiles
+              SET optout_emails_expiry_warnings=$2
+              WHERE uuid=$1`
+
+	result, err := transactionOrDatabase(txn).Exec(query, profileUUID, optoutEmailsExpiryWarnings)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
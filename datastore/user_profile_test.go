@@ -106,3 +106,80 @@ func deleteKeysAndUserProfiles(t *testing.T) {
 	_, err = db.Exec("DELETE FROM user_profiles")
 	assert.NoError(t, err)
 }
+
+func TestUpdateUserProfilePreferences(t *testing.T) {
+	deleteKeysAndUserProfiles(t)
+	defer deleteKeysAndUserProfiles(t)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+
+	profile, err := GetUserProfile(nil, exampledata.ExampleFingerprint2)
+	assert.NoError(t, err)
+
+	t.Run("defaults to opted in", func(t *testing.T) {
+		assert.Equal(t, false, profile.OptoutEmailsExpiryWarnings)
+	})
+
+	t.Run("opt out of expiry warnings", func(t *testing.T) {
+		assert.NoError(t, UpdateUserProfilePreferences(nil, profile.UUID, true))
+
+		updated, err := GetUserProfile(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.Equal(t, true, updated.OptoutEmailsExpiryWarnings)
+	})
+
+	t.Run("returns ErrNotFound for a missing profile", func(t *testing.T) {
+		err := UpdateUserProfilePreferences(nil, uuid.Must(uuid.NewV4()), true)
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestGetKeyWithProfile(t *testing.T) {
+	deleteKeysAndUserProfiles(t)
+	defer deleteKeysAndUserProfiles(t)
+
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+
+	t.Run("creates new profile if one doesn't exist already", func(t *testing.T) {
+		profile, err := GetKeyWithProfile(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+
+		var retrievedUUID uuid.UUID
+		err = db.QueryRow("SELECT uuid FROM user_profiles").Scan(&retrievedUUID)
+		assert.NoError(t, err)
+		assert.Equal(t, retrievedUUID, profile.UUID)
+	})
+
+	t.Run("matches the separate loads", func(t *testing.T) {
+		existing, err := GetUserProfile(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		assert.NoError(t, UpdateUserProfilePreferences(nil, existing.UUID, true))
+
+		separate, err := GetUserProfile(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+
+		combined, err := GetKeyWithProfile(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+
+		assert.Equal(t, separate.UUID, combined.UUID)
+		assert.Equal(t, separate.KeyID, combined.KeyID)
+		assert.Equal(t, true, combined.OptoutEmailsExpiryWarnings)
+		assert.Equal(t, separate.Key.Fingerprint(), combined.Key.Fingerprint())
+
+		t.Run("by key ID", func(t *testing.T) {
+			byKeyID, err := loadKeyWithProfile(nil, separate.KeyID)
+			assert.NoError(t, err)
+			assert.Equal(t, combined.UUID, byKeyID.UUID)
+			assert.Equal(t, combined.Key.Fingerprint(), byKeyID.Key.Fingerprint())
+		})
+	})
+
+	t.Run("returns error if no such key exists", func(t *testing.T) {
+		_, err := GetKeyWithProfile(nil, exampledata.ExampleFingerprint3)
+		assert.Equal(t, fmt.Errorf("no key found with fingerprint %s",
+			exampledata.ExampleFingerprint3), err)
+
+		_, err = loadKeyWithProfile(nil, 0)
+		assert.Equal(t, fmt.Errorf("no such key with id 0"), err)
+	})
+}
@@ -0,0 +1,77 @@
+package datastore
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"strings"
+)
+
+// GetArmoredPublicKeyForEmailHash returns the ASCII-armored public key linked to the email
+// address at the given domain whose local part has the given Web Key Directory hash.
+// See https://tools.ietf.org/html/draft-koch-openpgp-webkey-service
+func GetArmoredPublicKeyForEmailHash(txn *sql.Tx, domain string, hash string) (
+	armoredPublicKey string, found bool, err error) {
+
+	// The hash can't be computed in SQL, so fetch every linked email at the domain and hash
+	// each local part in turn.
+	rows, err := transactionOrDatabase(txn).Query(getLinkedEmailsForDomainQuery, domain)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email, &armoredPublicKey); err != nil {
+			return "", false, err
+		}
+
+		localPart := email[:strings.LastIndex(email, "@")]
+		if WKDHash(localPart) == hash {
+			return armoredPublicKey, true, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", false, err
+	}
+	return "", false, nil
+}
+
+const getLinkedEmailsForDomainQuery = `SELECT email_key_link.email,
+	                 keys.armored_public_key
+		  FROM email_key_link
+		  INNER JOIN keys ON email_key_link.key_id = keys.id
+		  WHERE lower(split_part(email_key_link.email, '@', 2)) = lower($1)`
+
+// WKDHash returns the Web Key Directory hash of an email address's local part: the z-base-32
+// encoded SHA-1 digest of the lowercased local part.
+func WKDHash(localPart string) string {
+	digest := sha1.Sum([]byte(strings.ToLower(localPart)))
+	return zBase32Encode(digest[:])
+}
+
+// zBase32Encode encodes data using the human-oriented base-32 encoding described in
+// http://philzimmermann.com/docs/human-oriented-base-32-encoding.txt
+// Trailing bits are zero-padded and no padding characters are added.
+func zBase32Encode(data []byte) string {
+	const alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+	var encoded strings.Builder
+	var buffer uint
+	var bufferedBits uint
+
+	for _, b := range data {
+		buffer = buffer<<8 | uint(b)
+		bufferedBits += 8
+
+		for bufferedBits >= 5 {
+			bufferedBits -= 5
+			encoded.WriteByte(alphabet[(buffer>>bufferedBits)&0x1f])
+		}
+	}
+
+	if bufferedBits > 0 {
+		encoded.WriteByte(alphabet[(buffer<<(5-bufferedBits))&0x1f])
+	}
+	return encoded.String()
+}
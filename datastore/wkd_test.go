@@ -0,0 +1,55 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestWKDHash(t *testing.T) {
+	// from https://tools.ietf.org/html/draft-koch-openpgp-webkey-service
+	assert.Equal(t, "iy9q119eutrkn8s1mk4r39qejnbu3n5q", WKDHash("Joe.Doe"))
+
+	// from gpg --with-wkd-hash
+	assert.Equal(t, "d93mghnk7zor75fid3ecw4cq9ieknph5", WKDHash("test4"))
+}
+
+func TestZBase32Encode(t *testing.T) {
+	assert.Equal(t, "", zBase32Encode([]byte{}))
+	assert.Equal(t, "yy", zBase32Encode([]byte{0x00})) // 00000 000(00)
+	assert.Equal(t, "9h", zBase32Encode([]byte{0xff})) // 11111 111(00)
+	assert.Equal(t, "6y", zBase32Encode([]byte{0xf0})) // 11110 000(00)
+	assert.Equal(t, "o9999999", zBase32Encode([]byte{0x87, 0xff, 0xff, 0xff, 0xff}))
+}
+
+func TestGetArmoredPublicKeyForEmailHash(t *testing.T) {
+	assert.NoError(t, UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	assert.NoError(t,
+		LinkEmailToFingerprint(nil, "Test4@Example.com", exampledata.ExampleFingerprint4, nil))
+
+	defer func() {
+		_, _, err := DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}()
+
+	t.Run("finds key for matching hash and domain", func(t *testing.T) {
+		armoredPublicKey, found, err := GetArmoredPublicKeyForEmailHash(
+			nil, "example.com", WKDHash("test4"))
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+		assert.Equal(t, exampledata.ExamplePublicKey4, armoredPublicKey)
+	})
+
+	t.Run("not found for a different domain", func(t *testing.T) {
+		_, found, err := GetArmoredPublicKeyForEmailHash(nil, "example.org", WKDHash("test4"))
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+	})
+
+	t.Run("not found for a different local part", func(t *testing.T) {
+		_, found, err := GetArmoredPublicKeyForEmailHash(nil, "example.com", WKDHash("test3"))
+		assert.NoError(t, err)
+		assert.Equal(t, false, found)
+	})
+}
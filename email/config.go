@@ -0,0 +1,130 @@
+package email
+
+import (
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fluidkeys/api/env"
+)
+
+func init() {
+	// the verification email settings are logged and ignored if they're invalid, rather than
+	// panicking like the rest of the config, so a typo in staging config can't stop the
+	// server starting
+	verifyFrom = addressFromEnv("VERIFY_EMAIL_FROM", verifyFrom)
+	verifyReplyTo = addressFromEnv("VERIFY_EMAIL_REPLY_TO", verifyReplyTo)
+
+	if value, got := os.LookupEnv("VERIFY_EMAIL_BCC"); got {
+		// an empty VERIFY_EMAIL_BCC disables the bcc entirely
+		if value == "" {
+			verifyBcc = ""
+		} else {
+			verifyBcc = addressFromEnv("VERIFY_EMAIL_BCC", verifyBcc)
+		}
+	}
+
+	if value, got := os.LookupEnv("VERIFICATION_BASE_URL"); got {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			log.Printf("ignoring invalid VERIFICATION_BASE_URL '%s', using %s",
+				value, verificationBaseUrl)
+		} else {
+			verificationBaseUrl = strings.TrimRight(value, "/")
+		}
+	}
+
+	resendVerificationAfter = env.Duration(
+		"RESEND_VERIFICATION_AFTER_DAYS", 24*time.Hour, resendVerificationAfter)
+	maxVerificationsPerEmail = env.PositiveInt(
+		"MAX_VERIFICATIONS_PER_EMAIL", maxVerificationsPerEmail)
+
+	allowedEmailDomains = domainsFromEnv("ALLOWED_EMAIL_DOMAINS")
+
+	opsSummaryTo = env.Address("OPS_SUMMARY_EMAIL", opsSummaryTo)
+	opsSummaryFrom = env.Address("OPS_SUMMARY_EMAIL_FROM", opsSummaryFrom)
+
+	smtpMaxAttempts = env.PositiveInt("SMTP_MAX_ATTEMPTS", smtpMaxAttempts)
+	smtpRetryBackoff = env.Duration("SMTP_RETRY_BACKOFF_MS", time.Millisecond, smtpRetryBackoff)
+}
+
+var (
+	// verifyFrom is the From address for verification emails.
+	// Override with VERIFY_EMAIL_FROM.
+	verifyFrom = "Fluidkeys <verify@mail.fluidkeys.com>"
+
+	// verifyReplyTo is the Reply-To address for verification emails.
+	// Override with VERIFY_EMAIL_REPLY_TO.
+	verifyReplyTo = "Fluidkeys Security <security@fluidkeys.com>"
+
+	// verifyBcc is blind copied on every verification email.
+	// Override with VERIFY_EMAIL_BCC, or set it empty to disable.
+	verifyBcc = "hello@fluidkeys.com"
+
+	// verificationBaseUrl is the scheme and host of the API that verification links point at,
+	// for example https://api.fluidkeys.com
+	// Override with VERIFICATION_BASE_URL.
+	verificationBaseUrl = "https://api.fluidkeys.com"
+
+	// resendVerificationAfter is how long resend_verifications waits after the last
+	// verification email to an address before sending another.
+	// Override with RESEND_VERIFICATION_AFTER_DAYS.
+	resendVerificationAfter = time.Duration(7*24) * time.Hour
+
+	// maxVerificationsPerEmail is the most verification emails resend_verifications will let an
+	// address receive in total, including the first one sent when the key was uploaded.
+	// Override with MAX_VERIFICATIONS_PER_EMAIL.
+	maxVerificationsPerEmail = 3
+
+	// allowedEmailDomains restricts verification emails to addresses at these domains. Keys
+	// with other emails are still stored, but those emails can't be verified. Empty allows
+	// every domain. Override with ALLOWED_EMAIL_DOMAINS, a comma-separated list.
+	allowedEmailDomains []string
+
+	// opsSummaryTo is sent a summary email after each run of the batch commands. Empty
+	// disables the summaries. Override with OPS_SUMMARY_EMAIL.
+	opsSummaryTo = ""
+
+	// opsSummaryFrom is the From address for summary emails.
+	// Override with OPS_SUMMARY_EMAIL_FROM.
+	opsSummaryFrom = "Fluidkeys API <ops@mail.fluidkeys.com>"
+
+	// smtpMaxAttempts is how many times send() tries to deliver an email before giving up on a
+	// transient failure. Override with SMTP_MAX_ATTEMPTS.
+	smtpMaxAttempts = 3
+
+	// smtpRetryBackoff is how long send() waits before its first retry. The wait doubles on
+	// each subsequent retry. Override with SMTP_RETRY_BACKOFF_MS.
+	smtpRetryBackoff = time.Duration(2) * time.Second
+)
+
+// addressFromEnv returns the value of the given environment variable if it's a valid email
+// address, or defaultValue if it isn't set. An invalid address is logged and ignored rather
+// than panicking, so a typo in staging config can't stop the server starting.
+func addressFromEnv(name string, defaultValue string) string {
+	value, got := os.LookupEnv(name)
+	if !got {
+		return defaultValue
+	}
+
+	if _, err := mail.ParseAddress(value); err != nil {
+		log.Printf("ignoring invalid %s '%s': %v, using %s", name, value, err, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// domainsFromEnv returns the lowercased, comma-separated domains in the given environment
+// variable, or nil if it isn't set or is empty.
+func domainsFromEnv(name string) []string {
+	var domains []string
+	for _, domain := range strings.Split(os.Getenv(name), ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
@@ -2,23 +2,28 @@ package email
 
 import (
 	"bytes"
+	"crypto/tls"
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/mail"
 	"net/smtp"
 	"net/textproto"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/env"
 	"github.com/fluidkeys/fluidkeys/pgpkey"
 	"github.com/gofrs/uuid"
 )
 
 func init() {
-	if os.Getenv("DISABLE_SEND_EMAIL") == "1" {
+	if env.Bool("DISABLE_SEND_EMAIL", false) {
 		disableSendEmail = true
 		return
 	}
@@ -68,29 +73,92 @@ type VerificationMetadata struct {
 	RequestTime      time.Time
 }
 
-// SendVerificationEmails iterates through the email addresses on the given key and works out
+// VerificationEmail is a verification that's been recorded in the database, but whose email
+// hasn't been sent yet. Create it inside the transaction that stores the key, and only Send it
+// once that transaction has committed: sending retries a failing SMTP server with a backoff,
+// which mustn't hold the transaction open.
+type VerificationEmail struct {
+	EmailAddress    string
+	VerificationUrl string
+
+	fingerprint string
+	secretUUID  uuid.UUID
+	email       *email
+}
+
+// CreateVerificationEmails iterates through the email addresses on the given key and works out
 // whether to send each one a verification email.
-// If so, it renders and sends the verification email, and records a new verification in the
-// database.
-func SendVerificationEmails(
-	txn *sql.Tx, publicKey *pgpkey.PgpKey, meta VerificationMetadata) error {
+// If so, it records a new verification in the database and renders the verification email,
+// ready to be sent with SendVerificationEmails after txn has committed.
+func CreateVerificationEmails(
+	txn *sql.Tx, publicKey *pgpkey.PgpKey, meta VerificationMetadata) (
+	verifications []*VerificationEmail, err error) {
 
 	for _, email := range publicKey.Emails(true) {
-		shouldSend, err := shouldSendVerificationEmail(txn, email)
+		verification, err := CreateVerificationEmail(txn, email, publicKey, meta)
 		if err != nil {
-			return err
-		} else if shouldSend {
-			if err := sendVerificationEmail(txn, email, publicKey, meta); err != nil {
-				return err
-			}
+			return nil, err
+		} else if verification != nil {
+			verifications = append(verifications, verification)
+		}
+	}
+	return verifications, nil
+}
+
+// CreateVerificationEmail creates a verification for a single address on the given key, if
+// shouldSendVerificationEmail allows it. It returns nil if no email should be sent.
+func CreateVerificationEmail(
+	txn *sql.Tx, emailAddress string, publicKey *pgpkey.PgpKey, meta VerificationMetadata) (
+	*VerificationEmail, error) {
+
+	shouldSend, err := shouldSendVerificationEmail(txn, emailAddress)
+	if err != nil || !shouldSend {
+		return nil, err
+	}
+	return createVerificationEmail(txn, emailAddress, publicKey, meta)
+}
+
+// SendVerificationEmails sends each of the verification emails, and returns the verification URL
+// sent to each email address. Emails that can't be sent are logged and left out of the URLs.
+func SendVerificationEmails(verifications []*VerificationEmail) (verificationUrls map[string]string) {
+	verificationUrls = map[string]string{}
+
+	for _, verification := range verifications {
+		if err := verification.Send(); err != nil {
+			log.Printf("error sending verification email to %s: %v",
+				verification.EmailAddress, err)
+			continue
+		}
+		verificationUrls[verification.EmailAddress] = verification.VerificationUrl
+	}
+	return verificationUrls
+}
+
+// Send sends the verification email. If it can't be delivered, the failure is recorded and the
+// verification is expired, so that the address isn't blocked from getting another one until it
+// would have run out.
+func (v *VerificationEmail) Send() error {
+	log.Printf("sending verification email to %s for key %s", v.EmailAddress, v.fingerprint)
+
+	sendErr := v.email.sendOrRecordFailure(verifyEmail{}.ID())
+	if sendErr != nil {
+		if err := expireVerification(nil, v.secretUUID, time.Now()); err != nil {
+			log.Printf("error expiring verification for %s: %v", v.EmailAddress, err)
 		}
+		return fmt.Errorf("error sending mail: %v", sendErr)
 	}
 	return nil
 }
 
-func sendVerificationEmail(
+// SendingDisabled returns true if the server was started with DISABLE_SEND_EMAIL=1, so emails
+// are printed rather than sent.
+func SendingDisabled() bool {
+	return disableSendEmail
+}
+
+func createVerificationEmail(
 	txn *sql.Tx, emailAddress string, publicKey *pgpkey.PgpKey,
-	meta VerificationMetadata) error {
+	meta VerificationMetadata) (*VerificationEmail, error) {
 
 	verifySecretUUID, err := datastore.CreateVerification(
 		txn, emailAddress, publicKey.Fingerprint(),
@@ -99,12 +167,32 @@ func sendVerificationEmail(
 		meta.RequestTime,
 	)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	email, err := makeVerificationEmail(emailAddress, *verifySecretUUID, publicKey, meta)
+	if err != nil {
+		return nil, err
 	}
 
+	return &VerificationEmail{
+		EmailAddress:    emailAddress,
+		VerificationUrl: makeVerificationUrl(*verifySecretUUID),
+		fingerprint:     publicKey.Fingerprint().Hex(),
+		secretUUID:      *verifySecretUUID,
+		email:           email,
+	}, nil
+}
+
+// makeVerificationEmail returns a rendered verification email for the given email address and
+// key, using the configured from, reply-to and bcc addresses.
+func makeVerificationEmail(
+	emailAddress string, verifySecretUUID uuid.UUID, publicKey *pgpkey.PgpKey,
+	meta VerificationMetadata) (*email, error) {
+
 	emailTemplateData := verifyEmail{
 		Email:            emailAddress,
-		VerificationUrl:  makeVerificationUrl(*verifySecretUUID),
+		VerificationUrl:  makeVerificationUrl(verifySecretUUID),
 		RequestIpAddress: meta.RequestIpAddress,
 		RequestTime:      meta.RequestTime,
 		KeyFingerprint:   publicKey.Fingerprint().Hex(),
@@ -113,26 +201,25 @@ func sendVerificationEmail(
 
 	email := email{
 		to:      emailAddress,
-		from:    "Fluidkeys <verify@mail.fluidkeys.com>",
-		replyTo: "Fluidkeys Security <security@fluidkeys.com>",
-		bcc:     "hello@fluidkeys.com",
+		from:    verifyFrom,
+		replyTo: verifyReplyTo,
+		bcc:     verifyBcc,
 	}
 
 	if err := email.renderSubjectAndBody(emailTemplateData); err != nil {
-		return fmt.Errorf("error rendering email: %v", err)
-	}
-
-	if err := email.send(); err != nil {
-		return fmt.Errorf("error sending mail: %v", err)
+		return nil, fmt.Errorf("error rendering email: %v", err)
 	}
-	log.Printf("sending verification email to %s for key %s",
-		emailAddress, publicKey.Fingerprint().Hex())
-	return nil
+	return &email, nil
 }
 
 // shouldSendVerificationEmail returns true if an email address should receive a new verification
 // email
 func shouldSendVerificationEmail(txn *sql.Tx, email string) (bool, error) {
+	if !DomainAllowed(email) {
+		log.Printf("email '%s' isn't at an allowed domain, not sending email", email)
+		return false, nil
+	}
+
 	_, alreadyLinked, err := datastore.GetArmoredPublicKeyForEmail(txn, email)
 	if err != nil {
 		return false, err
@@ -167,8 +254,29 @@ func shouldSendVerificationEmail(txn *sql.Tx, email string) (bool, error) {
 	return true, nil
 }
 
+// DomainAllowed returns true if the given email address is at one of the domains in
+// ALLOWED_EMAIL_DOMAINS, or if no allowlist is configured.
+func DomainAllowed(email string) bool {
+	if len(allowedEmailDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	for _, allowed := range allowedEmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 func makeVerificationUrl(secretUUID uuid.UUID) string {
-	return fmt.Sprintf("https://api.fluidkeys.com/v1/email/verify/%s", secretUUID.String())
+	return fmt.Sprintf("%s/v1/email/verify/%s", verificationBaseUrl, secretUUID.String())
 }
 
 func sendEmail(
@@ -206,7 +314,7 @@ func sendEmail(
 			return err
 		}
 
-		if err := email.send(); err != nil {
+		if err := email.sendOrRecordFailure(template.ID()); err != nil {
 			return fmt.Errorf("error sending mail: %v", err)
 		}
 		return nil
@@ -223,6 +331,7 @@ type email struct {
 	to       string
 	from     string
 	replyTo  string
+	cc       string
 	bcc      string
 	subject  string
 	textBody string
@@ -278,14 +387,17 @@ func (e *email) send() error {
 		return fmt.Errorf("error parsing address: %v", err)
 	}
 
-	to, err := mail.ParseAddress(e.to) // validate to address
+	recipients, err := e.recipients()
 	if err != nil {
-		return fmt.Errorf("error parsing to address: %v", err)
+		return err
 	}
 
 	header := textproto.MIMEHeader{}
 	header.Set(textproto.CanonicalMIMEHeaderKey("from"), e.from)
 	header.Set(textproto.CanonicalMIMEHeaderKey("to"), e.to)
+	if e.cc != "" {
+		header.Set(textproto.CanonicalMIMEHeaderKey("cc"), e.cc)
+	}
 	header.Set(textproto.CanonicalMIMEHeaderKey("reply-to"), e.replyTo)
 	if e.htmlBody != "" {
 		header.Set(textproto.CanonicalMIMEHeaderKey("content-type"), "text/html; charset=UTF-8")
@@ -314,12 +426,177 @@ func (e *email) send() error {
 	} else {
 		addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
 		auth := smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
-		log.Printf("sending email to %s via %s", to.Address, addr)
-		return smtp.SendMail(addr, auth, from.Address, []string{to.Address}, buffer.Bytes())
+		log.Printf("sending email to %s via %s", recipients[0], addr)
+		return sendMailWithRetry(addr, auth, from.Address, recipients, buffer.Bytes())
+	}
+}
+
+// sendOrRecordFailure sends the email and, if it can't be delivered even after retrying, records
+// the failure in the database so it can be investigated and sent again.
+// The failure is recorded outside of any transaction so that it survives the transaction that
+// sent the email being rolled back.
+func (e *email) sendOrRecordFailure(emailTemplateID string) error {
+	sendErr := e.send()
+	if sendErr != nil {
+		err := recordEmailFailure(nil, emailTemplateID, e.to, sendErr, time.Now())
+		if err != nil {
+			log.Printf("error recording email failure to %s: %v", e.to, err)
+		}
+	}
+	return sendErr
+}
+
+// sendMailWithRetry sends the email, retrying with an exponential backoff if it fails with a
+// transient error, up to smtpMaxAttempts times in total.
+func sendMailWithRetry(
+	addr string, auth smtp.Auth, from string, recipients []string, msg []byte) error {
+
+	backoff := smtpRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		beforeData, err := sendMail(addr, auth, from, recipients, msg)
+		if err == nil || attempt >= smtpMaxAttempts || !isTransientSMTPError(err, beforeData) {
+			return err
+		}
+
+		log.Printf("transient error sending email (attempt %d of %d), retrying in %s: %v",
+			attempt, smtpMaxAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendMail does the same as smtp.SendMail, but also returns whether it failed before the
+// message was sent with DATA. Until then, the server can't have accepted the message.
+// Once the server has accepted the message, failing to QUIT cleanly is only logged: the email
+// has been sent.
+func sendMail(addr string, auth smtp.Auth, from string, recipients []string, msg []byte) (
+	beforeData bool, err error) {
+
+	if err := validateLine(from); err != nil {
+		return true, err
+	}
+	for _, recipient := range recipients {
+		if err := validateLine(recipient); err != nil {
+			return true, err
+		}
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return true, err
+	}
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return true, err
+	}
+	defer c.Close()
+
+	if err = c.Hello("localhost"); err != nil {
+		return true, err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return true, err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return true, fmt.Errorf("smtp: server doesn't support AUTH")
+		}
+		if err = c.Auth(auth); err != nil {
+			return true, err
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return true, err
+	}
+	for _, recipient := range recipients {
+		if err = c.Rcpt(recipient); err != nil {
+			return true, err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return true, err // the server refused DATA, so hasn't seen the message
+	}
+	if _, err = w.Write(msg); err != nil {
+		w.Close()
+		return false, err
 	}
+	if err = w.Close(); err != nil {
+		return false, err
+	}
+
+	if err = c.Quit(); err != nil {
+		log.Printf("error closing SMTP connection after sending email: %v", err)
+	}
+	return false, nil
+}
+
+// validateLine checks that an address doesn't contain a CR or LF, which would let it inject
+// extra SMTP commands. smtp.SendMail does the same check.
+func validateLine(line string) error {
+	if strings.ContainsAny(line, "\n\r") {
+		return fmt.Errorf("smtp: a line must not contain CR or LF")
+	}
+	return nil
+}
+
+// isTransientSMTPError returns true if the error is worth retrying: a 4xx SMTP reply, or a
+// network error such as a refused or reset connection before the message was sent with DATA.
+// A network error after that is never retried: the server may have accepted the message
+// anyway, and retrying would send it twice. 5xx replies are permanent failures (for example a
+// hard bounce) and aren't retried either.
+func isTransientSMTPError(err error, beforeData bool) bool {
+	switch err := err.(type) {
+	case *textproto.Error:
+		return err.Code >= 400 && err.Code < 500
+	case net.Error:
+		return beforeData
+	}
+	return beforeData && (err == io.EOF || err == io.ErrUnexpectedEOF)
+}
+
+// recipients returns the bare addresses the email should be delivered to: the to address,
+// followed by the cc addresses and the bcc address if set. The cc addresses also appear in the
+// Cc header, but the bcc address is deliberately only used for delivery and never appears in
+// the headers.
+func (e *email) recipients() ([]string, error) {
+	to, err := mail.ParseAddress(e.to) // validate to address
+	if err != nil {
+		return nil, fmt.Errorf("error parsing to address: %v", err)
+	}
+	recipients := []string{to.Address}
+
+	if e.cc != "" {
+		ccs, err := mail.ParseAddressList(e.cc) // validate cc addresses
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cc address: %v", err)
+		}
+		for _, cc := range ccs {
+			recipients = append(recipients, cc.Address)
+		}
+	}
+
+	if e.bcc != "" {
+		bcc, err := mail.ParseAddress(e.bcc) // validate bcc address
+		if err != nil {
+			return nil, fmt.Errorf("error parsing bcc address: %v", err)
+		}
+		recipients = append(recipients, bcc.Address)
+	}
+	return recipients, nil
 }
 
 var (
+	// recordEmailFailure and expireVerification are replaced in tests, which don't have a
+	// database
+	recordEmailFailure = datastore.RecordEmailFailure
+	expireVerification = datastore.ExpireVerification
+
 	disableSendEmail bool
 	smtpHost         string
 	smtpPort         string
@@ -337,6 +614,11 @@ type verifyEmail struct {
 	KeyCreatedDate   time.Time
 }
 
+func (e verifyEmail) ID() string { return "verify" }
+func (e verifyEmail) RenderInto(eml *email) error {
+	return eml.renderSubjectAndBody(e)
+}
+
 var errRateLimit = fmt.Errorf("rate limit: not sending same email so soon")
 
 const verifySubjectTemplate = "Verify {{.Email}} on Fluidkeys"
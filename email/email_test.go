@@ -1,13 +1,23 @@
 package email
 
 import (
+	"database/sql"
 	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/fluidkeys/api/datastore"
 	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
 	"github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+	"github.com/gofrs/uuid"
 )
 
 func TestRenderVerifyEmail(t *testing.T) {
@@ -50,6 +60,624 @@ func TestRenderVerifyEmail(t *testing.T) {
 
 }
 
+func TestMakeVerificationEmail(t *testing.T) {
+	publicKey, err := pgpkey.LoadFromArmoredPublicKey(exampledata.ExamplePublicKey2)
+	assert.NoError(t, err)
+
+	secretUUID := uuid.Must(uuid.FromString("e7f1ad26-a1e1-4a4d-9ed0-1ae4b1a5c1de"))
+	meta := VerificationMetadata{
+		RequestIpAddress: "1.1.1.1",
+		RequestTime:      time.Date(2018, 6, 15, 16, 15, 37, 0, time.UTC),
+	}
+
+	t.Run("uses configured from, reply-to, bcc and base URL", func(t *testing.T) {
+		defer restoreVerifyConfig(verifyFrom, verifyReplyTo, verifyBcc, verificationBaseUrl)
+
+		verifyFrom = "Staging <verify@staging.example.com>"
+		verifyReplyTo = "Staging Security <security@staging.example.com>"
+		verifyBcc = "audit@staging.example.com"
+		verificationBaseUrl = "https://api.staging.example.com"
+
+		eml, err := makeVerificationEmail("test@example.com", secretUUID, publicKey, meta)
+		assert.NoError(t, err)
+
+		assert.Equal(t, "test@example.com", eml.to)
+		assert.Equal(t, "Staging <verify@staging.example.com>", eml.from)
+		assert.Equal(t, "Staging Security <security@staging.example.com>", eml.replyTo)
+		assert.Equal(t, "audit@staging.example.com", eml.bcc)
+
+		expectedUrl := "https://api.staging.example.com/v1/email/verify/" + secretUUID.String()
+		if !strings.Contains(eml.htmlBody, expectedUrl) {
+			t.Fatalf("expected htmlBody to contain %s, got:\n%s", expectedUrl, eml.htmlBody)
+		}
+	})
+
+	t.Run("with bcc disabled", func(t *testing.T) {
+		defer restoreVerifyConfig(verifyFrom, verifyReplyTo, verifyBcc, verificationBaseUrl)
+		verifyBcc = ""
+
+		eml, err := makeVerificationEmail("test@example.com", secretUUID, publicKey, meta)
+		assert.NoError(t, err)
+		assert.Equal(t, "", eml.bcc)
+	})
+}
+
+func restoreVerifyConfig(from, replyTo, bcc, baseUrl string) {
+	verifyFrom = from
+	verifyReplyTo = replyTo
+	verifyBcc = bcc
+	verificationBaseUrl = baseUrl
+}
+
+func TestAddressFromEnv(t *testing.T) {
+	const name = "TEST_ADDRESS_FROM_ENV"
+	defer os.Unsetenv(name)
+
+	t.Run("returns default when unset", func(t *testing.T) {
+		os.Unsetenv(name)
+		assert.Equal(t, "default@example.com", addressFromEnv(name, "default@example.com"))
+	})
+
+	t.Run("returns value when valid", func(t *testing.T) {
+		os.Setenv(name, "Test <test@example.com>")
+		assert.Equal(t, "Test <test@example.com>", addressFromEnv(name, "default@example.com"))
+	})
+
+	t.Run("returns default when invalid", func(t *testing.T) {
+		os.Setenv(name, "not an email address")
+		assert.Equal(t, "default@example.com", addressFromEnv(name, "default@example.com"))
+	})
+}
+
+func TestEmailRecipients(t *testing.T) {
+	t.Run("without bcc", func(t *testing.T) {
+		e := email{to: "Test <test@example.com>"}
+
+		recipients, err := e.recipients()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test@example.com"}, recipients)
+	})
+
+	t.Run("with bcc", func(t *testing.T) {
+		e := email{to: "Test <test@example.com>", bcc: "Audit <audit@example.com>"}
+
+		recipients, err := e.recipients()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test@example.com", "audit@example.com"}, recipients)
+	})
+
+	t.Run("with invalid bcc", func(t *testing.T) {
+		e := email{to: "test@example.com", bcc: "not an email address"}
+
+		_, err := e.recipients()
+		assert.GotError(t, err)
+	})
+
+	t.Run("with cc and bcc", func(t *testing.T) {
+		e := email{
+			to:  "Test <test@example.com>",
+			cc:  "Admin <admin@example.com>, other@example.com",
+			bcc: "audit@example.com",
+		}
+
+		recipients, err := e.recipients()
+		assert.NoError(t, err)
+		assert.Equal(t,
+			[]string{"test@example.com", "admin@example.com", "other@example.com", "audit@example.com"},
+			recipients)
+	})
+
+	t.Run("with invalid cc", func(t *testing.T) {
+		e := email{to: "test@example.com", cc: "not an email address"}
+
+		_, err := e.recipients()
+		assert.GotError(t, err)
+	})
+}
+
+func TestSendDeliversToCc(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.Close()
+	defer useFakeSMTPServer(server)()
+
+	e := email{
+		to:       "Test <test@example.com>",
+		from:     "Fluidkeys <verify@example.com>",
+		replyTo:  "Fluidkeys <security@example.com>",
+		cc:       "Admin <admin@example.com>",
+		subject:  "Test subject",
+		textBody: "Test body",
+	}
+	assert.NoError(t, e.send())
+
+	t.Run("cc address is a recipient", func(t *testing.T) {
+		assert.Equal(t, []string{"test@example.com", "admin@example.com"}, server.Recipients())
+	})
+
+	t.Run("message has a Cc header", func(t *testing.T) {
+		if !strings.Contains(server.Data(), "Cc: Admin <admin@example.com>\n") {
+			t.Fatalf("expected Cc header, got message:\n%s", server.Data())
+		}
+	})
+}
+
+func TestSendOmitsEmptyCcHeader(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.Close()
+	defer useFakeSMTPServer(server)()
+
+	e := email{
+		to:       "test@example.com",
+		from:     "verify@example.com",
+		subject:  "Test subject",
+		textBody: "Test body",
+	}
+	assert.NoError(t, e.send())
+
+	// headers are in no particular order, so Cc could be the first line
+	if strings.Contains("\n"+strings.ToLower(server.Data()), "\ncc:") {
+		t.Fatalf("expected no Cc header, got message:\n%s", server.Data())
+	}
+}
+
+func TestSendDeliversToBcc(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.Close()
+	defer useFakeSMTPServer(server)()
+
+	e := email{
+		to:       "Test <test@example.com>",
+		from:     "Fluidkeys <verify@example.com>",
+		replyTo:  "Fluidkeys <security@example.com>",
+		bcc:      "audit@example.com",
+		subject:  "Test subject",
+		textBody: "Test body",
+	}
+	assert.NoError(t, e.send())
+
+	t.Run("bcc address is a recipient", func(t *testing.T) {
+		assert.Equal(t, []string{"test@example.com", "audit@example.com"}, server.Recipients())
+	})
+
+	t.Run("message has no Bcc header", func(t *testing.T) {
+		if strings.Contains(strings.ToLower(server.Data()), "bcc:") {
+			t.Fatalf("expected no Bcc header, got message:\n%s", server.Data())
+		}
+	})
+}
+
+func TestSendRetriesTransientFailures(t *testing.T) {
+	e := email{
+		to:       "test@example.com",
+		from:     "verify@example.com",
+		subject:  "Test subject",
+		textBody: "Test body",
+	}
+
+	defer func(attempts int, backoff time.Duration) {
+		smtpMaxAttempts, smtpRetryBackoff = attempts, backoff
+	}(smtpMaxAttempts, smtpRetryBackoff)
+	smtpMaxAttempts = 3
+	smtpRetryBackoff = time.Millisecond
+
+	t.Run("succeeds after a 4xx failure", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		server.RejectConnections(1, "421 service not available")
+
+		assert.NoError(t, e.send())
+		assert.Equal(t, 2, server.Connections())
+		assert.Equal(t, []string{"test@example.com"}, server.Recipients())
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		server.RejectConnections(5, "421 service not available")
+
+		assert.GotError(t, e.send())
+		assert.Equal(t, 3, server.Connections())
+	})
+
+	t.Run("retries a connection dropped before DATA", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		server.DropConnections(1)
+
+		assert.NoError(t, e.send())
+		assert.Equal(t, 2, server.Connections())
+	})
+
+	t.Run("doesn't retry a connection dropped after DATA", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		// the server may have accepted the message before dropping the connection, so
+		// retrying could deliver it twice
+		server.DropConnectionsAfterData(1)
+
+		assert.GotError(t, e.send())
+		assert.Equal(t, 1, server.Connections())
+	})
+
+	t.Run("retries a 4xx reply to DATA", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		server.RejectData(1, "451 try again later")
+
+		assert.NoError(t, e.send())
+		assert.Equal(t, 2, server.Connections())
+	})
+
+	t.Run("doesn't retry a 5xx failure", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		server.RejectConnections(1, "554 no service here")
+
+		assert.GotError(t, e.send())
+		assert.Equal(t, 1, server.Connections())
+	})
+}
+
+func TestSendOrRecordFailure(t *testing.T) {
+	type failure struct {
+		emailTemplateID string
+		recipient       string
+		sendErr         error
+	}
+	var recorded []failure
+
+	defer func() { recordEmailFailure = datastore.RecordEmailFailure }()
+	recordEmailFailure = func(
+		txn *sql.Tx, emailTemplateID string, recipient string, sendErr error, now time.Time) error {
+
+		if txn != nil {
+			t.Fatalf("expected failure to be recorded outside of a transaction")
+		}
+		recorded = append(recorded, failure{emailTemplateID, recipient, sendErr})
+		return nil
+	}
+
+	e := email{
+		to:       "test@example.com",
+		from:     "help@example.com",
+		subject:  "Test subject",
+		textBody: "Test body",
+	}
+
+	t.Run("doesn't record a successful send", func(t *testing.T) {
+		recorded = nil
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		assert.NoError(t, e.sendOrRecordFailure("template_1"))
+		assert.Equal(t, 0, len(recorded))
+	})
+
+	t.Run("records a permanent failure", func(t *testing.T) {
+		recorded = nil
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		server.RejectConnections(1, "554 no service here")
+
+		err := e.sendOrRecordFailure("template_1")
+		assert.GotError(t, err)
+		assert.Equal(t, 1, len(recorded))
+		assert.Equal(t, "template_1", recorded[0].emailTemplateID)
+		assert.Equal(t, "test@example.com", recorded[0].recipient)
+		assert.Equal(t, err, recorded[0].sendErr)
+	})
+}
+
+func TestVerificationEmailSend(t *testing.T) {
+	var expired []uuid.UUID
+
+	defer func() {
+		recordEmailFailure = datastore.RecordEmailFailure
+		expireVerification = datastore.ExpireVerification
+	}()
+	recordEmailFailure = func(*sql.Tx, string, string, error, time.Time) error { return nil }
+	expireVerification = func(txn *sql.Tx, secretUUID uuid.UUID, now time.Time) error {
+		if txn != nil {
+			t.Fatalf("expected verification to be expired outside of a transaction")
+		}
+		expired = append(expired, secretUUID)
+		return nil
+	}
+
+	secretUUID := uuid.Must(uuid.FromString("e7f1ad26-a1e1-4a4d-9ed0-1ae4b1a5c1de"))
+	verification := VerificationEmail{
+		EmailAddress:    "test@example.com",
+		VerificationUrl: makeVerificationUrl(secretUUID),
+		secretUUID:      secretUUID,
+		email: &email{
+			to:       "test@example.com",
+			from:     "help@example.com",
+			subject:  "Test subject",
+			textBody: "Test body",
+		},
+	}
+
+	t.Run("doesn't expire a verification that was sent", func(t *testing.T) {
+		expired = nil
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		assert.NoError(t, verification.Send())
+		assert.Equal(t, 0, len(expired))
+	})
+
+	t.Run("expires a verification that couldn't be sent", func(t *testing.T) {
+		expired = nil
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		server.RejectConnections(1, "554 no service here")
+
+		assert.GotError(t, verification.Send())
+		assert.Equal(t, []uuid.UUID{secretUUID}, expired)
+	})
+
+	t.Run("SendVerificationEmails leaves out URLs that weren't sent", func(t *testing.T) {
+		server := newFakeSMTPServer(t)
+		defer server.Close()
+		defer useFakeSMTPServer(server)()
+
+		server.RejectConnections(1, "554 no service here")
+
+		got := SendVerificationEmails([]*VerificationEmail{&verification, &verification})
+		assert.Equal(t, map[string]string{"test@example.com": verification.VerificationUrl}, got)
+	})
+}
+
+func TestSendMailRejectsCRLF(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.Close()
+	addr := "localhost:" + server.Port()
+
+	t.Run("in from address", func(t *testing.T) {
+		_, err := sendMail(addr, nil, "help@example.com\r\nRCPT TO:<evil@example.com>",
+			[]string{"test@example.com"}, []byte("Subject: test\r\n\r\nbody"))
+		assert.GotError(t, err)
+	})
+
+	t.Run("in a recipient", func(t *testing.T) {
+		_, err := sendMail(addr, nil, "help@example.com",
+			[]string{"test@example.com\nRCPT TO:<evil@example.com>"},
+			[]byte("Subject: test\r\n\r\nbody"))
+		assert.GotError(t, err)
+	})
+
+	assert.Equal(t, 0, server.Connections())
+}
+
+// useFakeSMTPServer points send() at the given server, returning a function that restores the
+// previous configuration.
+func useFakeSMTPServer(server *fakeSMTPServer) (restore func()) {
+	previous := []string{smtpHost, smtpPort, smtpUsername, smtpPassword}
+	previousDisableSendEmail := disableSendEmail
+
+	disableSendEmail = false
+	smtpHost = "localhost" // smtp.PlainAuth refuses to authenticate unencrypted otherwise
+	smtpPort = server.Port()
+	smtpUsername = "username"
+	smtpPassword = "password"
+
+	return func() {
+		smtpHost, smtpPort, smtpUsername, smtpPassword =
+			previous[0], previous[1], previous[2], previous[3]
+		disableSendEmail = previousDisableSendEmail
+	}
+}
+
+// fakeSMTPServer accepts SMTP connections on localhost and records the recipients and message
+// data of the most recent email it received.
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	mutex       sync.Mutex
+	recipients  []string
+	data        string
+	connections int
+
+	// rejectConnections is how many connections to reject with rejectReply before accepting
+	// mail normally.
+	rejectConnections int
+	rejectReply       string
+
+	// dropConnections is how many connections to close without a greeting.
+	dropConnections int
+
+	// dropAfterData is how many connections to close after reading the message data, without
+	// replying.
+	dropAfterData int
+
+	// rejectData is how many messages to reply to with rejectDataReply after reading their data.
+	rejectData      int
+	rejectDataReply string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server := &fakeSMTPServer{listener: listener}
+	go server.serve()
+	return server
+}
+
+func (s *fakeSMTPServer) Port() string {
+	return strconv.Itoa(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (s *fakeSMTPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeSMTPServer) Recipients() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.recipients
+}
+
+func (s *fakeSMTPServer) Data() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.data
+}
+
+func (s *fakeSMTPServer) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.connections
+}
+
+// RejectConnections makes the server reply to the next n connections with the given greeting,
+// for example "421 service not available", and close them.
+func (s *fakeSMTPServer) RejectConnections(n int, reply string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rejectConnections = n
+	s.rejectReply = reply
+}
+
+// DropConnections makes the server close the next n connections without replying.
+func (s *fakeSMTPServer) DropConnections(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dropConnections = n
+}
+
+// DropConnectionsAfterData makes the server close the next n connections after reading the
+// message data, without replying to it.
+func (s *fakeSMTPServer) DropConnectionsAfterData(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dropAfterData = n
+}
+
+// RejectData makes the server reply to the next n messages with the given reply, for example
+// "451 try again later", after reading their data.
+func (s *fakeSMTPServer) RejectData(n int, reply string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rejectData = n
+	s.rejectDataReply = reply
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.handle(textproto.NewConn(conn))
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn *textproto.Conn) {
+	defer conn.Close()
+
+	s.mutex.Lock()
+	s.connections++
+	reject := s.rejectConnections > 0
+	if reject {
+		s.rejectConnections--
+		conn.PrintfLine("%s", s.rejectReply)
+	}
+	drop := !reject && s.dropConnections > 0
+	if drop {
+		s.dropConnections--
+	}
+	s.mutex.Unlock()
+
+	if reject || drop {
+		return
+	}
+
+	var recipients []string
+	conn.PrintfLine("220 localhost ESMTP fake")
+
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			conn.PrintfLine("250-localhost")
+			conn.PrintfLine("250 AUTH PLAIN")
+
+		case strings.HasPrefix(command, "AUTH"):
+			conn.PrintfLine("235 authenticated")
+
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			conn.PrintfLine("250 ok")
+
+		case strings.HasPrefix(command, "RCPT TO:"):
+			address := strings.Trim(line[len("RCPT TO:"):], "<> ")
+			recipients = append(recipients, address)
+			conn.PrintfLine("250 ok")
+
+		case command == "DATA":
+			conn.PrintfLine("354 go ahead")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			s.mutex.Lock()
+			dropAfterData := s.dropAfterData > 0
+			rejectData := !dropAfterData && s.rejectData > 0
+			rejectDataReply := s.rejectDataReply
+			switch {
+			case dropAfterData:
+				s.dropAfterData--
+			case rejectData:
+				s.rejectData--
+			default:
+				s.recipients = recipients
+				s.data = string(data)
+			}
+			s.mutex.Unlock()
+
+			if dropAfterData {
+				return
+			} else if rejectData {
+				conn.PrintfLine("%s", rejectDataReply)
+				return
+			}
+			conn.PrintfLine("250 ok")
+
+		case command == "QUIT":
+			conn.PrintfLine("221 bye")
+			return
+
+		default:
+			conn.PrintfLine("250 ok")
+		}
+	}
+}
+
 func assertEqualMultiLineStrings(t *testing.T, expected string, got string) {
 	if expected == got {
 		return
@@ -116,3 +744,53 @@ If you aren't expecting this email, please reply to this email so we can investi
 
 </body>
 </html>`
+
+func TestDomainAllowed(t *testing.T) {
+	defer func(saved []string) { allowedEmailDomains = saved }(allowedEmailDomains)
+
+	t.Run("allows every domain when no allowlist is set", func(t *testing.T) {
+		allowedEmailDomains = nil
+		assert.Equal(t, true, DomainAllowed("test@example.com"))
+	})
+
+	allowedEmailDomains = []string{"example.com", "example.org"}
+
+	for _, address := range []string{"test@example.com", "test@EXAMPLE.org"} {
+		t.Run("allows "+address, func(t *testing.T) {
+			assert.Equal(t, true, DomainAllowed(address))
+		})
+	}
+
+	for _, address := range []string{
+		"test@example.net",
+		"test@sub.example.com",
+		"test@example.com.evil.net",
+		"not an email",
+	} {
+		t.Run("disallows "+address, func(t *testing.T) {
+			assert.Equal(t, false, DomainAllowed(address))
+		})
+	}
+
+	t.Run("disallowed domain doesn't get a verification email", func(t *testing.T) {
+		// returns before touching the database, so a nil txn is fine
+		shouldSend, err := shouldSendVerificationEmail(nil, "test@example.net")
+		assert.NoError(t, err)
+		assert.Equal(t, false, shouldSend)
+	})
+}
+
+func TestDomainsFromEnv(t *testing.T) {
+	const name = "TEST_DOMAINS_FROM_ENV"
+	defer os.Unsetenv(name)
+
+	t.Run("returns nil when unset", func(t *testing.T) {
+		os.Unsetenv(name)
+		assert.Equal(t, 0, len(domainsFromEnv(name)))
+	})
+
+	t.Run("splits, trims and lowercases", func(t *testing.T) {
+		os.Setenv(name, " Example.com, ,example.org ")
+		assert.Equal(t, []string{"example.com", "example.org"}, domainsFromEnv(name))
+	})
+}
@@ -8,17 +8,18 @@ import (
 	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
 )
 
-// SendKeyExpiresEmails sends expiry reminders for keys expiring in 14, 7, 3 days
-func SendKeyExpiresEmails() error {
+// SendKeyExpiresEmails sends expiry reminders for keys expiring in 14, 7, 3 days, returning how
+// many were sent and how many failed.
+func SendKeyExpiresEmails() (numSent int, numErrors int, err error) {
 	const from = "Fluidkeys <help@mail.fluidkeys.com>"
 	const replyTo = "Fluidkeys <help@fluidkeys.com>"
 
 	keysExpiring, err := datastore.ListKeysExpiring()
 	if err != nil {
-		return fmt.Errorf("error calling datastore.ListKeysKeysExpiring: %v", err)
+		return 0, 0, fmt.Errorf("error calling datastore.ListKeysKeysExpiring: %v", err)
 	}
 
-	var numSent, numErrors, numAlreadySent int
+	var numAlreadySent int
 
 	for i := range keysExpiring {
 		daysUntilExpiry := keysExpiring[i].DaysUntilExpiry
@@ -75,7 +76,7 @@ func SendKeyExpiresEmails() error {
 	fmt.Printf("key expiring emails: %d sent, %d failed, %d already sent (rate-limited).\n",
 		numSent, numErrors, numAlreadySent)
 
-	return nil
+	return numSent, numErrors, nil
 }
 
 // -------------------- help_key_expires_3_days --------------------
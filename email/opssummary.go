@@ -0,0 +1,70 @@
+package email
+
+import (
+	"fmt"
+	"time"
+)
+
+// JobSummary holds the counts from a run of one of the batch commands, for emailing to
+// operators with SendOpsSummary.
+type JobSummary struct {
+	Job            string
+	FinishedAt     time.Time
+	KeysDeleted    int
+	SecretsDeleted int
+	EmailsSent     int
+	Errors         int
+}
+
+// SendOpsSummary emails the summary of a batch job to the operators' address set in
+// OPS_SUMMARY_EMAIL. It does nothing if that isn't set.
+// Unlike emails to users it isn't rate-limited or recorded in the database, since it's sent
+// once per run.
+func SendOpsSummary(summary JobSummary) error {
+	if opsSummaryTo == "" {
+		return nil
+	}
+
+	email := email{
+		to:      opsSummaryTo,
+		from:    opsSummaryFrom,
+		replyTo: opsSummaryFrom,
+	}
+
+	if err := (opsSummary{summary}).RenderInto(&email); err != nil {
+		return fmt.Errorf("error rendering email: %v", err)
+	}
+
+	if err := email.send(); err != nil {
+		return fmt.Errorf("error sending mail: %v", err)
+	}
+	return nil
+}
+
+// -------------------- ops_summary --------------------
+type opsSummary struct {
+	JobSummary
+}
+
+func (e opsSummary) ID() string { return "ops_summary" }
+func (e opsSummary) RenderInto(eml *email) (err error) {
+	eml.subject, err = renderText(opsSummarySubjectTemplate, e)
+	if err != nil {
+		return err
+	}
+	eml.textBody, err = renderText(opsSummaryBodyTemplate, e)
+	return err
+}
+
+const opsSummarySubjectTemplate = `{{if .Errors}}❌{{else}}✔{{end}} {{.Job}}: ` +
+	`{{.Errors}} errors`
+
+const opsSummaryBodyTemplate = `{{.Job}} finished at {{.FinishedAt.UTC.Format "2006-01-02 15:04:05 MST"}}.
+
+Keys deleted:    {{.KeysDeleted}}
+Secrets deleted: {{.SecretsDeleted}}
+Emails sent:     {{.EmailsSent}}
+Errors:          {{.Errors}}
+{{if .Errors}}
+Check the job's logs for details of the errors.
+{{end}}`
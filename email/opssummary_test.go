@@ -0,0 +1,59 @@
+package email
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestRenderOpsSummary(t *testing.T) {
+	summary := JobSummary{
+		Job:            "delete_expired_keys",
+		FinishedAt:     time.Date(2019, 7, 3, 3, 0, 12, 0, time.UTC),
+		KeysDeleted:    12,
+		SecretsDeleted: 3,
+		EmailsSent:     11,
+		Errors:         1,
+	}
+
+	t.Run("with errors", func(t *testing.T) {
+		eml := email{}
+		assert.NoError(t, opsSummary{summary}.RenderInto(&eml))
+
+		assert.Equal(t, "❌ delete_expired_keys: 1 errors", eml.subject)
+		assertEqualMultiLineStrings(t, `delete_expired_keys finished at 2019-07-03 03:00:12 UTC.
+
+Keys deleted:    12
+Secrets deleted: 3
+Emails sent:     11
+Errors:          1
+
+Check the job's logs for details of the errors.
+`, eml.textBody)
+	})
+
+	t.Run("without errors", func(t *testing.T) {
+		noErrors := summary
+		noErrors.Errors = 0
+
+		eml := email{}
+		assert.NoError(t, opsSummary{noErrors}.RenderInto(&eml))
+
+		assert.Equal(t, "✔ delete_expired_keys: 0 errors", eml.subject)
+		assertEqualMultiLineStrings(t, `delete_expired_keys finished at 2019-07-03 03:00:12 UTC.
+
+Keys deleted:    12
+Secrets deleted: 3
+Emails sent:     11
+Errors:          0
+`, eml.textBody)
+	})
+}
+
+func TestSendOpsSummaryWithoutAddress(t *testing.T) {
+	defer func(to string) { opsSummaryTo = to }(opsSummaryTo)
+	opsSummaryTo = ""
+
+	assert.NoError(t, SendOpsSummary(JobSummary{Job: "send_emails"}))
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"fmt"
+	"time"
+
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+)
+
+// ErrUnknownTemplate is returned by RenderPreview if there's no email template with the given ID.
+var ErrUnknownTemplate = fmt.Errorf("unknown email template")
+
+// Preview is an email rendered with sample data, for checking how it looks during development.
+type Preview struct {
+	Subject string
+
+	// HTMLBody is the body of HTML emails, or empty for plain text emails.
+	HTMLBody string
+
+	// TextBody is the body of plain text emails, or empty for HTML emails.
+	TextBody string
+}
+
+// RenderPreview renders the email template with the given ID (for example
+// `help_key_expires_3_days`) with sample data. Nothing is sent or stored.
+func RenderPreview(templateID string) (*Preview, error) {
+	for _, template := range previewTemplates() {
+		if template.ID() != templateID {
+			continue
+		}
+
+		eml := email{}
+		if err := template.RenderInto(&eml); err != nil {
+			return nil, fmt.Errorf("error rendering email: %v", err)
+		}
+		return &Preview{Subject: eml.subject, HTMLBody: eml.htmlBody, TextBody: eml.textBody}, nil
+	}
+	return nil, ErrUnknownTemplate
+}
+
+// previewTemplates returns every email template, filled in with sample data.
+func previewTemplates() []emailTemplateInterface {
+	const sampleEmail = "jane@example.com"
+	sampleFingerprint := fpr.MustParse("AAAABBBBAAAABBBBAAAABBBBAAAABBBBAAAABBBB")
+	sampleTime := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	return []emailTemplateInterface{
+		verifyEmail{
+			Email:            sampleEmail,
+			VerificationUrl:  "https://api.fluidkeys.com/v1/email/verify/00000000-0000-4000-8000-000000000000",
+			RequestIpAddress: "192.0.2.1",
+			RequestTime:      sampleTime,
+			KeyFingerprint:   sampleFingerprint.Hex(),
+			KeyCreatedDate:   sampleTime.Add(-time.Duration(24) * time.Hour),
+		},
+		helpKeyExpires3Days{Email: sampleEmail, Fingerprint: sampleFingerprint},
+		helpKeyExpires7Days{Email: sampleEmail, Fingerprint: sampleFingerprint},
+		helpKeyExpires14Days{Email: sampleEmail, Fingerprint: sampleFingerprint},
+		helpKeyExpiredDeleted{Email: sampleEmail, Fingerprint: sampleFingerprint},
+		opsSummary{JobSummary{
+			Job:            "delete_expired_keys",
+			FinishedAt:     sampleTime,
+			KeysDeleted:    12,
+			SecretsDeleted: 3,
+			EmailsSent:     11,
+		}},
+		testEmailText{},
+		testEmailHTML{},
+	}
+}
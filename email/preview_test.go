@@ -0,0 +1,44 @@
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestRenderPreview(t *testing.T) {
+	t.Run("HTML template", func(t *testing.T) {
+		preview, err := RenderPreview("verify")
+		assert.NoError(t, err)
+
+		assert.Equal(t, "Verify jane@example.com on Fluidkeys", preview.Subject)
+		if !strings.Contains(preview.HTMLBody, "AAAABBBBAAAABBBB") {
+			t.Fatalf("expected the sample fingerprint in the body, got %s", preview.HTMLBody)
+		}
+		assert.Equal(t, "", preview.TextBody)
+	})
+
+	t.Run("text template", func(t *testing.T) {
+		preview, err := RenderPreview("help_key_expired_deleted")
+		assert.NoError(t, err)
+
+		assert.Equal(t, helpKeyExpiredDeletedSubject, preview.Subject)
+		assert.Equal(t, "", preview.HTMLBody)
+		if preview.TextBody == "" {
+			t.Fatalf("expected a text body")
+		}
+	})
+
+	t.Run("every template renders", func(t *testing.T) {
+		for _, template := range previewTemplates() {
+			_, err := RenderPreview(template.ID())
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("unknown template", func(t *testing.T) {
+		_, err := RenderPreview("no_such_template")
+		assert.Equal(t, ErrUnknownTemplate, err)
+	})
+}
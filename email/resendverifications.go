@@ -0,0 +1,101 @@
+package email
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+)
+
+// ResendVerificationEmails sends another verification email to addresses on keys that were
+// uploaded but never verified, for people who dropped off before clicking the link.
+// It returns how many emails were sent.
+func ResendVerificationEmails(now time.Time) (sent int, err error) {
+	unverified, err := datastore.ListUnverifiedKeyEmails(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, u := range selectVerificationsToResend(unverified, now) {
+		if u.FirstIPAddress == "" {
+			// the email says where the key was uploaded from, so we can't send it without
+			log.Printf("no upload IP address for %s, not resending verification", u.Email)
+			continue
+		}
+
+		var verification *VerificationEmail
+		err := datastore.RunInTransaction(func(txn *sql.Tx) (err error) {
+			// the email describes the original upload, not this resend
+			meta := VerificationMetadata{
+				RequestUserAgent: u.FirstUserAgent,
+				RequestIpAddress: u.FirstIPAddress,
+				RequestTime:      u.FirstSentAt,
+			}
+			verification, err = CreateVerificationEmail(txn, u.Email, u.Key, meta)
+			return err
+		})
+		if err != nil {
+			log.Printf("error resending verification to %s: %v", u.Email, err)
+			continue
+		} else if verification == nil {
+			continue
+		}
+
+		if err := verification.Send(); err != nil {
+			log.Printf("error resending verification to %s: %v", u.Email, err)
+		} else {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// selectVerificationsToResend picks which of the unverified emails to resend a verification to.
+// Emails are rate limited however many keys they're on: each address gets at most one email per
+// resendVerificationAfter and maxVerificationsPerEmail in total. If an address is on more than
+// one key, it's sent a verification for the most recently uploaded one.
+func selectVerificationsToResend(
+	unverified []datastore.UnverifiedKeyEmail, now time.Time) []datastore.UnverifiedKeyEmail {
+
+	type perEmail struct {
+		totalSent  int
+		lastSentAt time.Time
+		newest     datastore.UnverifiedKeyEmail
+	}
+
+	byEmail := map[string]*perEmail{}
+	emailOrder := []string{}
+
+	for _, u := range unverified {
+		email := strings.ToLower(u.Email)
+		p, got := byEmail[email]
+		if !got {
+			p = &perEmail{newest: u}
+			byEmail[email] = p
+			emailOrder = append(emailOrder, email)
+		}
+
+		p.totalSent += u.VerificationsSent
+		if u.LastSentAt.After(p.lastSentAt) {
+			p.lastSentAt = u.LastSentAt
+		}
+		if u.FirstSentAt.After(p.newest.FirstSentAt) {
+			p.newest = u
+		}
+	}
+
+	selected := []datastore.UnverifiedKeyEmail{}
+	for _, email := range emailOrder {
+		p := byEmail[email]
+		if p.totalSent >= maxVerificationsPerEmail {
+			continue
+		}
+		if now.Sub(p.lastSentAt) < resendVerificationAfter {
+			continue
+		}
+		selected = append(selected, p.newest)
+	}
+	return selected
+}
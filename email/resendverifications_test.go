@@ -0,0 +1,92 @@
+package email
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestSelectVerificationsToResend(t *testing.T) {
+	now := time.Date(2019, 8, 20, 12, 0, 0, 0, time.UTC)
+	day := time.Duration(24) * time.Hour
+
+	defer func(after time.Duration, max int) {
+		resendVerificationAfter, maxVerificationsPerEmail = after, max
+	}(resendVerificationAfter, maxVerificationsPerEmail)
+	resendVerificationAfter = 7 * day
+	maxVerificationsPerEmail = 3
+
+	unverified := func(
+		email string, firstSent time.Time, sent int, lastSent time.Time,
+	) datastore.UnverifiedKeyEmail {
+		return datastore.UnverifiedKeyEmail{
+			Email:             email,
+			FirstSentAt:       firstSent,
+			FirstIPAddress:    "10.0.0.1",
+			VerificationsSent: sent,
+			LastSentAt:        lastSent,
+		}
+	}
+
+	emails := func(selected []datastore.UnverifiedKeyEmail) []string {
+		got := []string{}
+		for _, u := range selected {
+			got = append(got, u.Email)
+		}
+		return got
+	}
+
+	t.Run("last sent longer ago than resendVerificationAfter", func(t *testing.T) {
+		got := selectVerificationsToResend([]datastore.UnverifiedKeyEmail{
+			unverified("a@example.com", now.Add(-8*day), 1, now.Add(-8*day)),
+		}, now)
+		assert.Equal(t, []string{"a@example.com"}, emails(got))
+	})
+
+	t.Run("last sent too recently", func(t *testing.T) {
+		got := selectVerificationsToResend([]datastore.UnverifiedKeyEmail{
+			unverified("a@example.com", now.Add(-20*day), 2, now.Add(-6*day)),
+		}, now)
+		assert.Equal(t, []string{}, emails(got))
+	})
+
+	t.Run("already sent maxVerificationsPerEmail", func(t *testing.T) {
+		got := selectVerificationsToResend([]datastore.UnverifiedKeyEmail{
+			unverified("a@example.com", now.Add(-30*day), 3, now.Add(-10*day)),
+		}, now)
+		assert.Equal(t, []string{}, emails(got))
+	})
+
+	t.Run("email on several keys", func(t *testing.T) {
+		older := unverified("a@example.com", now.Add(-30*day), 1, now.Add(-30*day))
+		newer := unverified("A@example.com", now.Add(-10*day), 1, now.Add(-10*day))
+
+		t.Run("is sent one verification, for the newest key", func(t *testing.T) {
+			got := selectVerificationsToResend([]datastore.UnverifiedKeyEmail{older, newer}, now)
+			assert.Equal(t, []string{"A@example.com"}, emails(got))
+		})
+
+		t.Run("is rate limited across all its keys", func(t *testing.T) {
+			recent := unverified("a@example.com", now.Add(-2*day), 1, now.Add(-2*day))
+			got := selectVerificationsToResend(
+				[]datastore.UnverifiedKeyEmail{older, newer, recent}, now)
+			assert.Equal(t, []string{}, emails(got))
+		})
+
+		t.Run("counts verifications across all its keys", func(t *testing.T) {
+			older.VerificationsSent = 2
+			got := selectVerificationsToResend([]datastore.UnverifiedKeyEmail{older, newer}, now)
+			assert.Equal(t, []string{}, emails(got))
+		})
+	})
+
+	t.Run("keeps the order emails were listed in", func(t *testing.T) {
+		got := selectVerificationsToResend([]datastore.UnverifiedKeyEmail{
+			unverified("b@example.com", now.Add(-8*day), 1, now.Add(-8*day)),
+			unverified("a@example.com", now.Add(-8*day), 1, now.Add(-8*day)),
+		}, now)
+		assert.Equal(t, []string{"b@example.com", "a@example.com"}, emails(got))
+	})
+}
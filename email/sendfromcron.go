@@ -1,14 +1,25 @@
 package email
 
-import "log"
+import (
+	"log"
+	"time"
+)
 
 // SendFromCron is periodically called from cron, figures out which it needs to
-// send, sends them, and records they've been sent in the datastore.
-func SendFromCron() (sawError error) {
-	if err := SendKeyExpiresEmails(); err != nil {
+// send, sends them, and records they've been sent in the datastore. It returns a summary of
+// the run for SendOpsSummary.
+func SendFromCron() (summary JobSummary, sawError error) {
+	summary.Job = "send_emails"
+
+	sent, failed, err := SendKeyExpiresEmails()
+	summary.EmailsSent += sent
+	summary.Errors += failed
+	if err != nil {
 		log.Printf("error calling SendKeyExpiresEmails: %v", err)
+		summary.Errors++
 		sawError = err
 	}
 
-	return sawError
+	summary.FinishedAt = time.Now()
+	return summary, sawError
 }
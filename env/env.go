@@ -0,0 +1,80 @@
+// Package env reads configuration from environment variables.
+//
+// Every function returns defaultValue if the variable isn't set, and panics if it's set to
+// something invalid, so a typo in the config stops the server starting instead of being
+// silently replaced by the default.
+package env
+
+import (
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// PositiveInt returns the integer value of the given environment variable, or defaultValue if
+// it isn't set. It panics if the variable is set but isn't a positive integer.
+func PositiveInt(name string, defaultValue int) int {
+	value, got := os.LookupEnv(name)
+	if !got {
+		return defaultValue
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Panicf("invalid %s '%s', should be a positive integer", name, value)
+	}
+	return n
+}
+
+// Duration returns the duration set in the given environment variable as a positive whole
+// number of units, for example seconds, or defaultValue if it isn't set. It panics if the
+// variable is set but isn't a positive integer.
+func Duration(name string, unit time.Duration, defaultValue time.Duration) time.Duration {
+	return time.Duration(PositiveInt(name, int(defaultValue/unit))) * unit
+}
+
+// Bool returns the boolean value of the given environment variable, or defaultValue if it
+// isn't set. It panics if the variable is set to something other than 1, 0, true or false.
+func Bool(name string, defaultValue bool) bool {
+	value, got := os.LookupEnv(name)
+	if !got {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Panicf("invalid %s '%s', should be 1, 0, true or false", name, value)
+	}
+	return b
+}
+
+// URL returns the URL in the given environment variable, or defaultValue if it isn't set. It
+// panics if the variable is set but isn't an absolute URL.
+func URL(name string, defaultValue string) string {
+	value, got := os.LookupEnv(name)
+	if !got {
+		return defaultValue
+	}
+
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		log.Panicf("invalid %s '%s', should be an absolute URL", name, value)
+	}
+	return value
+}
+
+// Address returns the email address in the given environment variable, or defaultValue if it
+// isn't set. It panics if the variable is set but isn't a valid address.
+func Address(name string, defaultValue string) string {
+	value, got := os.LookupEnv(name)
+	if !got {
+		return defaultValue
+	}
+
+	if _, err := mail.ParseAddress(value); err != nil {
+		log.Panicf("invalid %s '%s', %v", name, value, err)
+	}
+	return value
+}
@@ -0,0 +1,119 @@
+package env
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestPositiveInt(t *testing.T) {
+	const name = "TEST_POSITIVE_INT"
+	defer os.Unsetenv(name)
+
+	t.Run("returns default when unset", func(t *testing.T) {
+		os.Unsetenv(name)
+		assert.Equal(t, 5, PositiveInt(name, 5))
+	})
+
+	t.Run("returns value when valid", func(t *testing.T) {
+		os.Setenv(name, "12")
+		assert.Equal(t, 12, PositiveInt(name, 5))
+	})
+
+	for _, invalid := range []string{"", "0", "-1", "twelve"} {
+		t.Run("panics when set to '"+invalid+"'", func(t *testing.T) {
+			os.Setenv(name, invalid)
+			assertPanics(t, func() { PositiveInt(name, 5) })
+		})
+	}
+}
+
+func TestDuration(t *testing.T) {
+	const name = "TEST_DURATION"
+	defer os.Unsetenv(name)
+
+	t.Run("returns default when unset", func(t *testing.T) {
+		os.Unsetenv(name)
+		assert.Equal(t, time.Duration(2)*time.Hour, Duration(name, time.Hour, 2*time.Hour))
+	})
+
+	t.Run("returns value in the given unit", func(t *testing.T) {
+		os.Setenv(name, "1500")
+		assert.Equal(t,
+			time.Duration(1500)*time.Millisecond, Duration(name, time.Millisecond, time.Second))
+	})
+}
+
+func TestBool(t *testing.T) {
+	const name = "TEST_BOOL"
+	defer os.Unsetenv(name)
+
+	t.Run("returns default when unset", func(t *testing.T) {
+		os.Unsetenv(name)
+		assert.Equal(t, true, Bool(name, true))
+	})
+
+	for value, expected := range map[string]bool{"1": true, "true": true, "0": false, "false": false} {
+		t.Run("returns "+value, func(t *testing.T) {
+			os.Setenv(name, value)
+			assert.Equal(t, expected, Bool(name, !expected))
+		})
+	}
+
+	t.Run("panics when invalid", func(t *testing.T) {
+		os.Setenv(name, "yes")
+		assertPanics(t, func() { Bool(name, false) })
+	})
+}
+
+func TestURL(t *testing.T) {
+	const name = "TEST_URL"
+	defer os.Unsetenv(name)
+
+	t.Run("returns default when unset", func(t *testing.T) {
+		os.Unsetenv(name)
+		assert.Equal(t, "https://example.com", URL(name, "https://example.com"))
+	})
+
+	t.Run("returns value when valid", func(t *testing.T) {
+		os.Setenv(name, "https://staging.example.com/docs")
+		assert.Equal(t, "https://staging.example.com/docs", URL(name, "https://example.com"))
+	})
+
+	t.Run("panics when not absolute", func(t *testing.T) {
+		os.Setenv(name, "/docs")
+		assertPanics(t, func() { URL(name, "https://example.com") })
+	})
+}
+
+func TestAddress(t *testing.T) {
+	const name = "TEST_ADDRESS"
+	defer os.Unsetenv(name)
+
+	t.Run("returns default when unset", func(t *testing.T) {
+		os.Unsetenv(name)
+		assert.Equal(t, "default@example.com", Address(name, "default@example.com"))
+	})
+
+	t.Run("returns value when valid", func(t *testing.T) {
+		os.Setenv(name, "Test <test@example.com>")
+		assert.Equal(t, "Test <test@example.com>", Address(name, "default@example.com"))
+	})
+
+	t.Run("panics when invalid", func(t *testing.T) {
+		os.Setenv(name, "not an email address")
+		assertPanics(t, func() { Address(name, "default@example.com") })
+	})
+}
+
+func assertPanics(t *testing.T, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic")
+		}
+	}()
+	f()
+}
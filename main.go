@@ -11,6 +11,11 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gen_openapi" {
+		// doesn't need the database
+		os.Exit(cmd.GenOpenAPI())
+	}
+
 	err := datastore.Initialize(datastore.MustReadDatabaseURL())
 	if err != nil {
 		log.Printf("error from ListenAndServe: %v", err)
@@ -32,9 +37,48 @@ func main() {
 	} else if os.Args[1] == "send_emails" {
 		os.Exit(cmd.SendEmails())
 
+	} else if os.Args[1] == "resend_verifications" {
+		os.Exit(cmd.ResendVerifications())
+
+	} else if os.Args[1] == "purge_deleted_teams" {
+		os.Exit(cmd.PurgeDeletedTeams())
+
+	} else if os.Args[1] == "prune_join_requests" {
+		os.Exit(cmd.PruneJoinRequests())
+
+	} else if os.Args[1] == "restore_team" {
+		os.Exit(cmd.RestoreTeam())
+
+	} else if os.Args[1] == "list_teams" {
+		os.Exit(cmd.ListTeams())
+
+	} else if os.Args[1] == "check_rosters" {
+		os.Exit(cmd.CheckRosters())
+
+	} else if os.Args[1] == "sweep_orphans" {
+		os.Exit(cmd.SweepOrphans())
+
+	} else if os.Args[1] == "ban_key" {
+		os.Exit(cmd.BanKey())
+
+	} else if os.Args[1] == "unban_key" {
+		os.Exit(cmd.UnbanKey())
+
+	} else if os.Args[1] == "clear_key_compromised" {
+		os.Exit(cmd.ClearKeyCompromised())
+
 	} else if os.Args[1] == "send_test_emails" {
 		os.Exit(cmd.SendTestEmails())
 
+	} else if os.Args[1] == "list_email_failures" {
+		os.Exit(cmd.ListEmailFailures())
+
+	} else if os.Args[1] == "list_suspicious" {
+		os.Exit(cmd.ListSuspicious())
+
+	} else if os.Args[1] == "explain_queries" {
+		os.Exit(cmd.ExplainQueries())
+
 	} else {
 		fmt.Printf("unrecognised command: `%s`\n", os.Args[1])
 		os.Exit(1)
@@ -1,39 +1,95 @@
 package server
 
 import (
+	"crypto"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/fluidkeys/api/datastore"
 	"github.com/fluidkeys/crypto/openpgp"
+	"github.com/fluidkeys/crypto/openpgp/armor"
 	"github.com/fluidkeys/crypto/openpgp/errors"
+	"github.com/fluidkeys/crypto/openpgp/packet"
 	"github.com/fluidkeys/fluidkeys/fingerprint"
 	"github.com/fluidkeys/fluidkeys/pgpkey"
 )
 
+// getAuthorizedUserPublicKey returns the public key the request is authorized as. It accepts
+// either of these headers:
+//
+// Authorization: Basic <base64 of fingerprint:password>
+//
+// where the password is the one returned, encrypted, when the key was uploaded (or its password
+// was rotated). The password is checked against the hash stored for the key.
+//
+// Authorization: tmpfingerprint: OPENPGP4FPR:AAAABBBBAAAABBBBAAAABBBBAAAABBBBAAAABBBB
+//
+// TODO: stop accepting tmpfingerprint. For now anyone can "authenticate" as any public key
+// with it, which is obviously stupid, so endpoints that accept it must either encrypt their
+// response to the key or require a request signed by the key.
 func getAuthorizedUserPublicKey(r *http.Request) (*pgpkey.PgpKey, error) {
-	// TODO: actually authenticate a public key!
-	//
-	// For now anyone can "authenticate" as any public key which is
-	// obviously stupid, but the impact is limited by the fact that all
-	// content is encrypted to the public key.
-	//
-	// Look for a header like:
-	// Authorization: tmpfingerprint: OPENPGP4FPR:AAAABBBBAAAABBBBAAAABBBBAAAABBBBAAAABBBB
+	key, _, err := authenticateRequest(r)
+	return key, err
+}
+
+// getPasswordAuthorizedUserPublicKey is like getAuthorizedUserPublicKey, but only accepts HTTP
+// basic auth, for endpoints where the caller must really own the key.
+func getPasswordAuthorizedUserPublicKey(r *http.Request) (*pgpkey.PgpKey, error) {
+	key, passwordChecked, err := authenticateRequest(r)
+	if err != nil {
+		return nil, err
+	} else if !passwordChecked {
+		return nil, errAuthPasswordRequired
+	}
+	return key, nil
+}
+
+// authenticateRequest returns the key the request is authorized as, and whether the key's
+// basic auth password was checked.
+func authenticateRequest(r *http.Request) (key *pgpkey.PgpKey, passwordChecked bool, err error) {
+	if username, password, ok := r.BasicAuth(); ok {
+		fpr, err := fingerprint.Parse(username)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to parse fingerprint: %v", err)
+		}
+
+		storedHash, err := datastore.GetBasicAuthPasswordHash(nil, fpr)
+		if err == datastore.ErrNotFound {
+			return nil, false, errAuthPasswordIncorrect // no such key, or it has no password
+		} else if err != nil {
+			return nil, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(hashPassword(password)), []byte(storedHash)) != 1 {
+			return nil, false, errAuthPasswordIncorrect
+		}
+
+		key, err := loadAuthorizedPublicKey(fpr)
+		return key, err == nil, err
+	}
 
 	const prefix string = "tmpfingerprint: OPENPGP4FPR:"
 
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, prefix) {
-		return nil, fmt.Errorf("missing Authorization header starting `tmpfingerprint: OPENPGP4FPR:`")
+		return nil, false, fmt.Errorf(
+			"missing Authorization header starting `Basic` or `tmpfingerprint: OPENPGP4FPR:`")
 	}
 
 	fpr, err := fingerprint.Parse(authHeader[len(prefix):])
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse fingerprint: %v", err)
+		return nil, false, fmt.Errorf("failed to parse fingerprint: %v", err)
 	}
 
+	key, err = loadAuthorizedPublicKey(fpr)
+	return key, false, err
+}
+
+// loadAuthorizedPublicKey loads the stored public key the request is authorized as.
+func loadAuthorizedPublicKey(fpr fingerprint.Fingerprint) (*pgpkey.PgpKey, error) {
 	armoredPublicKey, found, err := datastore.GetArmoredPublicKeyForFingerprint(fpr)
 	if err != nil {
 		return nil, err
@@ -72,3 +128,96 @@ func validateDataSignedByKey(data string, armoredDetachedSignature string, key *
 
 	return nil
 }
+
+// signatureCreationTime reads the signature packet from the given armored detached signature
+// and returns the time the signature claims it was made.
+func signatureCreationTime(armoredDetachedSignature string) (*time.Time, error) {
+	p, err := readSignaturePacket(armoredDetachedSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	switch sig := p.(type) {
+	case *packet.Signature:
+		return &sig.CreationTime, nil
+
+	case *packet.SignatureV3:
+		return &sig.CreationTime, nil
+
+	default:
+		return nil, fmt.Errorf("expected a signature packet, got %T", p)
+	}
+}
+
+// signatureHash reads the signature packet from the given armored detached signature and
+// returns the hash algorithm it was made with.
+func signatureHash(armoredDetachedSignature string) (crypto.Hash, error) {
+	p, err := readSignaturePacket(armoredDetachedSignature)
+	if err != nil {
+		return 0, err
+	}
+
+	switch sig := p.(type) {
+	case *packet.Signature:
+		return sig.Hash, nil
+
+	case *packet.SignatureV3:
+		return sig.Hash, nil
+
+	default:
+		return 0, fmt.Errorf("expected a signature packet, got %T", p)
+	}
+}
+
+// validateRosterSignatureHash returns errRosterSignatureHashNotAllowed if the given armored
+// detached signature was made with a hash algorithm that isn't in allowedRosterSignatureHashes,
+// for example SHA-1.
+func validateRosterSignatureHash(armoredDetachedSignature string) error {
+	hash, err := signatureHash(armoredDetachedSignature)
+	if err != nil {
+		return err
+	}
+
+	for _, allowed := range allowedRosterSignatureHashes {
+		if hash == allowed {
+			return nil
+		}
+	}
+	return errRosterSignatureHashNotAllowed
+}
+
+// signatureIssuerKeyID reads the signature packet from the given armored detached signature
+// and returns the ID of the key that the signature claims made it.
+func signatureIssuerKeyID(armoredDetachedSignature string) (uint64, error) {
+	p, err := readSignaturePacket(armoredDetachedSignature)
+	if err != nil {
+		return 0, err
+	}
+
+	switch sig := p.(type) {
+	case *packet.Signature:
+		if sig.IssuerKeyId == nil {
+			return 0, fmt.Errorf("signature has no issuer key ID")
+		}
+		return *sig.IssuerKeyId, nil
+
+	case *packet.SignatureV3:
+		return sig.IssuerKeyId, nil
+
+	default:
+		return 0, fmt.Errorf("expected a signature packet, got %T", p)
+	}
+}
+
+func readSignaturePacket(armoredDetachedSignature string) (packet.Packet, error) {
+	block, err := armor.Decode(strings.NewReader(armoredDetachedSignature))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding ASCII armor: %v", err)
+	}
+
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signature packet: %v", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"crypto"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fluidkeys/api/email"
+	"github.com/fluidkeys/api/env"
+	"github.com/fluidkeys/fluidkeys/policy"
+)
+
+func init() {
+	maxArmoredPublicKeyBytes = env.PositiveInt(
+		"MAX_ARMORED_PUBLIC_KEY_BYTES", maxArmoredPublicKeyBytes)
+	maxJsonRequestBytes = env.PositiveInt("MAX_JSON_REQUEST_BYTES", maxJsonRequestBytes)
+	maxLargeJsonRequestBytes = env.PositiveInt(
+		"MAX_LARGE_JSON_REQUEST_BYTES", maxLargeJsonRequestBytes)
+	maxSecretBytes = env.PositiveInt("MAX_SECRET_BYTES", maxSecretBytes)
+	maxVerifyBodyBytes = env.PositiveInt("MAX_VERIFY_BODY_BYTES", maxVerifyBodyBytes)
+
+	maintenanceMode = maintenanceModeFromEnv("MAINTENANCE_MODE")
+	maintenanceRetryAfterSeconds = env.PositiveInt(
+		"MAINTENANCE_RETRY_AFTER_SECONDS", maintenanceRetryAfterSeconds)
+
+	docsURL = env.URL("DOCS_URL", docsURL)
+
+	auditSecretSends = env.Bool("AUDIT_SECRET_SENDS", auditSecretSends)
+
+	returnVerificationUrls = env.Bool("RETURN_VERIFICATION_URLS", returnVerificationUrls)
+	if returnVerificationUrls && !email.SendingDisabled() {
+		// anyone could verify any email address by uploading a key with it
+		log.Panic("RETURN_VERIFICATION_URLS=1 is only allowed with DISABLE_SEND_EMAIL=1")
+	}
+
+	emailPreview = env.Bool("ENABLE_EMAIL_PREVIEW", emailPreview)
+	if emailPreview && !email.SendingDisabled() {
+		// it's for development only: a production server sends emails
+		log.Panic("ENABLE_EMAIL_PREVIEW=1 is only allowed with DISABLE_SEND_EMAIL=1")
+	}
+
+	httpReadTimeout = env.Duration("HTTP_READ_TIMEOUT_SECONDS", time.Second, httpReadTimeout)
+	httpWriteTimeout = env.Duration("HTTP_WRITE_TIMEOUT_SECONDS", time.Second, httpWriteTimeout)
+	httpIdleTimeout = env.Duration("HTTP_IDLE_TIMEOUT_SECONDS", time.Second, httpIdleTimeout)
+
+	minRSAKeyBits = env.PositiveInt("MIN_RSA_KEY_BITS", minRSAKeyBits)
+	allowDSAKeys = env.Bool("ALLOW_DSA_KEYS", allowDSAKeys)
+
+	maxRosterSignatureAge = env.Duration(
+		"MAX_ROSTER_SIGNATURE_AGE_HOURS", time.Hour, maxRosterSignatureAge)
+	maxRosterSignatureClockSkew = env.Duration(
+		"MAX_ROSTER_SIGNATURE_CLOCK_SKEW_SECONDS", time.Second, maxRosterSignatureClockSkew)
+	allowedRosterSignatureHashes = hashesFromEnv(
+		"ROSTER_SIGNATURE_HASHES", allowedRosterSignatureHashes)
+}
+
+// maxArmoredPublicKeyBytes is the largest ASCII-armored public key we'll accept on upload.
+// Keys carrying thousands of third-party signatures can legitimately run to a few hundred KB,
+// so this is deliberately generous. Override with MAX_ARMORED_PUBLIC_KEY_BYTES.
+var maxArmoredPublicKeyBytes = 256 * 1024
+
+// maxJsonRequestBytes is the largest JSON request body most endpoints will read. Bigger bodies
+// get 413 Request Entity Too Large. Override with MAX_JSON_REQUEST_BYTES.
+var maxJsonRequestBytes = 64 * 1024
+
+// maxLargeJsonRequestBytes is the largest JSON request body for the key upload and team roster
+// endpoints, which legitimately carry bigger payloads. It needs to leave room for an armored
+// key of maxArmoredPublicKeyBytes once it's escaped as JSON.
+// Override with MAX_LARGE_JSON_REQUEST_BYTES.
+var maxLargeJsonRequestBytes = 1024 * 1024
+
+// maxSecretBytes is the largest secret we'll store, measured (roughly) as the plaintext size:
+// the armored encrypted secret can be up to twice this. It defaults to the limit in the
+// fluidkeys policy package. Override with MAX_SECRET_BYTES, for example for a private deployment
+// that needs to send larger secrets.
+var maxSecretBytes = policy.SecretMaxSizeBytes
+
+// maxVerifyBodyBytes is the largest body we'll accept when the email verification form is
+// posted. The form is empty, so anything bigger (for example from a link scanner) is refused.
+// Override with MAX_VERIFY_BODY_BYTES.
+var maxVerifyBodyBytes = 4 * 1024
+
+// minRSAKeyBits is the shortest RSA primary key or encryption subkey we'll accept on upload.
+// Override with MIN_RSA_KEY_BITS.
+var minRSAKeyBits = 2048
+
+// allowDSAKeys accepts uploads of keys whose primary key or encryption subkey is DSA or ElGamal.
+// They're refused by default because DSA keys are limited to 1024 bits by most implementations.
+// Enable with ALLOW_DSA_KEYS=1.
+var allowDSAKeys = false
+
+// maxRosterSignatureAge is how long after signing a team roster it can be uploaded. This stops
+// an old, captured roster and signature being replayed to roll back a team.
+// Override with MAX_ROSTER_SIGNATURE_AGE_HOURS.
+var maxRosterSignatureAge = time.Duration(24) * time.Hour
+
+// maxRosterSignatureClockSkew is how far in the future a team roster's signature can be dated,
+// to allow for the signer's clock being a little fast.
+// Override with MAX_ROSTER_SIGNATURE_CLOCK_SKEW_SECONDS.
+var maxRosterSignatureClockSkew = time.Duration(5) * time.Minute
+
+// allowedRosterSignatureHashes are the hash algorithms a team roster can be signed with. SHA-1
+// and older are refused: a collision could let a signature be reused on a different roster.
+// Override with ROSTER_SIGNATURE_HASHES, a comma-separated list like SHA256,SHA512.
+var allowedRosterSignatureHashes = []crypto.Hash{
+	crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512,
+}
+
+// httpReadTimeout is how long a client has to send the whole request, including the body. It
+// stops slow clients (for example a slowloris attack) holding connections open.
+// Override with HTTP_READ_TIMEOUT_SECONDS.
+var httpReadTimeout = time.Duration(30) * time.Second
+
+// httpWriteTimeout is how long the server has to write the response, from the end of reading
+// the request headers. Override with HTTP_WRITE_TIMEOUT_SECONDS.
+var httpWriteTimeout = time.Duration(60) * time.Second
+
+// httpIdleTimeout is how long a keep-alive connection can wait for its next request before
+// it's closed. Override with HTTP_IDLE_TIMEOUT_SECONDS.
+var httpIdleTimeout = time.Duration(120) * time.Second
+
+// docsURL is where the API documentation lives. It's linked from the response to `GET /`.
+// Override with DOCS_URL, for example for a private deployment with its own docs.
+var docsURL = "https://github.com/fluidkeys/api/blob/master/README.md"
+
+// auditSecretSends records the metadata of every secret sent (sender IP address, recipient
+// fingerprint, time and size) in the secret_audit table. The secret itself is never recorded.
+// Enable with AUDIT_SECRET_SENDS=1.
+var auditSecretSends = false
+
+// returnVerificationUrls includes the verification links in the response to a key upload, for
+// end-to-end tests against a development server. It's refused unless emails are disabled,
+// because it would let anyone verify any email address.
+// Enable with RETURN_VERIFICATION_URLS=1.
+var returnVerificationUrls = false
+
+// emailPreview serves each email template, rendered with sample data, at
+// /v1/dev/email-preview/{templateID} so emails can be designed without sending them. Like
+// returnVerificationUrls, it's refused unless emails are disabled.
+// Enable with ENABLE_EMAIL_PREVIEW=1.
+var emailPreview = false
+
+// maintenanceMode stops the API writing to the database (or serving anything at all) so
+// operators can deploy or run migrations without racing live requests. Set MAINTENANCE_MODE=1
+// to refuse writes or MAINTENANCE_MODE=all to refuse every request.
+var maintenanceMode = maintenanceOff
+
+// maintenanceRetryAfterSeconds is sent in the Retry-After header of requests refused by
+// maintenanceMode. Override with MAINTENANCE_RETRY_AFTER_SECONDS.
+var maintenanceRetryAfterSeconds = 300
+
+// maintenanceModeFromEnv returns the maintenance level set in the given environment variable.
+// It panics if the variable is set to something it doesn't understand.
+func maintenanceModeFromEnv(name string) maintenanceLevel {
+	value := os.Getenv(name)
+	level, err := parseMaintenanceLevel(value)
+	if err != nil {
+		log.Panicf("invalid %s '%s', %v", name, value, err)
+	}
+	return level
+}
+
+// hashesFromEnv returns the hash algorithms listed in the given environment variable, separated
+// by commas, or defaultValue if it isn't set. Names are matched ignoring case and hyphens, so
+// SHA256 and sha-256 are the same. It panics if a name isn't one OpenPGP signatures can use.
+func hashesFromEnv(name string, defaultValue []crypto.Hash) []crypto.Hash {
+	value, got := os.LookupEnv(name)
+	if !got {
+		return defaultValue
+	}
+
+	hashes := []crypto.Hash{}
+	for _, hashName := range strings.Split(value, ",") {
+		hash, ok := openpgpHashes[normalizeHashName(hashName)]
+		if !ok {
+			log.Panicf("invalid %s '%s', unknown hash algorithm '%s'", name, value, hashName)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// openpgpHashes are the hash algorithms that can be used in OpenPGP signatures, by normalized
+// name.
+var openpgpHashes = map[string]crypto.Hash{
+	"MD5":       crypto.MD5,
+	"SHA1":      crypto.SHA1,
+	"RIPEMD160": crypto.RIPEMD160,
+	"SHA224":    crypto.SHA224,
+	"SHA256":    crypto.SHA256,
+	"SHA384":    crypto.SHA384,
+	"SHA512":    crypto.SHA512,
+}
+
+func normalizeHashName(hashName string) string {
+	return strings.ToUpper(strings.Replace(strings.TrimSpace(hashName), "-", "", -1))
+}
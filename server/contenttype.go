@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// jsonContentTypeMiddleware rejects requests to endpoints that take a JSON body unless they're
+// sent with `Content-Type: application/json`. An endpoint takes a JSON body if its entry in
+// apiOperations has a request, so the check can't drift from the OpenAPI spec.
+func jsonContentTypeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if takesJSONBody(r) && r.Header.Get("Content-Type") != "application/json" {
+			writeJsonError(w, errExpectingJSONContentType, http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takesJSONBody returns true if the route matched by the request expects a JSON request body.
+func takesJSONBody(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+
+	pathTemplate, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+
+	apiOp := apiOperations[r.Method+" "+stripPathVariablePatterns(pathTemplate)]
+	return apiOp.request != nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestJSONContentTypeMiddleware(t *testing.T) {
+	// values for the path variables of routes that take a JSON body
+	pathVariables := map[string]string{
+		"{teamUUID}": "74bb40b4-3510-11e9-968e-53c38df634be",
+	}
+	pathVariablePattern := regexp.MustCompile(`\{[^}]+\}`)
+
+	for key, apiOp := range apiOperations {
+		if apiOp.request == nil {
+			continue
+		}
+
+		parts := strings.SplitN(key, " ", 2)
+		method, path := parts[0], parts[1]
+		path = pathVariablePattern.ReplaceAllStringFunc(path, func(variable string) string {
+			value, ok := pathVariables[variable]
+			if !ok {
+				t.Fatalf("%s: no test value for path variable %s", key, variable)
+			}
+			return value
+		})
+
+		for _, contentType := range []string{"", "text/plain", "multipart/form-data"} {
+			t.Run(key+" with Content-Type '"+contentType+"'", func(t *testing.T) {
+				req, err := http.NewRequest(method, path, strings.NewReader("{}"))
+				assert.NoError(t, err)
+				if contentType != "" {
+					req.Header.Set("Content-Type", contentType)
+				}
+
+				response := httptest.NewRecorder()
+				router.ServeHTTP(response, req)
+
+				assertStatusCode(t, http.StatusBadRequest, response.Code)
+				assertHasJSONErrorDetail(t, response.Body,
+					"expecting header Content-Type: application/json")
+			})
+		}
+	}
+
+	t.Run("doesn't apply to the email verification form", func(t *testing.T) {
+		req, err := http.NewRequest(
+			"POST", "/v1/email/verify/a8f5bd1a-4e2b-4f2b-9a7c-0c2f6d3e1b5a", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+
+		if strings.Contains(response.Body.String(), "expecting header Content-Type") {
+			t.Fatalf("email verification form was rejected for its content type")
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/fluidkeys/api/email"
+	"github.com/gorilla/mux"
+)
+
+// emailPreviewHandler renders the given email template with sample data, so its design can be
+// checked in a browser without sending it. It's only available when emailPreview is enabled.
+func emailPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if !emailPreview {
+		writeJsonError(w, fmt.Errorf("email preview is disabled"), http.StatusNotFound)
+		return
+	}
+
+	templateID := mux.Vars(r)["templateID"]
+
+	preview, err := email.RenderPreview(templateID)
+	if err == email.ErrUnknownTemplate {
+		writeJsonError(w, fmt.Errorf("unknown email template '%s'", templateID), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Email-Subject", preview.Subject)
+	if preview.HTMLBody != "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, preview.HTMLBody)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, preview.TextBody)
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestEmailPreviewHandler(t *testing.T) {
+	defer func(saved bool) { emailPreview = saved }(emailPreview)
+
+	t.Run("disabled by default", func(t *testing.T) {
+		emailPreview = false
+
+		response := callAPI(t, "GET", "/v1/dev/email-preview/verify", nil, nil)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "email preview is disabled")
+	})
+
+	t.Run("renders an HTML template", func(t *testing.T) {
+		emailPreview = true
+
+		response := callAPI(t, "GET", "/v1/dev/email-preview/verify", nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+		assert.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+		assert.Equal(t, "Verify jane@example.com on Fluidkeys",
+			response.Header().Get("X-Email-Subject"))
+
+		if !strings.Contains(response.Body.String(), "<!DOCTYPE HTML>") {
+			t.Fatalf("expected an HTML document, got %s", response.Body.String())
+		}
+	})
+
+	t.Run("renders a text template", func(t *testing.T) {
+		emailPreview = true
+
+		response := callAPI(t, "GET", "/v1/dev/email-preview/help_key_expires_3_days", nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+	})
+
+	t.Run("unknown template", func(t *testing.T) {
+		emailPreview = true
+
+		response := callAPI(t, "GET", "/v1/dev/email-preview/no_such_template", nil, nil)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "unknown email template 'no_such_template'")
+	})
+}
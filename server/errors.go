@@ -1,18 +1,115 @@
 package server
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fluidkeys/api/datastore"
+)
 
 var errAuthKeyNotFound = fmt.Errorf("invalid authorization")
 
+var errAuthPasswordIncorrect = fmt.Errorf("invalid authorization: wrong fingerprint or password")
+
+var errAuthPasswordRequired = fmt.Errorf(
+	"this call must be authorized with HTTP basic auth: the key's fingerprint and its password")
+
 var errIdenticalRequestAlreadyExists = fmt.Errorf(
 	"request to join team already exists with the same email and fingerprint")
 
 var errConflictingRequestAlreadyExists = fmt.Errorf(
 	"request to join team already exists for that email with a different fingerprint")
 
+var errExpectingJSONContentType = fmt.Errorf("expecting header Content-Type: application/json")
+
+var errEmailNotOnKey = fmt.Errorf("email address isn't on the authorized key")
+
+var errEmailAlreadyVerified = fmt.Errorf("email address is already verified")
+
+// errVerificationAlreadyActive means a verification email was sent recently: another can't be
+// sent until it expires, to stop someone mailbombing the address
+var errVerificationAlreadyActive = fmt.Errorf(
+	"a verification email was sent recently: wait for it to expire before requesting another")
+
 var errSignedByWrongKey = fmt.Errorf("signed by wrong key")
 
 // errBadSignature means the signed data may have been tampered with
 var errBadSignature = fmt.Errorf("bad signature")
 
 var errNotAnAdminInExistingTeam = fmt.Errorf("signing key is not an admin of the team")
+
+var errRosterSignatureTooOld = fmt.Errorf("roster signature is too old")
+
+var errRosterSignatureInFuture = fmt.Errorf(
+	"roster signature is dated in the future: check your clock")
+
+// errRosterSignatureHashNotAllowed means the roster was signed using a weak hash algorithm
+var errRosterSignatureHashNotAllowed = fmt.Errorf(
+	"roster signature uses a hash algorithm that isn't allowed")
+
+// errRosterVersionConflict means the team was updated by someone else since the client last
+// fetched it, so applying the upload would overwrite their changes
+var errRosterVersionConflict = fmt.Errorf(
+	"team has been updated since the version given in If-Match")
+
+// errNotEnoughAdminSignatures means the team requires more of its admins to sign a roster update
+// than have signed this one
+var errNotEnoughAdminSignatures = fmt.Errorf("not enough team admins have signed the roster")
+
+// errTooFewAdminsForSignaturePolicy means the roster doesn't have enough admins to ever meet the
+// team's required number of admin signatures, which would lock the team
+var errTooFewAdminsForSignaturePolicy = fmt.Errorf(
+	"roster has fewer admins than the number of admin signatures required")
+
+var errRosterSignatureOlderThanExisting = fmt.Errorf(
+	"roster signature is older than the signature on the existing roster")
+
+// errStoredRosterSignatureInvalid means the roster in the database may have been corrupted or
+// tampered with
+var errStoredRosterSignatureInvalid = fmt.Errorf(
+	"stored roster signature isn't valid for any of the team's admins")
+
+var errSecretReceiptNotFound = fmt.Errorf("no secret matching that UUID and sender token")
+
+// errKeyRevoked means the uploaded key has been revoked by its owner, so the server won't
+// distribute it
+var errKeyRevoked = fmt.Errorf(
+	"key has been revoked: upload a key that hasn't been revoked")
+
+// errKeyBanned means an operator has banned the key (see the ban_key command), so the server
+// won't store or distribute it
+var errKeyBanned = fmt.Errorf("key has been banned from this server")
+
+// errReplayDetected means the signed data's single use UUID has been seen before: either a
+// replay attempt or a client retrying a request that already succeeded
+var errReplayDetected = codedError{
+	code: "REPLAY_DETECTED",
+	err:  fmt.Errorf("bad SingleUseUUID: %v", datastore.ErrSingleUseUUIDAlreadyUsed),
+}
+
+// codedError is an error with a machine-readable code, which writeJsonError puts in the
+// response's `code` so clients can tell it apart without matching on the detail.
+type codedError struct {
+	code string
+	err  error
+}
+
+func (c codedError) Error() string {
+	return c.err.Error()
+}
+
+// multiError reports several problems at once, for example everything wrong with a roster.
+// writeJsonError lists them individually in the response's `errors`.
+type multiError []error
+
+func (m multiError) Error() string {
+	return strings.Join(m.strings(), "; ")
+}
+
+func (m multiError) strings() []string {
+	messages := []string{}
+	for _, err := range m {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
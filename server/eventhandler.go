@@ -10,7 +10,7 @@ import (
 func createEventHandler(w http.ResponseWriter, r *http.Request) {
 	requestData := v1structs.CreateEventRequest{}
 	if err := decodeJsonRequest(r, &requestData); err != nil {
-		writeJsonError(w, err, http.StatusBadRequest)
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
 		return
 	}
 
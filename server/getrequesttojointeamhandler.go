@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/team"
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+)
+
+// getRequestToJoinTeamHandler returns the team a request to join belongs to, for a client that
+// only has the request's UUID. Only the key that made the request can see it.
+func getRequestToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
+	requesterKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	requestUUID, err := uuid.FromString(mux.Vars(r)["requestUUID"])
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("error parsing request UUID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	request, err := datastore.GetRequestToJoinTeamByUUID(nil, requestUUID)
+	if err == datastore.ErrNotFound {
+		writeJsonError(w, fmt.Errorf("no request matching that UUID"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if request.Fingerprint != requesterKey.Fingerprint() {
+		writeJsonError(w,
+			fmt.Errorf("can't view a request to join made by a different key"),
+			http.StatusForbidden)
+		return
+	}
+
+	dbTeam, err := datastore.GetTeam(nil, request.TeamUUID)
+	if err == datastore.ErrNotFound {
+		writeJsonError(w, fmt.Errorf("team not found"), http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	t, err := team.Load(dbTeam.Roster, dbTeam.RosterSignature)
+	if err != nil {
+		writeJsonError(w,
+			fmt.Errorf("error loading team from db: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	status := v1structs.RequestToJoinTeamPending
+	if t.Contains(request.Fingerprint) {
+		status = v1structs.RequestToJoinTeamAccepted
+	}
+
+	writeJsonResponse(w, v1structs.GetRequestToJoinTeamResponse{
+		TeamUUID: request.TeamUUID.String(),
+		TeamName: t.Name,
+		Email:    request.Email,
+		Status:   status,
+	})
+}
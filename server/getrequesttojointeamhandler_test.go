@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	"github.com/gofrs/uuid"
+)
+
+func TestGetRequestToJoinTeamHandler(t *testing.T) {
+	now := time.Date(2019, 7, 16, 10, 0, 0, 0, time.UTC)
+
+	pendingTeam := datastore.Team{
+		UUID: uuid.Must(uuid.FromString("e9f7c7a0-a7b4-11e9-9a0f-3b3a2b2d7e51")),
+		Roster: `uuid = "e9f7c7a0-a7b4-11e9-9a0f-3b3a2b2d7e51"
+		name = "Pending Team"
+
+		[[person]]
+			email = "test4@example.com"
+			fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+			is_admin = true`,
+		CreatedAt: now,
+	}
+
+	acceptedTeam := datastore.Team{
+		UUID: uuid.Must(uuid.FromString("f3c2e5b6-a7b4-11e9-8c3d-5f2e1b9c4a77")),
+		Roster: `uuid = "f3c2e5b6-a7b4-11e9-8c3d-5f2e1b9c4a77"
+		name = "Accepted Team"
+
+		[[person]]
+			email = "test4@example.com"
+			fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+			is_admin = true
+
+		[[person]]
+			email = "test3@example.com"
+			fingerprint = "7C18 DE4D E478 1356 8B24  3AC8 719B D63E F03B DC20"
+			is_admin = false`,
+		CreatedAt: now,
+	}
+
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	assert.NoError(t, datastore.UpsertTeam(nil, pendingTeam))
+	assert.NoError(t, datastore.UpsertTeam(nil, acceptedTeam))
+
+	defer func() {
+		_, err := datastore.DeleteTeam(nil, pendingTeam.UUID)
+		assert.NoError(t, err)
+		_, err = datastore.DeleteTeam(nil, acceptedTeam.UUID)
+		assert.NoError(t, err)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+	}()
+
+	pendingRequestUUID, err := datastore.CreateRequestToJoinTeam(
+		nil, pendingTeam.UUID, "test3@example.com", exampledata.ExampleFingerprint3, now)
+	assert.NoError(t, err)
+
+	acceptedRequestUUID, err := datastore.CreateRequestToJoinTeam(
+		nil, acceptedTeam.UUID, "test3@example.com", exampledata.ExampleFingerprint3, now)
+	assert.NoError(t, err)
+
+	t.Run("requesting key gets the team", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/requests-to-join/"+pendingRequestUUID.String(),
+			nil, &exampledata.ExampleFingerprint3)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetRequestToJoinTeamResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		assert.Equal(t, v1structs.GetRequestToJoinTeamResponse{
+			TeamUUID: pendingTeam.UUID.String(),
+			TeamName: "Pending Team",
+			Email:    "test3@example.com",
+			Status:   v1structs.RequestToJoinTeamPending,
+		}, responseData)
+	})
+
+	t.Run("request is accepted once the key is in the roster", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/requests-to-join/"+acceptedRequestUUID.String(),
+			nil, &exampledata.ExampleFingerprint3)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetRequestToJoinTeamResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, v1structs.RequestToJoinTeamAccepted, responseData.Status)
+	})
+
+	t.Run("a different key is forbidden", func(t *testing.T) {
+		// even a team admin
+		response := callAPI(t, "GET", "/v1/requests-to-join/"+pendingRequestUUID.String(),
+			nil, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"can't view a request to join made by a different key")
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/requests-to-join/"+pendingRequestUUID.String(),
+			nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("no such request", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/requests-to-join/"+uuid.Must(uuid.NewV4()).String(),
+			nil, &exampledata.ExampleFingerprint3)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "no request matching that UUID")
+	})
+
+	t.Run("invalid request UUID", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/requests-to-join/not-a-uuid",
+			nil, &exampledata.ExampleFingerprint3)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+	})
+}
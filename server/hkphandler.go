@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+)
+
+// hkpLookupHandler implements the read-only parts of the HKP keyserver protocol so that tools
+// like `gpg --keyserver` can fetch keys. Searches can be for a verified email address or a full
+// fingerprint prefixed with `0x`. Uploads (`op=add`) aren't supported: keys must go through the
+// verified upsert flow.
+// See https://tools.ietf.org/html/draft-shaw-openpgp-hkp-00
+func hkpLookupHandler(w http.ResponseWriter, r *http.Request) {
+	op := r.URL.Query().Get("op")
+	if op != "get" && op != "index" {
+		writeJsonError(w, fmt.Errorf("unsupported op '%s'", op), http.StatusNotImplemented)
+		return
+	}
+
+	armoredPublicKey, found, err := hkpSearch(r.URL.Query().Get("search"))
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	} else if !found {
+		writeJsonError(w, fmt.Errorf("no matching public key"), http.StatusNotFound)
+		return
+	}
+
+	publicKey, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+	if err != nil {
+		writeJsonError(w,
+			fmt.Errorf("error loading stored public key: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	if !checkKeyNotBanned(w, publicKey) {
+		return
+	}
+
+	switch op {
+	case "get":
+		w.Header().Set("Content-Type", "application/pgp-keys")
+		io.WriteString(w, armoredPublicKey)
+
+	case "index":
+		index, err := hkpMachineReadableIndex(publicKey)
+		if err != nil {
+			writeJsonError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, index)
+	}
+}
+
+// hkpSearch returns the armored public key matching an HKP search string, which is either a
+// fingerprint like `0xBB3C44BF...` or an email address, optionally in angle brackets.
+func hkpSearch(search string) (armoredPublicKey string, found bool, err error) {
+	search = strings.TrimSpace(search)
+
+	switch {
+	case search == "":
+		return "", false, fmt.Errorf("missing search")
+
+	case strings.HasPrefix(search, "0x") || strings.HasPrefix(search, "0X"):
+		hex := search[2:]
+		if len(hex) != 40 {
+			return "", false, fmt.Errorf("only full fingerprints are supported, not key IDs")
+		}
+
+		fpr, err := fingerprint.Parse(hex)
+		if err != nil {
+			return "", false, err
+		}
+		return datastore.GetArmoredPublicKeyForFingerprint(fpr)
+
+	default:
+		email := strings.TrimSuffix(strings.TrimPrefix(search, "<"), ">")
+		if !strings.Contains(email, "@") {
+			return "", false, fmt.Errorf("search must be an email address or a fingerprint")
+		}
+		return datastore.GetArmoredPublicKeyForEmail(nil, email)
+	}
+}
+
+// hkpMachineReadableIndex returns the `options=mr` index for a single key, for example:
+//
+// info:1:1
+// pub:BB3C44BF188D56E635F4A092F73D2F0533D7F9D6:1:1024:1543864399::
+// uid:test4@example.com:1543864399::
+func hkpMachineReadableIndex(key *pgpkey.PgpKey) (string, error) {
+	bitLength, err := key.PrimaryKey.BitLength()
+	if err != nil {
+		return "", fmt.Errorf("error getting key length: %v", err)
+	}
+
+	names := []string{}
+	for name := range key.Identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var expiry *time.Time
+	uidLines := []string{}
+
+	for _, name := range names {
+		selfSig := key.Identities[name].SelfSignature
+		if selfSig == nil {
+			continue
+		}
+
+		// the key's expiry is set on each identity's self signature: use the latest
+		if _, keyExpiry := pgpkey.CalculateExpiry(
+			key.PrimaryKey.CreationTime, selfSig.KeyLifetimeSecs); keyExpiry != nil {
+
+			if expiry == nil || keyExpiry.After(*expiry) {
+				expiry = keyExpiry
+			}
+		}
+
+		_, uidExpiry := pgpkey.CalculateExpiry(selfSig.CreationTime, selfSig.SigLifetimeSecs)
+
+		uidLines = append(uidLines, fmt.Sprintf("uid:%s:%d:%s:",
+			hkpEscape(name), selfSig.CreationTime.Unix(), hkpTimestamp(uidExpiry)))
+	}
+
+	flags := ""
+	if len(key.Revocations) > 0 {
+		flags += "r"
+	}
+	if expiry != nil && expiry.Before(time.Now()) {
+		flags += "e"
+	}
+
+	lines := []string{
+		"info:1:1",
+		fmt.Sprintf("pub:%s:%d:%d:%d:%s:%s",
+			key.Fingerprint().Hex(),
+			key.PrimaryKey.PubKeyAlgo,
+			bitLength,
+			key.PrimaryKey.CreationTime.Unix(),
+			hkpTimestamp(expiry),
+			flags,
+		),
+	}
+	lines = append(lines, uidLines...)
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func hkpTimestamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", t.Unix())
+}
+
+// hkpEscape percent-escapes `:`, `%` and any bytes outside printable ASCII, as required for
+// fields in the machine-readable index.
+func hkpEscape(s string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		if b == ':' || b == '%' || b < 0x20 || b > 0x7e {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		} else {
+			escaped.WriteByte(b)
+		}
+	}
+	return escaped.String()
+}
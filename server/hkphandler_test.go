@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestHKPLookupHandler(t *testing.T) {
+	setup := func() {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		assert.NoError(t, datastore.LinkEmailToFingerprint(
+			nil, "test4@example.com", exampledata.ExampleFingerprint4, nil))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	// from gpg --with-colons --list-keys test4@example.com
+	expectedIndex := "info:1:1\n" +
+		"pub:BB3C44BF188D56E635F4A092F73D2F0533D7F9D6:1:1024:1543864399::\n" +
+		"uid:test4@example.com:1543864399::\n"
+
+	t.Run("op=get", func(t *testing.T) {
+		t.Run("by email", func(t *testing.T) {
+			response := callAPI(t, "GET", "/pks/lookup?op=get&search=test4@example.com", nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assert.Equal(t, "application/pgp-keys", response.Header().Get("content-type"))
+			assertBodyEqualTo(t, response.Body, exampledata.ExamplePublicKey4)
+		})
+
+		t.Run("by email in angle brackets", func(t *testing.T) {
+			response := callAPI(t, "GET",
+				"/pks/lookup?op=get&search=%3Ctest4%40example.com%3E", nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assertBodyEqualTo(t, response.Body, exampledata.ExamplePublicKey4)
+		})
+
+		t.Run("by fingerprint", func(t *testing.T) {
+			response := callAPI(t, "GET",
+				"/pks/lookup?op=get&options=mr&search=0x"+exampledata.ExampleFingerprint4.Hex(),
+				nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assertBodyEqualTo(t, response.Body, exampledata.ExamplePublicKey4)
+		})
+
+		t.Run("with no matching email", func(t *testing.T) {
+			response := callAPI(t, "GET", "/pks/lookup?op=get&search=missing@example.com", nil, nil)
+			assertStatusCode(t, http.StatusNotFound, response.Code)
+			assertHasJSONErrorDetail(t, response.Body, "no matching public key")
+		})
+
+		t.Run("with a key ID", func(t *testing.T) {
+			response := callAPI(t, "GET", "/pks/lookup?op=get&search=0xF73D2F0533D7F9D6", nil, nil)
+			assertStatusCode(t, http.StatusBadRequest, response.Code)
+			assertHasJSONErrorDetail(t, response.Body,
+				"only full fingerprints are supported, not key IDs")
+		})
+
+		t.Run("with missing search", func(t *testing.T) {
+			response := callAPI(t, "GET", "/pks/lookup?op=get", nil, nil)
+			assertStatusCode(t, http.StatusBadRequest, response.Code)
+			assertHasJSONErrorDetail(t, response.Body, "missing search")
+		})
+	})
+
+	t.Run("op=index", func(t *testing.T) {
+		t.Run("by email", func(t *testing.T) {
+			response := callAPI(t, "GET",
+				"/pks/lookup?op=index&options=mr&search=test4@example.com", nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assertBodyEqualTo(t, response.Body, expectedIndex)
+		})
+
+		t.Run("by fingerprint", func(t *testing.T) {
+			response := callAPI(t, "GET",
+				"/pks/lookup?op=index&options=mr&search=0x"+exampledata.ExampleFingerprint4.Hex(),
+				nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assertBodyEqualTo(t, response.Body, expectedIndex)
+		})
+	})
+
+	t.Run("op=add isn't supported", func(t *testing.T) {
+		response := callAPI(t, "GET", "/pks/lookup?op=add", nil, nil)
+		assertStatusCode(t, http.StatusNotImplemented, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "unsupported op 'add'")
+	})
+}
+
+func TestHKPEscape(t *testing.T) {
+	assert.Equal(t, "Tina <tina@example.com>", hkpEscape("Tina <tina@example.com>"))
+	assert.Equal(t, "a%3Ab%25c", hkpEscape("a:b%c"))
+	assert.Equal(t, "Ren%C3%A9e", hkpEscape("Renée"))
+}
@@ -4,11 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+	"io"
 	"log"
 	"net/http"
 )
 
 func writeJsonResponse(w http.ResponseWriter, responseData interface{}) {
+	writeJsonResponseWithStatus(w, responseData, http.StatusOK)
+}
+
+func writeJsonResponseWithStatus(w http.ResponseWriter, responseData interface{}, statusCode int) {
 	out, err := json.MarshalIndent(responseData, "", "    ")
 
 	if err != nil {
@@ -16,38 +22,112 @@ func writeJsonResponse(w http.ResponseWriter, responseData interface{}) {
 		return
 	}
 
-	w.Header().Set("content-type", "application/json")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 	w.Write(out)
 }
 
-func writeJsonError(w http.ResponseWriter, err error, statusCode int) {
-	log.Print(err)
-	responseData := v1structs.ErrorResponse{Detail: err.Error()}
+// writeEncryptedJsonResponse writes out responseData as JSON encrypted to key, in a
+// v1structs.EncryptedJSONResponse, so that only the key's owner can read it.
+func writeEncryptedJsonResponse(
+	w http.ResponseWriter, responseData interface{}, key *pgpkey.PgpKey) {
 
-	out, err := json.MarshalIndent(responseData, "", "    ")
+	plaintextJSON, err := json.Marshal(responseData)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeJsonError(w, fmt.Errorf("failed to encode JSON: %v", err),
+			http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	w.Write(out)
+	encryptedJSON, err := encryptStringToArmor(string(plaintextJSON), key)
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("failed to encrypt to key: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	writeJsonResponse(w, v1structs.EncryptedJSONResponse{EncryptedJSON: encryptedJSON})
 }
 
-func decodeJsonRequest(r *http.Request, requestData interface{}) error {
-	if r.Header.Get("Content-Type") != "application/json" {
-		return fmt.Errorf("expecting header Content-Type: application/json")
+// writeJsonError logs the error and writes it out as a v1structs.ErrorResponse.
+func writeJsonError(w http.ResponseWriter, err error, statusCode int) {
+	log.Print(err)
+	responseData := v1structs.ErrorResponse{Detail: err.Error()}
+	if errs, ok := err.(multiError); ok {
+		responseData.Errors = errs.strings()
+	}
+	if coded, ok := err.(codedError); ok {
+		responseData.Code = coded.code
 	}
 
+	writeJsonResponseWithStatus(w, responseData, statusCode)
+}
+
+// decodeJsonRequest decodes the JSON request body into requestData. Bodies larger than
+// maxJsonRequestBytes are rejected with errRequestBodyTooLarge: use decodeJsonErrorStatus to
+// get the right status code for the error.
+func decodeJsonRequest(r *http.Request, requestData interface{}) error {
+	return decodeJsonRequestWithLimit(r, requestData, maxJsonRequestBytes)
+}
+
+// decodeLargeJsonRequest is like decodeJsonRequest, but allows bodies up to
+// maxLargeJsonRequestBytes for endpoints that carry keys or team rosters.
+func decodeLargeJsonRequest(r *http.Request, requestData interface{}) error {
+	return decodeJsonRequestWithLimit(r, requestData, maxLargeJsonRequestBytes)
+}
+
+// decodeJsonRequestWithLimit is like decodeJsonRequest with a custom size limit. The request's
+// Content-Type has already been checked by jsonContentTypeMiddleware.
+func decodeJsonRequestWithLimit(r *http.Request, requestData interface{}, maxBytes int) error {
 	if r.Body == nil {
 		return fmt.Errorf("empty request body")
 	}
 
-	decoder := json.NewDecoder(r.Body)
+	decoder := json.NewDecoder(&maxBytesReader{reader: r.Body, remaining: int64(maxBytes)})
 	err := decoder.Decode(&requestData)
-	if err != nil {
+	if err == errRequestBodyTooLarge {
+		return err
+	} else if err != nil {
 		return fmt.Errorf("invalid JSON: %v", err)
 	}
 	return nil
 }
+
+// decodeJsonErrorStatus returns the HTTP status code to respond with for an error from
+// decodeJsonRequest.
+func decodeJsonErrorStatus(err error) int {
+	if err == errRequestBodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+var errRequestBodyTooLarge = fmt.Errorf("request body too large")
+
+// maxBytesReader reads from reader, returning errRequestBodyTooLarge if there's more than
+// `remaining` bytes. Unlike http.MaxBytesReader, its error can be told apart from other read
+// errors.
+type maxBytesReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, errRequestBodyTooLarge
+	}
+
+	// read up to one byte more than allowed, to find out if the body is too large
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+
+	n, err := m.reader.Read(p)
+	if int64(n) > m.remaining {
+		n = int(m.remaining)
+		m.remaining = -1
+		return n, errRequestBodyTooLarge
+	}
+	m.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestWriteJsonError(t *testing.T) {
+	t.Run("single error", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		writeJsonError(recorder, fmt.Errorf("team not found"), http.StatusNotFound)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `{
+    "detail": "team not found"
+}`, recorder.Body.String())
+	})
+
+	t.Run("multiError lists each problem", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		writeJsonError(recorder,
+			multiError{fmt.Errorf("problem 1"), fmt.Errorf("problem 2")},
+			http.StatusBadRequest)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.Equal(t, `{
+    "detail": "problem 1; problem 2",
+    "errors": [
+        "problem 1",
+        "problem 2"
+    ]
+}`, recorder.Body.String())
+	})
+
+	t.Run("codedError includes its code", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		writeJsonError(recorder, errReplayDetected, http.StatusConflict)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, `{
+    "detail": "bad SingleUseUUID: single use UUID already used",
+    "code": "REPLAY_DETECTED"
+}`, recorder.Body.String())
+	})
+}
+
+func TestMaxBytesReader(t *testing.T) {
+	t.Run("body within the limit is read in full", func(t *testing.T) {
+		reader := &maxBytesReader{reader: strings.NewReader("12345"), remaining: 5}
+		got, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, "12345", string(got))
+	})
+
+	t.Run("body over the limit returns errRequestBodyTooLarge", func(t *testing.T) {
+		reader := &maxBytesReader{reader: strings.NewReader("123456"), remaining: 5}
+		got, err := ioutil.ReadAll(reader)
+		assert.Equal(t, errRequestBodyTooLarge, err)
+		assert.Equal(t, "12345", string(got))
+	})
+}
+
+func TestRequestBodySizeLimits(t *testing.T) {
+	defer func(small, large int) {
+		maxJsonRequestBytes, maxLargeJsonRequestBytes = small, large
+	}(maxJsonRequestBytes, maxLargeJsonRequestBytes)
+	maxJsonRequestBytes = 1024
+	maxLargeJsonRequestBytes = 4096
+
+	t.Run("oversized body is rejected with 413", func(t *testing.T) {
+		requestData := v1structs.SendSecretRequest{
+			RecipientFingerprint:   exampledata.ExampleFingerprint4.Uri(),
+			ArmoredEncryptedSecret: strings.Repeat("A", 2048),
+		}
+
+		response := callAPI(t, "POST", "/v1/secrets", requestData, nil)
+		assertStatusCode(t, http.StatusRequestEntityTooLarge, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "request body too large")
+	})
+
+	t.Run("key upload allows a larger body", func(t *testing.T) {
+		requestData := v1structs.UpsertPublicKeyRequest{
+			ArmoredPublicKey: strings.Repeat("A", 2048),
+		}
+
+		response := callAPI(t, "POST", "/v1/keys", requestData, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code) // not a valid key
+	})
+
+	t.Run("key upload rejects a body over the larger limit", func(t *testing.T) {
+		requestData := v1structs.UpsertPublicKeyRequest{
+			ArmoredPublicKey: strings.Repeat("A", 8192),
+		}
+
+		response := callAPI(t, "POST", "/v1/keys", requestData, nil)
+		assertStatusCode(t, http.StatusRequestEntityTooLarge, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "request body too large")
+	})
+
+	t.Run("team roster upload rejects a body over the larger limit", func(t *testing.T) {
+		requestData := v1structs.UpsertTeamRequest{
+			TeamRoster: strings.Repeat("A", 8192),
+		}
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, nil)
+		assertStatusCode(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+}
+
+func TestMaxSendSecretRequestBytes(t *testing.T) {
+	defer func(secret, json int) {
+		maxSecretBytes, maxJsonRequestBytes = secret, json
+	}(maxSecretBytes, maxJsonRequestBytes)
+	maxJsonRequestBytes = 64 * 1024
+
+	t.Run("default secret size fits in the normal JSON limit", func(t *testing.T) {
+		maxSecretBytes = 10 * 1024
+		assert.Equal(t, 64*1024, maxSendSecretRequestBytes())
+	})
+
+	t.Run("raised secret size raises the limit", func(t *testing.T) {
+		maxSecretBytes = 100 * 1024
+		assert.Equal(t, 300*1024, maxSendSecretRequestBytes())
+	})
+}
@@ -7,9 +7,13 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,72 +27,254 @@ import (
 )
 
 func getASCIIArmoredPublicKeyByEmailHandler(w http.ResponseWriter, r *http.Request) {
-	if armoredPublicKey, ok := getKeyByEmail(w, r); ok {
-		fmt.Fprintf(w, armoredPublicKey)
+	if key, ok := getKeyByEmail(w, r); ok {
+		filename := sanitizeFilename(strings.ToLower(mux.Vars(r)["email"])) + ".asc"
+		writeArmoredPublicKey(w, key, "application/pgp-keys", filename)
 	}
 }
 
 func getPublicKeyByEmailHandler(w http.ResponseWriter, r *http.Request) {
-	if armoredPublicKey, ok := getKeyByEmail(w, r); ok {
-		responseData := v1structs.GetPublicKeyResponse{
-			ArmoredPublicKey: armoredPublicKey,
-		}
-		writeJsonResponse(w, responseData)
+	if key, ok := getKeyByEmail(w, r); ok {
+		filename := sanitizeFilename(strings.ToLower(mux.Vars(r)["email"])) + ".asc"
+		writeNegotiatedPublicKey(w, r, key, filename)
 	}
 }
 
 func getASCIIArmoredPublicKeyByFingerprintHandler(w http.ResponseWriter, r *http.Request) {
-	if armoredPublicKey, ok := getKeyByFingerprint(w, r); ok {
-		fmt.Fprintf(w, armoredPublicKey)
+	if key, ok := getKeyByFingerprint(w, r); ok && !writeNotModifiedSince(w, r, key) {
+		filename := fingerprintFilename(r)
+		writeArmoredPublicKey(w, key, "application/pgp-keys", filename)
+	}
+}
+
+// fingerprintFilename returns the filename for downloading the key with the fingerprint in the
+// request path, e.g. BB3C44BF188D56E635F4A092F73D2F0533D7F9D6.asc, without any spaces the
+// fingerprint was given with.
+func fingerprintFilename(r *http.Request) string {
+	return strings.ToUpper(strings.Replace(mux.Vars(r)["fingerprint"], " ", "", -1)) + ".asc"
+}
+
+// writeNotModifiedSince handles the optional `since` query parameter, the hex SHA256 of the
+// armored key the client already has. If it matches the stored key, it writes 304 Not Modified so
+// the client doesn't download the key again. It returns true if it's written a response: either
+// that, or an error for a malformed `since`.
+func writeNotModifiedSince(w http.ResponseWriter, r *http.Request, key *lookedUpKey) bool {
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		return false
+	}
+
+	givenSHA256, err := hex.DecodeString(since)
+	if err != nil || len(givenSHA256) != sha256.Size {
+		writeJsonError(w,
+			fmt.Errorf("invalid `since`: should be the hex SHA256 of the armored public key"),
+			http.StatusBadRequest)
+		return true
+	}
+
+	storedSHA256 := sha256.Sum256([]byte(key.armoredPublicKey))
+	if !hashesEqual(givenSHA256, storedSHA256[:]) {
+		return false // the key has changed: send the whole thing
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// writeArmoredPublicKey writes out the armored public key with the given content type, as a
+// downloadable file with the given filename, or inline if filename is empty. If the key has been reported as compromised, the time it was reported is sent in
+// the X-Key-Compromised-At header. If it's been revoked, the time of the revocation is sent in
+// the X-Key-Revoked-At header. If it was looked up by a verified email, the time of the
+// verification is sent in the X-Key-Verified-At header.
+func writeArmoredPublicKey(
+	w http.ResponseWriter, key *lookedUpKey, contentType string, filename string) {
+
+	if key.compromisedAt != nil {
+		w.Header().Set("X-Key-Compromised-At", key.compromisedAt.UTC().Format(time.RFC3339))
 	}
+	if key.revokedAt != nil {
+		w.Header().Set("X-Key-Revoked-At", key.revokedAt.UTC().Format(time.RFC3339))
+	}
+	if key.verifiedAt != nil {
+		w.Header().Set("X-Key-Verified-At", key.verifiedAt.UTC().Format(time.RFC3339))
+	}
+	w.Header().Set("Content-Type", contentType)
+	if filename != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	}
+	io.WriteString(w, key.armoredPublicKey)
 }
 
+// sanitizeFilename replaces any characters that aren't safe in a filename (or in a quoted
+// Content-Disposition header) with underscores.
+func sanitizeFilename(name string) string {
+	return unsafeFilenameCharacters.ReplaceAllString(name, "_")
+}
+
+var unsafeFilenameCharacters = regexp.MustCompile(`[^a-zA-Z0-9@+._-]`)
+
 func getPublicKeyByFingerprintHandler(w http.ResponseWriter, r *http.Request) {
-	if armoredPublicKey, ok := getKeyByFingerprint(w, r); ok {
-		responseData := v1structs.GetPublicKeyResponse{
-			ArmoredPublicKey: armoredPublicKey,
+	if key, ok := getKeyByFingerprint(w, r); ok && !writeNotModifiedSince(w, r, key) {
+		filename := fingerprintFilename(r)
+		writeNegotiatedPublicKey(w, r, key, filename)
+	}
+}
+
+// getPrimaryEmailHandler returns the email address from the key's primary user ID, whether or
+// not it's been verified, and whether it has been.
+func getPrimaryEmailHandler(w http.ResponseWriter, r *http.Request) {
+	lookedUp, ok := getKeyByFingerprint(w, r)
+	if !ok {
+		return
+	}
+
+	key, err := pgpkey.LoadFromArmoredPublicKey(lookedUp.armoredPublicKey)
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("error loading key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	primaryEmail, err := key.Email()
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("key has no email address"), http.StatusNotFound)
+		return
+	}
+
+	verified, err := datastore.QueryEmailVerifiedForFingerprint(
+		nil, primaryEmail, key.Fingerprint())
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJsonResponse(w, v1structs.GetPrimaryEmailResponse{
+		Email:    primaryEmail,
+		Verified: verified,
+	})
+}
+
+// writeNegotiatedPublicKey writes out the key as JSON, unless the request's Accept header
+// prefers application/pgp-keys or text/plain, in which case it writes the armored key.
+func writeNegotiatedPublicKey(
+	w http.ResponseWriter, r *http.Request, key *lookedUpKey, filename string) {
+
+	w.Header().Set("Vary", "Accept")
+
+	switch preferredKeyContentType(r.Header.Get("Accept")) {
+	case "application/pgp-keys":
+		writeArmoredPublicKey(w, key, "application/pgp-keys", filename)
+
+	case "text/plain":
+		writeArmoredPublicKey(w, key, "text/plain; charset=utf-8", "")
+
+	default:
+		writeJsonResponse(w, key.response())
+	}
+}
+
+// preferredKeyContentType returns whichever of application/json, application/pgp-keys and
+// text/plain the given Accept header prefers, going by q-values and then by order. It returns
+// application/json if the header is empty or accepts none of them by name, e.g. `*/*`.
+func preferredKeyContentType(accept string) string {
+	best, bestQ := "application/json", 0.0
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "application/json", "application/pgp-keys", "text/plain":
+		default:
+			continue
+		}
+
+		q := 1.0
+		if value, got := params["q"]; got {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = mediaType, q
 		}
-		writeJsonResponse(w, responseData)
 	}
+	return best
+}
+
+// lookedUpKey is a stored public key along with anything that means it shouldn't be trusted.
+type lookedUpKey struct {
+	armoredPublicKey string
+
+	// compromisedAt is when the key was reported as compromised, or nil if it hasn't been
+	compromisedAt *time.Time
+
+	// revokedAt is when the key was revoked, or nil if it hasn't been
+	revokedAt *time.Time
+
+	// verifiedAt is when the email the key was looked up by was verified for it, or nil if the
+	// key wasn't looked up by email or the verification isn't known
+	verifiedAt *time.Time
 }
 
-// getKeyByEmail finds and returns an armored key for the given request, or if there's an
-// error, writes out an error response to w.
-// Returns armored key, success
-func getKeyByEmail(w http.ResponseWriter, r *http.Request) (string, bool) {
+func (k *lookedUpKey) response() v1structs.GetPublicKeyResponse {
+	return v1structs.GetPublicKeyResponse{
+		ArmoredPublicKey: k.armoredPublicKey,
+		Compromised:      k.compromisedAt != nil,
+		Revoked:          k.revokedAt != nil,
+		VerifiedAt:       k.verifiedAt,
+	}
+}
+
+// getKeyByEmail finds and returns the key for the given request, or if there's an error,
+// writes out an error response to w.
+func getKeyByEmail(w http.ResponseWriter, r *http.Request) (*lookedUpKey, bool) {
 	email := mux.Vars(r)["email"]
 
-	armoredPublicKey, found, err := datastore.GetArmoredPublicKeyForEmail(nil, email)
+	armoredPublicKey, verifiedAt, found, err := datastore.GetArmoredPublicKeyAndVerifiedAtForEmail(
+		nil, email)
 	if err != nil {
 		writeJsonError(w, err, http.StatusInternalServerError)
-		return "", false
+		return nil, false
 	} else if !found {
 		writeJsonError(
 			w,
 			fmt.Errorf("couldn't find a public key for email address '%s'", email),
 			http.StatusNotFound,
 		)
-		return "", false
+		return nil, false
+	}
+
+	key, ok := loadLookedUpKey(w, armoredPublicKey)
+	if ok {
+		key.verifiedAt = verifiedAt
 	}
-	return armoredPublicKey, true
+	return key, ok
 }
 
-// getKeyByFingerprint finds and returns an armored key for the given request, or if there's an
-// error, writes out an error response to w.
-// Returns armored key, success
-func getKeyByFingerprint(w http.ResponseWriter, r *http.Request) (string, bool) {
+// getKeyByFingerprint finds and returns the key for the given request, or if there's an error,
+// writes out an error response to w.
+func getKeyByFingerprint(w http.ResponseWriter, r *http.Request) (*lookedUpKey, bool) {
 	fingerprint, err := fingerprint.Parse(mux.Vars(r)["fingerprint"])
 
 	if err != nil {
 		writeJsonError(w, err, http.StatusBadRequest)
-		return "", false
+		return nil, false
 	}
 
 	armoredPublicKey, found, err := datastore.GetArmoredPublicKeyForFingerprint(fingerprint)
 	if err != nil {
 		writeJsonError(w, err, http.StatusInternalServerError)
-		return "", false
-	} else if !found {
+		return nil, false
+	} else if found {
+		return loadLookedUpKey(w, armoredPublicKey)
+	}
+
+	// tell clients that had the key that it's gone, so they stop asking for it
+	deletedKey, err := datastore.GetDeletedKey(nil, fingerprint)
+	if err == datastore.ErrNotFound {
 		writeJsonError(
 			w,
 			fmt.Errorf("fingerprint looked valid, but no public key found for '%s'",
@@ -96,9 +282,71 @@ func getKeyByFingerprint(w http.ResponseWriter, r *http.Request) (string, bool)
 			),
 			http.StatusNotFound,
 		)
-		return "", false
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+	} else {
+		writeJsonError(
+			w,
+			fmt.Errorf("public key '%s' was deleted on %s: %s",
+				fingerprint, deletedKey.DeletedAt.Format("2006-01-02"), deletedKey.Reason,
+			),
+			http.StatusGone,
+		)
 	}
-	return armoredPublicKey, true
+	return nil, false
+}
+
+// loadLookedUpKey works out whether the stored key has been compromised or revoked, or if
+// there's an error, writes out an error response to w.
+func loadLookedUpKey(w http.ResponseWriter, armoredPublicKey string) (*lookedUpKey, bool) {
+	key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("error loading key: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !checkKeyNotBanned(w, key) {
+		return nil, false
+	}
+
+	compromisedAt, err := datastore.GetKeyCompromisedAt(nil, key.Fingerprint())
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return &lookedUpKey{
+		armoredPublicKey: armoredPublicKey,
+		compromisedAt:    compromisedAt,
+		revokedAt:        revokedAt(key),
+	}, true
+}
+
+// checkKeyNotBanned returns true if the key hasn't been banned, or otherwise writes out a
+// 410 Gone error response to w and returns false.
+func checkKeyNotBanned(w http.ResponseWriter, key *pgpkey.PgpKey) bool {
+	banned, err := datastore.IsKeyBanned(nil, key.Fingerprint())
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return false
+	} else if banned {
+		writeJsonError(w, errKeyBanned, http.StatusGone)
+		return false
+	}
+	return true
+}
+
+// revokedAt returns when the key's primary key was revoked, or nil if it hasn't been. Only
+// revocations that verify against the primary key are counted.
+func revokedAt(key *pgpkey.PgpKey) *time.Time {
+	var earliest *time.Time
+	for _, revocation := range key.Revocations {
+		if earliest == nil || revocation.CreationTime.Before(*earliest) {
+			creationTime := revocation.CreationTime
+			earliest = &creationTime
+		}
+	}
+	return earliest
 }
 
 func upsertPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
@@ -106,8 +354,16 @@ func upsertPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
 
 	requestData := v1structs.UpsertPublicKeyRequest{}
 
-	if err := decodeJsonRequest(r, &requestData); err != nil {
-		writeJsonError(w, err, http.StatusBadRequest)
+	if err := decodeLargeJsonRequest(r, &requestData); err != nil {
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
+		return
+	}
+
+	if len(requestData.ArmoredPublicKey) > maxArmoredPublicKeyBytes {
+		writeJsonError(w,
+			fmt.Errorf("armored public key is larger than the maximum of %d bytes",
+				maxArmoredPublicKeyBytes),
+			http.StatusBadRequest)
 		return
 	}
 
@@ -119,29 +375,60 @@ func upsertPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if revokedAt(publicKey) != nil {
+		// check this first: a revoked key can't sign the upload, so it'd fail with a less helpful
+		// signature error below
+		writeJsonError(w, errKeyRevoked, http.StatusBadRequest)
+		return
+	}
+
+	if banned, err := datastore.IsKeyBanned(nil, publicKey.Fingerprint()); err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	} else if banned {
+		writeJsonError(w, errKeyBanned, http.StatusForbidden)
+		return
+	}
+
+	if err := checkKeyStrength(publicKey, now); err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
 	singleUseUUID, err := validateSignedData(
 		requestData.ArmoredSignedJSON,
 		requestData.ArmoredPublicKey,
 		publicKey,
+		ipAddress(r),
 		now,
 	)
-	if err != nil {
+	if err == errReplayDetected {
+		writeJsonError(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
 		writeJsonError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	_, encrypted, err := generateAndEncryptPassword(publicKey)
+	password, encrypted, err := generateAndEncryptPassword(publicKey)
 	if err != nil {
 		writeJsonError(w, err, http.StatusInternalServerError)
 		return
 	}
 
+	var verifications []*email.VerificationEmail
+
 	err = datastore.RunInTransaction(func(txn *sql.Tx) error {
 
 		if err := datastore.UpsertPublicKey(txn, requestData.ArmoredPublicKey); err != nil {
 			return fmt.Errorf("error storing key: %v", err)
 		}
 
+		if err := datastore.SetBasicAuthPasswordHash(
+			txn, publicKey.Fingerprint(), hashPassword(password)); err != nil {
+			return fmt.Errorf("error storing password: %v", err)
+		}
+
 		if err := datastore.StoreSingleUseNumber(txn, *singleUseUUID, now); err != nil {
 			return fmt.Errorf("error storing single use UUID: %v", err)
 		}
@@ -151,8 +438,9 @@ func upsertPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
 			RequestIpAddress: ipAddress(r),
 			RequestTime:      time.Now(),
 		}
-		if err = email.SendVerificationEmails(txn, publicKey, metadata); err != nil {
-			return fmt.Errorf("error sending verification emails: %v", err)
+		verifications, err = email.CreateVerificationEmails(txn, publicKey, metadata)
+		if err != nil {
+			return fmt.Errorf("error creating verifications: %v", err)
 		}
 
 		return nil // no errors, allow transaction to commit
@@ -163,11 +451,16 @@ func upsertPublicKeyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: store new basic auth password
+	// send the emails after the transaction has committed: the key and its new password are
+	// stored whether or not they can be delivered
+	verificationUrls := email.SendVerificationEmails(verifications)
 
 	responseData := v1structs.UpsertPublicKeyResponse{
 		ArmoredEncryptedBasicAuthPassword: encrypted,
 	}
+	if returnVerificationUrls {
+		responseData.VerificationUrls = verificationUrls
+	}
 
 	writeJsonResponse(w, responseData)
 }
@@ -193,9 +486,12 @@ func ipAddress(request *http.Request) string {
 	return ""
 }
 
+// validateSignedData checks the clearsigned JSON that accompanies a key upload. Rejections that
+// look like replay or tampering attempts are recorded as suspicious requests, along with the
+// IP address the request came from.
 func validateSignedData(
 	armoredSignedData string, armoredPublicKey string,
-	publicKey *pgpkey.PgpKey, now time.Time) (*uuid.UUID, error) {
+	publicKey *pgpkey.PgpKey, ipAddress string, now time.Time) (*uuid.UUID, error) {
 
 	verifiedJSON, err := verify([]byte(armoredSignedData), publicKey)
 	if err != nil {
@@ -209,35 +505,113 @@ func validateSignedData(
 		return nil, fmt.Errorf("failed to decode: %v", err)
 	}
 
-	if !within24Hours(now, signedData.Timestamp) {
-		// TODO: log possible attack
-		return nil, fmt.Errorf("timestamp is not within 24 hours of server time")
-	}
-
-	singleUseUUID, err := uuid.FromString(signedData.SingleUseUUID)
+	singleUseUUID, err := validateTimestampAndSingleUseUUID(
+		signedData.Timestamp, signedData.SingleUseUUID, publicKey, ipAddress, now)
 	if err != nil {
-		return nil, fmt.Errorf("bad SingleUseUUID: %v", err)
-	}
-
-	if err := datastore.VerifySingleUseNumberNotStored(singleUseUUID); err != nil {
-		// TODO: log possible attack
-		return nil, fmt.Errorf("bad SingleUseUUID: %v", err)
+		return nil, err
 	}
 
 	givenSHA256, err := hex.DecodeString(signedData.PublicKeySHA256)
 	if err != nil {
-		// TODO: log possible attack
+		recordSuspiciousSignedData(publicKey, ipAddress, now,
+			suspiciousBadSHA256, signedData.PublicKeySHA256)
 		return nil, fmt.Errorf("bad SHA256: %v", err)
 	}
 
 	calculatedSHA256 := sha256.Sum256([]byte(armoredPublicKey))
 	if !hashesEqual(givenSHA256, calculatedSHA256[:]) {
-		// TODO: log possible attack
+		recordSuspiciousSignedData(publicKey, ipAddress, now,
+			suspiciousMismatchingSHA256, fmt.Sprintf(
+				"signed SHA256 %X, calculated %X", givenSHA256, calculatedSHA256))
 		return nil, fmt.Errorf("mismatching public key SHA256")
 	}
+	return singleUseUUID, nil
+}
+
+// validateSignedRequest checks that armoredSignedJSON was clearsigned by key, is for the given
+// action and hasn't been used before. It returns the verified JSON, so the caller can decode any
+// fields the endpoint needs from it, and the request's SingleUseUUID, which the caller must
+// store in the same transaction as the change the request makes.
+func validateSignedRequest(
+	armoredSignedJSON string, action string,
+	key *pgpkey.PgpKey, ipAddress string, now time.Time) ([]byte, *uuid.UUID, error) {
+
+	if armoredSignedJSON == "" {
+		return nil, nil, fmt.Errorf("missing armoredSignedJSON")
+	}
+
+	verifiedJSON, err := verify([]byte(armoredSignedJSON), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to verify: %v", err)
+	}
+
+	signedData := v1structs.SignedRequestData{}
+	err = json.NewDecoder(bytes.NewReader(verifiedJSON)).Decode(&signedData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode: %v", err)
+	}
+
+	if signedData.Action != action {
+		return nil, nil, fmt.Errorf("signed action is `%s`, expected `%s`",
+			signedData.Action, action)
+	}
+
+	singleUseUUID, err := validateTimestampAndSingleUseUUID(
+		signedData.Timestamp, signedData.SingleUseUUID, key, ipAddress, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	return verifiedJSON, singleUseUUID, nil
+}
+
+// validateTimestampAndSingleUseUUID checks the parts of signed data that stop it being replayed:
+// the timestamp must be within 24 hours of now and the single use UUID must not have been used.
+func validateTimestampAndSingleUseUUID(
+	timestamp time.Time, singleUseUUIDString string,
+	key *pgpkey.PgpKey, ipAddress string, now time.Time) (*uuid.UUID, error) {
+
+	if !within24Hours(now, timestamp) {
+		recordSuspiciousSignedData(key, ipAddress, now, suspiciousTimestamp, fmt.Sprintf(
+			"signed timestamp %s, server time %s",
+			timestamp.Format(time.RFC3339), now.Format(time.RFC3339)))
+		return nil, fmt.Errorf("timestamp is not within 24 hours of server time")
+	}
+
+	singleUseUUID, err := uuid.FromString(singleUseUUIDString)
+	if err != nil {
+		return nil, fmt.Errorf("bad SingleUseUUID: %v", err)
+	}
+
+	if err := datastore.VerifySingleUseNumberNotStored(singleUseUUID); err != nil {
+		if err == datastore.ErrSingleUseUUIDAlreadyUsed {
+			recordSuspiciousSignedData(key, ipAddress, now,
+				suspiciousSingleUseUUIDReused, singleUseUUID.String())
+			return nil, errReplayDetected
+		}
+		return nil, fmt.Errorf("bad SingleUseUUID: %v", err)
+	}
 	return &singleUseUUID, nil
 }
 
+// recordSuspiciousSignedData records signed data that looks like a replay or tampering attempt
+// as a suspicious request.
+func recordSuspiciousSignedData(
+	key *pgpkey.PgpKey, ipAddress string, now time.Time, kind string, detail string) {
+
+	err := datastore.RecordSuspiciousRequest(nil, kind, key.Fingerprint(), ipAddress, detail, now)
+	if err != nil {
+		log.Printf("error recording suspicious request (%s): %v", kind, err)
+	}
+}
+
+// kinds of suspicious request recorded by validateSignedData and validateSignedRequest
+const (
+	suspiciousTimestamp           = "timestamp_not_within_24_hours"
+	suspiciousSingleUseUUIDReused = "single_use_uuid_reused"
+	suspiciousBadSHA256           = "bad_sha256"
+	suspiciousMismatchingSHA256   = "mismatching_sha256"
+)
+
 func generateAndEncryptPassword(publicKey *pgpkey.PgpKey) (
 	newPassword string, encrypted string, err error) {
 
@@ -254,6 +628,120 @@ func generateAndEncryptPassword(publicKey *pgpkey.PgpKey) (
 	return newPassword, encryptedPassword, nil
 }
 
+// hashPassword returns the hex SHA256 of the password. The passwords are random UUIDs rather than
+// chosen by people, so a fast hash is fine.
+func hashPassword(password string) string {
+	hash := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
+
+// rotatePasswordHandler generates a new basic auth password for the authorized key, replacing
+// the old one, and returns it encrypted to the key. The request must be authorized with the
+// current password, so it's only for replacing a password that may have leaked: a client that
+// has lost its password can get a new one by uploading its key again.
+func rotatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	myPublicKey, err := getPasswordAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	fingerprint, err := fingerprint.Parse(mux.Vars(r)["fingerprint"])
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	} else if fingerprint != myPublicKey.Fingerprint() {
+		writeJsonError(w,
+			fmt.Errorf("can only rotate the password for the authorized key"),
+			http.StatusForbidden)
+		return
+	}
+
+	password, encrypted, err := generateAndEncryptPassword(myPublicKey)
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := datastore.SetBasicAuthPasswordHash(
+		nil, fingerprint, hashPassword(password)); err != nil {
+
+		writeJsonError(w,
+			fmt.Errorf("error storing password: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	writeJsonResponse(w, v1structs.RotatePasswordResponse{
+		ArmoredEncryptedBasicAuthPassword: encrypted,
+	})
+}
+
+// reportKeyCompromisedHandler lets the owner of a key report that it's been compromised, for
+// example because the private key was lost or stolen. Anyone looking the key up afterwards is
+// warned. The request must be signed by the key: the Authorization header alone can't prove
+// that the caller owns it.
+func reportKeyCompromisedHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	fingerprint, err := fingerprint.Parse(mux.Vars(r)["fingerprint"])
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	} else if fingerprint != myPublicKey.Fingerprint() {
+		writeJsonError(w,
+			fmt.Errorf("can only report the authorized key as compromised"),
+			http.StatusForbidden)
+		return
+	}
+
+	requestData := v1structs.SignedRequest{}
+	if err := decodeJsonRequest(r, &requestData); err != nil {
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
+		return
+	}
+
+	_, singleUseUUID, err := validateSignedRequest(
+		requestData.ArmoredSignedJSON, v1structs.SignedRequestActionReportKeyCompromised,
+		myPublicKey, ipAddress(r), now)
+	if err == errReplayDetected {
+		writeJsonError(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var compromisedAt *time.Time
+
+	err = datastore.RunInTransaction(func(txn *sql.Tx) (err error) {
+		if err = datastore.StoreSingleUseNumber(txn, *singleUseUUID, now); err != nil {
+			return fmt.Errorf("error storing single use UUID: %v", err)
+		}
+		if err = datastore.SetKeyCompromised(txn, fingerprint, now); err != nil {
+			return err
+		}
+		compromisedAt, err = datastore.GetKeyCompromisedAt(txn, fingerprint)
+		return err
+	})
+	if err != nil {
+		writeJsonError(w,
+			fmt.Errorf("error reporting key as compromised: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	writeJsonResponse(w, v1structs.ReportKeyCompromisedResponse{
+		CompromisedAt: *compromisedAt,
+	})
+}
+
 func within24Hours(a, b time.Time) bool {
 	const twentyFourHours = time.Hour * time.Duration(24)
 
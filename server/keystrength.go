@@ -0,0 +1,44 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fluidkeys/crypto/openpgp/packet"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+)
+
+// checkKeyStrength returns an error naming the problem if the key's primary key, or the
+// encryption subkey that secrets would be encrypted to, is weaker than we're willing to
+// distribute: RSA shorter than minRSAKeyBits, or DSA / ElGamal unless allowDSAKeys is set.
+func checkKeyStrength(key *pgpkey.PgpKey, now time.Time) error {
+	if err := checkPublicKeyStrength(key.PrimaryKey); err != nil {
+		return fmt.Errorf("primary key is too weak: %v", err)
+	}
+
+	if subkey := key.EncryptionSubkey(now); subkey != nil {
+		if err := checkPublicKeyStrength(subkey.PublicKey); err != nil {
+			return fmt.Errorf("encryption subkey is too weak: %v", err)
+		}
+	}
+	return nil
+}
+
+func checkPublicKeyStrength(publicKey *packet.PublicKey) error {
+	switch publicKey.PubKeyAlgo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoRSASignOnly:
+		bits, err := publicKey.BitLength()
+		if err != nil {
+			return err
+		}
+		if int(bits) < minRSAKeyBits {
+			return fmt.Errorf("RSA key is %d bits, the minimum is %d", bits, minRSAKeyBits)
+		}
+
+	case packet.PubKeyAlgoDSA, packet.PubKeyAlgoElGamal:
+		if !allowDSAKeys {
+			return fmt.Errorf("DSA and ElGamal keys aren't allowed")
+		}
+	}
+	return nil
+}
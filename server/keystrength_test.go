@@ -0,0 +1,62 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/crypto/openpgp"
+	"github.com/fluidkeys/crypto/openpgp/packet"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+)
+
+func TestCheckKeyStrength(t *testing.T) {
+	defer func(bits int, allowDSA bool) {
+		minRSAKeyBits, allowDSAKeys = bits, allowDSA
+	}(minRSAKeyBits, allowDSAKeys)
+	minRSAKeyBits = 2048
+	allowDSAKeys = false
+
+	now := time.Now()
+
+	t.Run("weak key: 1024-bit RSA", func(t *testing.T) {
+		weakKey, err := pgpkey.LoadFromArmoredPublicKey(exampledata.ExamplePublicKey4)
+		assert.NoError(t, err)
+
+		err = checkKeyStrength(weakKey, now)
+		assert.GotError(t, err)
+		assert.Equal(t,
+			"primary key is too weak: RSA key is 1024 bits, the minimum is 2048", err.Error())
+	})
+
+	t.Run("strong key: 2048-bit RSA", func(t *testing.T) {
+		assert.NoError(t, checkKeyStrength(generateRSAKey(t, 2048), now))
+	})
+
+	t.Run("DSA key", func(t *testing.T) {
+		dsaKey := generateRSAKey(t, 2048)
+		// only the algorithm is checked for DSA keys, so there's no need for a real one
+		dsaKey.PrimaryKey.PubKeyAlgo = packet.PubKeyAlgoDSA
+
+		err := checkKeyStrength(dsaKey, now)
+		assert.GotError(t, err)
+		assert.Equal(t, "primary key is too weak: DSA and ElGamal keys aren't allowed", err.Error())
+
+		t.Run("allowed with allowDSAKeys", func(t *testing.T) {
+			allowDSAKeys = true
+			defer func() { allowDSAKeys = false }()
+
+			assert.NoError(t, checkKeyStrength(dsaKey, now))
+		})
+	})
+}
+
+// generateRSAKey returns a new key whose primary key and encryption subkey are RSA keys of the
+// given length.
+func generateRSAKey(t *testing.T, bits int) *pgpkey.PgpKey {
+	t.Helper()
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", &packet.Config{RSABits: bits})
+	assert.NoError(t, err)
+	return &pgpkey.PgpKey{Entity: *entity}
+}
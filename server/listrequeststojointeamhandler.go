@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/fluidkeys/api/datastore"
 	"github.com/fluidkeys/api/v1structs"
@@ -30,7 +31,28 @@ func listRequestsToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	limit, err := queryInt(r, "limit", defaultRequestsToJoinTeamPageSize)
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	} else if limit < 1 || limit > maxRequestsToJoinTeamPageSize {
+		writeJsonError(w,
+			fmt.Errorf("invalid `limit`: must be between 1 and %d", maxRequestsToJoinTeamPageSize),
+			http.StatusBadRequest)
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	} else if offset < 0 {
+		writeJsonError(w, fmt.Errorf("invalid `offset`: cannot be negative"), http.StatusBadRequest)
+		return
+	}
+
 	var requestsToJoinTeam = []datastore.RequestToJoinTeam{}
+	var total int
 
 	err = datastore.RunInTransaction(func(txn *sql.Tx) error {
 		dbTeam, err := datastore.GetTeam(nil, teamUUID)
@@ -48,7 +70,8 @@ func listRequestsToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 			return errNotAnAdminInExistingTeam
 		}
 
-		requestsToJoinTeam, err = datastore.GetRequestsToJoinTeam(txn, teamUUID)
+		requestsToJoinTeam, total, err = datastore.GetRequestsToJoinTeamPage(
+			txn, teamUUID, limit, offset)
 		if err != nil {
 			return fmt.Errorf("error querying for requests to join team: %v", err)
 		}
@@ -93,7 +116,31 @@ func listRequestsToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 
 	responseData := v1structs.ListRequestsToJoinTeamResponse{
 		Requests: responses,
+		Total:    total,
 	}
 
 	writeJsonResponse(w, responseData)
 }
+
+// defaultRequestsToJoinTeamPageSize is how many requests to join a team are listed when the
+// `limit` query parameter isn't given. maxRequestsToJoinTeamPageSize is the most that can be
+// asked for at once.
+const (
+	defaultRequestsToJoinTeamPageSize = 100
+	maxRequestsToJoinTeamPageSize     = 500
+)
+
+// queryInt returns the integer value of the named query parameter, or defaultValue if it isn't
+// given.
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid `%s`: should be an integer", name)
+	}
+	return n, nil
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/v1structs"
 	"github.com/fluidkeys/fluidkeys/assert"
 	"github.com/fluidkeys/fluidkeys/exampledata"
 	"github.com/fluidkeys/fluidkeys/fingerprint"
@@ -71,10 +72,10 @@ is_admin = false
 	}
 
 	teardown := func() {
-		_, err := datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 
-		_, err = datastore.DeletePublicKey(exampledata.ExampleFingerprint3)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint3)
 		assert.NoError(t, err)
 
 		_, err = datastore.DeleteTeam(nil, teamUUID)
@@ -118,7 +119,8 @@ is_admin = false
             "fingerprint": "OPENPGP4FPR:AAAABBBBAAAABBBBAAAABBBBAAAABBBBAAAABBBB",
             "email": "request@example.com"
         }
-    ]
+    ],
+    "total": 1
 }`
 			got := response.Body.String()
 
@@ -142,6 +144,90 @@ is_admin = false
 		})
 	})
 
+	t.Run("pages through requests", func(t *testing.T) {
+		// add to the request made above, for 5 altogether
+		for i := 1; i < 5; i++ {
+			_, err := datastore.CreateRequestToJoinTeam(
+				nil,
+				teamUUID,
+				fmt.Sprintf("request%d@example.com", i),
+				fingerprint.MustParse(fmt.Sprintf("%040d", i)),
+				now.Add(time.Duration(i)*time.Minute),
+			)
+			assert.NoError(t, err)
+		}
+
+		getPage := func(t *testing.T, query string) v1structs.ListRequestsToJoinTeamResponse {
+			t.Helper()
+			response := callAPI(
+				t,
+				"GET",
+				fmt.Sprintf("/v1/team/%s/requests-to-join?%s", teamUUID, query),
+				nil,
+				&exampledata.ExampleFingerprint4,
+			)
+			assertStatusCode(t, http.StatusOK, response.Code)
+
+			responseData := v1structs.ListRequestsToJoinTeamResponse{}
+			assertBodyDecodesInto(t, response.Body, &responseData)
+			return responseData
+		}
+
+		emailsOf := func(page v1structs.ListRequestsToJoinTeamResponse) []string {
+			emails := []string{}
+			for _, request := range page.Requests {
+				emails = append(emails, request.Email)
+			}
+			return emails
+		}
+
+		t.Run("first page", func(t *testing.T) {
+			page := getPage(t, "limit=2")
+			assert.Equal(t, 5, page.Total)
+			assert.Equal(t,
+				[]string{"request@example.com", "request1@example.com"}, emailsOf(page))
+		})
+
+		t.Run("last, partial page", func(t *testing.T) {
+			page := getPage(t, "limit=2&offset=4")
+			assert.Equal(t, 5, page.Total)
+			assert.Equal(t, []string{"request4@example.com"}, emailsOf(page))
+		})
+
+		t.Run("everything fits in the default page", func(t *testing.T) {
+			page := getPage(t, "")
+			assert.Equal(t, 5, page.Total)
+			assert.Equal(t, 5, len(page.Requests))
+		})
+	})
+
+	t.Run("rejects bad paging parameters", func(t *testing.T) {
+		tests := []struct {
+			query          string
+			expectedDetail string
+		}{
+			{"limit=foo", "invalid `limit`: should be an integer"},
+			{"limit=0", "invalid `limit`: must be between 1 and 500"},
+			{"limit=501", "invalid `limit`: must be between 1 and 500"},
+			{"offset=foo", "invalid `offset`: should be an integer"},
+			{"offset=-1", "invalid `offset`: cannot be negative"},
+		}
+
+		for _, test := range tests {
+			t.Run(test.query, func(t *testing.T) {
+				response := callAPI(
+					t,
+					"GET",
+					fmt.Sprintf("/v1/team/%s/requests-to-join?%s", teamUUID, test.query),
+					nil,
+					&exampledata.ExampleFingerprint4,
+				)
+				assertStatusCode(t, http.StatusBadRequest, response.Code)
+				assertHasJSONErrorDetail(t, response.Body, test.expectedDetail)
+			})
+		}
+	})
+
 	testEndpointRejectsUnauthenticated(t,
 		"GET", fmt.Sprintf("/v1/team/%s/requests-to-join", teamUUID), nil)
 
@@ -153,7 +239,7 @@ is_admin = false
 			datastore.LinkEmailToFingerprint(nil, "test2@example.com", mismatchedFingerprint, nil))
 
 		defer func() {
-			_, err := datastore.DeletePublicKey(mismatchedFingerprint)
+			_, _, err := datastore.DeletePublicKey(nil, mismatchedFingerprint)
 			assert.NoError(t, err)
 		}()
 
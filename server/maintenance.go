@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type maintenanceLevel int
+
+const (
+	// maintenanceOff serves every request as normal.
+	maintenanceOff maintenanceLevel = iota
+
+	// maintenanceWrites refuses requests that could change the database, but still serves
+	// read-only requests such as key lookups.
+	maintenanceWrites
+
+	// maintenanceAll refuses every request.
+	maintenanceAll
+)
+
+var errMaintenanceMode = fmt.Errorf("down for maintenance, please try again later")
+
+// parseMaintenanceLevel parses the value of MAINTENANCE_MODE: "1" blocks writes, "all" blocks
+// everything and "" or "0" turns maintenance mode off.
+func parseMaintenanceLevel(value string) (maintenanceLevel, error) {
+	switch strings.ToLower(value) {
+	case "", "0":
+		return maintenanceOff, nil
+	case "1":
+		return maintenanceWrites, nil
+	case "all":
+		return maintenanceAll, nil
+	default:
+		return maintenanceOff, fmt.Errorf("should be '0', '1' or 'all'")
+	}
+}
+
+// maintenanceModeMiddleware returns 503 Service Unavailable for requests that maintenanceMode
+// says shouldn't be served, for example while a migration is running.
+func maintenanceModeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !blockedByMaintenanceMode(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfterSeconds))
+		writeJsonError(w, errMaintenanceMode, http.StatusServiceUnavailable)
+	})
+}
+
+func blockedByMaintenanceMode(r *http.Request) bool {
+	switch maintenanceMode {
+	case maintenanceAll:
+		return true
+	case maintenanceWrites:
+		return !isReadOnlyRequest(r)
+	default:
+		return false
+	}
+}
+
+// isReadOnlyRequest returns true if serving the request won't write to the database.
+func isReadOnlyRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
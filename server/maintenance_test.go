@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestParseMaintenanceLevel(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected maintenanceLevel
+	}{
+		{"", maintenanceOff},
+		{"0", maintenanceOff},
+		{"1", maintenanceWrites},
+		{"all", maintenanceAll},
+		{"ALL", maintenanceAll},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseMaintenanceLevel(test.value)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+
+	t.Run("unknown value", func(t *testing.T) {
+		_, err := parseMaintenanceLevel("yes")
+		assert.GotError(t, err)
+	})
+}
+
+func TestMaintenanceModeMiddleware(t *testing.T) {
+	defer func(level maintenanceLevel) { maintenanceMode = level }(maintenanceMode)
+
+	handler := maintenanceModeMiddleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+	))
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	tests := []struct {
+		level          maintenanceLevel
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{maintenanceOff, "GET", "/v1/email/test4@example.com/key", http.StatusOK},
+		{maintenanceOff, "POST", "/v1/keys", http.StatusOK},
+
+		{maintenanceWrites, "GET", "/v1/email/test4@example.com/key", http.StatusOK},
+		{maintenanceWrites, "GET", "/pks/lookup", http.StatusOK},
+		{maintenanceWrites, "POST", "/v1/keys", http.StatusServiceUnavailable},
+		{maintenanceWrites, "DELETE", "/v1/secrets/abc", http.StatusServiceUnavailable},
+		{
+			maintenanceWrites,
+			"POST", "/v1/email/verify/8ef46a96-f735-11e8-a220-7fd225378c68",
+			http.StatusServiceUnavailable,
+		},
+
+		{maintenanceAll, "GET", "/v1/email/test4@example.com/key", http.StatusServiceUnavailable},
+		{maintenanceAll, "POST", "/v1/keys", http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			maintenanceMode = test.level
+			response := serve(test.method, test.path)
+			assertStatusCode(t, test.expectedStatus, response.Code)
+
+			if test.expectedStatus == http.StatusServiceUnavailable {
+				assert.Equal(t, "300", response.Header().Get("Retry-After"))
+				assertHasJSONErrorDetail(t, response.Body, errMaintenanceMode.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/gorilla/mux"
+)
+
+// apiOperation describes the request and response bodies of an endpoint, for generating the
+// OpenAPI spec. Every route registered on router needs an entry in apiOperations.
+type apiOperation struct {
+	summary string
+
+	// operationID overrides the operationId taken from the handler's name, for when several
+	// routes share a handler
+	operationID string
+
+	// request is an example of the JSON request body, or nil if there isn't one
+	request interface{}
+
+	// response is an example of the JSON response body, or nil if there isn't one
+	response interface{}
+
+	// status is the response status code on success
+	status int
+
+	// contentType is the content type of a non-JSON response, e.g. application/pgp-keys
+	contentType string
+}
+
+// apiOperations is keyed by method and path template, e.g. "GET /v1/key/{fingerprint}"
+var apiOperations = map[string]apiOperation{
+	"GET /": {
+		summary: "Point to the current API version and docs", status: http.StatusOK,
+		response: v1structs.GetRootResponse{},
+	},
+	"GET /pks/lookup": {
+		summary: "HKP keyserver lookup", status: http.StatusOK,
+		contentType: "application/pgp-keys",
+	},
+	"GET /.well-known/openpgpkey/policy": {
+		summary: "Web Key Directory policy", status: http.StatusOK, contentType: "text/plain",
+	},
+	"GET /.well-known/openpgpkey/{domain}/policy": {
+		summary: "Web Key Directory policy", status: http.StatusOK, contentType: "text/plain",
+		operationID: "wkdPolicyForDomain",
+	},
+	"GET /.well-known/openpgpkey/hu/{hash}": {
+		summary: "Web Key Directory key lookup", status: http.StatusOK,
+		contentType: "application/octet-stream",
+	},
+	"GET /.well-known/openpgpkey/{domain}/hu/{hash}": {
+		summary: "Web Key Directory key lookup", status: http.StatusOK,
+		operationID: "wkdKeyForDomain",
+		contentType: "application/octet-stream",
+	},
+	"GET /v1/ping/{word}": {
+		summary: "Check the API and database are up", status: http.StatusOK,
+		contentType: "text/plain",
+	},
+	"GET /v1/dev/email-preview/{templateID}": {
+		summary: "Preview an email template with sample data (development only)",
+		status:  http.StatusOK, contentType: "text/html",
+	},
+	"GET /v1/version": {
+		summary: "Get the server version", status: http.StatusOK,
+		response: v1structs.GetVersionResponse{},
+	},
+	"GET /v1/capabilities": {
+		summary: "List the optional features this server supports", status: http.StatusOK,
+		response: v1structs.GetCapabilitiesResponse{},
+	},
+	"GET /v1/email/verify/{uuid}": {
+		summary: "Show the page confirming an email verification", status: http.StatusOK,
+		operationID: "showVerifyEmail",
+		contentType: "text/html",
+	},
+	"POST /v1/email/verify/{uuid}": {
+		summary: "Verify an email address", status: http.StatusOK, contentType: "text/html",
+	},
+	"GET /v1/email/{email}/key": {
+		summary: "Get a public key by email", status: http.StatusOK,
+		response: v1structs.GetPublicKeyResponse{},
+	},
+	"GET /v1/email/{email}/key.asc": {
+		summary: "Get an ASCII-armored public key by email", status: http.StatusOK,
+		contentType: "application/pgp-keys",
+	},
+	"GET /v1/email/{email}/verification": {
+		summary: "Get when an email was verified", status: http.StatusOK,
+		response: v1structs.GetEmailVerificationResponse{},
+	},
+	"GET /v1/email/{email}/key-history": {
+		summary:  "List the keys an email has been verified for, encrypted to your key",
+		status:   http.StatusOK,
+		response: v1structs.EncryptedJSONResponse{},
+	},
+	"POST /v1/email/{email}/reverify": {
+		summary: "Resend the verification email for an address on your key",
+		status:  http.StatusAccepted,
+	},
+	"POST /v1/emails/verified": {
+		summary: "Query whether emails are verified", status: http.StatusOK,
+		request:  v1structs.QueryEmailsVerifiedRequest{},
+		response: v1structs.QueryEmailsVerifiedResponse{},
+	},
+	"GET /v1/key/{fingerprint}": {
+		summary: "Get a public key by fingerprint", status: http.StatusOK,
+		response: v1structs.GetPublicKeyResponse{},
+	},
+	"GET /v1/key/{fingerprint}.asc": {
+		summary: "Get an ASCII-armored public key by fingerprint", status: http.StatusOK,
+		contentType: "application/pgp-keys",
+	},
+	"GET /v1/key/{fingerprint}/primary-email": {
+		summary: "Get a key's primary email address", status: http.StatusOK,
+		response: v1structs.GetPrimaryEmailResponse{},
+	},
+	"POST /v1/key/{fingerprint}/rotate-password": {
+		summary: "Rotate your basic auth password", status: http.StatusOK,
+		response: v1structs.RotatePasswordResponse{},
+	},
+	"GET /v1/profile": {
+		summary: "Get your email preferences", status: http.StatusOK,
+		response: v1structs.UserProfileResponse{},
+	},
+	"PATCH /v1/profile": {
+		summary: "Update your email preferences", status: http.StatusOK,
+		request:  v1structs.SignedRequest{},
+		response: v1structs.UserProfileResponse{},
+	},
+	"GET /v1/key/{fingerprint}/verifications": {
+		summary:  "List your key's email verifications, encrypted to the key",
+		status:   http.StatusOK,
+		response: v1structs.EncryptedJSONResponse{},
+	},
+	"POST /v1/key/{fingerprint}/report-compromised": {
+		summary: "Report your key as compromised", status: http.StatusOK,
+		request:  v1structs.SignedRequest{},
+		response: v1structs.ReportKeyCompromisedResponse{},
+	},
+	"POST /v1/keys": {
+		summary: "Create or update a public key", status: http.StatusOK,
+		request:  v1structs.UpsertPublicKeyRequest{},
+		response: v1structs.UpsertPublicKeyResponse{},
+	},
+	"POST /v1/secrets": {
+		summary: "Send a secret to a public key", status: http.StatusCreated,
+		request:  v1structs.SendSecretRequest{},
+		response: v1structs.SendSecretResponse{},
+	},
+	"GET /v1/secrets": {
+		summary: "List your secrets", status: http.StatusOK,
+		response: v1structs.ListSecretsResponse{},
+	},
+	"GET /v1/inbox": {
+		summary: "Summarise your secrets", status: http.StatusOK,
+		response: v1structs.GetInboxResponse{},
+	},
+	"DELETE /v1/secrets/{uuid}": {
+		summary: "Delete a secret", status: http.StatusAccepted,
+	},
+	"GET /v1/secrets/{uuid}/status": {
+		summary: "Check if a secret has been picked up", status: http.StatusOK,
+		response: v1structs.GetSecretStatusResponse{},
+	},
+	"POST /v1/teams": {
+		summary: "Create or update a team", status: http.StatusOK,
+		request:  v1structs.UpsertTeamRequest{},
+		response: v1structs.UpsertTeamResponse{},
+	},
+	"POST /v1/teams/validate": {
+		summary: "Check a roster is valid without uploading it", status: http.StatusOK,
+		request:  v1structs.ValidateTeamRosterRequest{},
+		response: v1structs.ValidateTeamRosterResponse{},
+	},
+	"GET /v1/teams": {
+		summary: "List your teams", status: http.StatusOK,
+		response: v1structs.ListTeamsResponse{},
+	},
+	"GET /v1/team/{teamUUID}": {
+		summary: "Get a team", status: http.StatusOK,
+		response: v1structs.GetTeamResponse{},
+	},
+	"POST /v1/team/{teamUUID}/requests-to-join": {
+		summary: "Request to join a team", status: http.StatusCreated,
+		request: v1structs.RequestToJoinTeamRequest{},
+	},
+	"GET /v1/team/{teamUUID}/requests-to-join": {
+		summary: "List requests to join a team", status: http.StatusOK,
+		response: v1structs.ListRequestsToJoinTeamResponse{},
+	},
+	"GET /v1/team/{teamUUID}/roster": {
+		summary: "Get a team's roster", status: http.StatusOK,
+		response: v1structs.GetTeamRosterResponse{},
+	},
+	"HEAD /v1/team/{teamUUID}/roster": {
+		summary: "Check a team's roster version in the ETag header", status: http.StatusOK,
+		operationID: "headTeamRoster",
+	},
+	"GET /v1/team/{teamUUID}/members/{fingerprint}": {
+		summary: "Check a key's team membership", status: http.StatusOK,
+		response: v1structs.GetTeamMemberResponse{},
+	},
+	"DELETE /v1/team/{teamUUID}/requests-to-join/{requestUUID}": {
+		summary: "Delete a request to join a team", status: http.StatusAccepted,
+	},
+	"GET /v1/requests-to-join/{requestUUID}": {
+		summary: "Find the team your request to join belongs to", status: http.StatusOK,
+		response: v1structs.GetRequestToJoinTeamResponse{},
+	},
+	"POST /v1/events": {
+		summary: "Record a client event", status: http.StatusOK,
+		request: v1structs.CreateEventRequest{},
+	},
+}
+
+// OpenAPISpec returns an OpenAPI 3 document describing every route registered on the router,
+// as indented JSON.
+func OpenAPISpec() ([]byte, error) {
+	spec := openAPIDocument{
+		OpenAPI: "3.0.0",
+		Info:    openAPIInfo{Title: "Fluidkeys API", Version: "1"},
+		Servers: []openAPIServer{{URL: "https://api.fluidkeys.com"}},
+		Paths:   map[string]map[string]openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas: map[string]*openAPISchema{},
+		},
+	}
+
+	errorSchema := schemaFor(reflect.TypeOf(v1structs.ErrorResponse{}), spec.Components.Schemas)
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil // a subrouter's prefix, not an endpoint
+		}
+
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path := stripPathVariablePatterns(pathTemplate)
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			return fmt.Errorf("route %s has no methods: %v", path, err)
+		}
+
+		for _, method := range methods {
+			key := method + " " + path
+			apiOp, found := apiOperations[key]
+			if !found {
+				return fmt.Errorf("no entry in apiOperations for %s", key)
+			}
+
+			if spec.Paths[path] == nil {
+				spec.Paths[path] = map[string]openAPIOperation{}
+			}
+			spec.Paths[path][strings.ToLower(method)] = makeOperation(
+				apiOp, route.GetHandler(), path, errorSchema, spec.Components.Schemas)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func makeOperation(
+	apiOp apiOperation, handler http.Handler, path string,
+	errorSchema *openAPISchema, schemas map[string]*openAPISchema) openAPIOperation {
+
+	id := apiOp.operationID
+	if id == "" {
+		id = operationID(handler)
+	}
+
+	operation := openAPIOperation{
+		OperationID: id,
+		Summary:     apiOp.summary,
+		Responses: map[string]openAPIResponse{
+			"default": {
+				Description: "error",
+				Content:     map[string]openAPIMediaType{"application/json": {Schema: errorSchema}},
+			},
+		},
+	}
+
+	for _, name := range pathVariableNames(path) {
+		operation.Parameters = append(operation.Parameters, openAPIParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &openAPISchema{Type: "string"},
+		})
+	}
+
+	if apiOp.request != nil {
+		operation.RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content: map[string]openAPIMediaType{
+				"application/json": {Schema: schemaFor(reflect.TypeOf(apiOp.request), schemas)},
+			},
+		}
+	}
+
+	success := openAPIResponse{Description: http.StatusText(apiOp.status)}
+	if apiOp.response != nil {
+		success.Content = map[string]openAPIMediaType{
+			"application/json": {Schema: schemaFor(reflect.TypeOf(apiOp.response), schemas)},
+		}
+	} else if apiOp.contentType != "" {
+		success.Content = map[string]openAPIMediaType{
+			apiOp.contentType: {Schema: &openAPISchema{Type: "string"}},
+		}
+	}
+	operation.Responses[strconv.Itoa(apiOp.status)] = success
+
+	return operation
+}
+
+// operationID returns the name of the handler function without its package or Handler suffix,
+// e.g. getPublicKeyByEmail for getPublicKeyByEmailHandler
+func operationID(handler http.Handler) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimSuffix(name, "Handler")
+}
+
+// stripPathVariablePatterns turns a mux path template like /v1/key/{fingerprint:[0-9A-F]{40}}
+// into an OpenAPI path like /v1/key/{fingerprint}
+func stripPathVariablePatterns(pathTemplate string) string {
+	out := strings.Builder{}
+	depth := 0
+	inPattern := false
+
+	for _, c := range pathTemplate {
+		switch {
+		case c == '{':
+			depth++
+			if depth == 1 {
+				out.WriteRune(c)
+			}
+		case c == '}':
+			depth--
+			if depth == 0 {
+				inPattern = false
+				out.WriteRune(c)
+			}
+		case c == ':' && depth == 1:
+			inPattern = true
+		case !inPattern:
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
+
+var pathVariable = regexp.MustCompile(`{([^}]+)}`)
+
+func pathVariableNames(path string) []string {
+	names := []string{}
+	for _, match := range pathVariable.FindAllStringSubmatch(path, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the OpenAPI schema for values of type t once they're encoded as JSON.
+// Named structs are added to schemas and referred to with $ref.
+func schemaFor(t reflect.Type, schemas map[string]*openAPISchema) *openAPISchema {
+	if t == timeType {
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := schemaFor(t.Elem(), schemas)
+		if schema.Ref != "" {
+			// siblings of $ref are ignored, so wrap it to mark it nullable
+			return &openAPISchema{AllOf: []*openAPISchema{schema}, Nullable: true}
+		}
+		schema.Nullable = true
+		return schema
+
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: schemaFor(t.Elem(), schemas)}
+
+	case reflect.Map:
+		return &openAPISchema{
+			Type: "object", AdditionalProperties: schemaFor(t.Elem(), schemas),
+		}
+
+	case reflect.Struct:
+		ref := &openAPISchema{Ref: "#/components/schemas/" + t.Name()}
+		if _, alreadyAdded := schemas[t.Name()]; !alreadyAdded {
+			schemas[t.Name()] = nil // stops infinite recursion on self-referencing types
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return ref
+
+	default:
+		return &openAPISchema{}
+	}
+}
+
+func structSchema(t reflect.Type, schemas map[string]*openAPISchema) *openAPISchema {
+	schema := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+
+		name, omitEmpty := jsonFieldName(field)
+		if name == "-" {
+			continue
+		}
+
+		schema.Properties[name] = schemaFor(field.Type, schemas)
+		if !omitEmpty {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool) {
+	tag := strings.Split(field.Tag.Get("json"), ",")
+	name = tag[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range tag[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty
+}
+
+type openAPIDocument struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       openAPIInfo                            `json:"info"`
+	Servers    []openAPIServer                        `json:"servers"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components openAPIComponents                      `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref        string                    `json:"$ref,omitempty"`
+	Type       string                    `json:"type,omitempty"`
+	Format     string                    `json:"format,omitempty"`
+	Nullable   bool                      `json:"nullable,omitempty"`
+	AllOf      []*openAPISchema          `json:"allOf,omitempty"`
+	Items      *openAPISchema            `json:"items,omitempty"`
+	Properties map[string]*openAPISchema `json:"properties,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+
+	// AdditionalProperties is the schema of a map's values
+	AdditionalProperties *openAPISchema `json:"additionalProperties,omitempty"`
+}
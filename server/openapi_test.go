@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/fluidkeys/fluidkeys/assert"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	spec, err := OpenAPISpec()
+	assert.NoError(t, err)
+
+	var document openAPIDocument
+	assert.NoError(t, json.Unmarshal(spec, &document))
+
+	t.Run("every entry in apiOperations is a route", func(t *testing.T) {
+		for key := range apiOperations {
+			parts := strings.SplitN(key, " ", 2)
+			method, path := strings.ToLower(parts[0]), parts[1]
+
+			if _, found := document.Paths[path][method]; !found {
+				t.Errorf("apiOperations has %s but there's no such route", key)
+			}
+		}
+	})
+
+	t.Run("operation IDs are unique", func(t *testing.T) {
+		seen := map[string]bool{}
+		for path, operations := range document.Paths {
+			for method, operation := range operations {
+				if seen[operation.OperationID] {
+					t.Errorf("duplicate operationId %s on %s %s",
+						operation.OperationID, method, path)
+				}
+				seen[operation.OperationID] = true
+			}
+		}
+	})
+
+	t.Run("committed openapi.json is up to date", func(t *testing.T) {
+		committed, err := ioutil.ReadFile("../openapi.json")
+		assert.NoError(t, err)
+
+		if string(committed) != string(spec) {
+			t.Fatalf("openapi.json is out of date: run `make openapi` and commit the result")
+		}
+	})
+}
+
+func TestStripPathVariablePatterns(t *testing.T) {
+	tests := []struct {
+		pathTemplate string
+		expected     string
+	}{
+		{"/v1/ping/{word}", "/v1/ping/{word}"},
+		{"/v1/key/{fingerprint:[0-9A-F]{40}}", "/v1/key/{fingerprint}"},
+		{"/v1/key/{fingerprint:[0-9A-F]{40}}.asc", "/v1/key/{fingerprint}.asc"},
+		{
+			"/v1/team/{teamUUID:[0-9a-f-]+}/members/{fingerprint:[0-9A-F]{40}}",
+			"/v1/team/{teamUUID}/members/{fingerprint}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pathTemplate, func(t *testing.T) {
+			assert.Equal(t, test.expected, stripPathVariablePatterns(test.pathTemplate))
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/v1structs"
+)
+
+// getProfileHandler returns the email preferences for the authorized key.
+func getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	profile, err := datastore.GetUserProfile(nil, myPublicKey.Fingerprint())
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJsonResponse(w, userProfileResponse(profile))
+}
+
+// updateProfileHandler changes the email preferences given in the request for the authorized
+// key, leaving any that aren't given as they are. The preferences must be signed by the key:
+// the Authorization header alone can't prove that the caller owns it.
+func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	requestData := v1structs.SignedRequest{}
+	if err := decodeJsonRequest(r, &requestData); err != nil {
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
+		return
+	}
+
+	verifiedJSON, singleUseUUID, err := validateSignedRequest(
+		requestData.ArmoredSignedJSON, v1structs.SignedRequestActionUpdateProfile,
+		myPublicKey, ipAddress(r), now)
+	if err == errReplayDetected {
+		writeJsonError(w, err, http.StatusConflict)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	signedData := v1structs.UpdateUserProfileSignedData{}
+	if err := json.Unmarshal(verifiedJSON, &signedData); err != nil {
+		writeJsonError(w, fmt.Errorf("failed to decode: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if signedData.OptoutEmailsExpiryWarnings == nil {
+		writeJsonError(w,
+			fmt.Errorf("no preferences to update: expected `optoutEmailsExpiryWarnings`"),
+			http.StatusBadRequest)
+		return
+	}
+
+	var profile *datastore.UserProfile
+
+	err = datastore.RunInTransaction(func(txn *sql.Tx) error {
+		if err := datastore.StoreSingleUseNumber(txn, *singleUseUUID, now); err != nil {
+			return fmt.Errorf("error storing single use UUID: %v", err)
+		}
+
+		profile, err = datastore.GetUserProfile(txn, myPublicKey.Fingerprint())
+		if err != nil {
+			return err
+		}
+
+		profile.OptoutEmailsExpiryWarnings = *signedData.OptoutEmailsExpiryWarnings
+
+		return datastore.UpdateUserProfilePreferences(
+			txn, profile.UUID, profile.OptoutEmailsExpiryWarnings)
+	})
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJsonResponse(w, userProfileResponse(profile))
+}
+
+func userProfileResponse(profile *datastore.UserProfile) v1structs.UserProfileResponse {
+	return v1structs.UserProfileResponse{
+		OptoutEmailsExpiryWarnings: profile.OptoutEmailsExpiryWarnings,
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+)
+
+func TestProfileHandlers(t *testing.T) {
+	fingerprint := exampledata.ExampleFingerprint4
+
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+	otherUnlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey3, "test3")
+	assert.NoError(t, err)
+
+	signedPreferences := func(t *testing.T, key *pgpkey.PgpKey, optout *bool) v1structs.SignedRequest {
+		t.Helper()
+		return makeSignedRequestBody(t, key, v1structs.UpdateUserProfileSignedData{
+			SignedRequestData: newSignedRequestData(
+				v1structs.SignedRequestActionUpdateProfile),
+			OptoutEmailsExpiryWarnings: optout,
+		})
+	}
+
+	setup := func() {
+		// deleting the key first also deletes any profile left by other tests
+		_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	getProfile := func(t *testing.T) v1structs.UserProfileResponse {
+		t.Helper()
+		response := callAPI(t, "GET", "/v1/profile", nil, &fingerprint)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.UserProfileResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		return responseData
+	}
+
+	patchProfile := func(t *testing.T, optout bool) v1structs.UserProfileResponse {
+		t.Helper()
+		requestData := signedPreferences(t, unlockedKey, &optout)
+		response := callAPI(t, "PATCH", "/v1/profile", requestData, &fingerprint)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.UserProfileResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		return responseData
+	}
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/profile", nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+
+		response = callAPI(t, "PATCH", "/v1/profile", nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("defaults to receiving expiry warnings", func(t *testing.T) {
+		assert.Equal(t, false, getProfile(t).OptoutEmailsExpiryWarnings)
+	})
+
+	t.Run("opt out of expiry warnings", func(t *testing.T) {
+		assert.Equal(t, true, patchProfile(t, true).OptoutEmailsExpiryWarnings)
+		assert.Equal(t, true, getProfile(t).OptoutEmailsExpiryWarnings)
+	})
+
+	t.Run("opt back in to expiry warnings", func(t *testing.T) {
+		assert.Equal(t, false, patchProfile(t, false).OptoutEmailsExpiryWarnings)
+		assert.Equal(t, false, getProfile(t).OptoutEmailsExpiryWarnings)
+	})
+
+	t.Run("PATCH without any preferences", func(t *testing.T) {
+		requestData := signedPreferences(t, unlockedKey, nil)
+		response := callAPI(t, "PATCH", "/v1/profile", requestData, &fingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"no preferences to update: expected `optoutEmailsExpiryWarnings`")
+	})
+
+	t.Run("unsigned PATCH is refused", func(t *testing.T) {
+		requestData := map[string]bool{"optoutEmailsExpiryWarnings": true}
+		response := callAPI(t, "PATCH", "/v1/profile", requestData, &fingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "missing armoredSignedJSON")
+		assert.Equal(t, false, getProfile(t).OptoutEmailsExpiryWarnings)
+	})
+
+	t.Run("PATCH signed by a different key is refused", func(t *testing.T) {
+		optout := true
+		requestData := signedPreferences(t, otherUnlockedKey, &optout)
+		response := callAPI(t, "PATCH", "/v1/profile", requestData, &fingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assert.Equal(t, false, getProfile(t).OptoutEmailsExpiryWarnings)
+	})
+
+	t.Run("PATCH signed for a different action is refused", func(t *testing.T) {
+		optout := true
+		requestData := makeSignedRequestBody(t, unlockedKey, v1structs.UpdateUserProfileSignedData{
+			SignedRequestData: newSignedRequestData(
+				v1structs.SignedRequestActionReportKeyCompromised),
+			OptoutEmailsExpiryWarnings: &optout,
+		})
+		response := callAPI(t, "PATCH", "/v1/profile", requestData, &fingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assert.Equal(t, false, getProfile(t).OptoutEmailsExpiryWarnings)
+	})
+
+	testEndpointRejectsBadJSON(t, "PATCH", "/v1/profile", &fingerprint)
+}
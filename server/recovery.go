@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+var errInternalServerError = fmt.Errorf("internal server error")
+
+// recoveryMiddleware catches a panic in any handler, logs it with its stack trace and returns a
+// JSON 500 Internal Server Error. The panic value isn't returned, since it could leak internals.
+func recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				// net/http uses this to abort a response without logging: let it through
+				panic(recovered)
+			}
+
+			log.Printf("panic serving %s %s (request ID %s): %v\n%s",
+				r.Method, r.URL.Path, requestID(r), recovered, debug.Stack())
+			writeJsonError(w, errInternalServerError, http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestID returns the ID the Heroku router gives each request, so a logged error can be
+// matched up with the router's log line, or "-" if there isn't one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	return "-"
+}
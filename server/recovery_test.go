@@ -0,0 +1,54 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	handler := recoveryMiddleware(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { panic("secret database password") },
+	))
+
+	req, err := http.NewRequest("GET", "/v1/panic", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.Header.Set("X-Request-ID", "f9ed4675-f4b5-4b51-8ee4-f6c2b3c7c7e3")
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, req)
+	body := response.Body.String()
+
+	t.Run("returns 500 with a JSON error", func(t *testing.T) {
+		assertStatusCode(t, http.StatusInternalServerError, response.Code)
+		assertHasJSONErrorDetail(t, strings.NewReader(body), errInternalServerError.Error())
+	})
+
+	t.Run("doesn't leak the panic value", func(t *testing.T) {
+		if strings.Contains(body, "secret database password") {
+			t.Fatalf("response body contains the panic value: %s", body)
+		}
+	})
+
+	t.Run("logs the panic with the request ID and stack", func(t *testing.T) {
+		for _, expected := range []string{
+			"secret database password",
+			"request ID f9ed4675-f4b5-4b51-8ee4-f6c2b3c7c7e3",
+			"goroutine",
+		} {
+			if !strings.Contains(logged.String(), expected) {
+				t.Errorf("expected log to contain %q, got: %s", expected, logged.String())
+			}
+		}
+	})
+}
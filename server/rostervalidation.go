@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fluidkeys/api/datastore"
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/team"
+	"github.com/gofrs/uuid"
+)
+
+// CheckStoredTeam checks that a team's stored roster still loads and validates, that it's for the
+// same team, and that its stored signature is valid for one of the team's admins. It's for
+// spotting rows that have been corrupted or tampered with.
+func CheckStoredTeam(dbTeam datastore.Team) error {
+	t, err := loadRoster(dbTeam.Roster, dbTeam.RosterSignature)
+	if err != nil {
+		return fmt.Errorf("error loading roster: %v", err)
+	}
+
+	if t.UUID != dbTeam.UUID {
+		return fmt.Errorf("roster is for a different team: %s", t.UUID)
+	}
+
+	return validateStoredRosterSignature(t, dbTeam.Roster, dbTeam.RosterSignature)
+}
+
+// loadRoster loads a team from the roster like team.Load, but if the roster has more than one
+// problem it returns them all as a multiError rather than just the first, so that clients can
+// fix them in one go. It also rejects email addresses that we wouldn't be able to send to.
+func loadRoster(roster string, signature string) (*team.Team, error) {
+	loaded, err := team.Load(roster, signature)
+	if err == nil {
+		switch problems := validateEmails(loaded.People); len(problems) {
+		case 0:
+			return loaded, nil
+		case 1:
+			return nil, fmt.Errorf("error validating team: %v", problems[0])
+		default:
+			return nil, problems
+		}
+	}
+
+	parsed, parseErr := parseRoster(roster)
+	if parseErr != nil {
+		return nil, err // it doesn't even parse: team.Load's error says why
+	}
+
+	if problems := validateRoster(*parsed); len(problems) > 1 {
+		return nil, problems
+	}
+	return nil, err
+}
+
+// parseRoster decodes the roster without validating it. It matches the team package's own
+// (unexported) parser, which team.Load runs before Validate.
+func parseRoster(roster string) (*team.Team, error) {
+	var parsed team.Team
+	metadata, err := toml.DecodeReader(strings.NewReader(roster), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("error in toml.DecodeReader: %v", err)
+	}
+
+	if len(metadata.Undecoded()) > 0 {
+		return nil, fmt.Errorf("encountered unrecognised config keys: %v", metadata.Undecoded())
+	}
+	return &parsed, nil
+}
+
+// validateRoster makes the same checks as team.Validate plus validateEmails, but carries on
+// after the first problem and returns every one it finds.
+func validateRoster(t team.Team) multiError {
+	problems := multiError{}
+
+	if t.UUID == uuid.Nil {
+		problems = append(problems, fmt.Errorf("invalid roster: invalid UUID"))
+	}
+
+	problems = append(problems, validateEmails(t.People)...)
+
+	emailsSeen := map[string]int{}
+	fingerprintsSeen := map[fpr.Fingerprint]int{}
+	for _, person := range t.People {
+		emailsSeen[person.Email]++
+		if emailsSeen[person.Email] == 2 { // report each duplicate once
+			problems = append(problems, fmt.Errorf("email listed more than once: %s", person.Email))
+		}
+
+		fingerprintsSeen[person.Fingerprint]++
+		if fingerprintsSeen[person.Fingerprint] == 2 {
+			problems = append(problems,
+				fmt.Errorf("fingerprint listed more than once: %s", person.Fingerprint))
+		}
+	}
+
+	if len(t.People) == 0 {
+		problems = append(problems, fmt.Errorf("team has no members"))
+	} else if len(t.Admins()) == 0 {
+		problems = append(problems, fmt.Errorf("team has no administrators"))
+	}
+
+	return problems
+}
+
+// validateEmails checks that every person's email is a bare address that mail.ParseAddress
+// accepts, since otherwise sending them email would fail later on.
+func validateEmails(people []team.Person) multiError {
+	problems := multiError{}
+	for _, person := range people {
+		parsed, err := mail.ParseAddress(person.Email)
+		if err != nil || parsed.Address != person.Email {
+			problems = append(problems, fmt.Errorf("invalid email address: %q", person.Email))
+		}
+	}
+	return problems
+}
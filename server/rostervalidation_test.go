@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+	"github.com/gofrs/uuid"
+)
+
+func TestLoadRoster(t *testing.T) {
+	t.Run("valid roster", func(t *testing.T) {
+		loaded, err := loadRoster(`
+uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = true
+`, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "alice@example.com", loaded.People[0].Email)
+	})
+
+	t.Run("roster with one problem gives team.Load's error", func(t *testing.T) {
+		_, err := loadRoster(`uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"`, "")
+		assert.Equal(t, "error validating team: team has no members", err.Error())
+	})
+
+	t.Run("roster that doesn't parse gives team.Load's error", func(t *testing.T) {
+		_, err := loadRoster(`unknown = "key"`, "")
+		assert.Equal(t, "encountered unrecognised config keys: [unknown]", err.Error())
+	})
+
+	t.Run("roster with an invalid email", func(t *testing.T) {
+		_, err := loadRoster(`
+uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = true
+
+[[person]]
+email = "bob at example.com"
+fingerprint = "BBBB BBBB BBBB BBBB BBBB  BBBB BBBB BBBB BBBB BBBB"
+is_admin = false
+`, "")
+		assert.Equal(t, `error validating team: invalid email address: "bob at example.com"`, err.Error())
+	})
+
+	t.Run("roster with an email that has a display name", func(t *testing.T) {
+		_, err := loadRoster(`
+uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"
+
+[[person]]
+email = "Alice <alice@example.com>"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = true
+`, "")
+		assert.Equal(t, `error validating team: invalid email address: "Alice <alice@example.com>"`, err.Error())
+	})
+
+	t.Run("roster with several problems lists them all", func(t *testing.T) {
+		_, err := loadRoster(`
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = false
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "BBBB BBBB BBBB BBBB BBBB  BBBB BBBB BBBB BBBB BBBB"
+is_admin = false
+
+[[person]]
+email = "bob@example.com"
+fingerprint = "BBBB BBBB BBBB BBBB BBBB  BBBB BBBB BBBB BBBB BBBB"
+is_admin = false
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "CCCC CCCC CCCC CCCC CCCC  CCCC CCCC CCCC CCCC CCCC"
+is_admin = false
+`, "")
+
+		problems, ok := err.(multiError)
+		if !ok {
+			t.Fatalf("expected a multiError, got %T: %v", err, err)
+		}
+
+		assert.Equal(t, []string{
+			"invalid roster: invalid UUID",
+			"email listed more than once: alice@example.com",
+			"fingerprint listed more than once: BBBB BBBB BBBB BBBB BBBB  BBBB BBBB BBBB BBBB BBBB",
+			"team has no administrators",
+		}, problems.strings())
+	})
+}
+
+func TestCheckStoredTeam(t *testing.T) {
+	roster := `
+uuid = "0f8b6f62-a4c2-11e9-9b1c-6f0b1d4c1e2a"
+
+[[person]]
+email = "test4@example.com"
+fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+is_admin = true
+`
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
+		exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+
+	signature, err := makeArmoredDetachedSignature([]byte(roster), unlockedKey)
+	assert.NoError(t, err)
+
+	goodTeam := datastore.Team{
+		UUID:            uuid.Must(uuid.FromString("0f8b6f62-a4c2-11e9-9b1c-6f0b1d4c1e2a")),
+		Roster:          roster,
+		RosterSignature: signature,
+	}
+
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	defer func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}()
+
+	t.Run("healthy team", func(t *testing.T) {
+		assert.NoError(t, CheckStoredTeam(goodTeam))
+	})
+
+	t.Run("roster that doesn't parse", func(t *testing.T) {
+		brokenTeam := goodTeam
+		brokenTeam.Roster = "not [valid toml"
+
+		err := CheckStoredTeam(brokenTeam)
+		assert.GotError(t, err)
+		if !strings.HasPrefix(err.Error(), "error loading roster: ") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("signature doesn't match roster", func(t *testing.T) {
+		tamperedTeam := goodTeam
+		tamperedTeam.Roster = roster + "\n# tampered"
+
+		assert.Equal(t, errStoredRosterSignatureInvalid, CheckStoredTeam(tamperedTeam))
+	})
+
+	t.Run("roster is for a different team", func(t *testing.T) {
+		mismatchedTeam := goodTeam
+		mismatchedTeam.UUID = uuid.Must(uuid.FromString("5f1f2c0a-a4c2-11e9-8c4e-8b2f3c1d7e9b"))
+
+		assert.Equal(t,
+			fmt.Errorf("roster is for a different team: 0f8b6f62-a4c2-11e9-9b1c-6f0b1d4c1e2a"),
+			CheckStoredTeam(mismatchedTeam))
+	})
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"github.com/fluidkeys/api/datastore"
@@ -9,9 +10,9 @@ import (
 	"github.com/fluidkeys/crypto/openpgp/packet"
 	"github.com/fluidkeys/fluidkeys/fingerprint"
 	"github.com/fluidkeys/fluidkeys/pgpkey"
-	"github.com/fluidkeys/fluidkeys/policy"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -20,8 +21,8 @@ import (
 func sendSecretHandler(w http.ResponseWriter, r *http.Request) {
 	requestData := v1structs.SendSecretRequest{}
 
-	if err := decodeJsonRequest(r, &requestData); err != nil {
-		writeJsonError(w, err, http.StatusBadRequest)
+	if err := decodeJsonRequestWithLimit(r, &requestData, maxSendSecretRequestBytes()); err != nil {
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
 		return
 	}
 
@@ -43,14 +44,132 @@ func sendSecretHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = datastore.CreateSecret(*recipientFingerprint, requestData.ArmoredEncryptedSecret, time.Now())
+	if requestData.ArmoredEncryptedSenderHint != "" {
+		err = validateSenderHint(requestData.ArmoredEncryptedSenderHint, *recipientFingerprint)
+		if err != nil {
+			writeJsonError(w,
+				fmt.Errorf("invalid `armoredEncryptedSenderHint`: %v", err),
+				http.StatusBadRequest,
+			)
+			return
+		}
+	}
+
+	// force=true stores the secret even if the recipient's key has expired or been reported as
+	// compromised, for example if the recipient has asked for it regardless.
+	force := r.URL.Query().Get("force") == "true"
+
+	if !force && !recipientKeyIsUsable(w, *recipientFingerprint, time.Now()) {
+		return
+	}
+
+	secretUUID, err := datastore.CreateSecretWithSenderHint(
+		*recipientFingerprint,
+		requestData.ArmoredEncryptedSecret,
+		requestData.ArmoredEncryptedSenderHint,
+		time.Now(),
+	)
 	if err != nil {
 		writeJsonError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	w.Write(nil)
+	responseData := v1structs.SendSecretResponse{SecretUUID: secretUUID.String()}
+
+	senderToken, err := createSecretReceipt(*secretUUID, time.Now())
+	if err != nil {
+		// the secret's stored, so don't fail the request: the sender just can't check on it
+		log.Printf("error creating receipt for secret %s: %v", secretUUID, err)
+	} else {
+		responseData.SenderToken = senderToken
+	}
+
+	if auditSecretSends {
+		// validateSecret has already decoded the armor, so this can't fail in practice
+		sizeBytes, err := datastore.ArmoredSecretSize(requestData.ArmoredEncryptedSecret)
+		if err != nil {
+			log.Printf("error working out size of secret %s for audit: %v", secretUUID, err)
+		}
+
+		err = datastore.RecordSecretAudit(nil, datastore.SecretAudit{
+			CreatedAt:            time.Now(),
+			SecretUUID:           *secretUUID,
+			SenderIPAddress:      ipAddress(r),
+			RecipientFingerprint: *recipientFingerprint,
+			SizeBytes:            sizeBytes,
+		})
+		if err != nil {
+			// as with the receipt, the secret's stored so don't fail the request
+			log.Printf("error recording audit for secret %s: %v", secretUUID, err)
+		}
+	}
+
+	writeJsonResponseWithStatus(w, responseData, http.StatusCreated)
+}
+
+// maxSendSecretRequestBytes returns the largest request body the send secret endpoint accepts:
+// maxJsonRequestBytes, unless maxSecretBytes has been raised so far that the biggest allowed
+// secret wouldn't fit.
+func maxSendSecretRequestBytes() int {
+	// the armored secret can be 2 * maxSecretBytes, plus a bit more once it's escaped as JSON
+	needed := 3 * maxSecretBytes
+	if needed > maxJsonRequestBytes {
+		return needed
+	}
+	return maxJsonRequestBytes
+}
+
+// createSecretReceipt stores a receipt for the secret and returns the token the sender needs to
+// read it. Only the token's hash is stored.
+func createSecretReceipt(secretUUID uuid.UUID, now time.Time) (senderToken string, err error) {
+	tokenUUID, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("error making UUID: %v", err)
+	}
+	senderToken = tokenUUID.String()
+
+	if err := datastore.CreateSecretReceipt(nil, secretUUID, hashPassword(senderToken), now); err != nil {
+		return "", err
+	}
+	return senderToken, nil
+}
+
+// recipientKeyIsUsable returns true if secrets can be sent to the key with the given
+// fingerprint, or if not, writes out an error response to w: 409 if the key has expired or has
+// been reported as compromised. A missing key counts as usable, and is rejected by
+// datastore.CreateSecret.
+func recipientKeyIsUsable(
+	w http.ResponseWriter, recipientFingerprint fingerprint.Fingerprint, now time.Time) bool {
+
+	armoredPublicKey, found, err := datastore.GetArmoredPublicKeyForFingerprint(
+		recipientFingerprint)
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return false
+	} else if !found {
+		return true
+	}
+
+	compromisedAt, err := datastore.GetKeyCompromisedAt(nil, recipientFingerprint)
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return false
+	} else if compromisedAt != nil {
+		writeJsonError(w,
+			fmt.Errorf("recipient key has been reported as compromised"),
+			http.StatusConflict)
+		return false
+	}
+
+	key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("error loading key: %v", err), http.StatusInternalServerError)
+		return false
+	} else if datastore.AnyUIDHasExpired(key, now) {
+		writeJsonError(w, fmt.Errorf("recipient key has expired"), http.StatusConflict)
+		return false
+	}
+	return true
 }
 
 func listSecretsHandler(w http.ResponseWriter, r *http.Request) {
@@ -63,18 +182,36 @@ func listSecretsHandler(w http.ResponseWriter, r *http.Request) {
 
 	responseData := v1structs.ListSecretsResponse{}
 
-	secrets, err := datastore.GetSecrets(myPublicKey.Fingerprint())
+	// metadataOnly omits the (potentially large) encrypted content, for clients that only want
+	// to know which secrets are waiting.
+	metadataOnly := r.URL.Query().Get("metadataOnly") == "true"
+
+	getSecrets := datastore.GetSecrets
+	if metadataOnly {
+		getSecrets = datastore.GetSecretsMetadata
+	}
+
+	secrets, err := getSecrets(myPublicKey.Fingerprint())
 	if err != nil {
 		writeJsonError(w, fmt.Errorf("error getting secrets: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	total, err := datastore.CountSecretsForRecipient(nil, myPublicKey.Fingerprint())
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("error counting secrets: %v", err), http.StatusInternalServerError)
+		return
+	}
+	responseData.Total = total
+
 	responseData.Secrets = make([]v1structs.Secret, 0)
+	deliveredUUIDs := []uuid.UUID{}
 
 	for _, s := range secrets {
 		encryptedMetadata, err := encryptSecretMetadata(
 			v1structs.SecretMetadata{
-				SecretUUID: s.SecretUUID,
+				SecretUUID:                 s.SecretUUID,
+				ArmoredEncryptedSenderHint: s.ArmoredEncryptedSenderHint,
 			},
 			myPublicKey,
 		)
@@ -87,9 +224,19 @@ func listSecretsHandler(w http.ResponseWriter, r *http.Request) {
 		secret := v1structs.Secret{
 			EncryptedContent:  s.ArmoredEncryptedSecret,
 			EncryptedMetadata: encryptedMetadata,
+			SizeBytes:         s.SizeBytes,
 		}
 
 		responseData.Secrets = append(responseData.Secrets, secret)
+
+		if !metadataOnly {
+			deliveredUUIDs = append(deliveredUUIDs, uuid.FromStringOrNil(s.SecretUUID))
+		}
+	}
+
+	if err := datastore.MarkSecretsDelivered(nil, deliveredUUIDs, time.Now()); err != nil {
+		// don't stop the recipient getting their secrets just because the receipts failed
+		log.Printf("error marking secrets delivered: %v", err)
 	}
 
 	writeJsonResponse(w, responseData)
@@ -129,9 +276,8 @@ func validateSecret(armoredEncryptedSecret string, recipientFingerprint fingerpr
 		return fmt.Errorf("error decoding ASCII armor: %s", err)
 	}
 
-	if len(armoredEncryptedSecret) > 2*policy.SecretMaxSizeBytes {
-		return fmt.Errorf("secrets currently have a max size of %d bytes",
-			policy.SecretMaxSizeBytes)
+	if len(armoredEncryptedSecret) > 2*maxSecretBytes {
+		return fmt.Errorf("secrets currently have a max size of %d bytes", maxSecretBytes)
 	}
 
 	pkt1, err := packet.Read(block.Body)
@@ -156,21 +302,62 @@ func validateSecret(armoredEncryptedSecret string, recipientFingerprint fingerpr
 	return nil
 }
 
-func deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+// getInboxHandler summarises the secrets waiting for the authorized key, so clients can show
+// them without downloading the list.
+func getInboxHandler(w http.ResponseWriter, r *http.Request) {
 	myPublicKey, err := getAuthorizedUserPublicKey(r)
-
 	if err != nil {
 		writeJsonError(w, err, http.StatusUnauthorized)
 		return
 	}
 
+	inbox, err := datastore.SecretInboxSummary(nil, myPublicKey.Fingerprint())
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("error summarising secrets: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	responseData := v1structs.GetInboxResponse{
+		PendingSecrets: inbox.Count,
+		TotalSizeBytes: inbox.TotalSizeBytes,
+	}
+	if inbox.OldestCreatedAt != nil {
+		ageSeconds := int(time.Since(*inbox.OldestCreatedAt) / time.Second)
+		responseData.OldestSecretAgeSeconds = &ageSeconds
+	}
+	writeJsonResponse(w, responseData)
+}
+
+// maxSenderHintBytes is the largest sender hint we'll store, measured (roughly) as the plaintext
+// size. Hints are short labels, so this is much smaller than maxSecretBytes.
+const maxSenderHintBytes = 1024
+
+// validateSenderHint checks the sender hint is a (small) message encrypted in the same way as a
+// secret.
+func validateSenderHint(armoredEncryptedSenderHint string, recipientFingerprint fingerprint.Fingerprint) error {
+	if len(armoredEncryptedSenderHint) > 2*maxSenderHintBytes {
+		return fmt.Errorf("sender hints have a max size of %d bytes", maxSenderHintBytes)
+	}
+	return validateSecret(armoredEncryptedSenderHint, recipientFingerprint)
+}
+
+func deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	// validate the UUID here rather than in the route pattern so that a malformed UUID gets a
+	// JSON error like any other invalid input, rather than a bare 404
 	secretUUID, err := uuid.FromString(mux.Vars(r)["uuid"])
+	if err != nil || secretUUID.Version() != uuid.V4 {
+		writeJsonError(w, fmt.Errorf("invalid secret UUID"), http.StatusBadRequest)
+		return
+	}
+
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+
 	if err != nil {
-		writeJsonError(w, fmt.Errorf("error parsing UUID: %v", err), http.StatusBadRequest)
+		writeJsonError(w, err, http.StatusUnauthorized)
 		return
 	}
 
-	found, err := datastore.DeleteSecret(secretUUID, myPublicKey.Fingerprint())
+	found, err := datastore.DeleteSecret(nil, secretUUID, myPublicKey.Fingerprint())
 	if err != nil {
 		writeJsonError(w, fmt.Errorf("error deleting secret: %v", err), http.StatusInternalServerError)
 		return
@@ -179,6 +366,49 @@ func deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := datastore.MarkSecretDeleted(nil, secretUUID, time.Now()); err != nil {
+		log.Printf("error marking secret %s deleted: %v", secretUUID, err)
+	}
+
 	w.WriteHeader(http.StatusAccepted)
 	w.Write(nil)
 }
+
+// getSecretStatusHandler lets the sender of a secret find out whether the recipient has picked
+// it up. The sender proves they sent it with the token returned when the secret was created,
+// in the X-Sender-Token header.
+func getSecretStatusHandler(w http.ResponseWriter, r *http.Request) {
+	secretUUID, err := uuid.FromString(mux.Vars(r)["uuid"])
+	if err != nil || secretUUID.Version() != uuid.V4 {
+		writeJsonError(w, fmt.Errorf("invalid secret UUID"), http.StatusBadRequest)
+		return
+	}
+
+	senderToken := r.Header.Get("X-Sender-Token")
+	if senderToken == "" {
+		writeJsonError(w, fmt.Errorf("missing X-Sender-Token header"), http.StatusUnauthorized)
+		return
+	}
+
+	receipt, err := datastore.GetSecretReceipt(nil, secretUUID)
+	if err == datastore.ErrNotFound {
+		writeJsonError(w, errSecretReceiptNotFound, http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	tokenHash := hashPassword(senderToken)
+	if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(receipt.SenderTokenSHA256)) != 1 {
+		// same response as a missing receipt, so the token can't be used to probe for secrets
+		writeJsonError(w, errSecretReceiptNotFound, http.StatusNotFound)
+		return
+	}
+
+	writeJsonResponse(w, v1structs.GetSecretStatusResponse{
+		CreatedAt:   receipt.CreatedAt,
+		DeliveredAt: receipt.DeliveredAt,
+		DeletedAt:   receipt.DeletedAt,
+	})
+}
@@ -10,18 +10,45 @@ import (
 	"github.com/gorilla/mux"
 )
 
+var router *mux.Router
 var subrouter *mux.Router
 
 func init() {
-	r := mux.NewRouter()
-	subrouter = r.PathPrefix("/v1").Subrouter()
+	router = mux.NewRouter()
+	subrouter = router.PathPrefix("/v1").Subrouter()
+
+	router.Use(recoveryMiddleware)
+	router.Use(maintenanceModeMiddleware)
+	subrouter.Use(jsonContentTypeMiddleware)
+
+	router.HandleFunc("/", rootHandler).Methods("GET")
+
+	// HKP keyserver lookups live outside /v1 at the path keyserver clients expect
+	router.HandleFunc("/pks/lookup", hkpLookupHandler).Methods("GET")
+
+	// Web Key Directory lookups live outside /v1 at the paths OpenPGP clients expect
+	router.HandleFunc("/.well-known/openpgpkey/policy", wkdPolicyHandler).Methods("GET")
+	router.HandleFunc("/.well-known/openpgpkey/{domain}/policy", wkdPolicyHandler).Methods("GET")
+	router.HandleFunc("/.well-known/openpgpkey/hu/{hash:"+wkdHashPattern+"}", wkdKeyHandler).
+		Methods("GET")
+	router.HandleFunc(
+		"/.well-known/openpgpkey/{domain}/hu/{hash:"+wkdHashPattern+"}",
+		wkdKeyHandler,
+	).Methods("GET")
 
 	subrouter.HandleFunc("/ping/{word}", pingHandler).Methods("GET")
+	subrouter.HandleFunc("/version", getVersionHandler).Methods("GET")
+	subrouter.HandleFunc("/capabilities", getCapabilitiesHandler).Methods("GET")
 
 	subrouter.HandleFunc("/email/verify/{uuid:"+uuid4Pattern+"}", verifyEmailHandler).Methods("GET", "POST")
 
 	subrouter.HandleFunc("/email/{email}/key", getPublicKeyByEmailHandler).Methods("GET")
 	subrouter.HandleFunc("/email/{email}/key.asc", getASCIIArmoredPublicKeyByEmailHandler).Methods("GET")
+	subrouter.HandleFunc("/email/{email}/verification", getEmailVerificationHandler).Methods("GET")
+	subrouter.HandleFunc("/email/{email}/reverify", reverifyEmailHandler).Methods("POST")
+	subrouter.HandleFunc("/email/{email}/key-history", getEmailKeyHistoryHandler).Methods("GET")
+
+	subrouter.HandleFunc("/emails/verified", queryEmailsVerifiedHandler).Methods("POST")
 
 	subrouter.HandleFunc(
 		"/key/{fingerprint:"+v4FingerprintPattern+"}",
@@ -33,17 +60,52 @@ func init() {
 		getASCIIArmoredPublicKeyByFingerprintHandler,
 	).Methods("GET")
 
+	subrouter.HandleFunc(
+		"/key/{fingerprint:"+v4FingerprintPattern+"}/primary-email",
+		getPrimaryEmailHandler,
+	).Methods("GET")
+
+	subrouter.HandleFunc(
+		"/key/{fingerprint:"+v4FingerprintPattern+"}/rotate-password",
+		rotatePasswordHandler,
+	).Methods("POST")
+
+	subrouter.HandleFunc(
+		"/key/{fingerprint:"+v4FingerprintPattern+"}/verifications",
+		listVerificationsHandler,
+	).Methods("GET")
+
+	subrouter.HandleFunc(
+		"/key/{fingerprint:"+v4FingerprintPattern+"}/report-compromised",
+		reportKeyCompromisedHandler,
+	).Methods("POST")
+
+	subrouter.HandleFunc("/profile", getProfileHandler).Methods("GET")
+	subrouter.HandleFunc("/profile", updateProfileHandler).Methods("PATCH")
+
 	subrouter.HandleFunc("/keys", upsertPublicKeyHandler).Methods("POST")
 
 	subrouter.HandleFunc("/secrets", sendSecretHandler).Methods("POST")
 	subrouter.HandleFunc("/secrets", listSecretsHandler).Methods("GET")
-	subrouter.HandleFunc("/secrets/{uuid:"+uuid4Pattern+"}", deleteSecretHandler).Methods("DELETE")
+	subrouter.HandleFunc("/secrets/{uuid}", deleteSecretHandler).Methods("DELETE")
+	subrouter.HandleFunc("/secrets/{uuid}/status", getSecretStatusHandler).Methods("GET")
+	subrouter.HandleFunc("/inbox", getInboxHandler).Methods("GET")
 
 	subrouter.HandleFunc(
 		"/teams",
 		upsertTeamHandler,
 	).Methods("POST")
 
+	subrouter.HandleFunc(
+		"/teams",
+		listTeamsHandler,
+	).Methods("GET")
+
+	subrouter.HandleFunc(
+		"/teams/validate",
+		validateTeamRosterHandler,
+	).Methods("POST")
+
 	subrouter.HandleFunc(
 		"/team/{teamUUID}",
 		getTeamHandler,
@@ -64,22 +126,39 @@ func init() {
 		getTeamRosterHandler,
 	).Methods("GET")
 
+	subrouter.HandleFunc(
+		"/team/{teamUUID}/roster",
+		getTeamRosterHandler,
+	).Methods("HEAD")
+
+	subrouter.HandleFunc(
+		"/team/{teamUUID}/members/{fingerprint:"+v4FingerprintPattern+"}",
+		getTeamMemberHandler,
+	).Methods("GET")
+
 	subrouter.HandleFunc(
 		"/team/{teamUUID}/requests-to-join/{requestUUID}",
 		deleteRequestToJoinTeamHandler,
 	).Methods("DELETE")
 
+	subrouter.HandleFunc(
+		"/requests-to-join/{requestUUID}",
+		getRequestToJoinTeamHandler,
+	).Methods("GET")
+
 	subrouter.HandleFunc(
 		"/events",
 		createEventHandler,
 	).Methods("POST")
 
+	// only enabled in development: see emailPreview
+	subrouter.HandleFunc("/dev/email-preview/{templateID}", emailPreviewHandler).Methods("GET")
+
 }
 
 // Serve initializes the database and runs http.ListenAndServer
 func Serve() (exitCode int) {
-	http.Handle("/", subrouter)
-	err := http.ListenAndServe(getPort(), nil)
+	err := newHTTPServer(getPort(), router).ListenAndServe()
 	if err != nil {
 		log.Printf("error from ListenAndServe: %v", err)
 		return 1
@@ -87,6 +166,18 @@ func Serve() (exitCode int) {
 	return 0
 }
 
+// newHTTPServer returns a server for the handler with the configured timeouts. The default
+// http.Server has none, so a client could hold a connection open forever.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  httpReadTimeout,
+		WriteTimeout: httpWriteTimeout,
+		IdleTimeout:  httpIdleTimeout,
+	}
+}
+
 func getPort() string {
 	var port = os.Getenv("PORT")
 	// Set a default port if there is nothing in the environment
@@ -110,4 +201,8 @@ func pingHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 const uuid4Pattern string = `[0-9a-f]{8}\-[0-9a-f]{4}\-4[0-9a-f]{3}\-[89ab][0-9a-f]{3}\-[0-9a-f]{12}`
-const v4FingerprintPattern string = `[0-9A-F]{40}`
+
+// v4FingerprintPattern matches 40 hex characters, optionally in groups of 4 separated by spaces
+// (URL-encoded as %20), like fingerprints are usually displayed and copied
+const v4FingerprintPattern string = `[0-9A-F]{4}(?: {0,2}[0-9A-F]{4}){9}`
+const wkdHashPattern string = `[ybndrfg8ejkmcpqxot1uwisza345h769]{32}`
@@ -3,13 +3,17 @@ package server
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -38,6 +42,9 @@ func TestMain(m *testing.M) {
 		panic(fmt.Errorf("failed to migrate test database: %v", err))
 	}
 
+	// the example keys are 1024-bit RSA, which keeps the tests fast
+	minRSAKeyBits = 1024
+
 	code := m.Run()
 
 	err = datastore.DropAllTheTables()
@@ -64,6 +71,125 @@ func TestPingEndpoint(t *testing.T) {
 	})
 }
 
+func TestV4FingerprintPattern(t *testing.T) {
+	pattern := regexp.MustCompile("^" + v4FingerprintPattern + "$")
+
+	for _, fp := range []string{
+		"BB3C44BF188D56E635F4A092F73D2F0533D7F9D6",
+		"BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6",
+		"BB3C 44BF 188D 56E6 35F4 A092 F73D 2F05 33D7 F9D6",
+	} {
+		t.Run("matches "+fp, func(t *testing.T) {
+			assert.Equal(t, true, pattern.MatchString(fp))
+		})
+	}
+
+	for _, fp := range []string{
+		"BB3C44BF188D56E635F4A092F73D2F0533D7F9D",   // too short
+		"BB3C44BF188D56E635F4A092F73D2F0533D7F9D6A", // too long
+		"BB3C 44BF 188D 56E6 35F4   A092 F73D 2F05 33D7 F9D6",
+		" BB3C44BF188D56E635F4A092F73D2F0533D7F9D6",
+		"BB3C44BF188D56E635F4A092F73D2F0533D7F9D6 ",
+	} {
+		t.Run("doesn't match "+fp, func(t *testing.T) {
+			assert.Equal(t, false, pattern.MatchString(fp))
+		})
+	}
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	defer func(saved time.Duration) { httpReadTimeout = saved }(httpReadTimeout)
+	httpReadTimeout = time.Duration(100) * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	httpServer := newHTTPServer(listener.Addr().String(), router)
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+
+	t.Run("uses the configured timeouts", func(t *testing.T) {
+		assert.Equal(t, httpReadTimeout, httpServer.ReadTimeout)
+		assert.Equal(t, httpWriteTimeout, httpServer.WriteTimeout)
+		assert.Equal(t, httpIdleTimeout, httpServer.IdleTimeout)
+	})
+
+	t.Run("closes a request that's slow to send", func(t *testing.T) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		// send the start of the request, but never finish the headers
+		_, err = io.WriteString(conn, "GET /v1/ping/foo HTTP/1.1\r\nHost: localhost\r\n")
+		assert.NoError(t, err)
+
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, err = ioutil.ReadAll(conn)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			t.Fatalf("server didn't close the connection after its read timeout")
+		}
+	})
+}
+
+func TestRootHandler(t *testing.T) {
+	response := callAPI(t, "GET", "/", nil, nil)
+	assertStatusCode(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json", response.Header().Get("content-type"))
+
+	responseData := v1structs.GetRootResponse{}
+	assertBodyDecodesInto(t, response.Body, &responseData)
+
+	assert.Equal(t, "/v1", responseData.API)
+	assert.Equal(t, docsURL, responseData.Docs)
+}
+
+func TestGetVersionHandler(t *testing.T) {
+	defer func(version, commit string) { Version, Commit = version, commit }(Version, Commit)
+	Version = "1.2.3"
+	Commit = "0123456789abcdef0123456789abcdef01234567"
+
+	response := callAPI(t, "GET", "/v1/version", nil, nil)
+	assertStatusCode(t, http.StatusOK, response.Code)
+
+	responseData := v1structs.GetVersionResponse{}
+	assertBodyDecodesInto(t, response.Body, &responseData)
+
+	assert.Equal(t, "1.2.3", responseData.Version)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef01234567", responseData.Commit)
+}
+
+func TestGetCapabilitiesHandler(t *testing.T) {
+	t.Run("known capabilities are present", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/capabilities", nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		capabilities := map[string]interface{}{}
+		assertBodyDecodesInto(t, response.Body, &capabilities)
+
+		for _, key := range []string{
+			"wkd", "hkp", "batchEmailVerification", "secretReadReceipts",
+			"teamUpdateConflicts", "verificationHistory", "writable", "maxSecretBytes",
+		} {
+			if _, found := capabilities[key]; !found {
+				t.Errorf("expected capability `%s`, got %v", key, capabilities)
+			}
+		}
+	})
+
+	t.Run("not writable in maintenance mode", func(t *testing.T) {
+		defer func(level maintenanceLevel) { maintenanceMode = level }(maintenanceMode)
+		maintenanceMode = maintenanceWrites
+
+		response := callAPI(t, "GET", "/v1/capabilities", nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetCapabilitiesResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, false, responseData.Writable)
+		assert.Equal(t, maxSecretBytes, responseData.MaxSecretBytes)
+	})
+}
+
 func TestGetPublicKeyByEmailHandler(t *testing.T) {
 	assert.NoError(t,
 		datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4),
@@ -130,6 +256,15 @@ func TestGetPublicKeyByEmailHandler(t *testing.T) {
 			response := callAPI(t, "GET", "/v1/email/test4@example.com/key.asc", nil, nil)
 			assertStatusCode(t, http.StatusOK, response.Code)
 			assertBodyEqualTo(t, response.Body, exampledata.ExamplePublicKey4)
+
+			t.Run("response has pgp-keys content type", func(t *testing.T) {
+				assert.Equal(t, "application/pgp-keys", response.Header().Get("content-type"))
+			})
+
+			t.Run("response has email-based filename", func(t *testing.T) {
+				assert.Equal(t, `attachment; filename="test4@example.com.asc"`,
+					response.Header().Get("content-disposition"))
+			})
 		})
 
 		t.Run("with + in email, request not urlencoded", func(t *testing.T) {
@@ -142,6 +277,115 @@ func TestGetPublicKeyByEmailHandler(t *testing.T) {
 			assertStatusCode(t, http.StatusOK, response.Code)
 		})
 	})
+
+	t.Run("without a verification behind the link", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/email/test4@example.com/key.asc", nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+		assert.Equal(t, "", response.Header().Get("X-Key-Verified-At"))
+	})
+
+	t.Run("with a verified link", func(t *testing.T) {
+		verifiedAt := time.Date(2019, 7, 2, 11, 20, 0, 0, time.UTC)
+
+		verificationUUID, err := datastore.CreateVerification(
+			nil, "test4@example.com", exampledata.ExampleFingerprint4,
+			"fake user agent", "0.0.0.0", verifiedAt.Add(-time.Minute),
+		)
+		assert.NoError(t, err)
+		assert.NoError(t, datastore.MarkVerificationAsVerified(
+			nil, *verificationUUID, "fake user agent", "1.1.1.1", verifiedAt))
+		assert.NoError(t, datastore.LinkEmailToFingerprint(
+			nil, "test4@example.com", exampledata.ExampleFingerprint4, verificationUUID))
+
+		t.Run("JSON endpoint has verifiedAt", func(t *testing.T) {
+			response := callAPI(t, "GET", "/v1/email/test4@example.com/key", nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+
+			responseData := v1structs.GetPublicKeyResponse{}
+			assertBodyDecodesInto(t, response.Body, &responseData)
+			if responseData.VerifiedAt == nil {
+				t.Fatalf("expected verifiedAt, got nil")
+			}
+			assert.Equal(t, verifiedAt, responseData.VerifiedAt.UTC())
+		})
+
+		t.Run("ascii-armored endpoint has verified header", func(t *testing.T) {
+			response := callAPI(t, "GET", "/v1/email/test4@example.com/key.asc", nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assert.Equal(t, "2019-07-02T11:20:00Z", response.Header().Get("X-Key-Verified-At"))
+		})
+
+		t.Run("fingerprint endpoint doesn't have verifiedAt", func(t *testing.T) {
+			response := callAPI(t, "GET",
+				"/v1/key/"+exampledata.ExampleFingerprint4.Hex()+".asc", nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assert.Equal(t, "", response.Header().Get("X-Key-Verified-At"))
+		})
+	})
+}
+
+func TestGetPrimaryEmailHandler(t *testing.T) {
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	defer func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}()
+
+	primaryEmailPath := "/v1/key/" + exampledata.ExampleFingerprint4.Hex() + "/primary-email"
+
+	t.Run("unverified primary email", func(t *testing.T) {
+		response := callAPI(t, "GET", primaryEmailPath, nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetPrimaryEmailResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, "test4@example.com", responseData.Email)
+		assert.Equal(t, false, responseData.Verified)
+	})
+
+	t.Run("verified primary email", func(t *testing.T) {
+		assert.NoError(t, datastore.LinkEmailToFingerprint(
+			nil, "test4@example.com", exampledata.ExampleFingerprint4, nil))
+
+		response := callAPI(t, "GET", primaryEmailPath, nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetPrimaryEmailResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, "test4@example.com", responseData.Email)
+		assert.Equal(t, true, responseData.Verified)
+	})
+
+	t.Run("key without an email address", func(t *testing.T) {
+		entity, err := openpgp.NewEntity("No Email", "", "", nil)
+		assert.NoError(t, err)
+		key := pgpkey.PgpKey{Entity: *entity}
+		armoredPublicKey, err := key.Armor()
+		assert.NoError(t, err)
+
+		assert.NoError(t, datastore.UpsertPublicKey(nil, armoredPublicKey))
+		defer func() {
+			_, _, err := datastore.DeletePublicKey(nil, key.Fingerprint())
+			assert.NoError(t, err)
+		}()
+
+		response := callAPI(
+			t, "GET", "/v1/key/"+key.Fingerprint().Hex()+"/primary-email", nil, nil)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "key has no email address")
+	})
+
+	t.Run("key that isn't stored", func(t *testing.T) {
+		response := callAPI(t, "GET",
+			"/v1/key/"+exampledata.ExampleFingerprint3.Hex()+"/primary-email", nil, nil)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "test4+foo@example.com", sanitizeFilename("test4+foo@example.com"))
+	assert.Equal(t, "test4__@example.com", sanitizeFilename(`test4"/@example.com`))
+	assert.Equal(t, "a__b.asc", sanitizeFilename("a\r\nb.asc"))
 }
 
 func TestGetPublicKeyByFingerprintHandler(t *testing.T) {
@@ -171,9 +415,183 @@ func TestGetPublicKeyByFingerprintHandler(t *testing.T) {
 			responseData := v1structs.GetPublicKeyResponse{}
 			assertBodyDecodesInto(t, response.Body, &responseData)
 			assert.Equal(t, responseData.ArmoredPublicKey, exampledata.ExamplePublicKey4)
+			assert.Equal(t, false, responseData.Revoked)
+		})
+	})
+
+	t.Run("with since", func(t *testing.T) {
+		currentSHA256 := sha256.Sum256([]byte(exampledata.ExamplePublicKey4))
+		oldSHA256 := sha256.Sum256([]byte(exampleRevokedPublicKey4))
+
+		for _, path := range []string{
+			"/v1/key/" + exampledata.ExampleFingerprint4.Hex(),
+			"/v1/key/" + exampledata.ExampleFingerprint4.Hex() + ".asc",
+		} {
+			t.Run(path, func(t *testing.T) {
+				t.Run("matching the stored key returns 304", func(t *testing.T) {
+					response := callAPI(t, "GET",
+						fmt.Sprintf("%s?since=%x", path, currentSHA256), nil, nil)
+					assertStatusCode(t, http.StatusNotModified, response.Code)
+					assert.Equal(t, "", response.Body.String())
+				})
+
+				t.Run("matching the stored key in uppercase returns 304", func(t *testing.T) {
+					response := callAPI(t, "GET",
+						fmt.Sprintf("%s?since=%X", path, currentSHA256), nil, nil)
+					assertStatusCode(t, http.StatusNotModified, response.Code)
+				})
+
+				t.Run("not matching the stored key returns the key", func(t *testing.T) {
+					response := callAPI(t, "GET",
+						fmt.Sprintf("%s?since=%x", path, oldSHA256), nil, nil)
+					assertStatusCode(t, http.StatusOK, response.Code)
+					if !strings.Contains(response.Body.String(), "PGP PUBLIC KEY") {
+						t.Fatalf("expected the key, got %s", response.Body.String())
+					}
+				})
+
+				t.Run("malformed since returns 400", func(t *testing.T) {
+					response := callAPI(t, "GET", path+"?since=not-a-sha256", nil, nil)
+					assertStatusCode(t, http.StatusBadRequest, response.Code)
+					assertHasJSONErrorDetail(t, response.Body,
+						"invalid `since`: should be the hex SHA256 of the armored public key")
+				})
+			})
+		}
+	})
+
+	t.Run("with a spaced fingerprint", func(t *testing.T) {
+		// e.g. BB3C%2044BF%20188D%20...
+		spaced := url.PathEscape(exampledata.ExampleFingerprint4.String())
+
+		t.Run("JSON endpoint", func(t *testing.T) {
+			response := callAPI(t, "GET", "/v1/key/"+spaced, nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+
+			responseData := v1structs.GetPublicKeyResponse{}
+			assertBodyDecodesInto(t, response.Body, &responseData)
+			assert.Equal(t, exampledata.ExamplePublicKey4, responseData.ArmoredPublicKey)
+		})
+
+		t.Run("ascii-armored endpoint", func(t *testing.T) {
+			response := callAPI(t, "GET", "/v1/key/"+spaced+".asc", nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assertBodyEqualTo(t, response.Body, exampledata.ExamplePublicKey4)
+
+			t.Run("filename has no spaces", func(t *testing.T) {
+				assert.Equal(t,
+					"attachment; filename=\""+exampledata.ExampleFingerprint4.Hex()+".asc\"",
+					response.Header().Get("Content-Disposition"))
+			})
 		})
 	})
 
+	t.Run("negotiates content type from Accept header", func(t *testing.T) {
+		path := "/v1/key/" + exampledata.ExampleFingerprint4.Hex()
+
+		tests := []struct {
+			accept              string
+			expectedContentType string
+			armored             bool
+		}{
+			{"", "application/json", false},
+			{"*/*", "application/json", false},
+			{"application/json", "application/json", false},
+			{"application/pgp-keys", "application/pgp-keys", true},
+			{"text/plain", "text/plain; charset=utf-8", true},
+			{"text/html, text/plain;q=0.9, */*;q=0.8", "text/plain; charset=utf-8", true},
+		}
+
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("Accept: %s", test.accept), func(t *testing.T) {
+				response := callAPIWithHeaders(
+					t, "GET", path, nil, nil, map[string]string{"Accept": test.accept})
+				assertStatusCode(t, http.StatusOK, response.Code)
+				assert.Equal(t, test.expectedContentType, response.Header().Get("content-type"))
+				assert.Equal(t, "Accept", response.Header().Get("Vary"))
+
+				if test.armored {
+					assertBodyEqualTo(t, response.Body, exampledata.ExamplePublicKey4)
+				} else {
+					responseData := v1structs.GetPublicKeyResponse{}
+					assertBodyDecodesInto(t, response.Body, &responseData)
+					assert.Equal(t, exampledata.ExamplePublicKey4, responseData.ArmoredPublicKey)
+				}
+			})
+		}
+	})
+
+	t.Run("with a key that was revoked after upload", func(t *testing.T) {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampleRevokedPublicKey4))
+		defer func() {
+			assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		}()
+
+		t.Run("JSON endpoint has revoked flag", func(t *testing.T) {
+			response := callAPI(t,
+				"GET", "/v1/key/"+exampledata.ExampleFingerprint4.Hex(), nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+
+			responseData := v1structs.GetPublicKeyResponse{}
+			assertBodyDecodesInto(t, response.Body, &responseData)
+			assert.Equal(t, true, responseData.Revoked)
+		})
+
+		t.Run("ascii-armored endpoint has revoked header", func(t *testing.T) {
+			response := callAPI(t,
+				"GET", "/v1/key/"+exampledata.ExampleFingerprint4.Hex()+".asc", nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+			assert.Equal(t, exampleRevokedAt.Format(time.RFC3339),
+				response.Header().Get("X-Key-Revoked-At"))
+		})
+	})
+
+	t.Run("with a key that was deleted", func(t *testing.T) {
+		found, _, err := datastore.DeletePublicKeyWithReason(
+			exampledata.ExampleFingerprint4, "key expired",
+			time.Date(2019, 6, 12, 16, 35, 5, 0, time.UTC))
+		assert.NoError(t, err)
+		assert.Equal(t, true, found)
+		defer func() {
+			// uploading the key again forgets that it was deleted
+			assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		}()
+
+		for _, path := range []string{
+			"/v1/key/" + exampledata.ExampleFingerprint4.Hex(),
+			"/v1/key/" + exampledata.ExampleFingerprint4.Hex() + ".asc",
+		} {
+			t.Run(path, func(t *testing.T) {
+				response := callAPI(t, "GET", path, nil, nil)
+				assertStatusCode(t, http.StatusGone, response.Code)
+				assertHasJSONErrorDetail(t, response.Body,
+					"public key 'BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6' "+
+						"was deleted on 2019-06-12: key expired")
+			})
+		}
+	})
+
+	t.Run("with a key that has been banned", func(t *testing.T) {
+		assert.NoError(t, datastore.BanKey(
+			nil, exampledata.ExampleFingerprint4, "abuse", time.Now()))
+		defer func() {
+			_, err := datastore.UnbanKey(nil, exampledata.ExampleFingerprint4)
+			assert.NoError(t, err)
+		}()
+
+		for _, path := range []string{
+			"/v1/key/" + exampledata.ExampleFingerprint4.Hex(),
+			"/v1/key/" + exampledata.ExampleFingerprint4.Hex() + ".asc",
+			"/pks/lookup?op=get&search=0x" + exampledata.ExampleFingerprint4.Hex(),
+		} {
+			t.Run(path, func(t *testing.T) {
+				response := callAPI(t, "GET", path, nil, nil)
+				assertStatusCode(t, http.StatusGone, response.Code)
+				assertHasJSONErrorDetail(t, response.Body, errKeyBanned.Error())
+			})
+		}
+	})
+
 	t.Run("ascii-armored endpoint", func(t *testing.T) {
 		t.Run("with no matching fingerprint", func(t *testing.T) {
 			response := callAPI(t,
@@ -193,11 +611,46 @@ func TestGetPublicKeyByFingerprintHandler(t *testing.T) {
 			assertStatusCode(t, http.StatusOK, response.Code)
 
 			assertBodyEqualTo(t, response.Body, exampledata.ExamplePublicKey4)
+
+			t.Run("response has pgp-keys content type", func(t *testing.T) {
+				assert.Equal(t, "application/pgp-keys", response.Header().Get("content-type"))
+			})
+
+			t.Run("response has fingerprint filename", func(t *testing.T) {
+				assert.Equal(t,
+					`attachment; filename="BB3C44BF188D56E635F4A092F73D2F0533D7F9D6.asc"`,
+					response.Header().Get("content-disposition"))
+			})
 		})
 	})
 
 }
 
+func TestPreferredKeyContentType(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"text/html", "application/json"},
+		{"application/json", "application/json"},
+		{"application/pgp-keys", "application/pgp-keys"},
+		{"text/plain", "text/plain"},
+		{"application/pgp-keys, application/json", "application/pgp-keys"},
+		{"application/pgp-keys;q=0.5, application/json", "application/json"},
+		{"text/plain;q=0.9, application/pgp-keys;q=0.9", "text/plain"},
+		{"application/json;q=0, text/plain;q=0.1", "text/plain"},
+		{"application/pgp-keys;q=bad, text/plain", "text/plain"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			assert.Equal(t, test.expected, preferredKeyContentType(test.accept))
+		})
+	}
+}
+
 func TestUpsertPublicKeyHandler(t *testing.T) {
 	armoredPublicKey := exampledata.ExamplePublicKey4
 	validSha256 := fmt.Sprintf("%X", sha256.Sum256([]byte(exampledata.ExamplePublicKey4)))
@@ -209,16 +662,32 @@ func TestUpsertPublicKeyHandler(t *testing.T) {
 
 	uuid1 := uuid.Must(uuid.NewV4())
 	now := time.Date(2018, 6, 15, 16, 30, 0, 0, time.UTC)
+	const ip = "192.0.2.1"
 
 	setup := func() {
 
 	}
 
 	teardown := func() {
-		_, err := datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 	}
 
+	// assertRecordedSuspicious checks that the most recently recorded suspicious request is of
+	// the given kind, from key 4 and the test IP address.
+	assertRecordedSuspicious := func(t *testing.T, kind string) {
+		t.Helper()
+		requests, err := datastore.ListSuspiciousRequests(nil)
+		assert.NoError(t, err)
+		if len(requests) == 0 {
+			t.Fatalf("expected a suspicious request to be recorded, got none")
+		}
+		last := requests[len(requests)-1]
+		assert.Equal(t, kind, last.Kind)
+		assert.Equal(t, exampledata.ExampleFingerprint4, last.Fingerprint)
+		assert.Equal(t, ip, last.IPAddress)
+	}
+
 	makeSignedData := func(t *testing.T, timestamp time.Time, uuidString string, sha256 string) string {
 		t.Helper()
 		upsertPublicKeyJSON := new(bytes.Buffer)
@@ -243,36 +712,46 @@ func TestUpsertPublicKeyHandler(t *testing.T) {
 
 		truncatedSignature := goodSig[0 : len(goodSig)/2]
 
-		_, err := validateSignedData(truncatedSignature, armoredPublicKey, publicKey, now)
+		_, err := validateSignedData(truncatedSignature, armoredPublicKey, publicKey, ip, now)
 		assert.Equal(t, "failed to verify: error finding clearsigned data", err.Error())
 	})
 
 	t.Run("mismatching SHA256", func(t *testing.T) {
 		armoredSignedData := makeSignedData(t, now, uuid1.String(), "0a0a")
-		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, now)
+		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, ip, now)
 		assert.Equal(t, "mismatching public key SHA256", err.Error())
+		assertRecordedSuspicious(t, suspiciousMismatchingSHA256)
+	})
+
+	t.Run("SHA256 isn't hex", func(t *testing.T) {
+		armoredSignedData := makeSignedData(t, now, uuid1.String(), "not hex")
+		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, ip, now)
+		assert.Equal(t, "bad SHA256: encoding/hex: invalid byte: U+006E 'n'", err.Error())
+		assertRecordedSuspicious(t, suspiciousBadSHA256)
 	})
 
 	t.Run("timestamp too far in the future", func(t *testing.T) {
 		thirtyHoursFromNow := now.Add(time.Hour * time.Duration(30))
 		armoredSignedData := makeSignedData(t, thirtyHoursFromNow, uuid1.String(), validSha256)
 
-		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, now)
+		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, ip, now)
 		assert.Equal(t, "timestamp is not within 24 hours of server time", err.Error())
+		assertRecordedSuspicious(t, suspiciousTimestamp)
 	})
 
 	t.Run("timestamp too far in the past", func(t *testing.T) {
 		thirtyHoursInPast := now.Add(time.Hour * time.Duration(-30))
 		armoredSignedData := makeSignedData(t, thirtyHoursInPast, uuid1.String(), validSha256)
 
-		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, now)
+		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, ip, now)
 		assert.Equal(t, "timestamp is not within 24 hours of server time", err.Error())
+		assertRecordedSuspicious(t, suspiciousTimestamp)
 	})
 
 	t.Run("single use UUID not a valid UUID", func(t *testing.T) {
 		armoredSignedData := makeSignedData(t, now, "foo", validSha256)
 
-		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, now)
+		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, ip, now)
 		assert.Equal(t, "bad SingleUseUUID: uuid: incorrect UUID length: foo", err.Error())
 	})
 
@@ -283,12 +762,116 @@ func TestUpsertPublicKeyHandler(t *testing.T) {
 
 		armoredSignedData := makeSignedData(t, now, repeatedUUID.String(), validSha256)
 
-		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, now)
+		_, err := validateSignedData(armoredSignedData, armoredPublicKey, publicKey, ip, now)
 		assert.Equal(t, "bad SingleUseUUID: single use UUID already used", err.Error())
+		assertRecordedSuspicious(t, suspiciousSingleUseUUIDReused)
+	})
+
+	t.Run("single use UUID already used returns 409 with REPLAY_DETECTED", func(t *testing.T) {
+		repeatedUUID := uuid.Must(uuid.NewV4())
+		assert.NoError(t, datastore.StoreSingleUseNumber(nil, repeatedUUID, time.Now()))
+
+		requestData := v1structs.UpsertPublicKeyRequest{
+			ArmoredPublicKey: exampledata.ExamplePublicKey4,
+			ArmoredSignedJSON: makeSignedData(
+				t, time.Now(), repeatedUUID.String(), validSha256),
+		}
+
+		response := callAPI(t, "POST", "/v1/keys", requestData, nil)
+		assertStatusCode(t, http.StatusConflict, response.Code)
+
+		errorResponse := v1structs.ErrorResponse{}
+		assertBodyDecodesInto(t, response.Body, &errorResponse)
+		assert.Equal(t, "REPLAY_DETECTED", errorResponse.Code)
 	})
 
 	testEndpointRejectsBadJSON(t, "POST", "/v1/keys", nil)
 
+	t.Run("armored public key larger than the maximum size", func(t *testing.T) {
+		requestData := v1structs.UpsertPublicKeyRequest{
+			ArmoredPublicKey:  strings.Repeat("A", maxArmoredPublicKeyBytes+1),
+			ArmoredSignedJSON: makeSignedData(t, now, uuid1.String(), validSha256),
+		}
+
+		response := callAPI(t, "POST", "/v1/keys", requestData, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			fmt.Sprintf("armored public key is larger than the maximum of %d bytes",
+				maxArmoredPublicKeyBytes))
+	})
+
+	t.Run("revoked key", func(t *testing.T) {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+
+		revokedSha256 := fmt.Sprintf("%X", sha256.Sum256([]byte(exampleRevokedPublicKey4)))
+
+		requestData := v1structs.UpsertPublicKeyRequest{
+			ArmoredPublicKey: exampleRevokedPublicKey4,
+			ArmoredSignedJSON: makeSignedData(
+				t, time.Now(), uuid.Must(uuid.NewV4()).String(), revokedSha256),
+		}
+
+		response := callAPI(t, "POST", "/v1/keys", requestData, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errKeyRevoked.Error())
+
+		t.Run("isn't stored", func(t *testing.T) {
+			_, found, err := datastore.GetArmoredPublicKeyForFingerprint(
+				exampledata.ExampleFingerprint4)
+			assert.NoError(t, err)
+			assert.Equal(t, false, found)
+		})
+	})
+
+	t.Run("banned key", func(t *testing.T) {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+
+		assert.NoError(t, datastore.BanKey(
+			nil, exampledata.ExampleFingerprint4, "abuse", time.Now()))
+		defer func() {
+			_, err := datastore.UnbanKey(nil, exampledata.ExampleFingerprint4)
+			assert.NoError(t, err)
+		}()
+
+		requestData := v1structs.UpsertPublicKeyRequest{
+			ArmoredPublicKey: exampledata.ExamplePublicKey4,
+			ArmoredSignedJSON: makeSignedData(
+				t, time.Now(), uuid.Must(uuid.NewV4()).String(), validSha256),
+		}
+
+		response := callAPI(t, "POST", "/v1/keys", requestData, nil)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errKeyBanned.Error())
+
+		t.Run("isn't stored", func(t *testing.T) {
+			_, found, err := datastore.GetArmoredPublicKeyForFingerprint(
+				exampledata.ExampleFingerprint4)
+			assert.NoError(t, err)
+			assert.Equal(t, false, found)
+		})
+	})
+
+	t.Run("key weaker than the minimum", func(t *testing.T) {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+
+		defer func(saved int) { minRSAKeyBits = saved }(minRSAKeyBits)
+		minRSAKeyBits = 2048
+
+		requestData := v1structs.UpsertPublicKeyRequest{
+			ArmoredPublicKey: exampledata.ExamplePublicKey4,
+			ArmoredSignedJSON: makeSignedData(
+				t, time.Now(), uuid.Must(uuid.NewV4()).String(), validSha256),
+		}
+
+		response := callAPI(t, "POST", "/v1/keys", requestData, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"primary key is too weak: RSA key is 1024 bits, the minimum is 2048")
+	})
+
 	t.Run("valid signed data, brand new key", func(t *testing.T) {
 
 		requestData := v1structs.UpsertPublicKeyRequest{
@@ -315,14 +898,307 @@ func TestUpsertPublicKeyHandler(t *testing.T) {
 		newPasswordReader, err := decryptMessage(responseData.ArmoredEncryptedBasicAuthPassword, unlockedKey)
 		assert.NoError(t, err)
 
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(newPasswordReader)
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(newPasswordReader)
+
+		_, err = uuid.FromString(buf.String())
+		assert.NoError(t, err)
+
+		t.Run("stores hash of password", func(t *testing.T) {
+			storedHash, err := datastore.GetBasicAuthPasswordHash(nil, exampledata.ExampleFingerprint4)
+			assert.NoError(t, err)
+			assert.Equal(t, hashPassword(buf.String()), storedHash)
+		})
+
+		t.Run("doesn't return verification URLs", func(t *testing.T) {
+			assert.Equal(t, 0, len(responseData.VerificationUrls))
+		})
+	})
+
+	t.Run("RETURN_VERIFICATION_URLS returns the verification URLs sent", func(t *testing.T) {
+		defer func(saved bool) { returnVerificationUrls = saved }(returnVerificationUrls)
+		returnVerificationUrls = true
+
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+
+		// emails with an active verification from an earlier upload won't be sent another
+		expectedEmails := []string{}
+		for _, email := range publicKey.Emails(true) {
+			hasActive, err := datastore.HasActiveVerificationForEmail(nil, email)
+			assert.NoError(t, err)
+			if !hasActive {
+				expectedEmails = append(expectedEmails, email)
+			}
+		}
+
+		requestData := v1structs.UpsertPublicKeyRequest{
+			ArmoredPublicKey: exampledata.ExamplePublicKey4,
+			ArmoredSignedJSON: makeSignedData(
+				t, time.Now(), uuid.Must(uuid.NewV4()).String(), validSha256),
+		}
+
+		response := callAPI(t, "POST", "/v1/keys", requestData, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.UpsertPublicKeyResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		assert.Equal(t, len(expectedEmails), len(responseData.VerificationUrls))
+		for _, email := range expectedEmails {
+			verificationUrl, got := responseData.VerificationUrls[email]
+			if !got {
+				t.Fatalf("no verification URL for %s", email)
+			}
+
+			secretUUID := verificationUrl[strings.LastIndex(verificationUrl, "/")+1:]
+			verification, err := datastore.GetVerification(
+				nil, uuid.Must(uuid.FromString(secretUUID)), time.Now())
+			assert.NoError(t, err)
+			assert.Equal(t, email, verification.EmailSentTo)
+		}
+	})
+
+	teardown()
+}
+
+func TestRotatePasswordHandler(t *testing.T) {
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+
+	path := "/v1/key/" + exampledata.ExampleFingerprint4.Hex() + "/rotate-password"
+
+	setup := func() {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+		assert.NoError(t, datastore.SetBasicAuthPasswordHash(
+			nil, exampledata.ExampleFingerprint4, hashPassword("old password")))
+		assert.NoError(t, datastore.SetBasicAuthPasswordHash(
+			nil, exampledata.ExampleFingerprint3, hashPassword("other password")))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+	}
+
+	assertPasswordUnchanged := func(t *testing.T) {
+		t.Helper()
+		storedHash, err := datastore.GetBasicAuthPasswordHash(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		assert.Equal(t, hashPassword("old password"), storedHash)
+	}
+
+	setup()
+	defer teardown()
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "POST", path, nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("authorized with tmpfingerprint", func(t *testing.T) {
+		response := callAPI(t, "POST", path, nil, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errAuthPasswordRequired.Error())
+		assertPasswordUnchanged(t)
+	})
+
+	t.Run("with the wrong password", func(t *testing.T) {
+		response := callAPIWithHeaders(t, "POST", path, nil, nil,
+			basicAuthHeader(exampledata.ExampleFingerprint4, "wrong password"))
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errAuthPasswordIncorrect.Error())
+		assertPasswordUnchanged(t)
+	})
+
+	t.Run("authorized as a different key", func(t *testing.T) {
+		response := callAPIWithHeaders(t, "POST", path, nil, nil,
+			basicAuthHeader(exampledata.ExampleFingerprint3, "other password"))
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"can only rotate the password for the authorized key")
+		assertPasswordUnchanged(t)
+	})
+
+	t.Run("authorized as the key", func(t *testing.T) {
+		response := callAPIWithHeaders(t, "POST", path, nil, nil,
+			basicAuthHeader(exampledata.ExampleFingerprint4, "old password"))
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.RotatePasswordResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		newPasswordReader, err := decryptMessage(
+			responseData.ArmoredEncryptedBasicAuthPassword, unlockedKey)
+		assert.NoError(t, err)
+
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(newPasswordReader)
+		newPassword := buf.String()
+
+		t.Run("new password is a UUID", func(t *testing.T) {
+			_, err = uuid.FromString(newPassword)
+			assert.NoError(t, err)
+		})
+
+		t.Run("stored hash is replaced", func(t *testing.T) {
+			storedHash, err := datastore.GetBasicAuthPasswordHash(
+				nil, exampledata.ExampleFingerprint4)
+			assert.NoError(t, err)
+			assert.Equal(t, hashPassword(newPassword), storedHash)
+		})
+
+		t.Run("old password no longer works", func(t *testing.T) {
+			response := callAPIWithHeaders(t, "POST", path, nil, nil,
+				basicAuthHeader(exampledata.ExampleFingerprint4, "old password"))
+			assertStatusCode(t, http.StatusUnauthorized, response.Code)
+		})
+
+		t.Run("new password authorizes other calls", func(t *testing.T) {
+			response := callAPIWithHeaders(t, "GET", "/v1/profile", nil, nil,
+				basicAuthHeader(exampledata.ExampleFingerprint4, newPassword))
+			assertStatusCode(t, http.StatusOK, response.Code)
+		})
+	})
+}
+
+func TestReportKeyCompromisedHandler(t *testing.T) {
+	path := "/v1/key/" + exampledata.ExampleFingerprint4.Hex() + "/report-compromised"
+
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+	otherUnlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey3, "test3")
+	assert.NoError(t, err)
+
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	assert.NoError(t, datastore.LinkEmailToFingerprint(
+		nil, "test4@example.com", exampledata.ExampleFingerprint4, nil))
+	defer func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+	}()
+
+	assertNotCompromised := func(t *testing.T) {
+		t.Helper()
+		compromisedAt, err := datastore.GetKeyCompromisedAt(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		if compromisedAt != nil {
+			t.Fatalf("expected key not to be compromised, got compromisedAt=%v", compromisedAt)
+		}
+	}
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "POST", path, nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("authorized as a different key", func(t *testing.T) {
+		requestData := makeSignedRequestBody(
+			t, otherUnlockedKey,
+			newSignedRequestData(v1structs.SignedRequestActionReportKeyCompromised))
+
+		response := callAPI(t, "POST", path, requestData, &exampledata.ExampleFingerprint3)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"can only report the authorized key as compromised")
+		assertNotCompromised(t)
+	})
+
+	t.Run("without a signed request", func(t *testing.T) {
+		response := callAPI(
+			t, "POST", path, v1structs.SignedRequest{}, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "missing armoredSignedJSON")
+		assertNotCompromised(t)
+	})
+
+	t.Run("request signed by a different key", func(t *testing.T) {
+		requestData := makeSignedRequestBody(
+			t, otherUnlockedKey,
+			newSignedRequestData(v1structs.SignedRequestActionReportKeyCompromised))
+
+		response := callAPI(t, "POST", path, requestData, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertNotCompromised(t)
+	})
+
+	t.Run("request signed for a different action", func(t *testing.T) {
+		requestData := makeSignedRequestBody(
+			t, unlockedKey, newSignedRequestData("some_other_action"))
+
+		response := callAPI(t, "POST", path, requestData, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"signed action is `some_other_action`, expected `report_key_compromised`")
+		assertNotCompromised(t)
+	})
+
+	t.Run("lookups aren't flagged before the key is reported", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/email/test4@example.com/key", nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetPublicKeyResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, false, responseData.Compromised)
+	})
+
+	t.Run("authorized as and signed by the key", func(t *testing.T) {
+		requestData := makeSignedRequestBody(
+			t, unlockedKey,
+			newSignedRequestData(v1structs.SignedRequestActionReportKeyCompromised))
+
+		response := callAPI(t, "POST", path, requestData, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.ReportKeyCompromisedResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		compromisedAt, err := datastore.GetKeyCompromisedAt(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		if compromisedAt == nil || !compromisedAt.Equal(responseData.CompromisedAt) {
+			t.Fatalf("expected stored compromisedAt=%v, got %v",
+				responseData.CompromisedAt, compromisedAt)
+		}
+
+		t.Run("replaying the request is refused", func(t *testing.T) {
+			response := callAPI(t, "POST", path, requestData, &exampledata.ExampleFingerprint4)
+			assertStatusCode(t, http.StatusConflict, response.Code)
+			assertHasJSONErrorDetail(t, response.Body, errReplayDetected.Error())
+		})
+	})
+
+	t.Run("JSON lookups are flagged as compromised", func(t *testing.T) {
+		for _, lookupPath := range []string{
+			"/v1/email/test4@example.com/key",
+			"/v1/key/" + exampledata.ExampleFingerprint4.Hex(),
+		} {
+			response := callAPI(t, "GET", lookupPath, nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
+
+			responseData := v1structs.GetPublicKeyResponse{}
+			assertBodyDecodesInto(t, response.Body, &responseData)
+			assert.Equal(t, true, responseData.Compromised)
+		}
+	})
+
+	t.Run("ascii-armored lookups have a compromised header", func(t *testing.T) {
+		for _, lookupPath := range []string{
+			"/v1/email/test4@example.com/key.asc",
+			"/v1/key/" + exampledata.ExampleFingerprint4.Hex() + ".asc",
+		} {
+			response := callAPI(t, "GET", lookupPath, nil, nil)
+			assertStatusCode(t, http.StatusOK, response.Code)
 
-		_, err = uuid.FromString(buf.String())
-		assert.NoError(t, err)
+			_, err := time.Parse(time.RFC3339, response.Header().Get("X-Key-Compromised-At"))
+			assert.NoError(t, err)
+		}
 	})
-
-	teardown()
 }
 
 func TestSendSecretHandler(t *testing.T) {
@@ -342,10 +1218,10 @@ func TestSendSecretHandler(t *testing.T) {
 		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
 	}
 	teardown := func() {
-		_, err := datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 
-		_, err = datastore.DeletePublicKey(exampledata.ExampleFingerprint3)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint3)
 		assert.NoError(t, err)
 	}
 
@@ -359,6 +1235,76 @@ func TestSendSecretHandler(t *testing.T) {
 
 		response := callAPI(t, "POST", "/v1/secrets", requestData, nil)
 		assertStatusCode(t, http.StatusCreated, response.Code)
+
+		responseData := v1structs.SendSecretResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		secretUUID, err := uuid.FromString(responseData.SecretUUID)
+		assert.NoError(t, err)
+		assert.Equal(t, uuid.V4, secretUUID.Version())
+
+		if responseData.SenderToken == "" {
+			t.Fatalf("expected a senderToken, got none")
+		}
+	})
+
+	t.Run("with a sender hint", func(t *testing.T) {
+		senderHint, err := encryptStringToArmor("from alice", key)
+		assert.NoError(t, err)
+
+		requestData := v1structs.SendSecretRequest{
+			RecipientFingerprint:       key.Fingerprint().Uri(),
+			ArmoredEncryptedSecret:     validEncryptedArmoredSecret,
+			ArmoredEncryptedSenderHint: senderHint,
+		}
+
+		response := callAPI(t, "POST", "/v1/secrets", requestData, nil)
+		assertStatusCode(t, http.StatusCreated, response.Code)
+
+		responseData := v1structs.SendSecretResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		t.Run("hint is stored with the secret", func(t *testing.T) {
+			secrets, err := datastore.GetSecretsMetadata(key.Fingerprint())
+			assert.NoError(t, err)
+
+			for _, s := range secrets {
+				if s.SecretUUID == responseData.SecretUUID {
+					assert.Equal(t, senderHint, s.ArmoredEncryptedSenderHint)
+					return
+				}
+			}
+			t.Fatalf("secret %s wasn't stored", responseData.SecretUUID)
+		})
+	})
+
+	t.Run("sender hint that isn't an encrypted message", func(t *testing.T) {
+		requestData := v1structs.SendSecretRequest{
+			RecipientFingerprint:       key.Fingerprint().Uri(),
+			ArmoredEncryptedSecret:     validEncryptedArmoredSecret,
+			ArmoredEncryptedSenderHint: "from alice",
+		}
+
+		response := callAPI(t, "POST", "/v1/secrets", requestData, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"invalid `armoredEncryptedSenderHint`: error decoding ASCII armor: EOF")
+	})
+
+	t.Run("sender hint longer than maxSenderHintBytes", func(t *testing.T) {
+		senderHint, err := encryptStringToArmor(strings.Repeat("a", 2*maxSenderHintBytes), key)
+		assert.NoError(t, err)
+
+		requestData := v1structs.SendSecretRequest{
+			RecipientFingerprint:       key.Fingerprint().Uri(),
+			ArmoredEncryptedSecret:     validEncryptedArmoredSecret,
+			ArmoredEncryptedSenderHint: senderHint,
+		}
+
+		response := callAPI(t, "POST", "/v1/secrets", requestData, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"invalid `armoredEncryptedSenderHint`: sender hints have a max size of 1024 bytes")
 	})
 
 	testEndpointRejectsBadJSON(t, "POST", "/v1/keys", nil)
@@ -444,6 +1390,53 @@ func TestSendSecretHandler(t *testing.T) {
 		// 	"secret is encryped to a different key")
 	})
 
+	t.Run("recipient key has been reported as compromised", func(t *testing.T) {
+		assert.NoError(t, datastore.SetKeyCompromised(nil, key.Fingerprint(), time.Now()))
+		defer func() {
+			// upserting doesn't clear compromised_at, so re-create the key
+			_, _, err := datastore.DeletePublicKey(nil, key.Fingerprint())
+			assert.NoError(t, err)
+			assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		}()
+
+		requestData := v1structs.SendSecretRequest{
+			RecipientFingerprint:   key.Fingerprint().Uri(),
+			ArmoredEncryptedSecret: validEncryptedArmoredSecret,
+		}
+
+		response := callAPI(t, "POST", "/v1/secrets", requestData, nil)
+		assertStatusCode(t, http.StatusConflict, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"recipient key has been reported as compromised")
+
+		t.Run("unless forced", func(t *testing.T) {
+			response := callAPI(t, "POST", "/v1/secrets?force=true", requestData, nil)
+			assertStatusCode(t, http.StatusCreated, response.Code)
+		})
+	})
+
+	t.Run("recipient key has expired", func(t *testing.T) {
+		assert.NoError(t, datastore.UpsertPublicKey(nil,
+			makeExpiredPublicKey(t, exampledata.ExamplePrivateKey4, "test4")))
+		defer func() {
+			assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		}()
+
+		requestData := v1structs.SendSecretRequest{
+			RecipientFingerprint:   key.Fingerprint().Uri(),
+			ArmoredEncryptedSecret: validEncryptedArmoredSecret,
+		}
+
+		response := callAPI(t, "POST", "/v1/secrets", requestData, nil)
+		assertStatusCode(t, http.StatusConflict, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "recipient key has expired")
+
+		t.Run("unless forced", func(t *testing.T) {
+			response := callAPI(t, "POST", "/v1/secrets?force=true", requestData, nil)
+			assertStatusCode(t, http.StatusCreated, response.Code)
+		})
+	})
+
 	t.Run("armoredEncryptedSecret longer then 20K", func(t *testing.T) {
 		const msgLength int = 21 * 1024
 		const letter rune = 'a'
@@ -466,6 +1459,87 @@ func TestSendSecretHandler(t *testing.T) {
 				"max size of 10240 bytes")
 	})
 
+	t.Run("MAX_SECRET_BYTES override", func(t *testing.T) {
+		defer func(previous int) { maxSecretBytes = previous }(maxSecretBytes)
+
+		makeRequest := func(plaintextLength int) v1structs.SendSecretRequest {
+			encrypted, err := encryptStringToArmor(strings.Repeat("a", plaintextLength), key)
+			assert.NoError(t, err)
+			return v1structs.SendSecretRequest{
+				RecipientFingerprint:   key.Fingerprint().Uri(),
+				ArmoredEncryptedSecret: encrypted,
+			}
+		}
+
+		t.Run("raised limit accepts a secret over the default", func(t *testing.T) {
+			maxSecretBytes = 100 * 1024
+
+			response := callAPI(t, "POST", "/v1/secrets", makeRequest(60*1024), nil)
+			assertStatusCode(t, http.StatusCreated, response.Code)
+		})
+
+		t.Run("lowered limit rejects a secret under the default", func(t *testing.T) {
+			maxSecretBytes = 1024
+
+			response := callAPI(t, "POST", "/v1/secrets", makeRequest(2*1024), nil)
+			assertStatusCode(t, http.StatusBadRequest, response.Code)
+			assertHasJSONErrorDetail(t, response.Body,
+				"invalid `armoredEncryptedSecret`: secrets currently have a "+
+					"max size of 1024 bytes")
+		})
+	})
+
+	t.Run("audit of secret sends", func(t *testing.T) {
+		defer func(previous bool) { auditSecretSends = previous }(auditSecretSends)
+
+		requestData := v1structs.SendSecretRequest{
+			RecipientFingerprint:   key.Fingerprint().Uri(),
+			ArmoredEncryptedSecret: validEncryptedArmoredSecret,
+		}
+		headers := map[string]string{"X-Forwarded-For": "192.0.2.7"}
+
+		t.Run("nothing recorded when disabled", func(t *testing.T) {
+			auditSecretSends = false
+
+			response := callAPIWithHeaders(t, "POST", "/v1/secrets", requestData, nil, headers)
+			assertStatusCode(t, http.StatusCreated, response.Code)
+
+			audits, err := datastore.ListSecretAudits(nil)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, len(audits))
+		})
+
+		t.Run("metadata recorded when enabled", func(t *testing.T) {
+			auditSecretSends = true
+
+			response := callAPIWithHeaders(t, "POST", "/v1/secrets", requestData, nil, headers)
+			assertStatusCode(t, http.StatusCreated, response.Code)
+
+			responseData := v1structs.SendSecretResponse{}
+			assertBodyDecodesInto(t, response.Body, &responseData)
+
+			audits, err := datastore.ListSecretAudits(nil)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(audits))
+
+			assert.Equal(t, responseData.SecretUUID, audits[0].SecretUUID.String())
+			assert.Equal(t, "192.0.2.7", audits[0].SenderIPAddress)
+			assert.Equal(t, key.Fingerprint(), audits[0].RecipientFingerprint)
+			assert.Equal(t, decodedArmorLength(t, validEncryptedArmoredSecret), audits[0].SizeBytes)
+		})
+
+		t.Run("audit doesn't hold the secret", func(t *testing.T) {
+			audits, err := datastore.ListSecretAudits(nil)
+			assert.NoError(t, err)
+
+			for _, audit := range audits {
+				if strings.Contains(fmt.Sprintf("%+v", audit), "PGP MESSAGE") {
+					t.Fatalf("audit record contains the secret: %+v", audit)
+				}
+			}
+		})
+	})
+
 	teardown()
 
 }
@@ -492,13 +1566,13 @@ func TestListSecretsHandler(t *testing.T) {
 		assert.NoError(t, err)
 	}
 	teardown := func() {
-		_, err := datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 
-		_, err = datastore.DeletePublicKey(exampledata.ExampleFingerprint3)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint3)
 		assert.NoError(t, err)
 
-		_, err = datastore.DeleteSecret(*secretUUID, exampledata.ExampleFingerprint4)
+		_, err = datastore.DeleteSecret(nil, *secretUUID, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 	}
 
@@ -559,6 +1633,7 @@ func TestListSecretsHandler(t *testing.T) {
 		err = json.NewDecoder(response.Body).Decode(&responseData)
 		assert.NoError(t, err)
 		assert.Equal(t, 0, len(responseData.Secrets))
+		assert.Equal(t, 0, responseData.Total)
 	})
 
 	t.Run("valid request with 1 secret", func(t *testing.T) {
@@ -593,10 +1668,19 @@ func TestListSecretsHandler(t *testing.T) {
 			assert.Equal(t, 1, len(responseData.Secrets))
 		})
 
+		t.Run("total matches the number of secrets", func(t *testing.T) {
+			assert.Equal(t, 1, responseData.Total)
+		})
+
 		t.Run("encryptedContent is unaltered", func(t *testing.T) {
 			assert.Equal(t, validEncryptedArmoredSecret, responseData.Secrets[0].EncryptedContent)
 		})
 
+		t.Run("sizeBytes is the decoded length of encryptedContent", func(t *testing.T) {
+			assert.Equal(t, decodedArmorLength(t, validEncryptedArmoredSecret),
+				responseData.Secrets[0].SizeBytes)
+		})
+
 		t.Run("encryptedMetadata can be decrypted", func(t *testing.T) {
 			privateKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
 				exampledata.ExamplePrivateKey4, "test4")
@@ -624,10 +1708,109 @@ func TestListSecretsHandler(t *testing.T) {
 
 	})
 
+	t.Run("with metadataOnly=true", func(t *testing.T) {
+		response := callAPI(
+			t, "GET", "/v1/secrets?metadataOnly=true", nil, &exampledata.ExampleFingerprint4,
+		)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		t.Run("encryptedContent is omitted from JSON", func(t *testing.T) {
+			if strings.Contains(response.Body.String(), "encryptedContent") {
+				t.Fatalf("expected no encryptedContent, got %s", response.Body.String())
+			}
+		})
+
+		responseData := v1structs.ListSecretsResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, 1, len(responseData.Secrets))
+		assert.Equal(t, 1, responseData.Total)
+
+		t.Run("includes sizeBytes", func(t *testing.T) {
+			assert.Equal(t, decodedArmorLength(t, validEncryptedArmoredSecret),
+				responseData.Secrets[0].SizeBytes)
+		})
+
+		t.Run("encryptedMetadata has correct secret UUID", func(t *testing.T) {
+			privateKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
+				exampledata.ExamplePrivateKey4, "test4")
+			assert.NoError(t, err)
+			msg, err := decryptMessage(responseData.Secrets[0].EncryptedMetadata, privateKey)
+			assert.NoError(t, err)
+
+			metadata := v1structs.SecretMetadata{}
+			assert.NoError(t, json.NewDecoder(msg).Decode(&metadata))
+			assert.Equal(t, secretUUID.String(), metadata.SecretUUID)
+		})
+	})
+
 	teardown()
 
 }
 
+func TestGetInboxHandler(t *testing.T) {
+	key, err := pgpkey.LoadFromArmoredPublicKey(exampledata.ExamplePublicKey4)
+	assert.NoError(t, err)
+
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	defer func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+		assert.NoError(t, err)
+	}()
+
+	armoredSecret, err := encryptStringToArmor("test foo", key)
+	assert.NoError(t, err)
+
+	sentAt := time.Now().Add(-time.Duration(2) * time.Hour)
+	for i := 0; i < 2; i++ {
+		_, err := datastore.CreateSecret(exampledata.ExampleFingerprint4, armoredSecret, sentAt)
+		assert.NoError(t, err)
+	}
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/inbox", nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("with no secrets", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/inbox", nil, &exampledata.ExampleFingerprint3)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		t.Run("oldestSecretAgeSeconds is omitted", func(t *testing.T) {
+			if strings.Contains(response.Body.String(), "oldestSecretAgeSeconds") {
+				t.Fatalf("expected no oldestSecretAgeSeconds, got %s", response.Body.String())
+			}
+		})
+
+		responseData := v1structs.GetInboxResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, 0, responseData.PendingSecrets)
+		assert.Equal(t, 0, responseData.TotalSizeBytes)
+	})
+
+	t.Run("with secrets", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/inbox", nil, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetInboxResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, 2, responseData.PendingSecrets)
+		assert.Equal(t, 2*decodedArmorLength(t, armoredSecret), responseData.TotalSizeBytes)
+
+		t.Run("oldestSecretAgeSeconds is the age of the oldest secret", func(t *testing.T) {
+			if responseData.OldestSecretAgeSeconds == nil {
+				t.Fatalf("expected oldestSecretAgeSeconds, got none")
+			}
+			age := *responseData.OldestSecretAgeSeconds
+			if age < 2*60*60 || age > 2*60*60+60 {
+				t.Fatalf("expected an age of about 2 hours, got %d seconds", age)
+			}
+		})
+	})
+}
+
 func TestDeleteSecretHandler(t *testing.T) {
 	key, err := pgpkey.LoadFromArmoredPublicKey(exampledata.ExamplePublicKey4)
 	assert.NoError(t, err)
@@ -647,7 +1830,7 @@ func TestDeleteSecretHandler(t *testing.T) {
 		assert.NoError(t, err)
 	}
 	teardown := func() {
-		_, err := datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 	}
 
@@ -659,7 +1842,16 @@ func TestDeleteSecretHandler(t *testing.T) {
 		response := httptest.NewRecorder()
 		subrouter.ServeHTTP(response, req)
 
-		assertStatusCode(t, http.StatusNotFound, response.Code)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "invalid secret UUID")
+	})
+
+	t.Run("non-v4 UUID in URL", func(t *testing.T) {
+		response := callAPI(t, "DELETE", "/v1/secrets/"+uuid.Nil.String(), nil,
+			&exampledata.ExampleFingerprint4)
+
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "invalid secret UUID")
 	})
 
 	t.Run("without authorization header", func(t *testing.T) {
@@ -708,9 +1900,114 @@ func TestDeleteSecretHandler(t *testing.T) {
 	teardown()
 }
 
+func TestGetSecretStatusHandler(t *testing.T) {
+	key, err := pgpkey.LoadFromArmoredPublicKey(exampledata.ExamplePublicKey4)
+	assert.NoError(t, err)
+
+	validEncryptedArmoredSecret, err := encryptStringToArmor("test foo", key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+	defer func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}()
+
+	sendResponse := callAPI(t, "POST", "/v1/secrets", v1structs.SendSecretRequest{
+		RecipientFingerprint:   key.Fingerprint().Uri(),
+		ArmoredEncryptedSecret: validEncryptedArmoredSecret,
+	}, nil)
+	assertStatusCode(t, http.StatusCreated, sendResponse.Code)
+
+	sent := v1structs.SendSecretResponse{}
+	assertBodyDecodesInto(t, sendResponse.Body, &sent)
+
+	statusPath := "/v1/secrets/" + sent.SecretUUID + "/status"
+
+	getStatus := func(t *testing.T) v1structs.GetSecretStatusResponse {
+		t.Helper()
+		response := callAPIWithHeaders(t, "GET", statusPath, nil, nil,
+			map[string]string{"X-Sender-Token": sent.SenderToken})
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetSecretStatusResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		return responseData
+	}
+
+	t.Run("invalid UUID in URL", func(t *testing.T) {
+		response := callAPIWithHeaders(t, "GET", "/v1/secrets/invalid-uuid/status", nil, nil,
+			map[string]string{"X-Sender-Token": sent.SenderToken})
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "invalid secret UUID")
+	})
+
+	t.Run("missing sender token", func(t *testing.T) {
+		response := callAPI(t, "GET", statusPath, nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "missing X-Sender-Token header")
+	})
+
+	t.Run("wrong sender token", func(t *testing.T) {
+		response := callAPIWithHeaders(t, "GET", statusPath, nil, nil,
+			map[string]string{"X-Sender-Token": uuid.Must(uuid.NewV4()).String()})
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errSecretReceiptNotFound.Error())
+	})
+
+	t.Run("not picked up yet", func(t *testing.T) {
+		if status := getStatus(t); status.DeliveredAt != nil || status.DeletedAt != nil {
+			t.Fatalf("expected deliveredAt and deletedAt to be null, got %v, %v",
+				status.DeliveredAt, status.DeletedAt)
+		}
+	})
+
+	t.Run("listing metadata only doesn't count as delivered", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/secrets?metadataOnly=true", nil,
+			&exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		if status := getStatus(t); status.DeliveredAt != nil {
+			t.Fatalf("expected deliveredAt to be null, got %v", status.DeliveredAt)
+		}
+	})
+
+	t.Run("delivered once listed", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/secrets", nil, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		status := getStatus(t)
+		if status.DeliveredAt == nil {
+			t.Fatalf("expected deliveredAt to be set")
+		}
+		if status.DeletedAt != nil {
+			t.Fatalf("expected deletedAt to be null, got %v", status.DeletedAt)
+		}
+	})
+
+	t.Run("deleted once the recipient deletes it", func(t *testing.T) {
+		response := callAPI(t, "DELETE", "/v1/secrets/"+sent.SecretUUID, nil,
+			&exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusAccepted, response.Code)
+
+		if status := getStatus(t); status.DeletedAt == nil {
+			t.Fatalf("expected deletedAt to be set")
+		}
+	})
+}
+
 func callAPI(t *testing.T, method string, path string,
 	requestData interface{}, authFingerprint *fingerprint.Fingerprint) *httptest.ResponseRecorder {
 
+	t.Helper()
+	return callAPIWithHeaders(t, method, path, requestData, authFingerprint, nil)
+}
+
+// callAPIWithHeaders is like callAPI but also sets the given headers on the request.
+func callAPIWithHeaders(t *testing.T, method string, path string,
+	requestData interface{}, authFingerprint *fingerprint.Fingerprint,
+	headers map[string]string) *httptest.ResponseRecorder {
+
 	// Create a request to pass to our handler. We don't have any query parameters for now, so we'll
 	// pass 'nil' as the third parameter.
 	t.Helper()
@@ -734,13 +2031,23 @@ func callAPI(t *testing.T, method string, path string,
 	if authFingerprint != nil {
 		req.Header.Set("Authorization", fmt.Sprintf("tmpfingerprint: %s", authFingerprint.Uri()))
 	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
 
 	recorder := httptest.NewRecorder() // create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
-	subrouter.ServeHTTP(recorder, req)
+	router.ServeHTTP(recorder, req)
 
 	return recorder
 }
 
+// basicAuthHeader returns an Authorization header authorizing as the given key with HTTP basic
+// auth, for callAPIWithHeaders.
+func basicAuthHeader(fpr fingerprint.Fingerprint, password string) map[string]string {
+	credentials := base64.StdEncoding.EncodeToString([]byte(fpr.Hex() + ":" + password))
+	return map[string]string{"Authorization": "Basic " + credentials}
+}
+
 func assertStatusCode(t *testing.T, expected int, got int) {
 	t.Helper()
 	if expected != got {
@@ -758,6 +2065,31 @@ func assertHasJSONErrorDetail(t *testing.T, body io.Reader, expectedDetail strin
 	}
 }
 
+// decodedArmorLength returns the length of the given ASCII-armored message once decoded.
+func decodedArmorLength(t *testing.T, armored string) int {
+	t.Helper()
+	block, err := armor.Decode(strings.NewReader(armored))
+	assert.NoError(t, err)
+
+	decoded, err := ioutil.ReadAll(block.Body)
+	assert.NoError(t, err)
+	return len(decoded)
+}
+
+// assertEncryptedBodyDecodesInto decodes body as a v1structs.EncryptedJSONResponse, decrypts it
+// with the given unlocked key and decodes the JSON inside into responseStruct.
+func assertEncryptedBodyDecodesInto(
+	t *testing.T, body io.Reader, key *pgpkey.PgpKey, responseStruct interface{}) {
+
+	t.Helper()
+	encrypted := v1structs.EncryptedJSONResponse{}
+	assertBodyDecodesInto(t, body, &encrypted)
+
+	decrypted, err := decryptMessage(encrypted.EncryptedJSON, key)
+	assert.NoError(t, err)
+	assertBodyDecodesInto(t, decrypted, responseStruct)
+}
+
 func assertBodyDecodesInto(t *testing.T, body io.Reader, responseStruct interface{}) {
 	t.Helper()
 	if err := json.NewDecoder(body).Decode(&responseStruct); err != nil {
@@ -769,7 +2101,7 @@ func assertBodyEqualTo(t *testing.T, bodyReader io.Reader, expectedBody string)
 	body, err := ioutil.ReadAll(bodyReader)
 	assert.NoError(t, err)
 
-	assert.Equal(t, string(body), exampledata.ExamplePublicKey4)
+	assert.Equal(t, expectedBody, string(body))
 }
 
 func testEndpointRejectsUnauthenticated(t *testing.T, method string, urlPath string, requestData interface{}) {
@@ -890,6 +2222,30 @@ func decryptMessage(armoredEncryptedSecret string, key *pgpkey.PgpKey) (io.Reade
 	return decryptedBuf, nil
 }
 
+// newSignedRequestData returns SignedRequestData for the given action with the current time and
+// a fresh single use UUID.
+func newSignedRequestData(action string) v1structs.SignedRequestData {
+	return v1structs.SignedRequestData{
+		Action:        action,
+		Timestamp:     time.Now(),
+		SingleUseUUID: uuid.Must(uuid.NewV4()).String(),
+	}
+}
+
+// makeSignedRequestBody returns a SignedRequest containing signedData as JSON, clearsigned by
+// the given unlocked key.
+func makeSignedRequestBody(
+	t *testing.T, key *pgpkey.PgpKey, signedData interface{}) v1structs.SignedRequest {
+
+	t.Helper()
+	signedJSON, err := json.Marshal(signedData)
+	assert.NoError(t, err)
+
+	armoredSignedJSON, err := signText(signedJSON, key)
+	assert.NoError(t, err)
+	return v1structs.SignedRequest{ArmoredSignedJSON: armoredSignedJSON}
+}
+
 func signText(bytesToSign []byte, key *pgpkey.PgpKey) (armoredSigned string, err error) {
 	armorOutBuffer := bytes.NewBuffer(nil)
 	privKey := key.Entity.PrivateKey
@@ -907,3 +2263,48 @@ func signText(bytesToSign []byte, key *pgpkey.PgpKey) (armoredSigned string, err
 	armorWriteCloser.Close()
 	return armorOutBuffer.String(), nil
 }
+
+// makeExpiredPublicKey returns the armored public key for the given private key, re-signed so
+// that all of its UIDs expired on 1st January 2019.
+func makeExpiredPublicKey(t *testing.T, armoredPrivateKey string, password string) string {
+	t.Helper()
+
+	key, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(armoredPrivateKey, password)
+	assert.NoError(t, err)
+
+	signedAt := time.Date(2018, 12, 10, 0, 0, 0, 0, time.UTC)
+	expiredAt := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, key.UpdateExpiryForAllUserIds(expiredAt, signedAt))
+
+	armored, err := key.Armor()
+	assert.NoError(t, err)
+	return armored
+}
+
+// exampleRevokedPublicKey4 is exampledata.ExamplePublicKey4 with a revocation signature made
+// at exampleRevokedAt.
+const exampleRevokedPublicKey4 = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mI0EXAWATwEEAKyYQUizF1tulUc2LnL0oHlbZDnfqyQwH9smEvkaUj3bx0uKI/kQ
+nQ0pIYjh7sqOQR2SUW89tTC60jMMA/r5yeHWh8qlfOs2g9Op4mY0OEJDVzHw3lXf
+51o2n6nMPjinonvKqpo3qY2eHVrudnaBRLBSlDMQST4xsVLmPj020U2zABEBAAGI
+0wQgAQoAPRYhBLs8RL8YjVbmNfSgkvc9LwUz1/nWBQJq1CeqHx0CRXhhbXBsZSBr
+ZXkgcmV2b2tlZCBmb3IgdGVzdHMACgkQ9z0vBTPX+daiOQQApN9NX31K4gG6XTae
+pXmOTL8viSKgK9kW7Uy8t2OkskLyD5tPPULqBhw5DkUb+fEIHbWDCy8xVhtkZSl+
+jcjnE8IX6gk2SYR3D9rxPU0NhCn1fvtF1+EqcO6EqUvmyQ/SP4eq4hcL1mVZFZO4
+vAonzBgn8fcqj40TVMh60cWlxrq0EXRlc3Q0QGV4YW1wbGUuY29tiM0EEwEIADgW
+IQS7PES/GI1W5jX0oJL3PS8FM9f51gUCXAWATwIbAwULCQgHAgYVCgkICwIEFgID
+AQIeAQIXgAAKCRD3PS8FM9f51vrOA/iyPW0a1TjIhjqDj5VVjmE7GSXnLboCL3Fr
+HMn8boQZ8mgvFBLSjTmXRQjA3PhtWAUNRAJJo/kPHjyfYdHKC0zFTO4rvIofRc8o
+H1nHq70vfGbyRo8uhnQeC0IVP45yq4Q6W0Wtv9Yexo2LlA3ptY5uuGveodboKb00
+8bWqVqiYuI0EXAWATwEEANnQ+X9K9EejeysHyQG41Prg7RoHLuOEBBgI7HEc49hv
+/M8NPI7ai5vJEnxAIEC4x0Vzwi7mdDzxe4tJvwnCpD07dGE0xlqNh+kM7lte70yp
+f3d2W3ATNF18FXdnrhRHqJs9ZHtQ0L0tXxX6BpYNjGsEYxTL4ZguIudx5Je4Tyj5
+ABEBAAGItgQYAQgAIBYhBLs8RL8YjVbmNfSgkvc9LwUz1/nWBQJcBYBPAhsMAAoJ
+EPc9LwUz1/nW8n8D/14jfGTBVp5hMfrghxh6mbTWtDISfZ8yBfPcAXhAA3T3Tgky
+A87zMZITe/wpWdm+BTpaDIXNt2yVkKBVhslvyquTFFPeI+0sLltuofBlcwVVCH12
+GvjyGTrucdi+VWHAJUv2PXpOLuHPwZrV2Ga+PZWIsO+3TBAU6yBBSeV0Z8rL
+=xRFP
+-----END PGP PUBLIC KEY BLOCK-----`
+
+var exampleRevokedAt = time.Date(2026, 10, 18, 1, 58, 2, 0, time.UTC)
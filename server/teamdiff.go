@@ -0,0 +1,98 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/team"
+)
+
+// teamDiff describes how a team's roster changed in an update.
+type teamDiff struct {
+	nameChanged bool
+
+	added   []team.Person
+	removed []team.Person
+
+	// promoted and demoted are people whose admin status changed
+	promoted []team.Person
+	demoted  []team.Person
+}
+
+// diffTeams returns the changes made to the roster going from old to new. A person is matched
+// on both their email and fingerprint, so changing either shows up as removing the old person
+// and adding the new one. old is nil for a brand new team: everyone in new is added.
+func diffTeams(old *team.Team, new team.Team) teamDiff {
+	type identity struct {
+		email       string
+		fingerprint string
+	}
+	identify := func(person team.Person) identity {
+		return identity{person.Email, person.Fingerprint.Hex()}
+	}
+
+	diff := teamDiff{}
+	oldPeople := map[identity]team.Person{}
+
+	if old != nil {
+		diff.nameChanged = old.Name != new.Name
+		for _, person := range old.People {
+			oldPeople[identify(person)] = person
+		}
+	}
+
+	for _, person := range new.People {
+		oldPerson, found := oldPeople[identify(person)]
+		delete(oldPeople, identify(person))
+
+		switch {
+		case !found:
+			diff.added = append(diff.added, person)
+
+		case person.IsAdmin && !oldPerson.IsAdmin:
+			diff.promoted = append(diff.promoted, person)
+
+		case !person.IsAdmin && oldPerson.IsAdmin:
+			diff.demoted = append(diff.demoted, person)
+		}
+	}
+
+	for _, person := range oldPeople {
+		diff.removed = append(diff.removed, person)
+	}
+	sortPeopleByEmail(diff.removed) // map iteration order is random
+
+	return diff
+}
+
+// response returns the diff as the JSON response to the upsert team API endpoint.
+func (d teamDiff) response() v1structs.UpsertTeamResponse {
+	return v1structs.UpsertTeamResponse{
+		NameChanged: d.nameChanged,
+		Added:       teamMembers(d.added),
+		Removed:     teamMembers(d.removed),
+		Promoted:    teamMembers(d.promoted),
+		Demoted:     teamMembers(d.demoted),
+	}
+}
+
+func teamMembers(people []team.Person) []v1structs.TeamMember {
+	members := []v1structs.TeamMember{}
+	for _, person := range people {
+		members = append(members, v1structs.TeamMember{
+			Email:       person.Email,
+			Fingerprint: person.Fingerprint.Hex(),
+			IsAdmin:     person.IsAdmin,
+		})
+	}
+	return members
+}
+
+func sortPeopleByEmail(people []team.Person) {
+	sort.Slice(people, func(i, j int) bool {
+		if people[i].Email != people[j].Email {
+			return people[i].Email < people[j].Email
+		}
+		return people[i].Fingerprint.Hex() < people[j].Fingerprint.Hex()
+	})
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/assert"
+	fpr "github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/team"
+)
+
+func TestDiffTeams(t *testing.T) {
+	alice := team.Person{
+		Email:       "alice@example.com",
+		Fingerprint: fpr.MustParse("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"),
+		IsAdmin:     true,
+	}
+	bob := team.Person{
+		Email:       "bob@example.com",
+		Fingerprint: fpr.MustParse("BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB"),
+	}
+	carol := team.Person{
+		Email:       "carol@example.com",
+		Fingerprint: fpr.MustParse("CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC"),
+	}
+
+	adminBob := bob
+	adminBob.IsAdmin = true
+
+	oldTeam := team.Team{Name: "Kiffix", People: []team.Person{alice, adminBob, carol}}
+
+	t.Run("new team adds everyone", func(t *testing.T) {
+		diff := diffTeams(nil, oldTeam)
+
+		assert.Equal(t, []team.Person{alice, adminBob, carol}, diff.added)
+		assert.Equal(t, 0, len(diff.removed))
+		assert.Equal(t, false, diff.nameChanged)
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		diff := diffTeams(&oldTeam, oldTeam)
+
+		assert.Equal(t, teamDiff{}, diff)
+	})
+
+	t.Run("add member", func(t *testing.T) {
+		dave := team.Person{
+			Email:       "dave@example.com",
+			Fingerprint: fpr.MustParse("DDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDD"),
+		}
+		newTeam := team.Team{Name: "Kiffix", People: []team.Person{alice, adminBob, carol, dave}}
+
+		diff := diffTeams(&oldTeam, newTeam)
+		assert.Equal(t, teamDiff{added: []team.Person{dave}}, diff)
+	})
+
+	t.Run("remove members", func(t *testing.T) {
+		newTeam := team.Team{Name: "Kiffix", People: []team.Person{alice}}
+
+		diff := diffTeams(&oldTeam, newTeam)
+		assert.Equal(t, teamDiff{removed: []team.Person{adminBob, carol}}, diff)
+	})
+
+	t.Run("promote and demote", func(t *testing.T) {
+		adminCarol := carol
+		adminCarol.IsAdmin = true
+		newTeam := team.Team{Name: "Kiffix", People: []team.Person{alice, bob, adminCarol}}
+
+		diff := diffTeams(&oldTeam, newTeam)
+		assert.Equal(t, teamDiff{
+			promoted: []team.Person{adminCarol},
+			demoted:  []team.Person{bob},
+		}, diff)
+	})
+
+	t.Run("changing a member's key is a remove and an add", func(t *testing.T) {
+		carolNewKey := carol
+		carolNewKey.Fingerprint = fpr.MustParse("EEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEEE")
+		newTeam := team.Team{Name: "Kiffix", People: []team.Person{alice, adminBob, carolNewKey}}
+
+		diff := diffTeams(&oldTeam, newTeam)
+		assert.Equal(t, teamDiff{
+			added:   []team.Person{carolNewKey},
+			removed: []team.Person{carol},
+		}, diff)
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		newTeam := team.Team{Name: "Kiffix Ltd", People: []team.Person{alice, adminBob, carol}}
+
+		diff := diffTeams(&oldTeam, newTeam)
+		assert.Equal(t, teamDiff{nameChanged: true}, diff)
+	})
+
+	t.Run("response has empty lists rather than null", func(t *testing.T) {
+		response := teamDiff{}.response()
+
+		assert.Equal(t, []v1structs.TeamMember{}, response.Added)
+		assert.Equal(t, []v1structs.TeamMember{}, response.Removed)
+		assert.Equal(t, []v1structs.TeamMember{}, response.Promoted)
+		assert.Equal(t, []v1structs.TeamMember{}, response.Demoted)
+	})
+}
@@ -2,14 +2,21 @@ package server
 
 import (
 	"database/sql"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fluidkeys/api/datastore"
 	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/crypto/openpgp"
+	"github.com/fluidkeys/crypto/openpgp/errors"
+	"github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
 	"github.com/fluidkeys/fluidkeys/team"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
@@ -22,8 +29,8 @@ func upsertTeamHandler(w http.ResponseWriter, r *http.Request) {
 	// see the test suite for more detail on the validations this performs
 
 	requestData := v1structs.UpsertTeamRequest{}
-	if err := decodeJsonRequest(r, &requestData); err != nil {
-		writeJsonError(w, err, http.StatusBadRequest)
+	if err := decodeLargeJsonRequest(r, &requestData); err != nil {
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
 		return
 	}
 
@@ -54,11 +61,26 @@ func upsertTeamHandler(w http.ResponseWriter, r *http.Request) {
 		apparentSignerKey); err != nil {
 
 		log.Printf("roster signature verification failed: %v", err)
+		recordRosterSignatureFailure(r, apparentSignerKey.Fingerprint(), err)
 		writeJsonError(w, fmt.Errorf("signature verification failed"), http.StatusBadRequest)
 		return
 	}
 
-	newTeam, err := team.Load(requestData.TeamRoster, requestData.ArmoredDetachedSignature)
+	if err := validateRosterSignatureHash(requestData.ArmoredDetachedSignature); err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	signedAt, err := signatureCreationTime(requestData.ArmoredDetachedSignature)
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// The roster's `version` is a revision number that increments on every update, not a format
+	// version, so there's no maximum to check. Rosters in a format we don't understand are
+	// still rejected: team.Load refuses any keys it can't decode into a team.Team.
+	newTeam, err := loadRoster(requestData.TeamRoster, requestData.ArmoredDetachedSignature)
 	if err != nil {
 		writeJsonError(w, err, http.StatusBadRequest)
 		return
@@ -77,9 +99,29 @@ func upsertTeamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	expectedVersion, err := ifMatchRosterVersion(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestData.RequiredAdminSignatures < 0 {
+		writeJsonError(w,
+			fmt.Errorf("invalid `requiredAdminSignatures`: cannot be negative"),
+			http.StatusBadRequest)
+		return
+	}
+
 	var existingTeam *team.Team
+	var requiredAdminSignatures, gotAdminSignatures int
 
 	err = datastore.RunInTransaction(func(txn *sql.Tx) error {
+		// lock the existing team (if any) so a concurrent update can't slip in between checking
+		// the version below and writing the new roster
+		if err := datastore.LockTeam(txn, newTeam.UUID); err != nil && err != datastore.ErrNotFound {
+			return err
+		}
+
 		existingTeam, err = loadExistingTeam(txn, newTeam.UUID)
 		switch err {
 
@@ -93,11 +135,61 @@ func upsertTeamHandler(w http.ResponseWriter, r *http.Request) {
 				return errNotAnAdminInExistingTeam
 			}
 
+			// the update has to satisfy the existing team's policy, even if it changes it
+			dbTeam, err := datastore.GetTeam(txn, newTeam.UUID)
+			if err != nil {
+				return err
+			}
+
+			// clients often re-upload an unchanged roster: there's nothing to write, so don't
+			// refuse it for having a signature that's since grown too old
+			if rosterUnchanged(dbTeam, requestData) &&
+				(expectedVersion == nil || *expectedVersion == existingTeam.Version) {
+				return nil
+			}
+
+			if err := validateNotOlderThanExistingSignature(txn, newTeam.UUID, *signedAt); err != nil {
+				return err
+			}
+			requiredAdminSignatures = dbTeam.RequiredAdminSignatures
+
+			gotAdminSignatures, err = countAdminSignatures(
+				existingTeam.Admins(), requestData.TeamRoster, apparentSignerKey.Fingerprint(),
+				requestData.AdditionalArmoredDetachedSignatures)
+			if err != nil {
+				return err
+			} else if gotAdminSignatures < requiredAdminSignatures {
+				return errNotEnoughAdminSignatures
+			}
+
 		default: // some other error
 			return err
 
-		case datastore.ErrNotFound: // new team: crack on
-			break
+		case datastore.ErrNotFound: // new team: crack on, but check any additional signatures
+			requiredAdminSignatures = 1
+
+			gotAdminSignatures, err = countAdminSignatures(
+				newTeam.Admins(), requestData.TeamRoster, apparentSignerKey.Fingerprint(),
+				requestData.AdditionalArmoredDetachedSignatures)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := validateRosterSignatureFresh(*signedAt, time.Now()); err != nil {
+			return err
+		}
+
+		// the policy for future updates
+		if requestData.RequiredAdminSignatures > 0 {
+			requiredAdminSignatures = requestData.RequiredAdminSignatures
+		}
+		if requiredAdminSignatures > len(newTeam.Admins()) {
+			return errTooFewAdminsForSignaturePolicy
+		}
+
+		if expectedVersion != nil && (existingTeam == nil || existingTeam.Version != *expectedVersion) {
+			return errRosterVersionConflict
 		}
 
 		if verified, err := datastore.QueryEmailVerifiedForFingerprint(
@@ -110,13 +202,16 @@ func upsertTeamHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		team := datastore.Team{
-			UUID:            newTeam.UUID,
-			Roster:          requestData.TeamRoster,
-			RosterSignature: requestData.ArmoredDetachedSignature,
-			CreatedAt:       time.Now(),
+			UUID:                    newTeam.UUID,
+			Roster:                  requestData.TeamRoster,
+			RosterSignature:         requestData.ArmoredDetachedSignature,
+			CreatedAt:               time.Now(),
+			RequiredAdminSignatures: requiredAdminSignatures,
 		}
 
-		if err := datastore.UpsertTeam(txn, team); err != nil {
+		if err := datastore.UpsertTeam(txn, team); err == datastore.ErrTeamDeleted {
+			return err
+		} else if err != nil {
 			return fmt.Errorf("error creating team: %v", err)
 		}
 
@@ -131,24 +226,289 @@ func upsertTeamHandler(w http.ResponseWriter, r *http.Request) {
 		)
 		return
 
+	case errNotEnoughAdminSignatures:
+		writeJsonError(w,
+			fmt.Errorf("can't update team: it needs signatures from %d admins, got %d",
+				requiredAdminSignatures, gotAdminSignatures),
+			http.StatusForbidden,
+		)
+		return
+
+	case errRosterVersionConflict:
+		writeJsonError(w, err, http.StatusConflict)
+		return
+
+	case datastore.ErrTeamDeleted:
+		writeJsonError(w, err, http.StatusConflict)
+		return
+
 	default:
 		writeJsonError(w, err, http.StatusBadRequest)
 		return
 
 	case nil:
+		responseData := diffTeams(existingTeam, *newTeam).response()
 		if existingTeam == nil {
-			w.WriteHeader(http.StatusCreated) // no existing team: return *created*
+			// no existing team: return *created*
+			writeJsonResponseWithStatus(w, responseData, http.StatusCreated)
 		} else {
-			w.WriteHeader(http.StatusOK) // existing team: return OK (for *updated*)
+			// existing team: return OK (for *updated*)
+			writeJsonResponseWithStatus(w, responseData, http.StatusOK)
 		}
-		w.Write(nil)
 	}
 
 }
 
+// rosterUnchanged returns true if storing the request would leave the team exactly as it's
+// stored: the same roster, signature and required admin signatures.
+func rosterUnchanged(dbTeam *datastore.Team, request v1structs.UpsertTeamRequest) bool {
+	return request.TeamRoster == dbTeam.Roster &&
+		request.ArmoredDetachedSignature == dbTeam.RosterSignature &&
+		(request.RequiredAdminSignatures == 0 ||
+			request.RequiredAdminSignatures == dbTeam.RequiredAdminSignatures)
+}
+
+// recordRosterSignatureFailure records a roster whose signature didn't verify as a suspicious
+// request. The client is only told that verification failed, but the kind tells operators
+// whether the roster was signed by a different key or tampered with after signing.
+func recordRosterSignatureFailure(
+	r *http.Request, signer fingerprint.Fingerprint, verifyErr error) {
+
+	kind := suspiciousRosterBadSignature
+	if verifyErr == errSignedByWrongKey {
+		kind = suspiciousRosterSignedByWrongKey
+	}
+
+	err := datastore.RecordSuspiciousRequest(
+		nil, kind, signer, ipAddress(r), verifyErr.Error(), time.Now())
+	if err != nil {
+		log.Printf("error recording suspicious request (%s): %v", kind, err)
+	}
+}
+
+// kinds of suspicious request recorded by recordRosterSignatureFailure
+const (
+	suspiciousRosterSignedByWrongKey = "roster_signed_by_wrong_key"
+	suspiciousRosterBadSignature     = "roster_bad_signature"
+)
+
+// validateTeamRosterHandler checks a roster the way upsertTeamHandler would, without needing a
+// signature and without storing anything, so clients can catch mistakes before signing.
+func validateTeamRosterHandler(w http.ResponseWriter, r *http.Request) {
+	requestData := v1structs.ValidateTeamRosterRequest{}
+	if err := decodeLargeJsonRequest(r, &requestData); err != nil {
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
+		return
+	}
+
+	if requestData.TeamRoster == "" {
+		writeJsonError(w, fmt.Errorf("missing teamRoster"), http.StatusBadRequest)
+		return
+	}
+
+	// loadRoster validates the roster too
+	loadedTeam, err := loadRoster(requestData.TeamRoster, "")
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJsonResponse(w, v1structs.ValidateTeamRosterResponse{
+		UUID:    loadedTeam.UUID.String(),
+		Name:    loadedTeam.Name,
+		Version: loadedTeam.Version,
+		Members: teamMembers(loadedTeam.People),
+	})
+}
+
+// ifMatchRosterVersion returns the roster version the client expects to be replacing, from an
+// optional `If-Match: "3"` header, or nil if the header isn't set.
+func ifMatchRosterVersion(r *http.Request) (*uint, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil, nil
+	}
+
+	version, err := strconv.ParseUint(strings.Trim(header, `"`), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid If-Match header, should be a roster version like \"3\"")
+	}
+	v := uint(version)
+	return &v, nil
+}
+
+// countAdminSignatures returns how many different admins have signed the roster: the one who
+// signed the request (who must already have been checked to be an admin) plus one for each of
+// the additional signatures. Each additional signature must be a recent signature of the roster
+// by one of the given admins, otherwise an error is returned.
+func countAdminSignatures(admins []team.Person, roster string,
+	requestSigner fingerprint.Fingerprint, additionalSignatures []string) (int, error) {
+
+	signed := map[fingerprint.Fingerprint]bool{requestSigner: true}
+
+	for i, signature := range additionalSignatures {
+		signedAt, err := signatureCreationTime(signature)
+		if err != nil {
+			return 0, fmt.Errorf("additional signature %d: %v", i+1, err)
+		} else if err := validateRosterSignatureFresh(*signedAt, time.Now()); err != nil {
+			return 0, fmt.Errorf("additional signature %d: %v", i+1, err)
+		}
+
+		if err := validateRosterSignatureHash(signature); err != nil {
+			return 0, fmt.Errorf("additional signature %d: %v", i+1, err)
+		}
+
+		signer, err := findSigningAdmin(admins, roster, signature)
+		if err != nil {
+			return 0, fmt.Errorf("additional signature %d: %v", i+1, err)
+		}
+		signed[signer.Fingerprint] = true
+	}
+	return len(signed), nil
+}
+
+// findSigningAdmin returns the admin whose stored key made the given signature of the roster.
+func findSigningAdmin(admins []team.Person, roster string, signature string) (*team.Person, error) {
+	for i := range admins {
+		armoredPublicKey, found, err := datastore.GetArmoredPublicKeyForFingerprint(
+			admins[i].Fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("error getting admin's public key: %v", err)
+		} else if !found {
+			continue
+		}
+
+		key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading admin's public key: %v", err)
+		}
+
+		if validateDataSignedByKey(roster, signature, key) == nil {
+			return &admins[i], nil
+		}
+	}
+	return nil, fmt.Errorf("not a valid signature of the roster by a team admin")
+}
+
+// validateRosterSignatureFresh returns errRosterSignatureTooOld if a roster signature made at
+// signedAt is older than maxRosterSignatureAge, or errRosterSignatureInFuture if it's dated more
+// than maxRosterSignatureClockSkew ahead of now. A future-dated roster would otherwise lock the
+// team: every genuine update after it would look older than the stored one.
+func validateRosterSignatureFresh(signedAt time.Time, now time.Time) error {
+	if now.Sub(signedAt) > maxRosterSignatureAge {
+		return errRosterSignatureTooOld
+	} else if signedAt.Sub(now) > maxRosterSignatureClockSkew {
+		return errRosterSignatureInFuture
+	}
+	return nil
+}
+
+// validateNotOlderThanExistingSignature returns errRosterSignatureOlderThanExisting if the stored
+// roster for the team was signed after signedAt.
+func validateNotOlderThanExistingSignature(txn *sql.Tx, teamUUID uuid.UUID, signedAt time.Time) error {
+	dbTeam, err := datastore.GetTeam(txn, teamUUID)
+	if err != nil {
+		return err
+	}
+
+	existingSignedAt, err := signatureCreationTime(dbTeam.RosterSignature)
+	if err != nil {
+		// nothing to compare against: rely on maxRosterSignatureAge alone
+		log.Printf("team %s: failed to read existing roster signature: %v", teamUUID, err)
+		return nil
+	}
+
+	if signedAt.Before(*existingSignedAt) {
+		return errRosterSignatureOlderThanExisting
+	}
+	return nil
+}
+
+// validateStoredRosterSignature checks that the stored signature is a valid signature of the
+// stored roster by one of the team's admins, in case the database row has been corrupted or
+// tampered with.
+// If the signature was made by a key that's no longer in the database (e.g. an admin whose
+// key expired and was deleted) there's nothing to check it against, so it's allowed.
+func validateStoredRosterSignature(t *team.Team, roster string, armoredSignature string) error {
+	var keyring openpgp.EntityList
+	missingAdminKey := false
+
+	for _, admin := range t.Admins() {
+		armoredPublicKey, found, err := datastore.GetArmoredPublicKeyForFingerprint(
+			admin.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("error getting admin's public key: %v", err)
+		} else if !found {
+			missingAdminKey = true
+			continue
+		}
+
+		key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+		if err != nil {
+			return fmt.Errorf("error loading admin's public key: %v", err)
+		}
+		keyring = append(keyring, &key.Entity)
+	}
+
+	_, err := openpgp.CheckArmoredDetachedSignature(
+		keyring, strings.NewReader(roster), strings.NewReader(armoredSignature),
+	)
+	if err == errors.ErrUnknownIssuer && missingAdminKey {
+		return nil
+	} else if err != nil {
+		return errStoredRosterSignatureInvalid
+	}
+	return nil
+}
+
+// rosterSignerFingerprint returns the fingerprint of the admin whose key made the roster's
+// signature, by matching the signature's issuer key ID against the admins' fingerprints and,
+// failing that, the subkeys of the admins' keys stored in the database. It returns nil if none
+// match.
+func rosterSignerFingerprint(
+	t *team.Team, armoredSignature string) (*fingerprint.Fingerprint, error) {
+
+	keyID, err := signatureIssuerKeyID(armoredSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, admin := range t.Admins() {
+		// the key ID of a v4 key is the low 64 bits of its fingerprint
+		fingerprintBytes := admin.Fingerprint.Bytes()
+		if binary.BigEndian.Uint64(fingerprintBytes[12:]) == keyID {
+			signer := admin.Fingerprint
+			return &signer, nil
+		}
+	}
+
+	for _, admin := range t.Admins() {
+		armoredPublicKey, found, err := datastore.GetArmoredPublicKeyForFingerprint(
+			admin.Fingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("error getting admin's public key: %v", err)
+		} else if !found {
+			continue
+		}
+
+		key, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading admin's public key: %v", err)
+		}
+
+		for _, subkey := range key.Subkeys {
+			if subkey.PublicKey.KeyId == keyID {
+				signer := admin.Fingerprint
+				return &signer, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
 // loadExistingTeam loads a team from the database, parses its stored roster and returns a team.Team
 func loadExistingTeam(txn *sql.Tx, teamUUID uuid.UUID) (*team.Team, error) {
-	dbTeam, err := datastore.GetTeam(nil, teamUUID)
+	dbTeam, err := datastore.GetTeam(txn, teamUUID)
 	if err != nil {
 		return nil, err
 	}
@@ -183,6 +543,41 @@ func getTeamHandler(w http.ResponseWriter, r *http.Request) {
 	writeJsonResponse(w, responseData)
 }
 
+// listTeamsHandler lists the teams whose roster includes the authorized key, so that a client
+// can show a team picker.
+func listTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	teams, err := datastore.ListTeamsForFingerprint(nil, myPublicKey.Fingerprint())
+	if err != nil {
+		writeJsonError(w,
+			fmt.Errorf("error listing teams: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	if len(teams) > maxTeamsListed {
+		teams = teams[:maxTeamsListed]
+	}
+
+	responseData := v1structs.ListTeamsResponse{Teams: []v1structs.TeamMembership{}}
+	for _, t := range teams {
+		responseData.Teams = append(responseData.Teams, v1structs.TeamMembership{
+			UUID:    t.UUID.String(),
+			Name:    t.Name,
+			IsAdmin: t.IsAdmin(myPublicKey.Fingerprint()),
+		})
+	}
+	writeJsonResponse(w, responseData)
+}
+
+// maxTeamsListed is the most teams listTeamsHandler returns
+const maxTeamsListed = 100
+
 func createRequestToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 	teamUUID, err := uuid.FromString(mux.Vars(r)["teamUUID"])
 	if err != nil {
@@ -203,7 +598,7 @@ func createRequestToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 
 	requestData := v1structs.RequestToJoinTeamRequest{}
 	if err := decodeJsonRequest(r, &requestData); err != nil {
-		writeJsonError(w, err, http.StatusBadRequest)
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
 		return
 	}
 
@@ -227,25 +622,22 @@ func createRequestToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 			return fmt.Errorf("error fetching team: %v", err)
 		}
 
-		existingRequest, err := datastore.GetRequestToJoinTeam(txn, teamUUID, requestData.TeamEmail)
-		if err != nil && err != datastore.ErrNotFound {
-			return fmt.Errorf("error looking for existing request: %v", err)
-		}
+		_, created, err := datastore.CreateRequestToJoinTeamIfNew(
+			txn, dbTeam.UUID, requestData.TeamEmail, requestKey.Fingerprint(), time.Now())
+		switch {
+		case err == datastore.ErrConflictingRequestToJoinTeam:
+			// got an existing request for the same {team, email} combination but with a
+			// different fingerprint. reject it.
+			return errConflictingRequestAlreadyExists
 
-		if existingRequest != nil {
-			if existingRequest.Fingerprint == requestKey.Fingerprint() {
-				// got an existing, identical request. rather than creating a new one, just return the
-				// UUID of the existing one
-				return errIdenticalRequestAlreadyExists
-			}
+		case err != nil:
+			return fmt.Errorf("error creating request to join team: %v", err)
 
-			// got an existing request for the same {team, email} combination but with a different
-			// fingerprint. reject it.
-			return errConflictingRequestAlreadyExists
+		case !created:
+			// got an existing, identical request: it's been returned rather than creating a
+			// new one
+			return errIdenticalRequestAlreadyExists
 		}
-
-		_, err = datastore.CreateRequestToJoinTeam(
-			txn, dbTeam.UUID, requestData.TeamEmail, requestKey.Fingerprint(), time.Now())
 		return nil
 	})
 
@@ -262,8 +654,8 @@ func createRequestToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 
 	case errConflictingRequestAlreadyExists:
 		writeJsonError(w,
-			fmt.Errorf("got existing request for conflicting-example@example.com to join that "+
-				"team with a different fingerprint"),
+			fmt.Errorf("got existing request for %s to join that team with a different "+
+				"fingerprint", requestData.TeamEmail),
 			http.StatusConflict)
 		return
 
@@ -274,6 +666,9 @@ func createRequestToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// getTeamRosterHandler returns the team's roster and signature, encrypted to the requesting
+// key. For a HEAD request it returns just the ETag, so members polling for changes can skip
+// the encryption and transfer until the version changes.
 func getTeamRosterHandler(w http.ResponseWriter, r *http.Request) {
 	teamUUID, err := uuid.FromString(mux.Vars(r)["teamUUID"])
 	if err != nil {
@@ -315,6 +710,20 @@ func getTeamRosterHandler(w http.ResponseWriter, r *http.Request) {
 
 	}
 
+	if err := validateStoredRosterSignature(team, dbTeam.Roster, dbTeam.RosterSignature); err != nil {
+		log.Printf("team %s: %v", teamUUID, err)
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// clients send this back in If-Match when uploading an updated roster
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, team.Version))
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	rosterAndSig := v1structs.TeamRosterAndSignature{
 		TeamRoster:               dbTeam.Roster,
 		ArmoredDetachedSignature: dbTeam.RosterSignature,
@@ -335,9 +744,80 @@ func getTeamRosterHandler(w http.ResponseWriter, r *http.Request) {
 		ArmoredDetachedSignature: rosterAndSig.ArmoredDetachedSignature,
 	}
 
+	signer, err := rosterSignerFingerprint(team, dbTeam.RosterSignature)
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	} else if signer != nil {
+		responseData.SignerFingerprint = signer.Hex()
+	}
+
 	writeJsonResponse(w, responseData)
 }
 
+// getTeamMemberHandler returns whether the given fingerprint is in the team, and if it's an
+// admin, so that clients can check membership without downloading the roster. Like the roster,
+// it's only available to members of the team.
+func getTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	teamUUID, err := uuid.FromString(mux.Vars(r)["teamUUID"])
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	memberFingerprint, err := fingerprint.Parse(mux.Vars(r)["fingerprint"])
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	requesterKey, err := getAuthorizedUserPublicKey(r)
+	if err == errAuthKeyNotFound {
+		writeJsonError(w,
+			fmt.Errorf("requesting key has not been uploaded"),
+			http.StatusBadRequest)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	dbTeam, err := datastore.GetTeam(nil, teamUUID)
+	if err == datastore.ErrNotFound {
+		writeJsonError(w, err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	team, err := team.Load(dbTeam.Roster, dbTeam.RosterSignature)
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := team.GetPersonForFingerprint(requesterKey.Fingerprint()); err != nil {
+		writeJsonError(w,
+			fmt.Errorf("requesting key is not in the team"),
+			http.StatusForbidden)
+		return
+	}
+
+	if err := validateStoredRosterSignature(team, dbTeam.Roster, dbTeam.RosterSignature); err != nil {
+		log.Printf("team %s: %v", teamUUID, err)
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_, err = team.GetPersonForFingerprint(memberFingerprint)
+
+	writeJsonResponse(w, v1structs.GetTeamMemberResponse{
+		IsMember: err == nil,
+		IsAdmin:  team.IsAdmin(memberFingerprint),
+	})
+}
+
 func deleteRequestToJoinTeamHandler(w http.ResponseWriter, r *http.Request) {
 	requestUUID, err := uuid.FromString(mux.Vars(r)["requestUUID"])
 	if err != nil {
@@ -2,15 +2,19 @@ package server
 
 import (
 	"bytes"
+	"crypto"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/fluidkeys/api/datastore"
 	"github.com/fluidkeys/api/v1structs"
 	"github.com/fluidkeys/crypto/openpgp"
+	"github.com/fluidkeys/crypto/openpgp/armor"
+	"github.com/fluidkeys/crypto/openpgp/packet"
 	"github.com/fluidkeys/fluidkeys/assert"
 	"github.com/fluidkeys/fluidkeys/exampledata"
 	"github.com/fluidkeys/fluidkeys/fingerprint"
@@ -31,6 +35,68 @@ func makeArmoredDetachedSignature(dataToSign []byte, privateKey *pgpkey.PgpKey)
 	return outputBuf.String(), nil
 }
 
+func makeArmoredDetachedSignatureAt(
+	dataToSign []byte, privateKey *pgpkey.PgpKey, signedAt time.Time) (string, error) {
+
+	outputBuf := bytes.NewBuffer(nil)
+	entity := privateKey.Entity
+	config := &packet.Config{Time: func() time.Time { return signedAt }}
+
+	err := openpgp.ArmoredDetachSign(outputBuf, &entity, bytes.NewReader(dataToSign), config)
+	if err != nil {
+		return "", err
+	}
+	return outputBuf.String(), nil
+}
+
+func makeArmoredDetachedSignatureWithHash(
+	dataToSign []byte, privateKey *pgpkey.PgpKey, hash crypto.Hash) (string, error) {
+
+	outputBuf := bytes.NewBuffer(nil)
+	entity := privateKey.Entity
+	config := &packet.Config{DefaultHash: hash}
+
+	err := openpgp.ArmoredDetachSign(outputBuf, &entity, bytes.NewReader(dataToSign), config)
+	if err != nil {
+		return "", err
+	}
+	return outputBuf.String(), nil
+}
+
+// makeArmoredDetachedSignatureBySubkey signs dataToSign with the private key's first subkey
+// rather than its primary key.
+func makeArmoredDetachedSignatureBySubkey(
+	dataToSign []byte, privateKey *pgpkey.PgpKey) (string, error) {
+
+	subkey := privateKey.Subkeys[0].PrivateKey
+	sig := &packet.Signature{
+		SigType:      packet.SigTypeBinary,
+		PubKeyAlgo:   subkey.PubKeyAlgo,
+		Hash:         crypto.SHA256,
+		CreationTime: time.Now(),
+		IssuerKeyId:  &subkey.KeyId,
+	}
+
+	h := sig.Hash.New()
+	h.Write(dataToSign)
+	if err := sig.Sign(h, subkey, nil); err != nil {
+		return "", err
+	}
+
+	outputBuf := bytes.NewBuffer(nil)
+	armorWriteCloser, err := armor.Encode(outputBuf, openpgp.SignatureType, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := sig.Serialize(armorWriteCloser); err != nil {
+		return "", err
+	}
+	if err := armorWriteCloser.Close(); err != nil {
+		return "", err
+	}
+	return outputBuf.String(), nil
+}
+
 func TestCreateTeamHandler(t *testing.T) {
 
 	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
@@ -76,7 +142,7 @@ is_admin = false
 	}
 
 	teardown := func() {
-		_, err := datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 
 		_, err = datastore.DeleteTeam(nil, goodUUID)
@@ -101,6 +167,16 @@ is_admin = false
 			assertStatusCode(t, http.StatusCreated, response.Code)
 		})
 
+		t.Run("response lists everyone as added", func(t *testing.T) {
+			responseData := v1structs.UpsertTeamResponse{}
+			assertBodyDecodesInto(t, response.Body, &responseData)
+
+			assert.Equal(t, 2, len(responseData.Added))
+			assert.Equal(t, "test4@example.com", responseData.Added[0].Email)
+			assert.Equal(t, "b@example.com", responseData.Added[1].Email)
+			assert.Equal(t, 0, len(responseData.Removed))
+		})
+
 		t.Run("adds valid database row", func(t *testing.T) {
 			team, err := datastore.GetTeam(nil, goodUUID)
 			assert.NoError(t, err)
@@ -137,6 +213,33 @@ is_admin = true
 		assertStatusCode(t, http.StatusCreated, response.Code)
 	})
 
+	t.Run("rejects roster with fields from an unknown format", func(t *testing.T) {
+		rosterUnknownFormat := `
+uuid = "5c1f5e3c-9c9e-11e9-8a0b-b3a8d1d5e0a1"
+version = 1
+format = 2
+
+[[person]]
+email = "test4@example.com"
+fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+is_admin = true
+role = "owner"
+`
+		sigUnknownFormat, err := makeArmoredDetachedSignature(
+			[]byte(rosterUnknownFormat), unlockedKey)
+		assert.NoError(t, err)
+
+		requestData := v1structs.UpsertTeamRequest{
+			TeamRoster:               rosterUnknownFormat,
+			ArmoredDetachedSignature: sigUnknownFormat,
+		}
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"encountered unrecognised config keys: [format person.role]")
+	})
+
 	t.Run("request doesn't contain signer fingerprint in auth header", func(t *testing.T) {
 		requestData := v1structs.UpsertTeamRequest{
 			TeamRoster:               goodRoster,
@@ -193,8 +296,10 @@ is_admin = true
 		assertStatusCode(t, http.StatusBadRequest, response.Code)
 		assertHasJSONErrorDetail(t, response.Body,
 			"signature verification failed")
+		assertRecordedRosterSignatureFailure(
+			t, suspiciousRosterSignedByWrongKey, mismatchedFingerprint)
 
-		_, err := datastore.DeletePublicKey(mismatchedFingerprint)
+		_, _, err := datastore.DeletePublicKey(nil, mismatchedFingerprint)
 		assert.NoError(t, err)
 	})
 
@@ -223,6 +328,69 @@ is_admin = true
 		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
 		assertStatusCode(t, http.StatusBadRequest, response.Code)
 		assertHasJSONErrorDetail(t, response.Body, "signature verification failed")
+		assertRecordedRosterSignatureFailure(
+			t, suspiciousRosterBadSignature, signerFingerprint)
+	})
+
+	t.Run("roster signature older than maximum age", func(t *testing.T) {
+		roster := `
+uuid = "2cb4ab52-9c31-11e9-a53c-7b9a4ea4e6e6"
+
+[[person]]
+email = "test4@example.com"
+fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+is_admin = true
+`
+		oldSignature, err := makeArmoredDetachedSignatureAt(
+			[]byte(roster), unlockedKey, time.Now().Add(-maxRosterSignatureAge-time.Hour))
+		assert.NoError(t, err)
+
+		requestData := v1structs.UpsertTeamRequest{
+			TeamRoster:               roster,
+			ArmoredDetachedSignature: oldSignature,
+		}
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "roster signature is too old")
+	})
+
+	t.Run("roster signature dated in the future", func(t *testing.T) {
+		roster := `
+uuid = "5d0e7c2a-9c31-11e9-8f3b-1f2e3d4c5b6a"
+
+[[person]]
+email = "test4@example.com"
+fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+is_admin = true
+`
+		futureSignature, err := makeArmoredDetachedSignatureAt(
+			[]byte(roster), unlockedKey, time.Now().Add(maxRosterSignatureClockSkew+time.Hour))
+		assert.NoError(t, err)
+
+		requestData := v1structs.UpsertTeamRequest{
+			TeamRoster:               roster,
+			ArmoredDetachedSignature: futureSignature,
+		}
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errRosterSignatureInFuture.Error())
+	})
+
+	t.Run("roster signed using SHA-1", func(t *testing.T) {
+		sha1Signature, err := makeArmoredDetachedSignatureWithHash(
+			[]byte(goodRoster), unlockedKey, crypto.SHA1)
+		assert.NoError(t, err)
+
+		requestData := v1structs.UpsertTeamRequest{
+			TeamRoster:               goodRoster,
+			ArmoredDetachedSignature: sha1Signature,
+		}
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errRosterSignatureHashNotAllowed.Error())
 	})
 
 	t.Run("invalid roster", func(t *testing.T) {
@@ -410,6 +578,163 @@ is_admin = true
 			})
 		})
 
+		t.Run("identical roster and signature isn't written again", func(t *testing.T) {
+			roster := `
+				uuid = "b0a4c3e6-9c31-11e9-9f5e-2b7c5d0c1a7e"
+				name = "UNCHANGED"
+
+				[[person]]
+				email = "test4@example.com"
+				fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+				is_admin = true`
+			teamUUID := uuid.Must(uuid.FromString("b0a4c3e6-9c31-11e9-9f5e-2b7c5d0c1a7e"))
+			defer func() {
+				_, err := datastore.DeleteTeam(nil, teamUUID)
+				assert.NoError(t, err)
+			}()
+
+			requestData := makeSignedRequest(t, roster, unlockedKey)
+			response1 := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+			assertStatusCode(t, http.StatusCreated, response1.Code)
+
+			dbTeamBefore, err := datastore.GetTeam(nil, teamUUID)
+			assert.NoError(t, err)
+			if dbTeamBefore.UpdatedAt == nil {
+				t.Fatalf("expected UpdatedAt to be set, got nil")
+			}
+
+			// the stored signature has since grown too old, but there's nothing to write so the
+			// upload shouldn't be refused
+			defer func(saved time.Duration) { maxRosterSignatureAge = saved }(maxRosterSignatureAge)
+			maxRosterSignatureAge = time.Nanosecond
+
+			// writing the roster needs the signer's email to be verified: without it, a second
+			// upload can only succeed if it doesn't write
+			found, err := datastore.DeleteUnbackedEmailLink(nil, "test4@example.com")
+			assert.NoError(t, err)
+			assert.Equal(t, true, found)
+			defer func() {
+				assert.NoError(t, datastore.LinkEmailToFingerprint(
+					nil, "test4@example.com", exampledata.ExampleFingerprint4, nil))
+			}()
+
+			response2 := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+			assertStatusCode(t, http.StatusOK, response2.Code)
+
+			responseData := v1structs.UpsertTeamResponse{}
+			assertBodyDecodesInto(t, response2.Body, &responseData)
+			assert.Equal(t, 0, len(responseData.Added))
+			assert.Equal(t, 0, len(responseData.Removed))
+
+			dbTeam, err := datastore.GetTeam(nil, teamUUID)
+			assert.NoError(t, err)
+			assert.Equal(t, requestData.ArmoredDetachedSignature, dbTeam.RosterSignature)
+			if dbTeam.UpdatedAt == nil || !dbTeam.UpdatedAt.Equal(*dbTeamBefore.UpdatedAt) {
+				t.Fatalf("expected UpdatedAt to stay %v, got %v",
+					*dbTeamBefore.UpdatedAt, dbTeam.UpdatedAt)
+			}
+		})
+
+		t.Run("reject roster signed before the existing roster", func(t *testing.T) {
+			roster1 := `
+				uuid = "4b6f4c1c-9c31-11e9-8d1f-4f4b1e0e2a5d"
+				name = "BEFORE"
+
+				[[person]]
+				email = "test4@example.com"
+				fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+				is_admin = true`
+
+			roster2 := `
+				uuid = "4b6f4c1c-9c31-11e9-8d1f-4f4b1e0e2a5d"
+				name = "AFTER"
+
+				[[person]]
+				email = "test4@example.com"
+				fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+				is_admin = true`
+
+			requestData1 := makeSignedRequest(t, roster1, unlockedKey)
+			response1 := callAPI(t, "POST", "/v1/teams", requestData1, &signerFingerprint)
+			assertStatusCode(t, http.StatusCreated, response1.Code)
+
+			backDatedSignature, err := makeArmoredDetachedSignatureAt(
+				[]byte(roster2), unlockedKey, time.Now().Add(-time.Hour))
+			assert.NoError(t, err)
+
+			requestData2 := v1structs.UpsertTeamRequest{
+				TeamRoster:               roster2,
+				ArmoredDetachedSignature: backDatedSignature,
+			}
+			response2 := callAPI(t, "POST", "/v1/teams", requestData2, &signerFingerprint)
+			assertStatusCode(t, http.StatusBadRequest, response2.Code)
+			assertHasJSONErrorDetail(t, response2.Body,
+				"roster signature is older than the signature on the existing roster")
+		})
+
+		t.Run("If-Match version", func(t *testing.T) {
+			rosterAtVersion := func(version int) string {
+				return fmt.Sprintf(`
+				uuid = "c2b8e7a4-0f4e-11ea-8d71-362b9e155667"
+				name = "VERSION %d"
+				version = %d
+
+				[[person]]
+				email = "test4@example.com"
+				fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+				is_admin = true`, version, version)
+			}
+
+			requestData1 := makeSignedRequest(t, rosterAtVersion(1), unlockedKey)
+			response1 := callAPI(t, "POST", "/v1/teams", requestData1, &signerFingerprint)
+			assertStatusCode(t, http.StatusCreated, response1.Code)
+
+			t.Run("matching the stored version accepts the update", func(t *testing.T) {
+				requestData := makeSignedRequest(t, rosterAtVersion(2), unlockedKey)
+				response := callAPIWithHeaders(t, "POST", "/v1/teams", requestData,
+					&signerFingerprint, map[string]string{"If-Match": `"1"`})
+				assertStatusCode(t, http.StatusOK, response.Code)
+			})
+
+			t.Run("stale version is rejected with 409", func(t *testing.T) {
+				// another admin, who last saw version 1, tries to save their own changes
+				requestData := makeSignedRequest(t, rosterAtVersion(2), unlockedKey)
+				response := callAPIWithHeaders(t, "POST", "/v1/teams", requestData,
+					&signerFingerprint, map[string]string{"If-Match": `"1"`})
+				assertStatusCode(t, http.StatusConflict, response.Code)
+				assertHasJSONErrorDetail(t, response.Body, errRosterVersionConflict.Error())
+
+				retrievedTeam, err := loadExistingTeam(
+					nil, uuid.Must(uuid.FromString("c2b8e7a4-0f4e-11ea-8d71-362b9e155667")),
+				)
+				assert.NoError(t, err)
+				assert.Equal(t, uint(2), retrievedTeam.Version)
+			})
+
+			t.Run("team that doesn't exist is rejected with 409", func(t *testing.T) {
+				roster := `
+				uuid = "d7d4f2de-0f4e-11ea-8d71-362b9e155667"
+				version = 1
+
+				[[person]]
+				email = "test4@example.com"
+				fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+				is_admin = true`
+
+				requestData := makeSignedRequest(t, roster, unlockedKey)
+				response := callAPIWithHeaders(t, "POST", "/v1/teams", requestData,
+					&signerFingerprint, map[string]string{"If-Match": `"1"`})
+				assertStatusCode(t, http.StatusConflict, response.Code)
+			})
+
+			t.Run("invalid header is rejected with 400", func(t *testing.T) {
+				requestData := makeSignedRequest(t, rosterAtVersion(3), unlockedKey)
+				response := callAPIWithHeaders(t, "POST", "/v1/teams", requestData,
+					&signerFingerprint, map[string]string{"If-Match": "*"})
+				assertStatusCode(t, http.StatusBadRequest, response.Code)
+			})
+		})
+
 		t.Run("signer cannot demote themselves as admin", func(t *testing.T) {
 			roster1 := `
 				uuid = "6aa9b9b8-463e-11e9-8a5f-7753b9c9218c"
@@ -495,7 +820,7 @@ is_admin = true
 			)
 
 			defer func() {
-				datastore.DeletePublicKey(unauthorizedKey.Fingerprint())
+				datastore.DeletePublicKey(nil, unauthorizedKey.Fingerprint())
 			}()
 
 			requestData2 := makeSignedRequest(t, roster2, unauthorizedKey)
@@ -525,74 +850,430 @@ func makeSignedRequest(t *testing.T, roster string, privateKey *pgpkey.PgpKey) v
 	return requestData
 }
 
-func TestGetTeamHandler(t *testing.T) {
-	now := time.Date(2019, 2, 28, 16, 35, 45, 0, time.UTC)
-	exampleTeam := datastore.Team{
-		UUID: uuid.Must(uuid.FromString("aee4b386-3b52-11e9-a620-2381a199e2c8")),
-		Roster: `uuid = "aee4b386-3b52-11e9-a620-2381a199e2c8"
-		name = "Example Team"
+func TestUpsertTeamHandlerRequiredAdminSignatures(t *testing.T) {
+	key4, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+	key3, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey3, "test3")
+	assert.NoError(t, err)
+	key2, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey2, "test2")
+	assert.NoError(t, err)
 
-		[[person]]
-			email = "test4@example.com"
-			fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
-			is_admin = true`,
-		RosterSignature: "",
-		CreatedAt:       now,
+	teamUUID := uuid.Must(uuid.FromString("c5d7e4a8-a5a1-11e9-8f4b-1b2c3d4e5f60"))
+
+	makeRoster := func(version int) string {
+		return fmt.Sprintf(`
+uuid = "c5d7e4a8-a5a1-11e9-8f4b-1b2c3d4e5f60"
+version = %d
+
+[[person]]
+email = "test4@example.com"
+fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+is_admin = true
+
+[[person]]
+email = "test3@example.com"
+fingerprint = "7C18 DE4D E478 1356 8B24  3AC8 719B D63E F03B DC20"
+is_admin = true
+
+[[person]]
+email = "test2@example.com"
+fingerprint = "5C78 E71F 6FEF B558 2965  4CC5 343C C240 D350 C30C"
+is_admin = false
+`, version)
 	}
 
-	setup := func() {
-		assert.NoError(t, datastore.UpsertTeam(nil, exampleTeam))
+	sign := func(t *testing.T, roster string, key *pgpkey.PgpKey) string {
+		t.Helper()
+		signature, err := makeArmoredDetachedSignature([]byte(roster), key)
+		assert.NoError(t, err)
+		return signature
 	}
 
-	teardown := func() {
-		_, err := datastore.DeleteTeam(nil, exampleTeam.UUID)
+	requiredAdminSignatures := func(t *testing.T) int {
+		t.Helper()
+		dbTeam, err := datastore.GetTeam(nil, teamUUID)
 		assert.NoError(t, err)
+		return dbTeam.RequiredAdminSignatures
 	}
 
-	setup()
-	defer teardown()
+	signerFingerprint := key4.Fingerprint()
 
-	t.Run("for existing team", func(t *testing.T) {
-		mockResponse := callAPI(t,
-			"GET", "/v1/team/aee4b386-3b52-11e9-a620-2381a199e2c8", nil, nil)
+	for _, armoredPublicKey := range []string{
+		exampledata.ExamplePublicKey4, exampledata.ExamplePublicKey3, exampledata.ExamplePublicKey2,
+	} {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, armoredPublicKey))
+	}
+	assert.NoError(t, datastore.LinkEmailToFingerprint(
+		nil, "test4@example.com", exampledata.ExampleFingerprint4, nil))
+
+	defer func() {
+		for _, fingerprint := range []fpr.Fingerprint{
+			exampledata.ExampleFingerprint4,
+			exampledata.ExampleFingerprint3,
+			exampledata.ExampleFingerprint2,
+		} {
+			_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+			assert.NoError(t, err)
+		}
+		_, err := datastore.DeleteTeam(nil, teamUUID)
+		assert.NoError(t, err)
+	}()
 
-		t.Run("status code 200", func(t *testing.T) {
-			assertStatusCode(t, http.StatusOK, mockResponse.Code)
-		})
+	t.Run("new team sets the policy", func(t *testing.T) {
+		roster := makeRoster(1)
+		requestData := makeSignedRequest(t, roster, key4)
+		requestData.RequiredAdminSignatures = 2
 
-		t.Run("response has json content type", func(t *testing.T) {
-			assert.Equal(t, "application/json", mockResponse.Header().Get("content-type"))
-		})
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusCreated, response.Code)
+		assert.Equal(t, 2, requiredAdminSignatures(t))
+	})
 
-		t.Run("response body has name in JSON", func(t *testing.T) {
-			expected := "{\n    \"name\": \"Example Team\"\n}"
-			got := mockResponse.Body.String()
+	roster := makeRoster(2)
 
-			if got != expected {
-				t.Errorf("unexpected body, expected `%v`, got `%v`", expected, got)
-			}
-		})
+	t.Run("update signed by one admin is refused", func(t *testing.T) {
+		requestData := makeSignedRequest(t, roster, key4)
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"can't update team: it needs signatures from 2 admins, got 1")
 	})
 
-	t.Run("for non existent team", func(t *testing.T) {
-		// this UUID doesn't exist
-		mockResponse := callAPI(t,
-			"GET", "/v1/team/8d79a1a6-3b67-11e9-b2dc-9f62d9775810", nil, nil)
+	t.Run("update signed twice by the same admin is refused", func(t *testing.T) {
+		requestData := makeSignedRequest(t, roster, key4)
+		requestData.AdditionalArmoredDetachedSignatures = []string{sign(t, roster, key4)}
 
-		t.Run("status code 404", func(t *testing.T) {
-			assertStatusCode(t, http.StatusNotFound, mockResponse.Code)
-		})
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"can't update team: it needs signatures from 2 admins, got 1")
 	})
 
-	t.Run("for a team with a unparseable roster", func(t *testing.T) {
-		badRosterTeam := datastore.Team{
-			UUID:      uuid.Must(uuid.FromString("e9e6ab6e-3b67-11e9-a57c-8f865d47e520")),
-			Roster:    "broken roster, no team name",
-			CreatedAt: now,
+	t.Run("additional signature from a member who isn't an admin is rejected", func(t *testing.T) {
+		requestData := makeSignedRequest(t, roster, key4)
+		requestData.AdditionalArmoredDetachedSignatures = []string{sign(t, roster, key2)}
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"additional signature 1: not a valid signature of the roster by a team admin")
+	})
+
+	t.Run("additional signature of a different roster is rejected", func(t *testing.T) {
+		requestData := makeSignedRequest(t, roster, key4)
+		requestData.AdditionalArmoredDetachedSignatures = []string{
+			sign(t, makeRoster(99), key3),
 		}
 
-		assert.NoError(t, datastore.UpsertTeam(nil, badRosterTeam))
-		defer func() {
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"additional signature 1: not a valid signature of the roster by a team admin")
+	})
+
+	t.Run("policy can't need more signatures than there are admins", func(t *testing.T) {
+		requestData := makeSignedRequest(t, roster, key4)
+		requestData.AdditionalArmoredDetachedSignatures = []string{sign(t, roster, key3)}
+		requestData.RequiredAdminSignatures = 3
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"roster has fewer admins than the number of admin signatures required")
+	})
+
+	t.Run("policy can't be negative", func(t *testing.T) {
+		requestData := makeSignedRequest(t, roster, key4)
+		requestData.RequiredAdminSignatures = -1
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"invalid `requiredAdminSignatures`: cannot be negative")
+	})
+
+	t.Run("update signed by two admins is accepted and keeps the policy", func(t *testing.T) {
+		requestData := makeSignedRequest(t, roster, key4)
+		requestData.AdditionalArmoredDetachedSignatures = []string{sign(t, roster, key3)}
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusOK, response.Code)
+		assert.Equal(t, 2, requiredAdminSignatures(t))
+	})
+
+	t.Run("two admins can lower the policy", func(t *testing.T) {
+		roster := makeRoster(3)
+		requestData := makeSignedRequest(t, roster, key4)
+		requestData.AdditionalArmoredDetachedSignatures = []string{sign(t, roster, key3)}
+		requestData.RequiredAdminSignatures = 1
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusOK, response.Code)
+		assert.Equal(t, 1, requiredAdminSignatures(t))
+	})
+
+	t.Run("then one admin can update the team", func(t *testing.T) {
+		requestData := makeSignedRequest(t, makeRoster(4), key4)
+
+		response := callAPI(t, "POST", "/v1/teams", requestData, &signerFingerprint)
+		assertStatusCode(t, http.StatusOK, response.Code)
+	})
+}
+
+func TestValidateTeamRosterHandler(t *testing.T) {
+	const path = "/v1/teams/validate"
+
+	t.Run("valid roster", func(t *testing.T) {
+		roster := `
+uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"
+name = "Kiffix"
+version = 2
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = true
+
+[[person]]
+email = "bob@example.com"
+fingerprint = "BBBB BBBB BBBB BBBB BBBB  BBBB BBBB BBBB BBBB BBBB"
+is_admin = false
+`
+		response := callAPI(t, "POST", path,
+			v1structs.ValidateTeamRosterRequest{TeamRoster: roster}, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.ValidateTeamRosterResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		assert.Equal(t, v1structs.ValidateTeamRosterResponse{
+			UUID:    "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7",
+			Name:    "Kiffix",
+			Version: 2,
+			Members: []v1structs.TeamMember{
+				{
+					Email:       "alice@example.com",
+					Fingerprint: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+					IsAdmin:     true,
+				},
+				{
+					Email:       "bob@example.com",
+					Fingerprint: "BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB",
+					IsAdmin:     false,
+				},
+			},
+		}, responseData)
+
+		t.Run("doesn't store the team", func(t *testing.T) {
+			_, err := datastore.GetTeam(
+				nil, uuid.Must(uuid.FromString("9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7")))
+			assert.Equal(t, datastore.ErrNotFound, err)
+		})
+	})
+
+	invalidRosters := []struct {
+		name          string
+		roster        string
+		expectedError string
+	}{
+		{
+			name:          "missing roster",
+			roster:        "",
+			expectedError: "missing teamRoster",
+		},
+		{
+			name:   "bad TOML",
+			roster: `uuid = `,
+			expectedError: "error in toml.DecodeReader: Near line 1 (last key parsed 'uuid'): " +
+				"expected value but found '\\x00' instead",
+		},
+		{
+			name: "missing UUID",
+			roster: `
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = true
+`,
+			expectedError: "error validating team: invalid roster: invalid UUID",
+		},
+		{
+			name: "email listed twice",
+			roster: `
+uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = true
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "BBBB BBBB BBBB BBBB BBBB  BBBB BBBB BBBB BBBB BBBB"
+is_admin = false
+`,
+			expectedError: "error validating team: email listed more than once: alice@example.com",
+		},
+		{
+			name: "invalid email",
+			roster: `
+uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"
+
+[[person]]
+email = "alice.example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = true
+`,
+			expectedError: `error validating team: invalid email address: "alice.example.com"`,
+		},
+		{
+			name: "fingerprint listed twice",
+			roster: `
+uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = true
+
+[[person]]
+email = "bob@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = false
+`,
+			expectedError: "error validating team: fingerprint listed more than once: " +
+				"AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA",
+		},
+		{
+			name:          "no members",
+			roster:        `uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"`,
+			expectedError: "error validating team: team has no members",
+		},
+		{
+			name: "no admins",
+			roster: `
+uuid = "9ca1a3e4-a4b5-11e9-a6d6-3bd2d0b6f3f7"
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = false
+`,
+			expectedError: "error validating team: team has no administrators",
+		},
+	}
+
+	for _, test := range invalidRosters {
+		t.Run(test.name, func(t *testing.T) {
+			response := callAPI(t, "POST", path,
+				v1structs.ValidateTeamRosterRequest{TeamRoster: test.roster}, nil)
+			assertStatusCode(t, http.StatusBadRequest, response.Code)
+			assertHasJSONErrorDetail(t, response.Body, test.expectedError)
+		})
+	}
+
+	t.Run("roster with several problems lists them all", func(t *testing.T) {
+		roster := `
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = false
+
+[[person]]
+email = "alice@example.com"
+fingerprint = "AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA"
+is_admin = false
+`
+		response := callAPI(t, "POST", path,
+			v1structs.ValidateTeamRosterRequest{TeamRoster: roster}, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+
+		responseData := v1structs.ErrorResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		expectedErrors := []string{
+			"invalid roster: invalid UUID",
+			"email listed more than once: alice@example.com",
+			"fingerprint listed more than once: " +
+				"AAAA AAAA AAAA AAAA AAAA  AAAA AAAA AAAA AAAA AAAA",
+			"team has no administrators",
+		}
+		assert.Equal(t, expectedErrors, responseData.Errors)
+		assert.Equal(t, strings.Join(expectedErrors, "; "), responseData.Detail)
+	})
+
+	testEndpointRejectsBadJSON(t, "POST", path, nil)
+}
+
+func TestGetTeamHandler(t *testing.T) {
+	now := time.Date(2019, 2, 28, 16, 35, 45, 0, time.UTC)
+	exampleTeam := datastore.Team{
+		UUID: uuid.Must(uuid.FromString("aee4b386-3b52-11e9-a620-2381a199e2c8")),
+		Roster: `uuid = "aee4b386-3b52-11e9-a620-2381a199e2c8"
+		name = "Example Team"
+
+		[[person]]
+			email = "test4@example.com"
+			fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+			is_admin = true`,
+		RosterSignature: "",
+		CreatedAt:       now,
+	}
+
+	setup := func() {
+		assert.NoError(t, datastore.UpsertTeam(nil, exampleTeam))
+	}
+
+	teardown := func() {
+		_, err := datastore.DeleteTeam(nil, exampleTeam.UUID)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	t.Run("for existing team", func(t *testing.T) {
+		mockResponse := callAPI(t,
+			"GET", "/v1/team/aee4b386-3b52-11e9-a620-2381a199e2c8", nil, nil)
+
+		t.Run("status code 200", func(t *testing.T) {
+			assertStatusCode(t, http.StatusOK, mockResponse.Code)
+		})
+
+		t.Run("response has json content type", func(t *testing.T) {
+			assert.Equal(t, "application/json", mockResponse.Header().Get("content-type"))
+		})
+
+		t.Run("response body has name in JSON", func(t *testing.T) {
+			expected := "{\n    \"name\": \"Example Team\"\n}"
+			got := mockResponse.Body.String()
+
+			if got != expected {
+				t.Errorf("unexpected body, expected `%v`, got `%v`", expected, got)
+			}
+		})
+	})
+
+	t.Run("for non existent team", func(t *testing.T) {
+		// this UUID doesn't exist
+		mockResponse := callAPI(t,
+			"GET", "/v1/team/8d79a1a6-3b67-11e9-b2dc-9f62d9775810", nil, nil)
+
+		t.Run("status code 404", func(t *testing.T) {
+			assertStatusCode(t, http.StatusNotFound, mockResponse.Code)
+		})
+	})
+
+	t.Run("for a team with a unparseable roster", func(t *testing.T) {
+		badRosterTeam := datastore.Team{
+			UUID:      uuid.Must(uuid.FromString("e9e6ab6e-3b67-11e9-a57c-8f865d47e520")),
+			Roster:    "broken roster, no team name",
+			CreatedAt: now,
+		}
+
+		assert.NoError(t, datastore.UpsertTeam(nil, badRosterTeam))
+		defer func() {
 			datastore.DeleteTeam(nil, badRosterTeam.UUID)
 		}()
 
@@ -612,6 +1293,197 @@ func TestGetTeamHandler(t *testing.T) {
 	})
 }
 
+func TestListTeamsHandler(t *testing.T) {
+	now := time.Date(2019, 2, 28, 16, 35, 45, 0, time.UTC)
+
+	adminTeam := datastore.Team{
+		UUID: uuid.Must(uuid.FromString("2e7b1c3e-5a8e-4d6f-9c1b-1f2d3e4a5b6c")),
+		Roster: `uuid = "2e7b1c3e-5a8e-4d6f-9c1b-1f2d3e4a5b6c"
+		name = "Admin Team"
+
+		[[person]]
+			email = "test4@example.com"
+			fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+			is_admin = true`,
+		CreatedAt: now,
+	}
+
+	memberTeam := datastore.Team{
+		UUID: uuid.Must(uuid.FromString("8c4d2f1a-7b3e-4e5d-a6c7-9d8e7f6a5b4c")),
+		Roster: `uuid = "8c4d2f1a-7b3e-4e5d-a6c7-9d8e7f6a5b4c"
+		name = "Member Team"
+
+		[[person]]
+			email = "test3@example.com"
+			fingerprint = "7C18 DE4D E478 1356 8B24  3AC8 719B D63E F03B DC20"
+			is_admin = true
+
+		[[person]]
+			email = "test4@example.com"
+			fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+			is_admin = false`,
+		CreatedAt: now,
+	}
+
+	setup := func() {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+		assert.NoError(t, datastore.UpsertTeam(nil, adminTeam))
+		assert.NoError(t, datastore.UpsertTeam(nil, memberTeam))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+		assert.NoError(t, err)
+		_, err = datastore.DeleteTeam(nil, adminTeam.UUID)
+		assert.NoError(t, err)
+		_, err = datastore.DeleteTeam(nil, memberTeam.UUID)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/teams", nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("key in two teams", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/teams", nil, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.ListTeamsResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		assert.Equal(t, []v1structs.TeamMembership{
+			{UUID: adminTeam.UUID.String(), Name: "Admin Team", IsAdmin: true},
+			{UUID: memberTeam.UUID.String(), Name: "Member Team", IsAdmin: false},
+		}, responseData.Teams)
+	})
+
+	t.Run("key in no teams", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/teams", nil, &exampledata.ExampleFingerprint2)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.ListTeamsResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+		assert.Equal(t, []v1structs.TeamMembership{}, responseData.Teams)
+	})
+}
+
+func TestGetTeamMemberHandler(t *testing.T) {
+	now := time.Date(2019, 2, 28, 16, 35, 45, 0, time.UTC)
+	roster := `
+            name = "Example"
+			uuid = "5f0a2c64-9d3b-4b8e-8f1e-2c7d6b5a4e3f"
+
+			[[ person ]]
+			email = "test4@example.com"
+			fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+			is_admin = true
+
+			[[ person ]]
+			email = "test3@example.com"
+			fingerprint = "7C18 DE4D E478 1356 8B24  3AC8 719B D63E F03B DC20"
+			is_admin = false`
+
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
+		exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+
+	signature, err := makeArmoredDetachedSignature([]byte(roster), unlockedKey)
+	assert.NoError(t, err)
+
+	team := datastore.Team{
+		UUID:            uuid.Must(uuid.FromString("5f0a2c64-9d3b-4b8e-8f1e-2c7d6b5a4e3f")),
+		Roster:          roster,
+		RosterSignature: signature,
+		CreatedAt:       now,
+	}
+
+	setup := func() {
+		assert.NoError(t, datastore.UpsertTeam(nil, team))
+
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+	}
+
+	teardown := func() {
+		_, err := datastore.DeleteTeam(nil, team.UUID)
+		assert.NoError(t, err)
+
+		for _, fingerprint := range []fingerprint.Fingerprint{
+			exampledata.ExampleFingerprint4,
+			exampledata.ExampleFingerprint3,
+			exampledata.ExampleFingerprint2,
+		} {
+			_, _, err = datastore.DeletePublicKey(nil, fingerprint)
+			assert.NoError(t, err)
+		}
+	}
+
+	setup()
+	defer teardown()
+
+	memberPath := func(fingerprint fingerprint.Fingerprint) string {
+		return fmt.Sprintf("/v1/team/%s/members/%s", team.UUID, fingerprint.Hex())
+	}
+
+	tests := []struct {
+		name     string
+		member   fingerprint.Fingerprint
+		expected v1structs.GetTeamMemberResponse
+	}{
+		{
+			"admin",
+			exampledata.ExampleFingerprint4,
+			v1structs.GetTeamMemberResponse{IsMember: true, IsAdmin: true},
+		},
+		{
+			"member",
+			exampledata.ExampleFingerprint3,
+			v1structs.GetTeamMemberResponse{IsMember: true, IsAdmin: false},
+		},
+		{
+			"non-member",
+			exampledata.ExampleFingerprint2,
+			v1structs.GetTeamMemberResponse{IsMember: false, IsAdmin: false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := callAPI(t,
+				"GET", memberPath(test.member), nil, &exampledata.ExampleFingerprint3)
+			assertStatusCode(t, http.StatusOK, response.Code)
+
+			responseData := v1structs.GetTeamMemberResponse{}
+			assertBodyDecodesInto(t, response.Body, &responseData)
+			assert.Equal(t, test.expected, responseData)
+		})
+	}
+
+	t.Run("requester isn't in the team", func(t *testing.T) {
+		response := callAPI(t,
+			"GET", memberPath(exampledata.ExampleFingerprint4), nil,
+			&exampledata.ExampleFingerprint2)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, "requesting key is not in the team")
+	})
+
+	t.Run("team doesn't exist", func(t *testing.T) {
+		response := callAPI(t,
+			"GET", fmt.Sprintf("/v1/team/%s/members/%s",
+				uuid.Must(uuid.NewV4()), exampledata.ExampleFingerprint4.Hex()),
+			nil, &exampledata.ExampleFingerprint4)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+	})
+}
+
 func TestCreateRequestToJoinTeamHandler(t *testing.T) {
 	now := time.Date(2019, 2, 28, 16, 35, 45, 0, time.UTC)
 	exampleTeam := datastore.Team{
@@ -635,7 +1507,7 @@ func TestCreateRequestToJoinTeamHandler(t *testing.T) {
 		_, err := datastore.DeleteTeam(nil, exampleTeam.UUID)
 		assert.NoError(t, err)
 
-		_, err = datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 	}
 
@@ -744,9 +1616,84 @@ func TestCreateRequestToJoinTeamHandler(t *testing.T) {
 					"with a different fingerprint")
 		})
 
-		datastore.DeletePublicKey(exampledata.ExampleFingerprint2)
+		datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint2)
 	})
 
+	t.Run("concurrent requests with different fingerprints: one wins, the rest conflict",
+		func(t *testing.T) {
+			team := datastore.Team{
+				UUID:            uuid.Must(uuid.NewV4()),
+				Roster:          "name = \"Example Team\"",
+				RosterSignature: "",
+				CreatedAt:       now,
+			}
+			assert.NoError(t, datastore.UpsertTeam(nil, team))
+			defer func() {
+				_, err := datastore.DeleteTeam(nil, team.UUID)
+				assert.NoError(t, err)
+			}()
+
+			assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey2))
+			defer datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint2)
+
+			// an email can only be verified for one key at a time, so race key 4's requests
+			// against a conflicting request for key 2 stored directly, as a concurrent
+			// handler would
+			requestData := v1structs.RequestToJoinTeamRequest{
+				TeamEmail: "racing-example@example.com",
+			}
+			assert.NoError(t,
+				datastore.LinkEmailToFingerprint(
+					nil, "racing-example@example.com", exampledata.ExampleFingerprint4, nil,
+				))
+
+			const numRequests = 5
+			codes := make(chan int, numRequests+1)
+			start := make(chan struct{})
+
+			for i := 0; i < numRequests; i++ {
+				go func() {
+					<-start
+					response := callAPI(t,
+						"POST", fmt.Sprintf("/v1/team/%s/requests-to-join", team.UUID),
+						requestData, &exampledata.ExampleFingerprint4)
+					codes <- response.Code
+				}()
+			}
+			go func() {
+				<-start
+				_, err := datastore.CreateRequestToJoinTeam(
+					nil, team.UUID, "racing-example@example.com",
+					exampledata.ExampleFingerprint2, now)
+				if err == nil {
+					codes <- http.StatusCreated
+				} else {
+					codes <- http.StatusConflict
+				}
+			}()
+			close(start)
+
+			counts := map[int]int{}
+			for i := 0; i < numRequests+1; i++ {
+				counts[<-codes]++
+			}
+
+			stored, err := datastore.GetRequestToJoinTeam(
+				nil, team.UUID, "racing-example@example.com")
+			assert.NoError(t, err)
+
+			// exactly one request was created. if it was key 4's, its other requests were
+			// identical (200), otherwise they all conflict (409)
+			assert.Equal(t, 1, counts[http.StatusCreated])
+			if stored.Fingerprint == exampledata.ExampleFingerprint4 {
+				assert.Equal(t, numRequests-1, counts[http.StatusOK])
+				assert.Equal(t, 1, counts[http.StatusConflict])
+			} else {
+				assert.Equal(t, 0, counts[http.StatusOK])
+				assert.Equal(t, numRequests, counts[http.StatusConflict])
+			}
+		})
+
 	t.Run("existing {team, email, fingerprint} request should succeed", func(t *testing.T) {
 		team := datastore.Team{
 			UUID:            uuid.Must(uuid.NewV4()),
@@ -835,7 +1782,7 @@ fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
 	}
 
 	teardown := func() {
-		_, err := datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 
 		_, err = datastore.DeleteTeam(nil, teamUUID)
@@ -899,10 +1846,17 @@ func TestGetTeamRoster(t *testing.T) {
 			fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
 			is_admin = true`
 
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
+		exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+
+	signature, err := makeArmoredDetachedSignature([]byte(roster), unlockedKey)
+	assert.NoError(t, err)
+
 	team := datastore.Team{
 		UUID:            uuid.Must(uuid.FromString("18d12a10-4678-11e9-ba93-2385e4a50ded")),
 		Roster:          roster,
-		RosterSignature: "fake signature",
+		RosterSignature: signature,
 		CreatedAt:       now,
 	}
 
@@ -917,10 +1871,10 @@ func TestGetTeamRoster(t *testing.T) {
 		_, err := datastore.DeleteTeam(nil, team.UUID)
 		assert.NoError(t, err)
 
-		_, err = datastore.DeletePublicKey(exampledata.ExampleFingerprint4)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
 		assert.NoError(t, err)
 
-		_, err = datastore.DeletePublicKey(exampledata.ExampleFingerprint2)
+		_, _, err = datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint2)
 		assert.NoError(t, err)
 	}
 
@@ -981,6 +1935,37 @@ func TestGetTeamRoster(t *testing.T) {
 			assert.Equal(t, team.RosterSignature, responseData.ArmoredDetachedSignature)
 		})
 
+		t.Run("responseData.signerFingerprint", func(t *testing.T) {
+			assert.Equal(t, exampledata.ExampleFingerprint4.Hex(), responseData.SignerFingerprint)
+		})
+
+		t.Run("ETag is the roster version", func(t *testing.T) {
+			assert.Equal(t, `"0"`, response.Header().Get("ETag"))
+		})
+	})
+
+	t.Run("HEAD for a valid team member", func(t *testing.T) {
+		response := callAPI(t,
+			"HEAD", fmt.Sprintf("/v1/team/%s/roster", team.UUID),
+			nil, &exampledata.ExampleFingerprint4,
+		)
+
+		assertStatusCode(t, http.StatusOK, response.Code)
+		assert.Equal(t, `"0"`, response.Header().Get("ETag"))
+
+		if response.Body.Len() != 0 {
+			t.Errorf("expected empty body, got %q", response.Body.String())
+		}
+	})
+
+	t.Run("HEAD when request key is not in the roster returns 403 forbidden", func(t *testing.T) {
+		response := callAPI(t,
+			"HEAD", fmt.Sprintf("/v1/team/%s/roster", team.UUID),
+			nil, &exampledata.ExampleFingerprint2,
+		)
+
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assert.Equal(t, "", response.Header().Get("ETag"))
 	})
 
 	testEndpointRejectsUnauthenticated(t, "GET", fmt.Sprintf("/v1/team/%s/roster", team.UUID), nil)
@@ -1006,4 +1991,183 @@ func TestGetTeamRoster(t *testing.T) {
 		assertHasJSONErrorDetail(t, response.Body, "requesting key is not in the team")
 	})
 
+	t.Run("stored signature doesn't match stored roster", func(t *testing.T) {
+		mismatchedSignature, err := makeArmoredDetachedSignature(
+			[]byte(roster+"\n# tampered"), unlockedKey)
+		assert.NoError(t, err)
+
+		tamperedTeam := team
+		tamperedTeam.RosterSignature = mismatchedSignature
+		assert.NoError(t, datastore.UpsertTeam(nil, tamperedTeam))
+		defer func() { assert.NoError(t, datastore.UpsertTeam(nil, team)) }()
+
+		response := callAPI(t,
+			"GET", fmt.Sprintf("/v1/team/%s/roster", team.UUID),
+			nil, &exampledata.ExampleFingerprint4,
+		)
+
+		assertStatusCode(t, http.StatusInternalServerError, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"stored roster signature isn't valid for any of the team's admins")
+	})
+
+	t.Run("stored signature made by a deleted admin key", func(t *testing.T) {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+		defer func() {
+			assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		}()
+
+		assert.NoError(t, validateStoredRosterSignature(
+			mustLoadTeam(t, roster, signature), roster, signature))
+	})
+}
+
+func TestRosterSignerFingerprint(t *testing.T) {
+	roster := `
+uuid = "18d12a10-4678-11e9-ba93-2385e4a50ded"
+
+[[person]]
+email = "test2@example.com"
+fingerprint = "5C78 E71F 6FEF B558 2965  4CC5 343C C240 D350 C30C"
+is_admin = false
+
+[[person]]
+email = "test4@example.com"
+fingerprint = "BB3C 44BF 188D 56E6 35F4  A092 F73D 2F05 33D7 F9D6"
+is_admin = true
+`
+	loadedTeam, err := parseRoster(roster)
+	assert.NoError(t, err)
+
+	t.Run("signed by an admin", func(t *testing.T) {
+		key4, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
+			exampledata.ExamplePrivateKey4, "test4")
+		assert.NoError(t, err)
+		signature, err := makeArmoredDetachedSignature([]byte(roster), key4)
+		assert.NoError(t, err)
+
+		signer, err := rosterSignerFingerprint(loadedTeam, signature)
+		assert.NoError(t, err)
+		if signer == nil {
+			t.Fatalf("expected signer %s, got nil", exampledata.ExampleFingerprint4)
+		}
+		assert.Equal(t, exampledata.ExampleFingerprint4, *signer)
+	})
+
+	t.Run("signed by a member who isn't an admin", func(t *testing.T) {
+		key2, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
+			exampledata.ExamplePrivateKey2, "test2")
+		assert.NoError(t, err)
+		signature, err := makeArmoredDetachedSignature([]byte(roster), key2)
+		assert.NoError(t, err)
+
+		signer, err := rosterSignerFingerprint(loadedTeam, signature)
+		assert.NoError(t, err)
+		if signer != nil {
+			t.Fatalf("expected nil signer, got %s", signer)
+		}
+	})
+
+	t.Run("signed by an admin's subkey", func(t *testing.T) {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		defer func() {
+			_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+			assert.NoError(t, err)
+		}()
+
+		key4, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
+			exampledata.ExamplePrivateKey4, "test4")
+		assert.NoError(t, err)
+		signature, err := makeArmoredDetachedSignatureBySubkey([]byte(roster), key4)
+		assert.NoError(t, err)
+
+		signer, err := rosterSignerFingerprint(loadedTeam, signature)
+		assert.NoError(t, err)
+		if signer == nil {
+			t.Fatalf("expected signer %s, got nil", exampledata.ExampleFingerprint4)
+		}
+		assert.Equal(t, exampledata.ExampleFingerprint4, *signer)
+	})
+
+	t.Run("signature isn't valid armor", func(t *testing.T) {
+		_, err := rosterSignerFingerprint(loadedTeam, "not a signature")
+		assert.GotError(t, err)
+	})
+}
+
+func mustLoadTeam(t *testing.T, roster string, signature string) *team.Team {
+	t.Helper()
+	loaded, err := team.Load(roster, signature)
+	assert.NoError(t, err)
+	return loaded
+}
+
+// assertRecordedRosterSignatureFailure checks that the most recently recorded suspicious request
+// is of the given kind and from the given fingerprint.
+func assertRecordedRosterSignatureFailure(
+	t *testing.T, kind string, signer fingerprint.Fingerprint) {
+
+	t.Helper()
+	requests, err := datastore.ListSuspiciousRequests(nil)
+	assert.NoError(t, err)
+	if len(requests) == 0 {
+		t.Fatalf("expected a suspicious request to be recorded, got none")
+	}
+	last := requests[len(requests)-1]
+	assert.Equal(t, kind, last.Kind)
+	assert.Equal(t, signer, last.Fingerprint)
+}
+
+func TestValidateRosterSignatureHash(t *testing.T) {
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(
+		exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+
+	roster := []byte("uuid = \"74bb40b4-3510-11e9-968e-53c38df634be\"\n")
+
+	t.Run("SHA-256 signature is allowed", func(t *testing.T) {
+		signature, err := makeArmoredDetachedSignatureWithHash(roster, unlockedKey, crypto.SHA256)
+		assert.NoError(t, err)
+		assert.NoError(t, validateRosterSignatureHash(signature))
+	})
+
+	t.Run("SHA-1 signature isn't allowed", func(t *testing.T) {
+		signature, err := makeArmoredDetachedSignatureWithHash(roster, unlockedKey, crypto.SHA1)
+		assert.NoError(t, err)
+		assert.Equal(t, errRosterSignatureHashNotAllowed, validateRosterSignatureHash(signature))
+	})
+
+	t.Run("SHA-1 signature is allowed if configured", func(t *testing.T) {
+		defer func(saved []crypto.Hash) {
+			allowedRosterSignatureHashes = saved
+		}(allowedRosterSignatureHashes)
+		allowedRosterSignatureHashes = []crypto.Hash{crypto.SHA1, crypto.SHA256}
+
+		signature, err := makeArmoredDetachedSignatureWithHash(roster, unlockedKey, crypto.SHA1)
+		assert.NoError(t, err)
+		assert.NoError(t, validateRosterSignatureHash(signature))
+	})
+}
+
+func TestValidateRosterSignatureFresh(t *testing.T) {
+	now := time.Date(2019, 7, 2, 11, 20, 0, 0, time.UTC)
+
+	t.Run("signed just now", func(t *testing.T) {
+		assert.NoError(t, validateRosterSignatureFresh(now, now))
+	})
+
+	t.Run("signed a little in the future, within the clock skew", func(t *testing.T) {
+		assert.NoError(t, validateRosterSignatureFresh(now.Add(time.Minute), now))
+	})
+
+	t.Run("signed further in the future than the clock skew", func(t *testing.T) {
+		assert.Equal(t, errRosterSignatureInFuture, validateRosterSignatureFresh(
+			now.Add(maxRosterSignatureClockSkew+time.Second), now))
+	})
+
+	t.Run("signed longer ago than the maximum age", func(t *testing.T) {
+		assert.Equal(t, errRosterSignatureTooOld, validateRosterSignatureFresh(
+			now.Add(-maxRosterSignatureAge-time.Second), now))
+	})
 }
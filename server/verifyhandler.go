@@ -3,10 +3,19 @@ package server
 import (
 	"database/sql"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/email"
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/fingerprint"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 )
@@ -31,6 +40,11 @@ func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(verifyPage))
 
 	case "POST":
+		if err := discardVerifyBody(r); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
 		err = verifyEmailByUUID(verifyUUID, userAgent(r), ipAddress(r))
 
 		if err != nil {
@@ -44,9 +58,26 @@ func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// discardVerifyBody reads and throws away the body of a verify POST, which is ignored since the
+// UUID in the path is all that's needed. It returns errRequestBodyTooLarge, without reading it
+// all, if the body is bigger than maxVerifyBodyBytes.
+func discardVerifyBody(r *http.Request) error {
+	if r.ContentLength > int64(maxVerifyBodyBytes) {
+		return errRequestBodyTooLarge
+	}
+
+	_, err := io.Copy(
+		ioutil.Discard, &maxBytesReader{reader: r.Body, remaining: int64(maxVerifyBodyBytes)})
+	if err == errRequestBodyTooLarge {
+		return err
+	}
+	return nil // other read errors don't matter: the body isn't used
+}
+
 // verifyEmailByUUID takes a uuid from an email verification link and does the following:
 // * verifies that there's an active email_verification for the UUID
 // * looks up the email address and key id
+// * checks the email is at a domain allowed by ALLOWED_EMAIL_DOMAINS
 // * verifies there no existing email_key_link for the email address
 // * creates an email_key_link
 // * updates the email_verification's verify_user_agent, verify_ip_address
@@ -57,6 +88,11 @@ func verifyEmailByUUID(secretUUID uuid.UUID, userAgent string, ipAddress string)
 			return fmt.Errorf("error getting verification: %v", err)
 		}
 
+		if !email.DomainAllowed(verification.EmailSentTo) {
+			// the allowlist may have been tightened since the link was sent
+			return fmt.Errorf("email domain isn't allowed on this server")
+		}
+
 		_, alreadyLinked, err := datastore.GetArmoredPublicKeyForEmail(txn, verification.EmailSentTo)
 		if err != nil {
 			return err
@@ -71,7 +107,8 @@ func verifyEmailByUUID(secretUUID uuid.UUID, userAgent string, ipAddress string)
 			return fmt.Errorf("Error linking email to key: %v", err)
 		}
 
-		err = datastore.MarkVerificationAsVerified(txn, secretUUID, userAgent, ipAddress)
+		err = datastore.MarkVerificationAsVerified(
+			txn, secretUUID, userAgent, ipAddress, time.Now())
 		if err != nil {
 			return fmt.Errorf("error updating verification: %v", err)
 		}
@@ -80,6 +117,264 @@ func verifyEmailByUUID(secretUUID uuid.UUID, userAgent string, ipAddress string)
 	})
 }
 
+// getEmailVerificationHandler returns when the email address was last verified for the
+// authenticated key.
+func getEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	email := mux.Vars(r)["email"]
+
+	verifiedAt, err := datastore.GetVerificationTimeForEmailFingerprint(
+		nil, email, myPublicKey.Fingerprint())
+	if err == datastore.ErrNotFound {
+		writeJsonError(w,
+			fmt.Errorf("email address '%s' has never been verified for this key", email),
+			http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	responseData := v1structs.GetEmailVerificationResponse{
+		VerifiedAt: *verifiedAt,
+	}
+	writeJsonResponse(w, responseData)
+}
+
+// reverifyEmailHandler sends another verification email for an address on the authorized key,
+// for someone who didn't receive the first one. Like a key upload, it won't send one while an
+// earlier verification for the address is still active.
+func reverifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	emailAddress := mux.Vars(r)["email"]
+
+	if !keyHasEmail(myPublicKey, emailAddress) {
+		writeJsonError(w, errEmailNotOnKey, http.StatusForbidden)
+		return
+	} else if !email.DomainAllowed(emailAddress) {
+		writeJsonError(w,
+			fmt.Errorf("email domain isn't allowed on this server"), http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	var retryAfter time.Duration
+	var verification *email.VerificationEmail
+
+	err = datastore.RunInTransaction(func(txn *sql.Tx) error {
+		_, alreadyLinked, err := datastore.GetArmoredPublicKeyForEmail(txn, emailAddress)
+		if err != nil {
+			return err
+		} else if alreadyLinked {
+			return errEmailAlreadyVerified
+		}
+
+		validUntil, err := datastore.GetActiveVerificationValidUntil(txn, emailAddress, now)
+		if err != nil {
+			return err
+		} else if validUntil != nil {
+			retryAfter = validUntil.Sub(now)
+			return errVerificationAlreadyActive
+		}
+
+		metadata := email.VerificationMetadata{
+			RequestUserAgent: userAgent(r),
+			RequestIpAddress: ipAddress(r),
+			RequestTime:      now,
+		}
+		verification, err = email.CreateVerificationEmail(txn, emailAddress, myPublicKey, metadata)
+		return err
+	})
+
+	if err == nil && verification != nil {
+		err = verification.Send()
+	}
+
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(nil)
+
+	case errEmailAlreadyVerified:
+		writeJsonError(w, err, http.StatusConflict)
+
+	case errVerificationAlreadyActive:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeJsonError(w, err, http.StatusTooManyRequests)
+
+	default:
+		writeJsonError(w, err, http.StatusInternalServerError)
+	}
+}
+
+// getEmailKeyHistoryHandler lists every key the email address has been verified for, oldest
+// first, so its owner can audit key rotations. Only the key the email is currently linked to can
+// see the history: anyone can name that key in the Authorization header, so the history is
+// encrypted to it.
+func getEmailKeyHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	emailAddress := mux.Vars(r)["email"]
+
+	isOwner, err := datastore.QueryEmailVerifiedForFingerprint(
+		nil, emailAddress, myPublicKey.Fingerprint())
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	} else if !isOwner {
+		writeJsonError(w,
+			fmt.Errorf("email address isn't verified for the authorized key"),
+			http.StatusForbidden)
+		return
+	}
+
+	verifications, err := datastore.GetVerificationHistoryForEmail(nil, emailAddress)
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	responseData := v1structs.GetEmailKeyHistoryResponse{
+		Keys: []v1structs.EmailKeyHistoryEntry{},
+	}
+	for _, v := range verifications {
+		responseData.Keys = append(responseData.Keys, v1structs.EmailKeyHistoryEntry{
+			Fingerprint: v.KeyFingerprint.Hex(),
+			CreatedAt:   v.CreatedAt,
+			VerifiedAt:  v.VerifiedAt,
+		})
+	}
+	writeEncryptedJsonResponse(w, responseData, myPublicKey)
+}
+
+// keyHasEmail returns true if the given email address is one of the key's identities, ignoring
+// case.
+func keyHasEmail(publicKey *pgpkey.PgpKey, emailAddress string) bool {
+	for _, keyEmail := range publicKey.Emails(true) {
+		if strings.EqualFold(keyEmail, emailAddress) {
+			return true
+		}
+	}
+	return false
+}
+
+// listVerificationsHandler returns the history of email verifications for the authorized key,
+// so its owner can check for emails they don't recognise, or verifications from unexpected
+// places. The history includes IP addresses and user agents, so it's encrypted to the key: the
+// Authorization header doesn't prove the caller owns it.
+func listVerificationsHandler(w http.ResponseWriter, r *http.Request) {
+	myPublicKey, err := getAuthorizedUserPublicKey(r)
+	if err != nil {
+		writeJsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	fingerprint, err := fingerprint.Parse(mux.Vars(r)["fingerprint"])
+	if err != nil {
+		writeJsonError(w, err, http.StatusBadRequest)
+		return
+	} else if fingerprint != myPublicKey.Fingerprint() {
+		writeJsonError(w,
+			fmt.Errorf("can only list verifications for the authorized key"),
+			http.StatusForbidden)
+		return
+	}
+
+	verifications, err := datastore.ListVerificationsForKey(nil, fingerprint)
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	responseData := v1structs.ListVerificationsResponse{
+		Verifications: []v1structs.Verification{},
+	}
+	for _, v := range verifications {
+		responseData.Verifications = append(responseData.Verifications, v1structs.Verification{
+			CreatedAt:       v.CreatedAt,
+			Email:           v.EmailSentTo,
+			UpsertIPAddress: v.UpsertIPAddress,
+			UpsertUserAgent: v.UpsertUserAgent,
+			Verified:        v.VerifyIPAddress != nil,
+			VerifiedAt:      v.VerifiedAt,
+			VerifyIPAddress: v.VerifyIPAddress,
+			VerifyUserAgent: v.VerifyUserAgent,
+		})
+	}
+	writeEncryptedJsonResponse(w, responseData, myPublicKey)
+}
+
+// queryEmailsVerifiedHandler returns whether each of the given emails is verified for the key
+// fingerprint given with it.
+func queryEmailsVerifiedHandler(w http.ResponseWriter, r *http.Request) {
+	requestData := v1structs.QueryEmailsVerifiedRequest{}
+	if err := decodeJsonRequest(r, &requestData); err != nil {
+		writeJsonError(w, err, decodeJsonErrorStatus(err))
+		return
+	}
+
+	if len(requestData.Emails) > maxEmailsVerifiedBatchSize {
+		writeJsonError(w,
+			fmt.Errorf("too many emails: maximum is %d per request", maxEmailsVerifiedBatchSize),
+			http.StatusBadRequest)
+		return
+	}
+
+	pairs := []datastore.EmailFingerprint{}
+
+	for _, pair := range requestData.Emails {
+		fingerprint, err := parseFingerprint(pair.Fingerprint)
+		if err != nil {
+			writeJsonError(w,
+				fmt.Errorf("invalid fingerprint '%s': %v", pair.Fingerprint, err),
+				http.StatusBadRequest)
+			return
+		}
+		pairs = append(pairs, datastore.EmailFingerprint{
+			Email:       pair.Email,
+			Fingerprint: *fingerprint,
+		})
+	}
+
+	verified, err := datastore.QueryEmailsVerified(nil, pairs)
+	if err != nil {
+		writeJsonError(w, fmt.Errorf("error querying verification: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	responseData := v1structs.QueryEmailsVerifiedResponse{
+		Results: []v1structs.EmailVerified{},
+	}
+	for _, pair := range pairs {
+		responseData.Results = append(responseData.Results, v1structs.EmailVerified{
+			Email:       pair.Email,
+			Fingerprint: pair.Fingerprint.Uri(),
+			Verified:    verified[pair],
+		})
+	}
+
+	writeJsonResponse(w, responseData)
+}
+
+// maxEmailsVerifiedBatchSize is the most emails that can be queried in one request to
+// queryEmailsVerifiedHandler
+const maxEmailsVerifiedBatchSize = 100
+
 const verifyPage string = `<html>
 	<body>
 		<h1>Verifying email...</h1>
@@ -0,0 +1,426 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/api/v1structs"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+)
+
+func TestGetEmailVerificationHandler(t *testing.T) {
+	verifiedAt := time.Date(2019, 7, 2, 11, 20, 0, 0, time.UTC)
+	sentAt := verifiedAt.Add(-10 * time.Minute)
+	fingerprint := exampledata.ExampleFingerprint4
+	otherFingerprint := exampledata.ExampleFingerprint3
+
+	setup := func() {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+
+		verificationUUID, err := datastore.CreateVerification(
+			nil, "test4@example.com", fingerprint, "fake user agent", "0.0.0.0", sentAt,
+		)
+		assert.NoError(t, err)
+
+		assert.NoError(t, datastore.MarkVerificationAsVerified(
+			nil, *verificationUUID, "fake user agent", "1.1.1.1", verifiedAt))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+
+		_, _, err = datastore.DeletePublicKey(nil, otherFingerprint)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/email/test4@example.com/verification", nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("returns time of verification", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/email/test4@example.com/verification", nil, &fingerprint)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.GetEmailVerificationResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		if !verifiedAt.Equal(responseData.VerifiedAt) {
+			t.Fatalf("expected verifiedAt %v, got %v", verifiedAt, responseData.VerifiedAt)
+		}
+	})
+
+	t.Run("returns 404 for email never verified", func(t *testing.T) {
+		response := callAPI(t, "GET", "/v1/email/missing@example.com/verification", nil, &fingerprint)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"email address 'missing@example.com' has never been verified for this key")
+	})
+
+	t.Run("returns 404 for email verified by a different key", func(t *testing.T) {
+		response := callAPI(
+			t, "GET", "/v1/email/test4@example.com/verification", nil, &otherFingerprint,
+		)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+	})
+}
+
+func TestListVerificationsHandler(t *testing.T) {
+	sentAt := time.Date(2019, 7, 3, 9, 0, 0, 0, time.UTC)
+	verifiedAt := sentAt.Add(5 * time.Minute)
+	fingerprint := exampledata.ExampleFingerprint4
+
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey4, "test4")
+	assert.NoError(t, err)
+
+	setup := func() {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+
+		verifiedUUID, err := datastore.CreateVerification(
+			nil, "history-verified@example.com", fingerprint, "uploader", "10.0.0.1", sentAt,
+		)
+		assert.NoError(t, err)
+		assert.NoError(t, datastore.MarkVerificationAsVerified(
+			nil, *verifiedUUID, "browser", "10.0.0.2", verifiedAt))
+
+		_, err = datastore.CreateVerification(
+			nil, "history-unverified@example.com", fingerprint, "uploader", "10.0.0.3",
+			sentAt.Add(time.Hour),
+		)
+		assert.NoError(t, err)
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	path := "/v1/key/" + fingerprint.Hex() + "/verifications"
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "GET", path, nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("for a different key", func(t *testing.T) {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+		defer datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint3)
+
+		response := callAPI(t, "GET", path, nil, &exampledata.ExampleFingerprint3)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"can only list verifications for the authorized key")
+	})
+
+	t.Run("returns the key's verifications encrypted to the key", func(t *testing.T) {
+		response := callAPI(t, "GET", path, nil, &fingerprint)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		if strings.Contains(response.Body.String(), "10.0.0.1") {
+			t.Fatalf("expected IP addresses to be encrypted, got %s", response.Body.String())
+		}
+
+		responseData := v1structs.ListVerificationsResponse{}
+		assertEncryptedBodyDecodesInto(t, response.Body, unlockedKey, &responseData)
+
+		// other tests leave verifications for the same key behind, so look for ours by email
+		byEmail := map[string]v1structs.Verification{}
+		emailOrder := []string{}
+		for _, v := range responseData.Verifications {
+			byEmail[v.Email] = v
+			emailOrder = append(emailOrder, v.Email)
+		}
+
+		t.Run("completed verification", func(t *testing.T) {
+			v, got := byEmail["history-verified@example.com"]
+			if !got {
+				t.Fatalf("verification missing from %v", emailOrder)
+			}
+			assert.Equal(t, true, v.Verified)
+			if !sentAt.Equal(v.CreatedAt) {
+				t.Fatalf("expected createdAt %v, got %v", sentAt, v.CreatedAt)
+			}
+			if v.VerifiedAt == nil || !verifiedAt.Equal(*v.VerifiedAt) {
+				t.Fatalf("expected verifiedAt %v, got %v", verifiedAt, v.VerifiedAt)
+			}
+			assert.Equal(t, "10.0.0.1", *v.UpsertIPAddress)
+			assert.Equal(t, "uploader", *v.UpsertUserAgent)
+			assert.Equal(t, "10.0.0.2", *v.VerifyIPAddress)
+			assert.Equal(t, "browser", *v.VerifyUserAgent)
+		})
+
+		t.Run("incomplete verification", func(t *testing.T) {
+			v, got := byEmail["history-unverified@example.com"]
+			if !got {
+				t.Fatalf("verification missing from %v", emailOrder)
+			}
+			assert.Equal(t, false, v.Verified)
+			if v.VerifiedAt != nil || v.VerifyIPAddress != nil || v.VerifyUserAgent != nil {
+				t.Fatalf("expected verify fields to be null, got %+v", v)
+			}
+		})
+
+		t.Run("newest first", func(t *testing.T) {
+			for i := 1; i < len(responseData.Verifications); i++ {
+				previous := responseData.Verifications[i-1].CreatedAt
+				if responseData.Verifications[i].CreatedAt.After(previous) {
+					t.Fatalf("verifications not in newest first order: %v", emailOrder)
+				}
+			}
+		})
+	})
+}
+
+func TestQueryEmailsVerifiedHandler(t *testing.T) {
+	setup := func() {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		assert.NoError(t, datastore.LinkEmailToFingerprint(
+			nil, "test4@example.com", exampledata.ExampleFingerprint4, nil))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	testEndpointRejectsBadJSON(t, "POST", "/v1/emails/verified", nil)
+
+	t.Run("with a mix of verified and unverified emails", func(t *testing.T) {
+		requestData := v1structs.QueryEmailsVerifiedRequest{
+			Emails: []v1structs.EmailAndFingerprint{
+				{Email: "test4@example.com", Fingerprint: exampledata.ExampleFingerprint4.Uri()},
+				{Email: "test4@example.com", Fingerprint: exampledata.ExampleFingerprint3.Uri()},
+				{Email: "unverified@example.com", Fingerprint: exampledata.ExampleFingerprint4.Uri()},
+			},
+		}
+
+		response := callAPI(t, "POST", "/v1/emails/verified", requestData, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		responseData := v1structs.QueryEmailsVerifiedResponse{}
+		assertBodyDecodesInto(t, response.Body, &responseData)
+
+		expected := []v1structs.EmailVerified{
+			{Email: "test4@example.com", Fingerprint: exampledata.ExampleFingerprint4.Uri(), Verified: true},
+			{Email: "test4@example.com", Fingerprint: exampledata.ExampleFingerprint3.Uri(), Verified: false},
+			{Email: "unverified@example.com", Fingerprint: exampledata.ExampleFingerprint4.Uri(), Verified: false},
+		}
+		assert.Equal(t, expected, responseData.Results)
+	})
+
+	t.Run("with an invalid fingerprint", func(t *testing.T) {
+		requestData := v1structs.QueryEmailsVerifiedRequest{
+			Emails: []v1structs.EmailAndFingerprint{
+				{Email: "test4@example.com", Fingerprint: "OPENPGP4FPR:invalid"},
+			},
+		}
+
+		response := callAPI(t, "POST", "/v1/emails/verified", requestData, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("with too many emails", func(t *testing.T) {
+		requestData := v1structs.QueryEmailsVerifiedRequest{}
+		for i := 0; i <= maxEmailsVerifiedBatchSize; i++ {
+			requestData.Emails = append(requestData.Emails, v1structs.EmailAndFingerprint{
+				Email:       fmt.Sprintf("test%d@example.com", i),
+				Fingerprint: exampledata.ExampleFingerprint4.Uri(),
+			})
+		}
+
+		response := callAPI(t, "POST", "/v1/emails/verified", requestData, nil)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			fmt.Sprintf("too many emails: maximum is %d per request", maxEmailsVerifiedBatchSize))
+	})
+}
+
+func TestGetEmailKeyHistoryHandler(t *testing.T) {
+	fingerprint := exampledata.ExampleFingerprint3
+	otherFingerprint := exampledata.ExampleFingerprint4
+	path := "/v1/email/test3@example.com/key-history"
+
+	unlockedKey, err := pgpkey.LoadFromArmoredEncryptedPrivateKey(exampledata.ExamplePrivateKey3, "test3")
+	assert.NoError(t, err)
+
+	setup := func() {
+		// deleting the keys first clears any verifications left by other tests
+		_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+		_, _, err = datastore.DeletePublicKey(nil, otherFingerprint)
+		assert.NoError(t, err)
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+
+		verificationUUID, err := datastore.CreateVerification(
+			nil, "test3@example.com", fingerprint, "uploader", "10.0.0.1", time.Now())
+		assert.NoError(t, err)
+		assert.NoError(t, datastore.MarkVerificationAsVerified(
+			nil, *verificationUUID, "browser", "10.0.0.2", time.Now()))
+		assert.NoError(t,
+			datastore.LinkEmailToFingerprint(nil, "test3@example.com", fingerprint, nil))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+		_, _, err = datastore.DeletePublicKey(nil, otherFingerprint)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "GET", path, nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("email isn't verified for the authorized key", func(t *testing.T) {
+		response := callAPI(t, "GET", path, nil, &otherFingerprint)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"email address isn't verified for the authorized key")
+	})
+
+	t.Run("lists the verified keys encrypted to the key", func(t *testing.T) {
+		response := callAPI(t, "GET", path, nil, &fingerprint)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		if strings.Contains(response.Body.String(), fingerprint.Hex()) {
+			t.Fatalf("expected fingerprints to be encrypted, got %s", response.Body.String())
+		}
+
+		responseData := v1structs.GetEmailKeyHistoryResponse{}
+		assertEncryptedBodyDecodesInto(t, response.Body, unlockedKey, &responseData)
+
+		// verifications outlive their keys, so earlier test runs may have left some behind
+		if len(responseData.Keys) == 0 {
+			t.Fatalf("expected at least 1 key, got none")
+		}
+		latest := responseData.Keys[len(responseData.Keys)-1]
+		assert.Equal(t, fingerprint.Hex(), latest.Fingerprint)
+		if latest.VerifiedAt == nil {
+			t.Fatalf("expected verifiedAt to be set")
+		}
+	})
+}
+
+func TestReverifyEmailHandler(t *testing.T) {
+	fingerprint := exampledata.ExampleFingerprint3
+	path := "/v1/email/test3@example.com/reverify"
+
+	setup := func() {
+		// deleting the key first clears any email link left by other tests
+		_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey3))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, fingerprint)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	t.Run("without authorization header", func(t *testing.T) {
+		response := callAPI(t, "POST", path, nil, nil)
+		assertStatusCode(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("email isn't on the authorized key", func(t *testing.T) {
+		response := callAPI(t, "POST", "/v1/email/test4@example.com/reverify", nil, &fingerprint)
+		assertStatusCode(t, http.StatusForbidden, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errEmailNotOnKey.Error())
+	})
+
+	t.Run("sends a verification", func(t *testing.T) {
+		response := callAPI(t, "POST", path, nil, &fingerprint)
+		assertStatusCode(t, http.StatusAccepted, response.Code)
+
+		validUntil, err := datastore.GetActiveVerificationValidUntil(
+			nil, "test3@example.com", time.Now())
+		assert.NoError(t, err)
+		if validUntil == nil {
+			t.Fatalf("expected an active verification for test3@example.com")
+		}
+	})
+
+	t.Run("returns 429 while the verification is active", func(t *testing.T) {
+		response := callAPI(t, "POST", path, nil, &fingerprint)
+		assertStatusCode(t, http.StatusTooManyRequests, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errVerificationAlreadyActive.Error())
+
+		retryAfter, err := strconv.Atoi(response.Header().Get("Retry-After"))
+		assert.NoError(t, err)
+		if retryAfter < 1 || retryAfter > 15*60 {
+			t.Fatalf("expected Retry-After between 1 and 900 seconds, got %d", retryAfter)
+		}
+	})
+
+	t.Run("returns 409 for an email that's already verified", func(t *testing.T) {
+		assert.NoError(t,
+			datastore.LinkEmailToFingerprint(nil, "test3@example.com", fingerprint, nil))
+
+		response := callAPI(t, "POST", path, nil, &fingerprint)
+		assertStatusCode(t, http.StatusConflict, response.Code)
+		assertHasJSONErrorDetail(t, response.Body, errEmailAlreadyVerified.Error())
+	})
+}
+
+func TestVerifyEmailHandlerBodySize(t *testing.T) {
+	// no verification has this UUID, so a body that's allowed gets as far as failing to find it
+	const path = "/v1/email/verify/0c5c9a7e-0b8e-4e6b-9f0a-3d1c2b7a6e5f"
+
+	postForm := func(body io.Reader, contentLength int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.ContentLength = contentLength
+
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, req)
+		return response
+	}
+
+	t.Run("empty body is accepted", func(t *testing.T) {
+		response := postForm(strings.NewReader(""), 0)
+		assertStatusCode(t, http.StatusBadRequest, response.Code)
+		assert.Equal(t, true, strings.Contains(response.Body.String(), "error getting verification"))
+	})
+
+	t.Run("body larger than the maximum is refused", func(t *testing.T) {
+		body := strings.Repeat("a", maxVerifyBodyBytes+1)
+
+		response := postForm(strings.NewReader(body), int64(len(body)))
+		assertStatusCode(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+
+	t.Run("body larger than the maximum without a Content-Length is refused", func(t *testing.T) {
+		body := strings.Repeat("a", maxVerifyBodyBytes+1)
+
+		response := postForm(strings.NewReader(body), -1)
+		assertStatusCode(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/fluidkeys/api/v1structs"
+)
+
+// Version and Commit identify the running build. They're injected at build time, for example:
+//
+// > go build -ldflags "-X github.com/fluidkeys/api/server.Version=1.2.0 \
+// >     -X github.com/fluidkeys/api/server.Commit=$(git rev-parse HEAD)"
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
+
+// rootHandler points anyone looking at the API's base URL to the versioned API and the docs.
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	writeJsonResponse(w, v1structs.GetRootResponse{
+		API:  "/v1",
+		Docs: docsURL,
+	})
+}
+
+func getVersionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJsonResponse(w, v1structs.GetVersionResponse{
+		Version: Version,
+		Commit:  Commit,
+	})
+}
+
+func getCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJsonResponse(w, v1structs.GetCapabilitiesResponse{
+		WKD:                    true,
+		HKP:                    true,
+		BatchEmailVerification: true,
+		SecretReadReceipts:     true,
+		TeamUpdateConflicts:    true,
+		VerificationHistory:    true,
+		Writable:               maintenanceMode == maintenanceOff,
+		MaxSecretBytes:         maxSecretBytes,
+	})
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/crypto/openpgp/armor"
+	"github.com/fluidkeys/fluidkeys/pgpkey"
+	"github.com/gorilla/mux"
+)
+
+// wkdKeyHandler serves Web Key Directory lookups, returning the binary public key linked to
+// the email address with the hashed local part. It handles both the advanced method, where the
+// domain is in the path, and the direct method, where the domain is the requested host.
+// See https://tools.ietf.org/html/draft-koch-openpgp-webkey-service
+func wkdKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	domain, ok := mux.Vars(r)["domain"]
+	if !ok {
+		domain = hostWithoutPort(r.Host)
+	}
+	hash := mux.Vars(r)["hash"]
+
+	armoredPublicKey, found, err := datastore.GetArmoredPublicKeyForEmailHash(nil, domain, hash)
+	if err != nil {
+		writeJsonError(w, err, http.StatusInternalServerError)
+		return
+	} else if !found {
+		writeJsonError(w,
+			fmt.Errorf("couldn't find a public key for hash '%s' at '%s'", hash, domain),
+			http.StatusNotFound)
+		return
+	}
+
+	publicKey, err := pgpkey.LoadFromArmoredPublicKey(armoredPublicKey)
+	if err != nil {
+		writeJsonError(w,
+			fmt.Errorf("error loading stored public key: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	if !checkKeyNotBanned(w, publicKey) {
+		return
+	}
+
+	block, err := armor.Decode(strings.NewReader(armoredPublicKey))
+	if err != nil {
+		writeJsonError(w,
+			fmt.Errorf("error decoding stored public key: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, block.Body)
+}
+
+// wkdPolicyHandler serves an empty policy file, which tells clients this is a Web Key Directory.
+func wkdPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+}
+
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
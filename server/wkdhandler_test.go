@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fluidkeys/api/datastore"
+	"github.com/fluidkeys/crypto/openpgp/armor"
+	"github.com/fluidkeys/fluidkeys/assert"
+	"github.com/fluidkeys/fluidkeys/exampledata"
+)
+
+func TestWKDKeyHandler(t *testing.T) {
+	// gpg --with-wkd-hash lists test4@example.com as:
+	const test4Hash = "d93mghnk7zor75fid3ecw4cq9ieknph5"
+
+	block, err := armor.Decode(strings.NewReader(exampledata.ExamplePublicKey4))
+	assert.NoError(t, err)
+	binaryPublicKey, err := ioutil.ReadAll(block.Body)
+	assert.NoError(t, err)
+
+	setup := func() {
+		assert.NoError(t, datastore.UpsertPublicKey(nil, exampledata.ExamplePublicKey4))
+		assert.NoError(t, datastore.LinkEmailToFingerprint(
+			nil, "test4@example.com", exampledata.ExampleFingerprint4, nil))
+	}
+
+	teardown := func() {
+		_, _, err := datastore.DeletePublicKey(nil, exampledata.ExampleFingerprint4)
+		assert.NoError(t, err)
+	}
+
+	setup()
+	defer teardown()
+
+	t.Run("advanced method returns binary key", func(t *testing.T) {
+		response := callAPI(
+			t, "GET", "/.well-known/openpgpkey/example.com/hu/"+test4Hash, nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		assert.Equal(t, "application/octet-stream", response.Header().Get("content-type"))
+		assert.Equal(t, "*", response.Header().Get("access-control-allow-origin"))
+		if !bytes.Equal(binaryPublicKey, response.Body.Bytes()) {
+			t.Fatalf("expected binary public key, got %d bytes", response.Body.Len())
+		}
+	})
+
+	t.Run("direct method uses the requested host as domain", func(t *testing.T) {
+		response := callAPI(
+			t, "GET", "https://example.com/.well-known/openpgpkey/hu/"+test4Hash, nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+
+		if !bytes.Equal(binaryPublicKey, response.Body.Bytes()) {
+			t.Fatalf("expected binary public key, got %d bytes", response.Body.Len())
+		}
+	})
+
+	t.Run("returns 404 for a different domain", func(t *testing.T) {
+		response := callAPI(
+			t, "GET", "/.well-known/openpgpkey/example.org/hu/"+test4Hash, nil, nil)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("returns 404 for an unknown hash", func(t *testing.T) {
+		response := callAPI(t, "GET",
+			"/.well-known/openpgpkey/example.com/hu/iy9q119eutrkn8s1mk4r39qejnbu3n5q", nil, nil)
+		assertStatusCode(t, http.StatusNotFound, response.Code)
+		assertHasJSONErrorDetail(t, response.Body,
+			"couldn't find a public key for hash 'iy9q119eutrkn8s1mk4r39qejnbu3n5q' "+
+				"at 'example.com'")
+	})
+
+	t.Run("serves a policy file", func(t *testing.T) {
+		response := callAPI(t, "GET", "/.well-known/openpgpkey/example.com/policy", nil, nil)
+		assertStatusCode(t, http.StatusOK, response.Code)
+	})
+}
@@ -4,12 +4,85 @@ import (
 	"time"
 )
 
+// GetRootResponse is the JSON structure returned from the API's base URL, `GET /`.
+type GetRootResponse struct {
+	// API is the path of the current version of the API.
+	API string `json:"api"`
+
+	// Docs is the URL of the API documentation.
+	Docs string `json:"docs"`
+}
+
+// GetVersionResponse is the JSON structure returned by the version API endpoint.
+type GetVersionResponse struct {
+	// Version is the release version of the running server, or `dev` for a local build.
+	Version string `json:"version"`
+
+	// Commit is the git commit the running server was built from.
+	Commit string `json:"commit"`
+}
+
+// GetCapabilitiesResponse is the JSON structure returned by the capabilities API endpoint. It
+// tells clients which optional features this server supports, so they can check before using
+// them. New fields may be added over time: clients should treat a missing field as false.
+type GetCapabilitiesResponse struct {
+	// WKD is true if the server answers Web Key Directory lookups under /.well-known/openpgpkey
+	WKD bool `json:"wkd"`
+
+	// HKP is true if the server answers HKP keyserver lookups at /pks/lookup
+	HKP bool `json:"hkp"`
+
+	// BatchEmailVerification is true if several emails can be checked at once with
+	// POST /v1/emails/verified
+	BatchEmailVerification bool `json:"batchEmailVerification"`
+
+	// SecretReadReceipts is true if the sender of a secret can check whether it's been picked
+	// up with GET /v1/secrets/{uuid}/status
+	SecretReadReceipts bool `json:"secretReadReceipts"`
+
+	// TeamUpdateConflicts is true if team updates accept an If-Match roster version and
+	// refuse conflicting updates with 409 Conflict
+	TeamUpdateConflicts bool `json:"teamUpdateConflicts"`
+
+	// VerificationHistory is true if a key's owner can list its email verifications with
+	// GET /v1/key/{fingerprint}/verifications
+	VerificationHistory bool `json:"verificationHistory"`
+
+	// Writable is false while the server is in maintenance mode and refusing writes.
+	Writable bool `json:"writable"`
+
+	// MaxSecretBytes is the largest secret the server will accept, in bytes.
+	MaxSecretBytes int `json:"maxSecretBytes"`
+}
+
 // GetPublicKeyResponse is the JSON structure returned by the get public key
 // API endpoint. See:
 // https://github.com/fluidkeys/api/blob/master/README.md#get-a-public-key
 type GetPublicKeyResponse struct {
 	// ArmoredPublicKey is the ASCII-armored OpenPGP public key.
 	ArmoredPublicKey string `json:"armoredPublicKey"`
+
+	// Compromised is true if the key's owner has reported it as compromised. The key shouldn't
+	// be trusted or encrypted to.
+	Compromised bool `json:"compromised"`
+
+	// Revoked is true if the key carries a revocation signature from its owner. The key
+	// shouldn't be trusted or encrypted to.
+	Revoked bool `json:"revoked"`
+
+	// VerifiedAt is when the email address the key was looked up by was verified for it. It's
+	// omitted when looking up by fingerprint, or if the verification isn't known.
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+}
+
+// GetPrimaryEmailResponse is the JSON structure returned by the get primary email API endpoint.
+type GetPrimaryEmailResponse struct {
+	// Email is the email address in the key's primary user ID.
+	Email string `json:"email"`
+
+	// Verified is true if the email address has been verified for the key. An unverified email
+	// address is only a claim by whoever made the key.
+	Verified bool `json:"verified"`
 }
 
 // UpsertPublicKeyRequest is a request to create or update a public key.
@@ -49,6 +122,159 @@ type UpsertPublicKeyResponse struct {
 	// system-generated password that can be used to authenticate
 	// subsequent API calls using HTTP basic auth.
 	ArmoredEncryptedBasicAuthPassword string `json:"armoredEncryptedBasicAuthPassword"`
+
+	// VerificationUrls maps each email address sent a verification email to the link in it.
+	// It's only returned by development servers run with RETURN_VERIFICATION_URLS=1, so
+	// end-to-end tests can complete verification without reading the email.
+	VerificationUrls map[string]string `json:"verificationUrls,omitempty"`
+}
+
+// RotatePasswordResponse is the JSON response returned from the rotate password endpoint.
+type RotatePasswordResponse struct {
+	// ArmoredEncryptedBasicAuthPassword, when decrypted, contains a new system-generated
+	// password that replaces the key's previous basic auth password.
+	ArmoredEncryptedBasicAuthPassword string `json:"armoredEncryptedBasicAuthPassword"`
+}
+
+// SignedRequest is the JSON request for endpoints that change something about a key, where
+// the Authorization header alone isn't proof enough that the caller owns the key.
+type SignedRequest struct {
+	// ArmoredSignedJSON is an ASCII-armored message clearsigned by the key, decoding to a JSON
+	// message which decodes as a SignedRequestData, plus any fields the endpoint needs
+	ArmoredSignedJSON string `json:"armoredSignedJSON"`
+}
+
+// SignedRequestData is data self-signed by a key to prove that the request changing it came
+// from the key's owner.
+type SignedRequestData struct {
+	// Action is what the request is for, for example SignedRequestActionReportKeyCompromised,
+	// so a signature made for one endpoint can't be used at another
+	Action string `json:"action"`
+
+	// The client's current time which must be within 24 hours of the server's timestamp
+	Timestamp time.Time `json:"timestamp"`
+
+	// SingleUseUUID is a random UUID that is used once and must not be used again, so the
+	// signed request can't be replayed
+	SingleUseUUID string `json:"singleUseUuid"`
+}
+
+// Actions for SignedRequestData
+const (
+	// SignedRequestActionReportKeyCompromised reports the key as compromised
+	SignedRequestActionReportKeyCompromised = "report_key_compromised"
+
+	// SignedRequestActionUpdateProfile changes the key's email preferences
+	SignedRequestActionUpdateProfile = "update_profile"
+)
+
+// ReportKeyCompromisedResponse is the JSON response returned from the report compromised key
+// endpoint.
+type ReportKeyCompromisedResponse struct {
+	// CompromisedAt is when the key was first reported as compromised.
+	CompromisedAt time.Time `json:"compromisedAt"`
+}
+
+// UserProfileResponse is the JSON response returned by the get and update profile endpoints.
+type UserProfileResponse struct {
+	// OptoutEmailsExpiryWarnings is true if the key's owner doesn't want emails warning them
+	// that their key is about to expire.
+	OptoutEmailsExpiryWarnings bool `json:"optoutEmailsExpiryWarnings"`
+}
+
+// UpdateUserProfileSignedData is the data clearsigned in the SignedRequest sent to the update
+// profile endpoint. Only the preferences given are changed.
+type UpdateUserProfileSignedData struct {
+	SignedRequestData
+	OptoutEmailsExpiryWarnings *bool `json:"optoutEmailsExpiryWarnings"`
+}
+
+// GetEmailVerificationResponse is the JSON structure returned by the get email verification API
+// endpoint.
+type GetEmailVerificationResponse struct {
+	// VerifiedAt is when the verification link was sent to the email address. The link
+	// must be opened within 15 minutes for the verification to succeed.
+	VerifiedAt time.Time `json:"verifiedAt"`
+}
+
+// EncryptedJSONResponse is the JSON structure returned by endpoints whose response is only for
+// the owner of the authorized key.
+type EncryptedJSONResponse struct {
+	// EncryptedJSON is an ASCII-armored PGP message, encrypted to the authorized key, which
+	// decrypts to the endpoint's JSON response, for example a ListVerificationsResponse.
+	EncryptedJSON string `json:"encryptedJSON"`
+}
+
+// ListVerificationsResponse is the JSON structure, encrypted in an EncryptedJSONResponse,
+// returned by the list verifications API endpoint. Verifications are listed newest first.
+type ListVerificationsResponse struct {
+	Verifications []Verification `json:"verifications"`
+}
+
+// Verification is an attempt to link an email address to a key: an email containing a
+// verification link was sent to the address when the key was uploaded.
+type Verification struct {
+	// CreatedAt is when the verification email was sent
+	CreatedAt time.Time `json:"createdAt"`
+	Email     string    `json:"email"`
+
+	// UpsertIPAddress and UpsertUserAgent are from the request that uploaded the key
+	UpsertIPAddress *string `json:"upsertIpAddress"`
+	UpsertUserAgent *string `json:"upsertUserAgent"`
+
+	// Verified is true if the link in the email was opened
+	Verified bool `json:"verified"`
+
+	// VerifiedAt is when the link was opened. It's null for old verifications where this wasn't
+	// recorded.
+	VerifiedAt *time.Time `json:"verifiedAt"`
+
+	// VerifyIPAddress and VerifyUserAgent are from the request that opened the link
+	VerifyIPAddress *string `json:"verifyIpAddress"`
+	VerifyUserAgent *string `json:"verifyUserAgent"`
+}
+
+// GetEmailKeyHistoryResponse is the JSON structure, encrypted in an EncryptedJSONResponse,
+// returned by the email key history API endpoint. Keys are listed oldest first.
+type GetEmailKeyHistoryResponse struct {
+	Keys []EmailKeyHistoryEntry `json:"keys"`
+}
+
+// EmailKeyHistoryEntry is a completed verification of an email address for a key.
+type EmailKeyHistoryEntry struct {
+	Fingerprint string `json:"fingerprint"`
+
+	// CreatedAt is when the verification email was sent
+	CreatedAt time.Time `json:"createdAt"`
+
+	// VerifiedAt is when the link was opened. It's null for old verifications where this wasn't
+	// recorded.
+	VerifiedAt *time.Time `json:"verifiedAt"`
+}
+
+// QueryEmailsVerifiedRequest is the JSON structure used for requests to the batch email
+// verification status API endpoint.
+type QueryEmailsVerifiedRequest struct {
+	Emails []EmailAndFingerprint `json:"emails"`
+}
+
+// EmailAndFingerprint is an email address and a key fingerprint, prepended with `OPENPGP4FPR:`
+type EmailAndFingerprint struct {
+	Email       string `json:"email"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// QueryEmailsVerifiedResponse is the JSON structure returned by the batch email verification
+// status API endpoint. Results are in the same order as the request.
+type QueryEmailsVerifiedResponse struct {
+	Results []EmailVerified `json:"results"`
+}
+
+// EmailVerified says whether an email address is verified for a key fingerprint.
+type EmailVerified struct {
+	Email       string `json:"email"`
+	Fingerprint string `json:"fingerprint"`
+	Verified    bool   `json:"verified"`
 }
 
 // SendSecretRequest is the JSON structure used for requests to the send secret
@@ -57,6 +283,34 @@ type UpsertPublicKeyResponse struct {
 type SendSecretRequest struct {
 	RecipientFingerprint   string `json:"recipientFingerprint"`
 	ArmoredEncryptedSecret string `json:"armoredEncryptedSecret"`
+
+	// ArmoredEncryptedSenderHint is an optional ASCII-armored PGP message, encrypted to the
+	// recipient, saying who the secret is from. It's returned to the recipient in
+	// `SecretMetadata` so they can tell secrets apart without decrypting the content.
+	ArmoredEncryptedSenderHint string `json:"armoredEncryptedSenderHint,omitempty"`
+}
+
+// SendSecretResponse is the JSON structure returned by the send secret API endpoint.
+type SendSecretResponse struct {
+	// SecretUUID identifies the stored secret
+	SecretUUID string `json:"secretUuid"`
+
+	// SenderToken lets the sender check whether the secret has been picked up, with the get
+	// secret status API endpoint. It's omitted if the receipt couldn't be stored.
+	SenderToken string `json:"senderToken,omitempty"`
+}
+
+// GetSecretStatusResponse is the JSON structure returned by the get secret status API
+// endpoint. See:
+// https://github.com/fluidkeys/api/blob/master/README.md#check-if-a-secret-has-been-picked-up
+type GetSecretStatusResponse struct {
+	CreatedAt time.Time `json:"createdAt"`
+
+	// DeliveredAt is when the recipient first downloaded the secret, or null if they haven't
+	DeliveredAt *time.Time `json:"deliveredAt"`
+
+	// DeletedAt is when the recipient deleted the secret, or null if they haven't
+	DeletedAt *time.Time `json:"deletedAt"`
 }
 
 // ListSecretsResponse is the JSON structure returned by the list secrets
@@ -64,6 +318,23 @@ type SendSecretRequest struct {
 // https://github.com/fluidkeys/api/blob/master/README.md#list-your-secrets
 type ListSecretsResponse struct {
 	Secrets []Secret `json:"secrets"`
+
+	// Total is how many secrets are stored for the authorized key
+	Total int `json:"total"`
+}
+
+// GetInboxResponse is the JSON structure returned by the get inbox API endpoint. See:
+// https://github.com/fluidkeys/api/blob/master/README.md#summarise-your-secrets
+type GetInboxResponse struct {
+	// PendingSecrets is how many secrets are waiting for the authorized key
+	PendingSecrets int `json:"pendingSecrets"`
+
+	// OldestSecretAgeSeconds is how long ago the oldest pending secret was sent. It's omitted
+	// if there aren't any.
+	OldestSecretAgeSeconds *int `json:"oldestSecretAgeSeconds,omitempty"`
+
+	// TotalSizeBytes is the total size of the pending secrets once their ASCII armor is decoded
+	TotalSizeBytes int `json:"totalSizeBytes"`
 }
 
 // Secret is the JSON structure containing the metadata and content for an
@@ -74,14 +345,24 @@ type Secret struct {
 	EncryptedMetadata string `json:"encryptedMetadata"`
 
 	// EncryptedContent is an ASCII-armored encrypted PGP message
-	// containing the actual content of the secret.
-	EncryptedContent string `json:"encryptedContent"`
+	// containing the actual content of the secret. It's omitted if the
+	// secrets were listed with `metadataOnly=true`.
+	EncryptedContent string `json:"encryptedContent,omitempty"`
+
+	// SizeBytes is the size of the encrypted content once its ASCII armor is decoded, so
+	// clients listing with `metadataOnly=true` can decide whether to fetch it. It's omitted if
+	// the size isn't known.
+	SizeBytes int `json:"sizeBytes,omitempty"`
 }
 
 // SecretMetadata contains non-content information about an encrypted secret.
 type SecretMetadata struct {
 	// SecretUUID uniquely identifies the secret to the API
 	SecretUUID string `json:"secretUuid"`
+
+	// ArmoredEncryptedSenderHint is the sender hint given when the secret was sent, an
+	// ASCII-armored PGP message encrypted to the recipient. It's omitted if there wasn't one.
+	ArmoredEncryptedSenderHint string `json:"armoredEncryptedSenderHint,omitempty"`
 }
 
 // GetTeamResponse is the JSON structure returned by the get team API endpoint.
@@ -89,8 +370,46 @@ type GetTeamResponse struct {
 	Name string `json:"name"`
 }
 
+// ListTeamsResponse is the JSON structure returned by the list teams API endpoint.
+type ListTeamsResponse struct {
+	Teams []TeamMembership `json:"teams"`
+}
+
+// TeamMembership is a team that the authorized key is listed in.
+type TeamMembership struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+
+	// IsAdmin is true if the authorized key is an admin of the team.
+	IsAdmin bool `json:"isAdmin"`
+}
+
+// GetTeamMemberResponse is the JSON structure returned by the get team member API endpoint.
+type GetTeamMemberResponse struct {
+	// IsMember is true if the fingerprint is listed in the team roster.
+	IsMember bool `json:"isMember"`
+
+	// IsAdmin is true if the fingerprint is listed as an admin of the team.
+	IsAdmin bool `json:"isAdmin"`
+}
+
 // UpsertTeamRequest is the JSON structure containing a signed team roster.
-type UpsertTeamRequest = TeamRosterAndSignature
+type UpsertTeamRequest struct {
+	// TeamRoster describes the members and configuration of a team.
+	// See github.com/fluidkeys/fluidkeys/teamroster
+	TeamRoster string `json:"teamRoster"`
+
+	// The ASCII-armored detached signature of the team roster by the admin making the request.
+	ArmoredDetachedSignature string `json:"armoredDetachedSignature"`
+
+	// AdditionalArmoredDetachedSignatures are signatures of the same roster by other admins, for
+	// teams that need more than one admin to sign off changes.
+	AdditionalArmoredDetachedSignatures []string `json:"additionalArmoredDetachedSignatures,omitempty"`
+
+	// RequiredAdminSignatures sets how many admins must sign future changes to the roster. If
+	// it's 0 or missing, a new team needs 1 and an existing team keeps its current setting.
+	RequiredAdminSignatures int `json:"requiredAdminSignatures,omitempty"`
+}
 
 // TeamRosterAndSignature contains a TOML team roster and an armored detached OpenPGP signature.
 type TeamRosterAndSignature struct {
@@ -105,6 +424,44 @@ type TeamRosterAndSignature struct {
 	ArmoredDetachedSignature string `json:"armoredDetachedSignature"`
 }
 
+// UpsertTeamResponse is the JSON structure returned by the upsert team API endpoint. It
+// summarises what the upload changed compared to the previous roster. For a new team, everyone
+// is in Added.
+type UpsertTeamResponse struct {
+	// NameChanged is true if the team was renamed.
+	NameChanged bool `json:"nameChanged"`
+
+	Added   []TeamMember `json:"added"`
+	Removed []TeamMember `json:"removed"`
+
+	// Promoted and Demoted list members who were made, or stopped being, team admins.
+	Promoted []TeamMember `json:"promoted"`
+	Demoted  []TeamMember `json:"demoted"`
+}
+
+// ValidateTeamRosterRequest is the JSON structure used for requests to the validate team roster
+// API endpoint. Unlike UpsertTeamRequest it doesn't need a signature.
+type ValidateTeamRosterRequest struct {
+	TeamRoster string `json:"teamRoster"`
+}
+
+// ValidateTeamRosterResponse is the JSON structure returned by the validate team roster API
+// endpoint for a valid roster. It's the team as the server understood it.
+type ValidateTeamRosterResponse struct {
+	UUID    string       `json:"uuid"`
+	Name    string       `json:"name"`
+	Version uint         `json:"version"`
+	Members []TeamMember `json:"members"`
+}
+
+// TeamMember is a person listed in a team roster. In UpsertTeamResponse, fields are as they
+// are in the new roster, or the old one for removed members.
+type TeamMember struct {
+	Email       string `json:"email"`
+	Fingerprint string `json:"fingerprint"`
+	IsAdmin     bool   `json:"isAdmin"`
+}
+
 // RequestToJoinTeamRequest is the JSON structure used for requests to the request to join team
 // API enndpoint.
 type RequestToJoinTeamRequest struct {
@@ -115,6 +472,9 @@ type RequestToJoinTeamRequest struct {
 // API endpoint.
 type ListRequestsToJoinTeamResponse struct {
 	Requests []RequestToJoinTeam `json:"requests"`
+
+	// Total is how many requests there are to join the team, including those not in this page
+	Total int `json:"total"`
 }
 
 // RequestToJoinTeam is the JSON structure containg the data for a request to join a team returned
@@ -125,6 +485,26 @@ type RequestToJoinTeam struct {
 	Email       string `json:"email"`
 }
 
+// GetRequestToJoinTeamResponse is the JSON structure returned by the get request to join team
+// API endpoint.
+type GetRequestToJoinTeamResponse struct {
+	TeamUUID string `json:"teamUuid"`
+	TeamName string `json:"teamName"`
+	Email    string `json:"email"`
+
+	// Status is RequestToJoinTeamPending or RequestToJoinTeamAccepted
+	Status string `json:"status"`
+}
+
+// Statuses of a request to join a team
+const (
+	// RequestToJoinTeamPending means the requesting key isn't in the team roster yet
+	RequestToJoinTeamPending = "pending"
+
+	// RequestToJoinTeamAccepted means an admin has added the requesting key to the team roster
+	RequestToJoinTeamAccepted = "accepted"
+)
+
 // GetTeamRosterResponse is the JSON structure containing the team's roster and detached signature,
 // encrypted to the key that requested it.
 type GetTeamRosterResponse struct {
@@ -141,6 +521,11 @@ type GetTeamRosterResponse struct {
 	//
 	// > gpg --armor --output roster.toml.sig --detach-sig roster.toml
 	ArmoredDetachedSignature string `json:"armoredDetachedSignature"`
+
+	// SignerFingerprint is the fingerprint of the admin who signed the roster, taken from the
+	// signature's issuer key ID, which may be one of the admin's subkeys. It's omitted if the
+	// issuer isn't one of the roster's admins.
+	SignerFingerprint string `json:"signerFingerprint,omitempty"`
 }
 
 // CreateEventRequest is the JSON structure containing an event to be logged from Fluidkeys client.
@@ -157,4 +542,12 @@ type CreateEventRequest struct {
 type ErrorResponse struct {
 	// Detail is a human-readable string describing the error.
 	Detail string `json:"detail"`
+
+	// Errors lists each problem separately when there's more than one, for example everything
+	// wrong with a team roster. Detail joins them together.
+	Errors []string `json:"errors,omitempty"`
+
+	// Code identifies errors that clients may want to handle specially, for example
+	// `REPLAY_DETECTED`. It's omitted for most errors.
+	Code string `json:"code,omitempty"`
 }